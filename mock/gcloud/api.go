@@ -4,6 +4,7 @@
 package gcloud
 
 import (
+	context "context"
 	gcloud "github.com/docker/infrakit.gcp/plugin/gcloud"
 	gomock "github.com/golang/mock/gomock"
 	v1 "google.golang.org/api/compute/v1"
@@ -30,19 +31,54 @@ func (_m *MockAPI) EXPECT() *_MockAPIRecorder {
 	return _m.recorder
 }
 
-func (_m *MockAPI) AddInstanceMetadata(_param0 string, _param1 []*v1.MetadataItems) error {
-	ret := _m.ctrl.Call(_m, "AddInstanceMetadata", _param0, _param1)
+func (_m *MockAPI) AbandonInstances(_param0 context.Context, _param1 string, _param2 ...string) error {
+	_s := []interface{}{_param0, _param1}
+	for _, _x := range _param2 {
+		_s = append(_s, _x)
+	}
+	ret := _m.ctrl.Call(_m, "AbandonInstances", _s...)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockAPIRecorder) AddInstanceMetadata(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddInstanceMetadata", arg0, arg1)
+func (_mr *_MockAPIRecorder) AbandonInstances(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	_s := append([]interface{}{arg0, arg1}, arg2...)
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AbandonInstances", _s...)
 }
 
-func (_m *MockAPI) AddInstanceToTargetPool(_param0 string, _param1 ...string) error {
-	_s := []interface{}{_param0}
-	for _, _x := range _param1 {
+func (_m *MockAPI) AddInstanceGroupToBackendService(_param0 context.Context, _param1 string, _param2 string, _param3 int64) error {
+	ret := _m.ctrl.Call(_m, "AddInstanceGroupToBackendService", _param0, _param1, _param2, _param3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) AddInstanceGroupToBackendService(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddInstanceGroupToBackendService", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockAPI) AddInstanceLabels(_param0 context.Context, _param1 string, _param2 map[string]string) error {
+	ret := _m.ctrl.Call(_m, "AddInstanceLabels", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) AddInstanceLabels(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddInstanceLabels", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) AddInstanceMetadata(_param0 context.Context, _param1 string, _param2 []*v1.MetadataItems) error {
+	ret := _m.ctrl.Call(_m, "AddInstanceMetadata", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) AddInstanceMetadata(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddInstanceMetadata", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) AddInstanceToTargetPool(_param0 context.Context, _param1 string, _param2 ...string) error {
+	_s := []interface{}{_param0, _param1}
+	for _, _x := range _param2 {
 		_s = append(_s, _x)
 	}
 	ret := _m.ctrl.Call(_m, "AddInstanceToTargetPool", _s...)
@@ -50,80 +86,395 @@ func (_m *MockAPI) AddInstanceToTargetPool(_param0 string, _param1 ...string) er
 	return ret0
 }
 
-func (_mr *_MockAPIRecorder) AddInstanceToTargetPool(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
-	_s := append([]interface{}{arg0}, arg1...)
+func (_mr *_MockAPIRecorder) AddInstanceToTargetPool(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	_s := append([]interface{}{arg0, arg1}, arg2...)
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "AddInstanceToTargetPool", _s...)
 }
 
-func (_m *MockAPI) CreateInstance(_param0 string, _param1 *gcloud.InstanceSettings) error {
-	ret := _m.ctrl.Call(_m, "CreateInstance", _param0, _param1)
+func (_m *MockAPI) AggregatedListInstances(_param0 context.Context, _param1 string) ([]*v1.Instance, error) {
+	ret := _m.ctrl.Call(_m, "AggregatedListInstances", _param0, _param1)
+	ret0, _ := ret[0].([]*v1.Instance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) AggregatedListInstances(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AggregatedListInstances", arg0, arg1)
+}
+
+func (_m *MockAPI) AttachDisk(_param0 context.Context, _param1 string, _param2 string, _param3 string) error {
+	ret := _m.ctrl.Call(_m, "AttachDisk", _param0, _param1, _param2, _param3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) AttachDisk(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AttachDisk", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockAPI) BatchGetInstances(_param0 context.Context, _param1 []string) (map[string]*v1.Instance, map[string]error, error) {
+	ret := _m.ctrl.Call(_m, "BatchGetInstances", _param0, _param1)
+	ret0, _ := ret[0].(map[string]*v1.Instance)
+	ret1, _ := ret[1].(map[string]error)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+func (_mr *_MockAPIRecorder) BatchGetInstances(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "BatchGetInstances", arg0, arg1)
+}
+
+func (_m *MockAPI) CreateAutoscaler(_param0 context.Context, _param1 string, _param2 *gcloud.AutoscalerSettings) error {
+	ret := _m.ctrl.Call(_m, "CreateAutoscaler", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateAutoscaler(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateAutoscaler", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) CreateDisk(_param0 context.Context, _param1 string, _param2 *gcloud.PersistentDiskSettings) error {
+	ret := _m.ctrl.Call(_m, "CreateDisk", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateDisk(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateDisk", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) CreateFirewallRule(_param0 context.Context, _param1 string, _param2 *gcloud.FirewallSettings) error {
+	ret := _m.ctrl.Call(_m, "CreateFirewallRule", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateFirewallRule(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateFirewallRule", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) CreateHealthCheck(_param0 context.Context, _param1 string, _param2 *gcloud.HealthCheckSettings) error {
+	ret := _m.ctrl.Call(_m, "CreateHealthCheck", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateHealthCheck(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateHealthCheck", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) CreateInstance(_param0 context.Context, _param1 string, _param2 *gcloud.InstanceSettings) error {
+	ret := _m.ctrl.Call(_m, "CreateInstance", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateInstance(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateInstance", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) CreateInstanceGroupManager(_param0 context.Context, _param1 string, _param2 *gcloud.InstanceManagerSettings) error {
+	ret := _m.ctrl.Call(_m, "CreateInstanceGroupManager", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateInstanceGroupManager(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateInstanceGroupManager", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) CreateInstanceInZone(_param0 context.Context, _param1 string, _param2 string, _param3 *gcloud.InstanceSettings) error {
+	ret := _m.ctrl.Call(_m, "CreateInstanceInZone", _param0, _param1, _param2, _param3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateInstanceInZone(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateInstanceInZone", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockAPI) CreateInstanceTemplate(_param0 context.Context, _param1 string, _param2 *gcloud.InstanceSettings) error {
+	ret := _m.ctrl.Call(_m, "CreateInstanceTemplate", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateInstanceTemplate(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateInstanceTemplate", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) CreateInstances(_param0 context.Context, _param1 map[string]*gcloud.InstanceSettings) map[string]error {
+	ret := _m.ctrl.Call(_m, "CreateInstances", _param0, _param1)
+	ret0, _ := ret[0].(map[string]error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateInstances(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateInstances", arg0, arg1)
+}
+
+func (_m *MockAPI) CreateRegionalInstanceGroupManager(_param0 context.Context, _param1 string, _param2 *gcloud.InstanceManagerSettings) error {
+	ret := _m.ctrl.Call(_m, "CreateRegionalInstanceGroupManager", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateRegionalInstanceGroupManager(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateRegionalInstanceGroupManager", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) CreateSnapshot(_param0 context.Context, _param1 string, _param2 string) error {
+	ret := _m.ctrl.Call(_m, "CreateSnapshot", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateSnapshot(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateSnapshot", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) CreateSpreadPlacementPolicy(_param0 context.Context, _param1 string, _param2 int64) error {
+	ret := _m.ctrl.Call(_m, "CreateSpreadPlacementPolicy", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) CreateSpreadPlacementPolicy(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateSpreadPlacementPolicy", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) DeleteAutoscaler(_param0 context.Context, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteAutoscaler", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) DeleteAutoscaler(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteAutoscaler", arg0, arg1)
+}
+
+func (_m *MockAPI) DeleteDisk(_param0 context.Context, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteDisk", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) DeleteDisk(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteDisk", arg0, arg1)
+}
+
+func (_m *MockAPI) DeleteFirewallRule(_param0 context.Context, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteFirewallRule", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) DeleteFirewallRule(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteFirewallRule", arg0, arg1)
+}
+
+func (_m *MockAPI) DeleteGroupInstances(_param0 context.Context, _param1 string, _param2 ...string) error {
+	_s := []interface{}{_param0, _param1}
+	for _, _x := range _param2 {
+		_s = append(_s, _x)
+	}
+	ret := _m.ctrl.Call(_m, "DeleteGroupInstances", _s...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) DeleteGroupInstances(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	_s := append([]interface{}{arg0, arg1}, arg2...)
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteGroupInstances", _s...)
+}
+
+func (_m *MockAPI) DeleteHealthCheck(_param0 context.Context, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteHealthCheck", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) DeleteHealthCheck(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteHealthCheck", arg0, arg1)
+}
+
+func (_m *MockAPI) DeleteInstance(_param0 context.Context, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteInstance", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) DeleteInstance(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteInstance", arg0, arg1)
+}
+
+func (_m *MockAPI) DeleteInstanceGroupManager(_param0 context.Context, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteInstanceGroupManager", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) DeleteInstanceGroupManager(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteInstanceGroupManager", arg0, arg1)
+}
+
+func (_m *MockAPI) DeleteInstanceInZone(_param0 context.Context, _param1 string, _param2 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteInstanceInZone", _param0, _param1, _param2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockAPIRecorder) CreateInstance(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateInstance", arg0, arg1)
+func (_mr *_MockAPIRecorder) DeleteInstanceInZone(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteInstanceInZone", arg0, arg1, arg2)
 }
 
-func (_m *MockAPI) CreateInstanceGroupManager(_param0 string, _param1 *gcloud.InstanceManagerSettings) error {
-	ret := _m.ctrl.Call(_m, "CreateInstanceGroupManager", _param0, _param1)
+func (_m *MockAPI) DeleteInstanceTemplate(_param0 context.Context, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteInstanceTemplate", _param0, _param1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockAPIRecorder) CreateInstanceGroupManager(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateInstanceGroupManager", arg0, arg1)
+func (_mr *_MockAPIRecorder) DeleteInstanceTemplate(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteInstanceTemplate", arg0, arg1)
+}
+
+func (_m *MockAPI) DeleteInstances(_param0 context.Context, _param1 []string) map[string]error {
+	ret := _m.ctrl.Call(_m, "DeleteInstances", _param0, _param1)
+	ret0, _ := ret[0].(map[string]error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) DeleteInstances(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteInstances", arg0, arg1)
 }
 
-func (_m *MockAPI) CreateInstanceTemplate(_param0 string, _param1 *gcloud.InstanceSettings) error {
-	ret := _m.ctrl.Call(_m, "CreateInstanceTemplate", _param0, _param1)
+func (_m *MockAPI) DeleteRegionalInstanceGroupManager(_param0 context.Context, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteRegionalInstanceGroupManager", _param0, _param1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockAPIRecorder) CreateInstanceTemplate(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "CreateInstanceTemplate", arg0, arg1)
+func (_mr *_MockAPIRecorder) DeleteRegionalInstanceGroupManager(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteRegionalInstanceGroupManager", arg0, arg1)
 }
 
-func (_m *MockAPI) DeleteInstance(_param0 string) error {
-	ret := _m.ctrl.Call(_m, "DeleteInstance", _param0)
+func (_m *MockAPI) DeleteResourcePolicy(_param0 context.Context, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteResourcePolicy", _param0, _param1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockAPIRecorder) DeleteInstance(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteInstance", arg0)
+func (_mr *_MockAPIRecorder) DeleteResourcePolicy(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteResourcePolicy", arg0, arg1)
 }
 
-func (_m *MockAPI) DeleteInstanceGroupManager(_param0 string) error {
-	ret := _m.ctrl.Call(_m, "DeleteInstanceGroupManager", _param0)
+func (_m *MockAPI) DeleteSnapshot(_param0 context.Context, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "DeleteSnapshot", _param0, _param1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockAPIRecorder) DeleteInstanceGroupManager(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteInstanceGroupManager", arg0)
+func (_mr *_MockAPIRecorder) DeleteSnapshot(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteSnapshot", arg0, arg1)
 }
 
-func (_m *MockAPI) DeleteInstanceTemplate(_param0 string) error {
-	ret := _m.ctrl.Call(_m, "DeleteInstanceTemplate", _param0)
+func (_m *MockAPI) DetachDisk(_param0 context.Context, _param1 string, _param2 string) error {
+	ret := _m.ctrl.Call(_m, "DetachDisk", _param0, _param1, _param2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockAPIRecorder) DeleteInstanceTemplate(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "DeleteInstanceTemplate", arg0)
+func (_mr *_MockAPIRecorder) DetachDisk(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "DetachDisk", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) GetAddress(_param0 context.Context, _param1 string) (*gcloud.Address, error) {
+	ret := _m.ctrl.Call(_m, "GetAddress", _param0, _param1)
+	ret0, _ := ret[0].(*gcloud.Address)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) GetAddress(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetAddress", arg0, arg1)
+}
+
+func (_m *MockAPI) GetAutoscaler(_param0 context.Context, _param1 string) (*v1.Autoscaler, error) {
+	ret := _m.ctrl.Call(_m, "GetAutoscaler", _param0, _param1)
+	ret0, _ := ret[0].(*v1.Autoscaler)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) GetAutoscaler(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetAutoscaler", arg0, arg1)
+}
+
+func (_m *MockAPI) GetDeletionProtection(_param0 context.Context, _param1 string, _param2 string) (bool, error) {
+	ret := _m.ctrl.Call(_m, "GetDeletionProtection", _param0, _param1, _param2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) GetDeletionProtection(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetDeletionProtection", arg0, arg1, arg2)
 }
 
-func (_m *MockAPI) GetInstance(_param0 string) (*v1.Instance, error) {
-	ret := _m.ctrl.Call(_m, "GetInstance", _param0)
+func (_m *MockAPI) GetInstance(_param0 context.Context, _param1 string) (*v1.Instance, error) {
+	ret := _m.ctrl.Call(_m, "GetInstance", _param0, _param1)
 	ret0, _ := ret[0].(*v1.Instance)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-func (_mr *_MockAPIRecorder) GetInstance(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetInstance", arg0)
+func (_mr *_MockAPIRecorder) GetInstance(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetInstance", arg0, arg1)
+}
+
+func (_m *MockAPI) GetInstanceGroupManager(_param0 context.Context, _param1 string) (*v1.InstanceGroupManager, error) {
+	ret := _m.ctrl.Call(_m, "GetInstanceGroupManager", _param0, _param1)
+	ret0, _ := ret[0].(*v1.InstanceGroupManager)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) GetInstanceGroupManager(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetInstanceGroupManager", arg0, arg1)
+}
+
+func (_m *MockAPI) GetInstanceInZone(_param0 context.Context, _param1 string, _param2 string) (*v1.Instance, error) {
+	ret := _m.ctrl.Call(_m, "GetInstanceInZone", _param0, _param1, _param2)
+	ret0, _ := ret[0].(*v1.Instance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) GetInstanceInZone(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetInstanceInZone", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) GetInstanceMaintenance(_param0 context.Context, _param1 string, _param2 string) (*gcloud.InstanceMaintenance, error) {
+	ret := _m.ctrl.Call(_m, "GetInstanceMaintenance", _param0, _param1, _param2)
+	ret0, _ := ret[0].(*gcloud.InstanceMaintenance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) GetInstanceMaintenance(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetInstanceMaintenance", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) GetInstanceTemplate(_param0 context.Context, _param1 string) (*v1.InstanceTemplate, error) {
+	ret := _m.ctrl.Call(_m, "GetInstanceTemplate", _param0, _param1)
+	ret0, _ := ret[0].(*v1.InstanceTemplate)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) GetInstanceTemplate(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetInstanceTemplate", arg0, arg1)
 }
 
 func (_m *MockAPI) GetProject() string {
@@ -136,6 +487,60 @@ func (_mr *_MockAPIRecorder) GetProject() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetProject")
 }
 
+func (_m *MockAPI) GetRegion() string {
+	ret := _m.ctrl.Call(_m, "GetRegion")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) GetRegion() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRegion")
+}
+
+func (_m *MockAPI) GetRegionalInstanceGroupManager(_param0 context.Context, _param1 string) (*v1.InstanceGroupManager, error) {
+	ret := _m.ctrl.Call(_m, "GetRegionalInstanceGroupManager", _param0, _param1)
+	ret0, _ := ret[0].(*v1.InstanceGroupManager)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) GetRegionalInstanceGroupManager(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetRegionalInstanceGroupManager", arg0, arg1)
+}
+
+func (_m *MockAPI) GetSerialPortOutput(_param0 context.Context, _param1 string, _param2 int64) (string, error) {
+	ret := _m.ctrl.Call(_m, "GetSerialPortOutput", _param0, _param1, _param2)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) GetSerialPortOutput(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetSerialPortOutput", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) GetSpreadPlacementPolicy(_param0 context.Context, _param1 string) (int64, error) {
+	ret := _m.ctrl.Call(_m, "GetSpreadPlacementPolicy", _param0, _param1)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) GetSpreadPlacementPolicy(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetSpreadPlacementPolicy", arg0, arg1)
+}
+
+func (_m *MockAPI) GetTargetPool(_param0 context.Context, _param1 string) (*v1.TargetPool, error) {
+	ret := _m.ctrl.Call(_m, "GetTargetPool", _param0, _param1)
+	ret0, _ := ret[0].(*v1.TargetPool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) GetTargetPool(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetTargetPool", arg0, arg1)
+}
+
 func (_m *MockAPI) GetZone() string {
 	ret := _m.ctrl.Call(_m, "GetZone")
 	ret0, _ := ret[0].(string)
@@ -146,44 +551,336 @@ func (_mr *_MockAPIRecorder) GetZone() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "GetZone")
 }
 
-func (_m *MockAPI) ListInstanceGroupInstances(_param0 string) ([]*v1.InstanceWithNamedPorts, error) {
-	ret := _m.ctrl.Call(_m, "ListInstanceGroupInstances", _param0)
+func (_m *MockAPI) ListInstanceGroupInstances(_param0 context.Context, _param1 string) ([]*v1.InstanceWithNamedPorts, error) {
+	ret := _m.ctrl.Call(_m, "ListInstanceGroupInstances", _param0, _param1)
 	ret0, _ := ret[0].([]*v1.InstanceWithNamedPorts)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-func (_mr *_MockAPIRecorder) ListInstanceGroupInstances(arg0 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListInstanceGroupInstances", arg0)
+func (_mr *_MockAPIRecorder) ListInstanceGroupInstances(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListInstanceGroupInstances", arg0, arg1)
 }
 
-func (_m *MockAPI) ListInstances() ([]*v1.Instance, error) {
-	ret := _m.ctrl.Call(_m, "ListInstances")
+func (_m *MockAPI) ListInstanceGroupManagers(_param0 context.Context) ([]*v1.InstanceGroupManager, error) {
+	ret := _m.ctrl.Call(_m, "ListInstanceGroupManagers", _param0)
+	ret0, _ := ret[0].([]*v1.InstanceGroupManager)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) ListInstanceGroupManagers(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListInstanceGroupManagers", arg0)
+}
+
+func (_m *MockAPI) ListInstanceTemplates(_param0 context.Context, _param1 string) ([]*v1.InstanceTemplate, error) {
+	ret := _m.ctrl.Call(_m, "ListInstanceTemplates", _param0, _param1)
+	ret0, _ := ret[0].([]*v1.InstanceTemplate)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) ListInstanceTemplates(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListInstanceTemplates", arg0, arg1)
+}
+
+func (_m *MockAPI) ListInstances(_param0 context.Context, _param1 string) ([]*v1.Instance, error) {
+	ret := _m.ctrl.Call(_m, "ListInstances", _param0, _param1)
 	ret0, _ := ret[0].([]*v1.Instance)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-func (_mr *_MockAPIRecorder) ListInstances() *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListInstances")
+func (_mr *_MockAPIRecorder) ListInstances(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListInstances", arg0, arg1)
+}
+
+func (_m *MockAPI) ListMaintenanceOperations(_param0 context.Context, _param1 string, _param2 string) ([]*v1.Operation, error) {
+	ret := _m.ctrl.Call(_m, "ListMaintenanceOperations", _param0, _param1, _param2)
+	ret0, _ := ret[0].([]*v1.Operation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) ListMaintenanceOperations(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListMaintenanceOperations", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) ListManagedInstances(_param0 context.Context, _param1 string) ([]*v1.ManagedInstance, error) {
+	ret := _m.ctrl.Call(_m, "ListManagedInstances", _param0, _param1)
+	ret0, _ := ret[0].([]*v1.ManagedInstance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) ListManagedInstances(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListManagedInstances", arg0, arg1)
+}
+
+func (_m *MockAPI) ListRegionalInstanceGroupInstances(_param0 context.Context, _param1 string) ([]*v1.InstanceWithNamedPorts, error) {
+	ret := _m.ctrl.Call(_m, "ListRegionalInstanceGroupInstances", _param0, _param1)
+	ret0, _ := ret[0].([]*v1.InstanceWithNamedPorts)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) ListRegionalInstanceGroupInstances(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListRegionalInstanceGroupInstances", arg0, arg1)
+}
+
+func (_m *MockAPI) ListRegionalManagedInstances(_param0 context.Context, _param1 string) ([]*v1.ManagedInstance, error) {
+	ret := _m.ctrl.Call(_m, "ListRegionalManagedInstances", _param0, _param1)
+	ret0, _ := ret[0].([]*v1.ManagedInstance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) ListRegionalManagedInstances(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListRegionalManagedInstances", arg0, arg1)
+}
+
+func (_m *MockAPI) ListSnapshots(_param0 context.Context, _param1 string) ([]*v1.Snapshot, error) {
+	ret := _m.ctrl.Call(_m, "ListSnapshots", _param0, _param1)
+	ret0, _ := ret[0].([]*v1.Snapshot)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+func (_mr *_MockAPIRecorder) ListSnapshots(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ListSnapshots", arg0, arg1)
+}
+
+func (_m *MockAPI) RecreateInstances(_param0 context.Context, _param1 string, _param2 ...string) error {
+	_s := []interface{}{_param0, _param1}
+	for _, _x := range _param2 {
+		_s = append(_s, _x)
+	}
+	ret := _m.ctrl.Call(_m, "RecreateInstances", _s...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) RecreateInstances(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	_s := append([]interface{}{arg0, arg1}, arg2...)
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RecreateInstances", _s...)
+}
+
+func (_m *MockAPI) RecreateRegionalInstances(_param0 context.Context, _param1 string, _param2 ...string) error {
+	_s := []interface{}{_param0, _param1}
+	for _, _x := range _param2 {
+		_s = append(_s, _x)
+	}
+	ret := _m.ctrl.Call(_m, "RecreateRegionalInstances", _s...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) RecreateRegionalInstances(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	_s := append([]interface{}{arg0, arg1}, arg2...)
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RecreateRegionalInstances", _s...)
+}
+
+func (_m *MockAPI) ReleaseAddress(_param0 context.Context, _param1 string) error {
+	ret := _m.ctrl.Call(_m, "ReleaseAddress", _param0, _param1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) ReleaseAddress(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ReleaseAddress", arg0, arg1)
+}
+
+func (_m *MockAPI) RemoveInstanceFromTargetPool(_param0 context.Context, _param1 string, _param2 ...string) error {
+	_s := []interface{}{_param0, _param1}
+	for _, _x := range _param2 {
+		_s = append(_s, _x)
+	}
+	ret := _m.ctrl.Call(_m, "RemoveInstanceFromTargetPool", _s...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) RemoveInstanceFromTargetPool(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	_s := append([]interface{}{arg0, arg1}, arg2...)
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoveInstanceFromTargetPool", _s...)
+}
+
+func (_m *MockAPI) RemoveInstanceGroupFromBackendService(_param0 context.Context, _param1 string, _param2 string) error {
+	ret := _m.ctrl.Call(_m, "RemoveInstanceGroupFromBackendService", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) RemoveInstanceGroupFromBackendService(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "RemoveInstanceGroupFromBackendService", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) ReserveAddress(_param0 context.Context, _param1 string, _param2 *gcloud.AddressSettings) error {
+	ret := _m.ctrl.Call(_m, "ReserveAddress", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) ReserveAddress(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ReserveAddress", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) ResizeInstanceGroupManager(_param0 context.Context, _param1 string, _param2 int64) error {
+	ret := _m.ctrl.Call(_m, "ResizeInstanceGroupManager", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) ResizeInstanceGroupManager(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ResizeInstanceGroupManager", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) ResizeRegionalInstanceGroupManager(_param0 context.Context, _param1 string, _param2 int64) error {
+	ret := _m.ctrl.Call(_m, "ResizeRegionalInstanceGroupManager", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) ResizeRegionalInstanceGroupManager(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ResizeRegionalInstanceGroupManager", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) SetAutoHealingPolicy(_param0 context.Context, _param1 string, _param2 string, _param3 int64) error {
+	ret := _m.ctrl.Call(_m, "SetAutoHealingPolicy", _param0, _param1, _param2, _param3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) SetAutoHealingPolicy(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetAutoHealingPolicy", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockAPI) SetDeletionProtection(_param0 context.Context, _param1 string, _param2 string, _param3 bool) error {
+	ret := _m.ctrl.Call(_m, "SetDeletionProtection", _param0, _param1, _param2, _param3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) SetDeletionProtection(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetDeletionProtection", arg0, arg1, arg2, arg3)
+}
+
+func (_m *MockAPI) SetInstanceTags(_param0 context.Context, _param1 string, _param2 []string) error {
+	ret := _m.ctrl.Call(_m, "SetInstanceTags", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) SetInstanceTags(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetInstanceTags", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) SetInstanceTemplate(_param0 context.Context, _param1 string, _param2 string) error {
+	ret := _m.ctrl.Call(_m, "SetInstanceTemplate", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) SetInstanceTemplate(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetInstanceTemplate", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) SetNamedPorts(_param0 context.Context, _param1 string, _param2 []gcloud.NamedPort) error {
+	ret := _m.ctrl.Call(_m, "SetNamedPorts", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) SetNamedPorts(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetNamedPorts", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) SetRegionalInstanceTemplate(_param0 context.Context, _param1 string, _param2 string) error {
+	ret := _m.ctrl.Call(_m, "SetRegionalInstanceTemplate", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) SetRegionalInstanceTemplate(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetRegionalInstanceTemplate", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) SetRegionalNamedPorts(_param0 context.Context, _param1 string, _param2 []gcloud.NamedPort) error {
+	ret := _m.ctrl.Call(_m, "SetRegionalNamedPorts", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) SetRegionalNamedPorts(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetRegionalNamedPorts", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) SetRegionalTargetPools(_param0 context.Context, _param1 string, _param2 []string) error {
+	ret := _m.ctrl.Call(_m, "SetRegionalTargetPools", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) SetRegionalTargetPools(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetRegionalTargetPools", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) SetTargetPools(_param0 context.Context, _param1 string, _param2 []string) error {
+	ret := _m.ctrl.Call(_m, "SetTargetPools", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) SetTargetPools(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetTargetPools", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) StartRollingUpdate(_param0 context.Context, _param1 string, _param2 string, _param3 int, _param4 int) error {
+	ret := _m.ctrl.Call(_m, "StartRollingUpdate", _param0, _param1, _param2, _param3, _param4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) StartRollingUpdate(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "StartRollingUpdate", arg0, arg1, arg2, arg3, arg4)
 }
 
-func (_m *MockAPI) ResizeInstanceGroupManager(_param0 string, _param1 int64) error {
-	ret := _m.ctrl.Call(_m, "ResizeInstanceGroupManager", _param0, _param1)
+func (_m *MockAPI) UpdateAutoscaler(_param0 context.Context, _param1 string, _param2 *gcloud.AutoscalerSettings) error {
+	ret := _m.ctrl.Call(_m, "UpdateAutoscaler", _param0, _param1, _param2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockAPIRecorder) ResizeInstanceGroupManager(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "ResizeInstanceGroupManager", arg0, arg1)
+func (_mr *_MockAPIRecorder) UpdateAutoscaler(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateAutoscaler", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) UpdateHealthCheck(_param0 context.Context, _param1 string, _param2 *gcloud.HealthCheckSettings) error {
+	ret := _m.ctrl.Call(_m, "UpdateHealthCheck", _param0, _param1, _param2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) UpdateHealthCheck(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UpdateHealthCheck", arg0, arg1, arg2)
+}
+
+func (_m *MockAPI) UserAgent() string {
+	ret := _m.ctrl.Call(_m, "UserAgent")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+func (_mr *_MockAPIRecorder) UserAgent() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "UserAgent")
 }
 
-func (_m *MockAPI) SetInstanceTemplate(_param0 string, _param1 string) error {
-	ret := _m.ctrl.Call(_m, "SetInstanceTemplate", _param0, _param1)
+func (_m *MockAPI) ValidateNetwork(_param0 context.Context, _param1 string, _param2 string) error {
+	ret := _m.ctrl.Call(_m, "ValidateNetwork", _param0, _param1, _param2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-func (_mr *_MockAPIRecorder) SetInstanceTemplate(arg0, arg1 interface{}) *gomock.Call {
-	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetInstanceTemplate", arg0, arg1)
+func (_mr *_MockAPIRecorder) ValidateNetwork(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "ValidateNetwork", arg0, arg1, arg2)
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 
+	gcp_plugin "github.com/docker/infrakit.gcp/plugin"
 	"github.com/docker/infrakit.gcp/plugin/flavor"
 	"github.com/docker/infrakit/pkg/cli"
 	"github.com/docker/infrakit/pkg/discovery/local"
@@ -22,6 +23,7 @@ func main() {
 	logLevel := cmd.Flags().Int("log", cli.DefaultLogLevel, "Logging level. 0 is least verbose. Max is 5")
 	project := cmd.Flags().String("project", "", "Google Cloud project")
 	zone := cmd.Flags().String("zone", "", "Google Cloud zone")
+	gcloudOptions := gcp_plugin.GCloudOptions(cmd.Flags(), "flavor")
 	minAge := cmd.Flags().Duration("minAge", 0, "Min age to be considered healthy")
 
 	cmd.RunE = func(c *cobra.Command, args []string) error {
@@ -40,7 +42,9 @@ func main() {
 			return flavor_client.NewClient(n, endpoint.Address)
 		}
 
-		cli.RunPlugin(*name, flavor_client.PluginServer(flavor.NewPlugin(flavorPluginLookup, *project, *zone, *minAge)))
+		options := gcloudOptions()
+
+		cli.RunPlugin(*name, flavor_client.PluginServer(flavor.NewPlugin(flavorPluginLookup, *project, *zone, *minAge, options...)))
 
 		return nil
 	}
@@ -1,6 +1,7 @@
 package flavor
 
 import (
+	"context"
 	"errors"
 	"log"
 	"strings"
@@ -14,6 +15,9 @@ import (
 	"github.com/docker/infrakit/pkg/types"
 )
 
+// healthTimeout bounds the Compute API call made to check an instance's health.
+const healthTimeout = 1 * time.Minute
+
 // Spec is the model of the plugin Properties.
 type Spec struct {
 	Flavors []group_types.FlavorPlugin
@@ -26,8 +30,8 @@ type flavorCombo struct {
 }
 
 // NewPlugin creates a Flavor Combo plugin that chains multiple flavors in a sequence.
-func NewPlugin(flavorPlugins group.FlavorPluginLookup, project, zone string, minAge time.Duration) flavor.Plugin {
-	api, err := gcloud.NewAPI(project, zone)
+func NewPlugin(flavorPlugins group.FlavorPluginLookup, project, zone string, minAge time.Duration, options ...gcloud.Option) flavor.Plugin {
+	api, err := gcloud.NewAPI(project, zone, options...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -47,7 +51,10 @@ func (f flavorCombo) Validate(flavorProperties *types.Any, allocation group_type
 func (f flavorCombo) Healthy(flavorProperties *types.Any, inst instance.Description) (flavor.Health, error) {
 	name := string(inst.ID)
 
-	instance, err := f.API.GetInstance(name)
+	ctx, cancel := context.WithTimeout(context.Background(), healthTimeout)
+	defer cancel()
+
+	instance, err := f.API.GetInstance(ctx, name)
 	if err != nil {
 		return flavor.Unknown, err
 	}
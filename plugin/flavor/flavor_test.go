@@ -192,7 +192,7 @@ func TestHealthy(t *testing.T) {
 
 	for _, test := range tests {
 		api, _ := NewMockGCloud(t)
-		api.EXPECT().GetInstance("vm-1").Return(&compute.Instance{
+		api.EXPECT().GetInstance(gomock.Any(), "vm-1").Return(&compute.Instance{
 			Status:            test.status,
 			CreationTimestamp: test.creationTimestamp,
 		}, nil)
@@ -1,15 +1,24 @@
 package gcloud
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"cloud.google.com/go/compute/metadata"
 	log "github.com/Sirupsen/logrus"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
@@ -21,6 +30,16 @@ const (
 
 	// EnvZone is the environment variable that defines the default GCP zone
 	EnvZone = "CLOUDSDK_COMPUTE_ZONE"
+
+	// DefaultOperationTimeout is how long a mutating call waits for its operation to complete
+	DefaultOperationTimeout = 5 * time.Minute
+
+	// defaultPollInterval is the delay between the first two checks of an operation's status
+	defaultPollInterval = 1 * time.Second
+
+	// maxPollInterval caps the delay between two checks of an operation's status, which
+	// doubles after each check
+	maxPollInterval = 10 * time.Second
 )
 
 // API is the list of operations that can execute on Google Cloud Platform.
@@ -31,70 +50,426 @@ type API interface {
 	// GetZone returns the zone short name.
 	GetZone() string
 
-	// ListInstances lists the instances.
-	ListInstances() ([]*compute.Instance, error)
+	// UserAgent returns the User-Agent sent with the requests to the Compute API.
+	UserAgent() string
+
+	// GetRegion returns the region short name, used by the regional methods. It's
+	// the region of the zone unless another region is given.
+	GetRegion() string
+
+	// ListInstances lists the instances that match an optional filter expression.
+	ListInstances(ctx context.Context, filter string) ([]*compute.Instance, error)
+
+	// AggregatedListInstances lists the instances of every zone of the project that match an
+	// optional filter expression. Each instance's Zone tells which zone it belongs to.
+	AggregatedListInstances(ctx context.Context, filter string) ([]*compute.Instance, error)
 
 	// GetInstance find an instance by name.
-	GetInstance(name string) (*compute.Instance, error)
+	GetInstance(ctx context.Context, name string) (*compute.Instance, error)
+
+	// GetInstanceMaintenance returns how an instance of a zone of the project
+	// reacts to host maintenance, and the maintenance GCE scheduled on its host.
+	GetInstanceMaintenance(ctx context.Context, zone string, name string) (*InstanceMaintenance, error)
+
+	// BatchGetInstances finds instances by name with as few requests as
+	// possible. It returns the instances that were found, and the error for
+	// each name that couldn't be found.
+	BatchGetInstances(ctx context.Context, names []string) (map[string]*compute.Instance, map[string]error, error)
+
+	// GetInstanceInZone returns the details of an instance of another zone of the project.
+	GetInstanceInZone(ctx context.Context, zone string, name string) (*compute.Instance, error)
+
+	// ListMaintenanceOperations lists the operations that live migrated or
+	// terminated an instance of a zone of the project for a host maintenance.
+	ListMaintenanceOperations(ctx context.Context, zone string, name string) ([]*compute.Operation, error)
+
+	// GetSerialPortOutput returns the output of a serial port of an instance,
+	// 1 being the console.
+	GetSerialPortOutput(ctx context.Context, name string, port int64) (string, error)
+
+	// ValidateNetwork checks that a network exists and that a subnetwork, if any, belongs
+	// to this network and to the region of the zone. Custom mode networks need a subnetwork.
+	ValidateNetwork(ctx context.Context, network, subnetwork string) error
 
 	// CreateInstance creates an instance.
-	CreateInstance(name string, settings *InstanceSettings) error
+	CreateInstance(ctx context.Context, name string, settings *InstanceSettings) error
+
+	// CreateInstances sends the inserts of many instances, a few at a time,
+	// then waits for all of their operations together. It returns the error
+	// for each instance that couldn't be created.
+	CreateInstances(ctx context.Context, instances map[string]*InstanceSettings) map[string]error
+
+	// CreateInstanceInZone creates an instance in another zone of the project.
+	CreateInstanceInZone(ctx context.Context, zone string, name string, settings *InstanceSettings) error
+
+	// GetTargetPool returns a target pool of the region.
+	GetTargetPool(ctx context.Context, name string) (*compute.TargetPool, error)
+
+	// AddInstanceToTargetPool adds a list of instances to a target pool of the region,
+	// unless they are already members. Instances of other zones are given by their URL
+	// instead of their name.
+	AddInstanceToTargetPool(ctx context.Context, targetPool string, instances ...string) error
 
-	// AddInstanceToTargetPool adds a list of instances to a target pool.
-	AddInstanceToTargetPool(targetPool string, instances ...string) error
+	// AddInstanceMetadata replaces/adds metadata items to an instance. The
+	// update is retried once if the metadata changed concurrently.
+
+	// RemoveInstanceFromTargetPool removes a list of instances from a target pool of the
+	// region, if they are members. Instances of other zones are given by their URL.
+	RemoveInstanceFromTargetPool(ctx context.Context, targetPool string, instances ...string) error
 
 	// AddInstanceMetadata replaces/adds metadata items to an instance
-	AddInstanceMetadata(instanceName string, items []*compute.MetadataItems) error
+	AddInstanceMetadata(ctx context.Context, instanceName string, items []*compute.MetadataItems) error
+
+	// AddInstanceLabels replaces/adds labels to an instance. The update is
+	// retried once if the labels changed concurrently.
+	AddInstanceLabels(ctx context.Context, instanceName string, labels map[string]string) error
+
+	// SetInstanceTags replaces the network tags of an instance.
+	SetInstanceTags(ctx context.Context, instanceName string, tags []string) error
 
 	// DeleteInstance deletes an instance.
-	DeleteInstance(name string) error
+	DeleteInstance(ctx context.Context, name string) error
+
+	// DeleteInstances sends the deletes of many instances, a few at a time,
+	// then waits for all of their operations together. It returns the error
+	// for each instance that couldn't be deleted.
+	DeleteInstances(ctx context.Context, names []string) map[string]error
+
+	// DeleteInstanceInZone deletes an instance that belongs to another zone.
+	DeleteInstanceInZone(ctx context.Context, zone string, name string) error
+
+	// GetDeletionProtection tells if an instance of a given zone is protected
+	// against deletion.
+	GetDeletionProtection(ctx context.Context, zone string, name string) (bool, error)
+
+	// SetDeletionProtection protects, or stops protecting, an instance of a
+	// given zone against deletion.
+	SetDeletionProtection(ctx context.Context, zone string, name string, protected bool) error
+
+	// CreateDisk creates a standalone persistent disk, blank or from an image or a
+	// snapshot.
+	CreateDisk(ctx context.Context, name string, settings *PersistentDiskSettings) error
+
+	// DeleteDisk deletes a persistent disk. It fails with ErrInUse while the disk is
+	// attached to an instance.
+	DeleteDisk(ctx context.Context, name string) error
+
+	// AttachDisk attaches a persistent disk to an instance, under a device name.
+	AttachDisk(ctx context.Context, instanceName string, diskName string, deviceName string) error
+
+	// DetachDisk detaches the disk attached to an instance under a device name.
+	DetachDisk(ctx context.Context, instanceName string, deviceName string) error
+
+	// CreateSnapshot snapshots a persistent disk and waits for the snapshot to
+	// complete.
+	CreateSnapshot(ctx context.Context, diskName string, snapshotName string) error
+
+	// DeleteSnapshot deletes a snapshot.
+	DeleteSnapshot(ctx context.Context, name string) error
+
+	// ListSnapshots lists the snapshots of the project, optionally filtered.
+	ListSnapshots(ctx context.Context, filter string) ([]*compute.Snapshot, error)
 
 	// DeleteInstanceGroupManager deletes an instance group manager.
-	DeleteInstanceGroupManager(name string) error
+	DeleteInstanceGroupManager(ctx context.Context, name string) error
 
 	// DeleteInstanceTemplate deletes an instance template.
-	DeleteInstanceTemplate(name string) error
+	DeleteInstanceTemplate(ctx context.Context, name string) error
 
 	// ListInstanceGroupInstances lists the instances of an instance group found by its name.
-	ListInstanceGroupInstances(name string) ([]*compute.InstanceWithNamedPorts, error)
+	ListInstanceGroupInstances(ctx context.Context, name string) ([]*compute.InstanceWithNamedPorts, error)
+
+	// ListManagedInstances lists the instances of an instance group manager, with the
+	// action the manager is performing on each of them and their status.
+	ListManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error)
 
 	// CreateInstanceTemplate creates an instance template
-	CreateInstanceTemplate(name string, settings *InstanceSettings) error
+	CreateInstanceTemplate(ctx context.Context, name string, settings *InstanceSettings) error
+
+	// ListInstanceTemplates lists the instance templates that match an optional filter
+	// expression.
+	ListInstanceTemplates(ctx context.Context, filter string) ([]*compute.InstanceTemplate, error)
+
+	// GetInstanceTemplate returns an instance template, including its description and
+	// properties.
+	GetInstanceTemplate(ctx context.Context, name string) (*compute.InstanceTemplate, error)
 
 	// CreateInstanceGroupManager creates an instance group manager.
-	CreateInstanceGroupManager(name string, settings *InstanceManagerSettings) error
+	CreateInstanceGroupManager(ctx context.Context, name string, settings *InstanceManagerSettings) error
+
+	// GetInstanceGroupManager returns the details of an instance group manager, including its
+	// current actions.
+	GetInstanceGroupManager(ctx context.Context, name string) (*compute.InstanceGroupManager, error)
+
+	// ListInstanceGroupManagers lists the instance group managers of the zone, with their
+	// target size, current template and base instance name.
+	ListInstanceGroupManagers(ctx context.Context) ([]*compute.InstanceGroupManager, error)
+
+	// SetNamedPorts replaces the named ports of an instance group.
+	SetNamedPorts(ctx context.Context, name string, ports []NamedPort) error
+
+	// SetTargetPools replaces the target pools a group manager adds its instances to.
+	SetTargetPools(ctx context.Context, name string, targetPools []string) error
 
 	// SetInstanceTemplate sets the instance template used by a group manager.
-	SetInstanceTemplate(name string, templateName string) error
+	SetInstanceTemplate(ctx context.Context, name string, templateName string) error
+
+	// RecreateInstances recreates some instances of a group with the group's current template,
+	// and waits for the operation to complete. Instances are given by name or by url. When
+	// some of them can't be recreated, the error is an *OperationError that tells why.
+	RecreateInstances(ctx context.Context, name string, instances ...string) error
+
+	// StartRollingUpdate has a group manager replace its instances with a template,
+	// creating up to maxSurge extra instances and stopping up to maxUnavailable at a time.
+	StartRollingUpdate(ctx context.Context, name string, templateName string, maxSurge, maxUnavailable int) error
 
 	// ResizeInstanceGroupManager changes the target size of an instance group manager.
-	ResizeInstanceGroupManager(name string, targetSize int64) error
+	ResizeInstanceGroupManager(ctx context.Context, name string, targetSize int64) error
+
+	// CreateSpreadPlacementPolicy creates a resource policy of the region that spreads the
+	// instances it's attached to across distinct hosts, in a number of availability domains.
+	CreateSpreadPlacementPolicy(ctx context.Context, name string, availabilityDomains int64) error
+
+	// GetSpreadPlacementPolicy returns the number of availability domains of a spread
+	// placement policy of the region, or 0 if the resource policy isn't one.
+	GetSpreadPlacementPolicy(ctx context.Context, name string) (int64, error)
+
+	// DeleteResourcePolicy deletes a resource policy of the region.
+	DeleteResourcePolicy(ctx context.Context, name string) error
+
+	// AbandonInstances removes some instances from a group without deleting them, and
+	// decreases its target size accordingly. Instances are given by name or by url.
+	AbandonInstances(ctx context.Context, name string, instances ...string) error
+
+	// DeleteGroupInstances deletes some instances of a group, and decreases its target size
+	// accordingly. Instances are given by name or by url.
+	DeleteGroupInstances(ctx context.Context, name string, instances ...string) error
+
+	// CreateAutoscaler creates an autoscaler for the instance group manager of the same name.
+	CreateAutoscaler(ctx context.Context, name string, settings *AutoscalerSettings) error
+
+	// GetAutoscaler returns an autoscaler, including its policy.
+	GetAutoscaler(ctx context.Context, name string) (*compute.Autoscaler, error)
+
+	// UpdateAutoscaler changes the policy of an autoscaler.
+	UpdateAutoscaler(ctx context.Context, name string, settings *AutoscalerSettings) error
+
+	// DeleteAutoscaler deletes an autoscaler. The size of its group is left as is.
+	DeleteAutoscaler(ctx context.Context, name string) error
+
+	// CreateHealthCheck creates an HTTP, HTTPS or TCP health check.
+	CreateHealthCheck(ctx context.Context, name string, settings *HealthCheckSettings) error
+
+	// UpdateHealthCheck replaces the settings of a health check.
+	UpdateHealthCheck(ctx context.Context, name string, settings *HealthCheckSettings) error
+
+	// DeleteHealthCheck deletes a health check.
+	DeleteHealthCheck(ctx context.Context, name string) error
+
+	// SetAutoHealingPolicy makes an instance group manager recreate the instances that fail
+	// a health check, after an initial delay. An empty health check disables autohealing.
+	SetAutoHealingPolicy(ctx context.Context, name string, healthCheck string, initialDelaySec int64) error
+
+	// CreateRegionalInstanceGroupManager creates an instance group manager that spreads
+	// its instances across the zones of the region.
+	CreateRegionalInstanceGroupManager(ctx context.Context, name string, settings *InstanceManagerSettings) error
+
+	// GetRegionalInstanceGroupManager returns the details of a regional instance group
+	// manager, including its current actions.
+	GetRegionalInstanceGroupManager(ctx context.Context, name string) (*compute.InstanceGroupManager, error)
+
+	// ListRegionalInstanceGroupInstances lists the instances of a regional instance group,
+	// in all the zones of the region.
+	ListRegionalInstanceGroupInstances(ctx context.Context, name string) ([]*compute.InstanceWithNamedPorts, error)
+
+	// ListRegionalManagedInstances lists the instances of a regional instance group
+	// manager, with their current action and status.
+	ListRegionalManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error)
+
+	// SetRegionalNamedPorts replaces the named ports of a regional instance group.
+	SetRegionalNamedPorts(ctx context.Context, name string, ports []NamedPort) error
+
+	// SetRegionalTargetPools replaces the target pools a regional group manager adds its
+	// instances to.
+	SetRegionalTargetPools(ctx context.Context, name string, targetPools []string) error
+
+	// SetRegionalInstanceTemplate sets the instance template used by a regional group manager.
+	SetRegionalInstanceTemplate(ctx context.Context, name string, templateName string) error
+
+	// RecreateRegionalInstances recreates some instances of a regional group with the
+	// group's current template. Instances are given by url, since their zone can't be
+	// guessed from their name.
+	RecreateRegionalInstances(ctx context.Context, name string, instances ...string) error
+
+	// ResizeRegionalInstanceGroupManager changes the target size of a regional instance
+	// group manager.
+	ResizeRegionalInstanceGroupManager(ctx context.Context, name string, targetSize int64) error
+
+	// DeleteRegionalInstanceGroupManager deletes a regional instance group manager.
+	DeleteRegionalInstanceGroupManager(ctx context.Context, name string) error
+
+	// AddInstanceGroupToBackendService makes an instance group a backend of a backend
+	// service, if it isn't already. A drainingTimeoutSec greater than zero sets the
+	// connection draining timeout of the backend service. Instance groups of the zone
+	// are given by their name, and the others by their relative url, eg.
+	// regions/<region>/instanceGroups/<name>.
+	AddInstanceGroupToBackendService(ctx context.Context, backendService string, instanceGroup string, drainingTimeoutSec int64) error
+
+	// RemoveInstanceGroupFromBackendService removes an instance group from the backends of
+	// a backend service.
+	RemoveInstanceGroupFromBackendService(ctx context.Context, backendService string, instanceGroup string) error
+
+	// ReserveAddress reserves a static IP address in the region. Reserving an address
+	// that already exists with the same settings succeeds, and fails with ErrConflict
+	// if the settings differ.
+	ReserveAddress(ctx context.Context, name string, settings *AddressSettings) error
+
+	// GetAddress returns a static IP address of the region.
+	GetAddress(ctx context.Context, name string) (*Address, error)
+
+	// ReleaseAddress releases a static IP address of the region. It fails with ErrInUse
+	// while an instance uses the address.
+	ReleaseAddress(ctx context.Context, name string) error
+
+	// CreateFirewallRule creates a firewall rule that allows ingress traffic. A rule that
+	// already exists with the same settings is left as is, one with other settings fails
+	// with ErrConflict.
+	CreateFirewallRule(ctx context.Context, name string, settings *FirewallSettings) error
+
+	// DeleteFirewallRule deletes a firewall rule.
+	DeleteFirewallRule(ctx context.Context, name string) error
 }
 
 // InstanceSettings lists the characteristics of a VM instance.
 type InstanceSettings struct {
-	Description string
-	MachineType string
-	Network     string
-	Subnetwork  string
-	PrivateIP   string
-	Tags        []string
-	Scopes      []string
-	Disks       []DiskSettings
-	Preemptible bool
-	MetaData    []*compute.MetadataItems
-}
-
-// DiskSettings lists the characteristics of an attached disk.
+	Description  string
+	MachineType  string
+	Network      string
+	Subnetwork   string
+	PrivateIP    string
+	NoExternalIP bool
+	StaticIP     string
+	Tags         []string
+	Scopes       []string
+	Disks        []DiskSettings
+	Preemptible  bool
+	MetaData     []*compute.MetadataItems
+
+	// AutomaticRestart restarts the instance when GCE stops it. Nil means true,
+	// or false for preemptible instances, which can't be restarted.
+	AutomaticRestart *bool
+
+	// OnHostMaintenance is MIGRATE, to live migrate the instance when its host
+	// is maintained, or TERMINATE. It defaults to MIGRATE, except for the
+	// preemptible instances and the instances with accelerators, which can't
+	// be live migrated.
+	OnHostMaintenance string
+
+	// Accelerators are the GPUs attached to the instance.
+	Accelerators []Accelerator
+
+	// Labels are set on the instance, or on the instances created from a
+	// template. The list filters can match them, unlike the metadata.
+	Labels map[string]string
+
+	// ResourcePolicies are the names of the resource policies of the region,
+	// for example spread placement policies, attached to the instance.
+	ResourcePolicies []string
+
+	// SourceInstance names an existing instance that templates are created
+	// from. Its settings, metadata and disks replace all the others.
+	SourceInstance string
+
+	// TemplateDescription describes the instance templates created with the
+	// settings, when it differs from the description of their instances.
+	TemplateDescription string
+
+	// MinCPUPlatform is the oldest CPU platform the instance can run on, for
+	// example "Intel Skylake". Empty lets GCE pick any platform of the zone.
+	MinCPUPlatform string
+
+	// NetworkTier is the tier of the external IP, PREMIUM or STANDARD. Empty
+	// means the default tier of the project.
+	NetworkTier string
+
+	// ServiceAccount is the email of the service account the instance runs
+	// as, with the Scopes. Empty means the default compute service account.
+	ServiceAccount string
+
+	// CanIPForward lets the instance send and receive packets for other
+	// addresses than its own, as NAT gateways and routers do. It can only be
+	// set when the instance is created.
+	CanIPForward bool
+
+	// DeletionProtection keeps the instance from being deleted until the
+	// protection is cleared. Instance templates don't support it.
+	DeletionProtection bool
+
+	// Shielded turns on the Shielded VM features. The boot disk image must
+	// support them. Nil leaves them to the defaults of GCE.
+	Shielded *ShieldedSettings
+
+	// CreationTimeoutSeconds bounds how long CreateInstance waits for the
+	// instance to be created. Zero means the API's operation timeout.
+	CreationTimeoutSeconds int64
+}
+
+// Accelerator is a number of GPUs of a given type, for example
+// "nvidia-tesla-t4", attached to an instance.
+type Accelerator struct {
+	Type  string
+	Count int64
+}
+
+// ShieldedSettings lists the Shielded VM features of an instance.
+type ShieldedSettings struct {
+	SecureBoot          bool
+	VTPM                bool
+	IntegrityMonitoring bool
+}
+
+// PersistentDiskSettings lists the characteristics of a standalone persistent
+// disk. The disk is blank unless an Image or a Snapshot is given.
+type PersistentDiskSettings struct {
+	SizeGb   int64
+	Type     string
+	Image    string
+	Snapshot string
+}
+
+// DiskSettings lists the characteristics of an attached disk. A disk is created
+// from an Image, from a Snapshot or blank. Additional disks need a NameSuffix
+// to have a name of their own. The DeviceName exposes a disk in the guest as
+// /dev/disk/by-id/google-DEVICENAME.
 type DiskSettings struct {
 	Boot          bool
 	Type          string
 	Mode          string
 	SizeGb        int64
 	Image         string
+	Snapshot      string
 	AutoDelete    bool
 	ReuseExisting bool
 	NameSuffix    string
+	DeviceName    string
+}
+
+// InstanceMaintenance is the maintenance policy of an instance, MIGRATE or
+// TERMINATE, and the maintenance GCE scheduled on its host, nil when none is.
+type InstanceMaintenance struct {
+	OnHostMaintenance string
+	Upcoming          *UpcomingMaintenance
+}
+
+// UpcomingMaintenance is a maintenance GCE scheduled on the host of an
+// instance. Status is PENDING, or ONGOING once the window started. The window
+// is given in RFC3339 format.
+type UpcomingMaintenance struct {
+	Type          string
+	Status        string
+	WindowStart   string
+	WindowEnd     string
+	CanReschedule bool
 }
 
 // InstanceManagerSettings the characteristics of a VM instance template manager.
@@ -104,16 +479,251 @@ type InstanceManagerSettings struct {
 	TargetSize       int64
 	TargetPools      []string
 	BaseInstanceName string
+
+	// Zones restricts the zones of the region across which a regional group
+	// spreads its instances. All the zones of the region are used if empty.
+	Zones []string
+}
+
+// NamedPort maps a name to a port of the instances of a group. Backend
+// services reach the instances of a group by port name.
+type NamedPort struct {
+	Name string
+	Port int64
+}
+
+// HealthCheckSettings the protocol, port and thresholds of a health check. Zero values
+// keep the defaults of the Compute API.
+type HealthCheckSettings struct {
+	Protocol           string
+	Port               int64
+	RequestPath        string
+	CheckIntervalSec   int64
+	TimeoutSec         int64
+	HealthyThreshold   int64
+	UnhealthyThreshold int64
+}
+
+// AddressSettings lists the characteristics of a static IP address. An
+// external address is reserved unless Internal is set, in which case the
+// address is taken from the Subnetwork, or from the default subnetwork.
+type AddressSettings struct {
+	Internal   bool
+	Subnetwork string
+}
+
+// FirewallSettings the network, sources, targets and allowed ports of a firewall rule.
+// Allowed ports are given as protocol[:ports], for example tcp:2377, udp:7946,
+// tcp:8000-8100 or icmp. A rule without source ranges allows every source, and one
+// without target tags applies to all the instances of the network.
+type FirewallSettings struct {
+	Network      string
+	SourceRanges []string
+	TargetTags   []string
+	AllowedPorts []string
+}
+
+// AddressStatus is the status of a static IP address.
+type AddressStatus string
+
+const (
+	// AddressReserving is the status of an address being reserved.
+	AddressReserving AddressStatus = "RESERVING"
+
+	// AddressReserved is the status of a reserved address that no resource uses.
+	AddressReserved AddressStatus = "RESERVED"
+
+	// AddressInUse is the status of an address used by a resource, for example an
+	// instance.
+	AddressInUse AddressStatus = "IN_USE"
+)
+
+// Address is a static IP address. Users are the urls of the resources that use it.
+type Address struct {
+	Name       string
+	Address    string
+	Region     string
+	Internal   bool
+	Subnetwork string
+	Status     AddressStatus
+	Users      []string
+}
+
+// AutoscalerSettings the size limits and the CPU utilization target of an autoscaler.
+type AutoscalerSettings struct {
+	MinReplicas    int64
+	MaxReplicas    int64
+	CPUUtilization float64
 }
 
 type computeServiceWrapper struct {
-	project string
-	zone    string
-	service *compute.Service
+	project          string
+	zone             string
+	service          *compute.Service
+	operationTimeout time.Duration
+	readTimeout      time.Duration
+	mutationTimeout  time.Duration
+	pollInterval     time.Duration
+	credentialsFile  string
+	endpoint         string
+	userAgent        string
+	skipVerify       bool
+	client           *http.Client
+	recorder         *Recorder
+	dryRun           *DryRun
+	maxListResults   int
+
+	readsPerSecond     float64
+	mutationsPerSecond float64
+
+	hook Hook
+
+	// regionName overrides the region of the zone, for the regional methods.
+	regionName string
+}
+
+// Option customizes the API created by NewAPI.
+type Option func(*computeServiceWrapper)
+
+// WithOperationTimeout sets how long mutating calls wait for their operation
+// to complete before giving up.
+func WithOperationTimeout(timeout time.Duration) Option {
+	return func(g *computeServiceWrapper) {
+		g.operationTimeout = timeout
+	}
+}
+
+// WithPollInterval sets how long mutating calls first wait between two polls
+// of their operation. The interval then backs off up to 10s.
+func WithPollInterval(interval time.Duration) Option {
+	return func(g *computeServiceWrapper) {
+		g.pollInterval = interval
+	}
+}
+
+// WithCallTimeouts bounds how long a call waits for the response of the
+// Compute API, with separate bounds for reads and for mutations. Zero means no
+// bound.
+func WithCallTimeouts(read, mutation time.Duration) Option {
+	return func(g *computeServiceWrapper) {
+		g.readTimeout = read
+		g.mutationTimeout = mutation
+	}
+}
+
+// WithCredentialsFile authenticates with a service account JSON key file
+// instead of the Application Default Credentials. An empty path keeps the
+// default behaviour.
+func WithCredentialsFile(path string) Option {
+	return func(g *computeServiceWrapper) {
+		g.credentialsFile = path
+	}
+}
+
+// WithEndpoint sends the requests to another Compute API endpoint, such as an
+// emulator or a recording proxy. The endpoint replaces
+// https://www.googleapis.com/compute/v1/projects/. An empty endpoint keeps the
+// default behaviour.
+func WithEndpoint(endpoint string) Option {
+	return func(g *computeServiceWrapper) {
+		g.endpoint = endpoint
+	}
+}
+
+// WithUserAgent identifies the caller in the User-Agent sent with the requests,
+// for example "infrakit.gcp/v0.5.0 (instance)". It's appended to the
+// User-Agent of the Google API client.
+func WithUserAgent(userAgent string) Option {
+	return func(g *computeServiceWrapper) {
+		g.userAgent = userAgent
+	}
+}
+
+// WithInsecureSkipVerify disables the verification of the endpoint's TLS
+// certificate. It should only be used to test against a local endpoint.
+func WithInsecureSkipVerify() Option {
+	return func(g *computeServiceWrapper) {
+		g.skipVerify = true
+	}
+}
+
+// WithHTTPClient sends the requests through the given client, as is. No
+// credentials are added to the requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(g *computeServiceWrapper) {
+		g.client = client
+	}
+}
+
+// WithRecorder records the exchanges with the Compute API, for example to
+// create the fixtures of a Replayer.
+func WithRecorder(recorder *Recorder) Option {
+	return func(g *computeServiceWrapper) {
+		g.recorder = recorder
+	}
+}
+
+// WithReplayer answers the calls with recorded exchanges instead of calling
+// the Compute API. No credentials are needed.
+func WithReplayer(replayer *Replayer) Option {
+	return WithHTTPClient(&http.Client{Transport: replayer})
+}
+
+// WithDryRun logs and records the mutations as intents instead of sending
+// them. Reads still go to the Compute API.
+func WithDryRun(dryRun *DryRun) Option {
+	return func(g *computeServiceWrapper) {
+		g.dryRun = dryRun
+	}
+}
+
+// WithMaxListResults fails the list calls that return more than max items,
+// instead of paging through them without bounds. Zero means no limit.
+func WithMaxListResults(max int) Option {
+	return func(g *computeServiceWrapper) {
+		g.maxListResults = max
+	}
+}
+
+// WithRateLimits throttles the calls to the Compute API, with separate limits
+// for reads and for mutations, in calls per second. Calls over the limit wait
+// instead of failing. Zero means no limit.
+func WithRateLimits(readsPerSecond, mutationsPerSecond float64) Option {
+	return func(g *computeServiceWrapper) {
+		g.readsPerSecond = readsPerSecond
+		g.mutationsPerSecond = mutationsPerSecond
+	}
+}
+
+// WithRegion sets the region of the regional methods, instead of the region
+// of the zone. No zone is required then, as long as only regional methods and
+// methods taking a zone are used.
+func WithRegion(region string) Option {
+	return func(g *computeServiceWrapper) {
+		g.regionName = region
+	}
+}
+
+// WithHook calls the hook after every API call, for instance to record the
+// latency and the errors of the Compute API. See ExpvarHook.
+func WithHook(hook Hook) Option {
+	return func(g *computeServiceWrapper) {
+		g.hook = hook
+	}
 }
 
 // NewAPI creates a new API instance.
-func NewAPI(project, zone string) (API, error) {
+func NewAPI(project, zone string, options ...Option) (API, error) {
+	wrapper := &computeServiceWrapper{
+		operationTimeout: DefaultOperationTimeout,
+		readTimeout:      DefaultReadTimeout,
+		mutationTimeout:  DefaultMutationTimeout,
+		pollInterval:     defaultPollInterval,
+	}
+	for _, option := range options {
+		option(wrapper)
+	}
+
 	if project == "" {
 		log.Debugln("Project not passed on the command line")
 
@@ -123,7 +733,7 @@ func NewAPI(project, zone string) (API, error) {
 		}
 	}
 
-	if zone == "" {
+	if zone == "" && wrapper.regionName == "" {
 		log.Debugln("Zone not passed on the command line")
 
 		zone = findZone()
@@ -134,27 +744,98 @@ func NewAPI(project, zone string) (API, error) {
 
 	log.Debugln("Project:", project)
 	log.Debugln("Zone:", zone)
+	if wrapper.regionName != "" {
+		log.Debugln("Region:", wrapper.regionName)
+	}
 
-	serviceProvider := func() (*compute.Service, error) {
-		client, err := google.DefaultClient(context.TODO(), compute.ComputeScope)
-		if err != nil {
-			return nil, err
-		}
+	wrapper.project = project
+	wrapper.zone = zone
+
+	client, err := wrapper.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	if wrapper.dryRun != nil {
+		log.Warningln("Dry run: the mutations of the Compute API are not sent")
 
-		return compute.New(client)
+		client = wrapper.dryRun.wrap(client)
+	}
+	if wrapper.recorder != nil {
+		client = wrapper.recorder.wrap(client)
 	}
+	if wrapper.readsPerSecond > 0 || wrapper.mutationsPerSecond > 0 {
+		log.Infof("Rate limits: %g reads/s, %g mutations/s (0 means unlimited)", wrapper.readsPerSecond, wrapper.mutationsPerSecond)
+	}
+	client = wrapper.rateLimited(wrapper.timeLimited(client))
+	wrapper.client = client
+
+	wrapper.client = client
 
 	// Check that everything works
-	service, err := serviceProvider()
+	service, err := compute.New(client)
 	if err != nil {
 		return nil, err
 	}
+	if wrapper.endpoint != "" {
+		log.Debugln("Endpoint:", wrapper.endpoint)
 
-	return &computeServiceWrapper{
-		project: project,
-		zone:    zone,
-		service: service,
-	}, nil
+		service.BasePath = strings.TrimSuffix(wrapper.endpoint, "/") + "/"
+	}
+	service.UserAgent = wrapper.userAgent
+	wrapper.service = service
+
+	if wrapper.hook != nil {
+		return Instrument(wrapper, wrapper.hook), nil
+	}
+
+	return wrapper, nil
+}
+
+func (g *computeServiceWrapper) httpClient() (*http.Client, error) {
+	if g.client != nil {
+		return g.client, nil
+	}
+
+	ctx := context.TODO()
+	if g.skipVerify {
+		log.Warningln("TLS verification of the Compute API endpoint is disabled")
+
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		})
+	}
+
+	if g.credentialsFile == "" {
+		log.Debugln("Using Application Default Credentials")
+
+		return google.DefaultClient(ctx, compute.ComputeScope)
+	}
+
+	log.Debugln("Using credentials file", g.credentialsFile)
+
+	data, err := ioutil.ReadFile(g.credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read credentials file %s: %v", g.credentialsFile, err)
+	}
+
+	key := struct {
+		ProjectID string `json:"project_id"`
+	}{}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("Invalid credentials file %s: %v", g.credentialsFile, err)
+	}
+	if key.ProjectID != "" && key.ProjectID != g.project {
+		return nil, fmt.Errorf("Credentials file %s is for project %s, not %s", g.credentialsFile, key.ProjectID, g.project)
+	}
+
+	config, err := google.JWTConfigFromJSON(data, compute.ComputeScope)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid credentials file %s: %v", g.credentialsFile, err)
+	}
+
+	return config.Client(ctx), nil
 }
 
 func findProject() string {
@@ -205,19 +886,38 @@ func (g *computeServiceWrapper) GetZone() string {
 	return g.zone
 }
 
-func (g *computeServiceWrapper) ListInstances() ([]*compute.Instance, error) {
+func (g *computeServiceWrapper) GetRegion() string {
+	return g.region()
+}
+
+func (g *computeServiceWrapper) UserAgent() string {
+	if g.userAgent == "" {
+		return googleapi.UserAgent
+	}
+	return googleapi.UserAgent + " " + g.userAgent
+}
+
+func (g *computeServiceWrapper) ListInstances(ctx context.Context, filter string) ([]*compute.Instance, error) {
 	items := []*compute.Instance{}
 
 	pageToken := ""
 	for {
-		list, err := g.service.Instances.List(g.project, g.zone).PageToken(pageToken).Do()
+		call := g.service.Instances.List(g.project, g.zone).PageToken(pageToken)
+		if filter != "" {
+			call = call.Filter(filter)
+		}
+
+		list, err := call.Context(ctx).Do()
 		if err != nil {
-			return nil, err
+			return nil, callError("ListInstances", err)
 		}
 
 		for i := range list.Items {
 			items = append(items, list.Items[i])
 		}
+		if err := g.checkListResults(len(items)); err != nil {
+			return nil, callError("ListInstances", err)
+		}
 
 		pageToken = list.NextPageToken
 		if pageToken == "" {
@@ -228,79 +928,583 @@ func (g *computeServiceWrapper) ListInstances() ([]*compute.Instance, error) {
 	return items, nil
 }
 
-func (g *computeServiceWrapper) GetInstance(name string) (*compute.Instance, error) {
-	return g.service.Instances.Get(g.project, g.zone, name).Do()
-}
+func (g *computeServiceWrapper) AggregatedListInstances(ctx context.Context, filter string) ([]*compute.Instance, error) {
+	items := []*compute.Instance{}
 
-func (g *computeServiceWrapper) addAPIUrlPrefix(value string, prefix string) string {
-	if value == "" {
-		return ""
-	}
-	if strings.HasPrefix(value, g.service.BasePath+prefix) {
-		return value
-	}
-	if strings.HasPrefix(value, prefix) {
-		return g.service.BasePath + value
+	pageToken := ""
+	for {
+		call := g.service.Instances.AggregatedList(g.project).PageToken(pageToken)
+		if filter != "" {
+			call = call.Filter(filter)
+		}
+
+		list, err := call.Context(ctx).Do()
+		if err != nil {
+			return nil, callError("AggregatedListInstances", err)
+		}
+
+		scopes := []string{}
+		for scope := range list.Items {
+			scopes = append(scopes, scope)
+		}
+		sort.Strings(scopes)
+
+		for _, scope := range scopes {
+			items = append(items, list.Items[scope].Instances...)
+		}
+		if err := g.checkListResults(len(items)); err != nil {
+			return nil, callError("AggregatedListInstances", err)
+		}
+
+		pageToken = list.NextPageToken
+		if pageToken == "" {
+			break
+		}
 	}
-	return g.service.BasePath + prefix + value
+
+	return items, nil
 }
 
-func (g *computeServiceWrapper) CreateInstance(name string, settings *InstanceSettings) error {
-	machineType := g.addAPIUrlPrefix(settings.MachineType, g.project+"/zones/"+g.zone+"/machineTypes/")
-	network := g.addAPIUrlPrefix(settings.Network, g.project+"/global/networks/")
-	subnetwork := g.addAPIUrlPrefix(settings.Subnetwork, g.project+"/regions/"+g.region()+"/subnetworks/")
+func (g *computeServiceWrapper) GetInstance(ctx context.Context, name string) (*compute.Instance, error) {
+	instance, err := g.service.Instances.Get(g.project, g.zone, name).Context(ctx).Do()
+	return instance, callError("GetInstance", err)
+}
 
-	disks, err := g.attachedDisks(name, settings.Disks)
-	if err != nil {
-		return err
+// GetInstanceMaintenance reads the instance with a raw call, since the
+// compute client doesn't know the upcoming maintenance of the instances.
+func (g *computeServiceWrapper) GetInstanceMaintenance(ctx context.Context, zone string, name string) (*InstanceMaintenance, error) {
+	current := struct {
+		Scheduling struct {
+			OnHostMaintenance string `json:"onHostMaintenance"`
+		} `json:"scheduling"`
+		ResourceStatus struct {
+			UpcomingMaintenance *struct {
+				Type              string `json:"type"`
+				MaintenanceStatus string `json:"maintenanceStatus"`
+				WindowStartTime   string `json:"windowStartTime"`
+				WindowEndTime     string `json:"windowEndTime"`
+				CanReschedule     bool   `json:"canReschedule"`
+			} `json:"upcomingMaintenance"`
+		} `json:"resourceStatus"`
+	}{}
+	if err := g.send(ctx, "GET", g.project+"/zones/"+zone+"/instances/"+name, nil, &current); err != nil {
+		return nil, callError("GetInstanceMaintenance", err)
 	}
 
-	instance := &compute.Instance{
-		Name:        name,
-		Description: settings.Description,
-		MachineType: machineType,
-		Tags: &compute.Tags{
-			Items: settings.Tags,
-		},
-		Disks: disks,
-		NetworkInterfaces: []*compute.NetworkInterface{
-			{
-				Network:    network,
-				Subnetwork: subnetwork,
-				NetworkIP:  settings.PrivateIP,
-				AccessConfigs: []*compute.AccessConfig{
-					{
-						Type: "ONE_TO_ONE_NAT",
-					},
-				},
-			},
-		},
-		Metadata: &compute.Metadata{
-			Items: settings.MetaData,
-		},
-		ServiceAccounts: []*compute.ServiceAccount{
-			{
-				Email:  "default",
-				Scopes: settings.Scopes,
-			},
-		},
-		Scheduling: &compute.Scheduling{
-			AutomaticRestart:  true,
-			OnHostMaintenance: "MIGRATE",
-			Preemptible:       settings.Preemptible,
-		},
+	maintenance := &InstanceMaintenance{OnHostMaintenance: current.Scheduling.OnHostMaintenance}
+	if upcoming := current.ResourceStatus.UpcomingMaintenance; upcoming != nil {
+		maintenance.Upcoming = &UpcomingMaintenance{
+			Type:          upcoming.Type,
+			Status:        upcoming.MaintenanceStatus,
+			WindowStart:   upcoming.WindowStartTime,
+			WindowEnd:     upcoming.WindowEndTime,
+			CanReschedule: upcoming.CanReschedule,
+		}
 	}
 
-	return g.doCall(g.service.Instances.Insert(g.project, g.zone, instance))
+	return maintenance, nil
 }
 
-func (g *computeServiceWrapper) attachedDisks(instanceName string, disksSettings []DiskSettings) ([]*compute.AttachedDisk, error) {
-	disks := []*compute.AttachedDisk{}
+func (g *computeServiceWrapper) GetInstanceInZone(ctx context.Context, zone string, name string) (*compute.Instance, error) {
+	instance, err := g.service.Instances.Get(g.project, zone, name).Context(ctx).Do()
+	return instance, callError("GetInstanceInZone", err)
+}
 
-	for _, diskSettings := range disksSettings {
-		disk, err := g.attachedDisk(instanceName, diskSettings)
+// maintenanceOperations matches the types of the operations GCE runs on the
+// instances of a host under maintenance.
+const maintenanceOperations = `compute\.instances\.(migrate|terminate)OnHostMaintenance`
+
+func (g *computeServiceWrapper) ListMaintenanceOperations(ctx context.Context, zone string, name string) ([]*compute.Operation, error) {
+	items := []*compute.Operation{}
+
+	pageToken := ""
+	for {
+		list, err := g.service.ZoneOperations.List(g.project, zone).Filter("operationType eq " + maintenanceOperations).PageToken(pageToken).Context(ctx).Do()
 		if err != nil {
-			return nil, err
+			return nil, callError("ListMaintenanceOperations", err)
+		}
+
+		for _, op := range list.Items {
+			if last(op.TargetLink) == name {
+				items = append(items, op)
+			}
+		}
+
+		pageToken = list.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	return items, nil
+}
+
+func (g *computeServiceWrapper) GetSerialPortOutput(ctx context.Context, name string, port int64) (string, error) {
+	output, err := g.service.Instances.GetSerialPortOutput(g.project, g.zone, name).Port(port).Context(ctx).Do()
+	if err != nil {
+		return "", callError("GetSerialPortOutput", err)
+	}
+
+	return output.Contents, nil
+}
+
+// imageURL resolves an image given by url, by path, either
+// projects/IMAGE_PROJECT/global/images/NAME or IMAGE_PROJECT/global/images/NAME,
+// or by name in the project.
+func (g *computeServiceWrapper) imageURL(image string) string {
+	switch {
+	case image == "":
+		return ""
+	case strings.Contains(image, "://"):
+		return image
+	case strings.HasPrefix(image, "projects/"):
+		return g.service.BasePath + strings.TrimPrefix(image, "projects/")
+	case strings.Contains(image, "/"):
+		return g.service.BasePath + image
+	}
+
+	return g.service.BasePath + g.project + "/global/images/" + image
+}
+
+// networkURL resolves a network given by url, by path, for example
+// projects/HOST_PROJECT/global/networks/NAME for a shared VPC, or by name in
+// the project.
+func (g *computeServiceWrapper) networkURL(network string) string {
+	return g.projectResourceURL(network, "global/networks/")
+}
+
+// subnetworkURL resolves a subnetwork given by url, by path, either
+// projects/PROJECT/regions/REGION/subnetworks/NAME or
+// regions/REGION/subnetworks/NAME, or by name in the region of the zone.
+func (g *computeServiceWrapper) subnetworkURL(subnetwork string) string {
+	return g.projectResourceURL(subnetwork, "regions/"+g.region()+"/subnetworks/")
+}
+
+// projectResourceURL resolves a resource given by url, by path, with or
+// without its project, or by name in a collection of the project.
+func (g *computeServiceWrapper) projectResourceURL(value, collection string) string {
+	switch {
+	case value == "":
+		return ""
+	case strings.Contains(value, "://"):
+		return value
+	case strings.HasPrefix(value, "projects/"):
+		return g.service.BasePath + strings.TrimPrefix(value, "projects/")
+	case strings.HasPrefix(value, g.project+"/"):
+		return g.service.BasePath + value
+	case strings.Contains(value, "/"):
+		return g.service.BasePath + g.project + "/" + value
+	}
+
+	return g.service.BasePath + g.project + "/" + collection + value
+}
+
+func (g *computeServiceWrapper) addAPIUrlPrefix(value string, prefix string) string {
+	if value == "" {
+		return ""
+	}
+	if strings.HasPrefix(value, g.service.BasePath+prefix) {
+		return value
+	}
+	if strings.HasPrefix(value, prefix) {
+		return g.service.BasePath + value
+	}
+	return g.service.BasePath + prefix + value
+}
+
+func (g *computeServiceWrapper) ValidateNetwork(ctx context.Context, network, subnetwork string) error {
+	networkName := last(network)
+	net, err := g.service.Networks.Get(resourceProject(network, g.project), networkName).Context(ctx).Do()
+	if err != nil {
+		return callError("ValidateNetwork", fmt.Errorf("Unable to find network %s: %w", networkName, err))
+	}
+
+	if subnetwork == "" {
+		// Legacy networks have a range and no subnetworks. Auto mode networks
+		// have a subnetwork in each region, picked by GCE.
+		if net.IPv4Range == "" && !net.AutoCreateSubnetworks {
+			return fmt.Errorf("Network %s is a custom mode network, a Subnetwork must be set", networkName)
+		}
+		return nil
+	}
+
+	subnetworkName := last(subnetwork)
+	region := resourceRegion(subnetwork, g.region())
+	if region != g.region() {
+		return fmt.Errorf("Subnetwork %s is in region %s but zone %s is in region %s", subnetworkName, region, g.zone, g.region())
+	}
+
+	sub, err := g.service.Subnetworks.Get(resourceProject(subnetwork, g.project), region, subnetworkName).Context(ctx).Do()
+	if err != nil {
+		return callError("ValidateNetwork", fmt.Errorf("Unable to find subnetwork %s in region %s: %w", subnetworkName, region, err))
+	}
+
+	if last(sub.Network) != networkName {
+		return fmt.Errorf("Subnetwork %s belongs to network %s, not %s", subnetworkName, last(sub.Network), networkName)
+	}
+
+	return nil
+}
+
+// buildMachineTypeURL turns a predefined or custom machine type name into its
+// zonal url. Instance templates take the bare name instead.
+func (g *computeServiceWrapper) buildMachineTypeURL(machineType string) string {
+	return g.addAPIUrlPrefix(machineType, g.project+"/zones/"+g.zone+"/machineTypes/")
+}
+
+func (g *computeServiceWrapper) CreateInstance(ctx context.Context, name string, settings *InstanceSettings) error {
+	return callError("CreateInstance", g.createInstance(ctx, name, settings))
+}
+
+func (g *computeServiceWrapper) CreateInstanceInZone(ctx context.Context, zone string, name string, settings *InstanceSettings) error {
+	return callError("CreateInstanceInZone", g.inZone(zone).createInstance(ctx, name, settings))
+}
+
+// inZone returns a copy of the wrapper that works in another zone of the
+// same project.
+func (g *computeServiceWrapper) inZone(zone string) *computeServiceWrapper {
+	copied := *g
+	copied.zone = zone
+	return &copied
+}
+
+func (g *computeServiceWrapper) createInstance(ctx context.Context, name string, settings *InstanceSettings) error {
+	op, timeout, err := g.insertInstance(ctx, name, settings)
+	if err != nil {
+		return err
+	}
+
+	return g.waitForOperation(ctx, op, timeout)
+}
+
+// insertInstance sends the insert of an instance without waiting for its
+// operation. It returns the operation and how long to wait for it.
+func (g *computeServiceWrapper) insertInstance(ctx context.Context, name string, settings *InstanceSettings) (*compute.Operation, time.Duration, error) {
+	machineType := g.buildMachineTypeURL(settings.MachineType)
+	network := g.networkURL(settings.Network)
+	subnetwork := g.subnetworkURL(settings.Subnetwork)
+
+	if err := g.checkPrivateIP(ctx, settings); err != nil {
+		return nil, 0, err
+	}
+
+	accessConfigs, err := g.accessConfigs(ctx, settings)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	disks, err := g.attachedDisks(ctx, name, settings.Disks)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	instance := &compute.Instance{
+		Name:         name,
+		Description:  settings.Description,
+		MachineType:  machineType,
+		CanIpForward: settings.CanIPForward,
+		Tags: &compute.Tags{
+			Items: settings.Tags,
+		},
+		Disks: disks,
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{
+				Network:       network,
+				Subnetwork:    subnetwork,
+				NetworkIP:     settings.PrivateIP,
+				AccessConfigs: accessConfigs,
+			},
+		},
+		Metadata: &compute.Metadata{
+			Items: settings.MetaData,
+		},
+		ServiceAccounts: serviceAccounts(settings),
+		Scheduling:      scheduling(settings),
+	}
+
+	timeout := g.operationTimeout
+	if settings.CreationTimeoutSeconds > 0 {
+		timeout = time.Duration(settings.CreationTimeoutSeconds) * time.Second
+	}
+
+	var call Call = g.service.Instances.Insert(g.project, g.zone, instance).Context(ctx)
+	fields := g.unknownFields(settings, false)
+	// Instance templates don't support the deletion protection.
+	if settings.DeletionProtection {
+		fields["deletionProtection"] = true
+	}
+	if len(fields) > 0 || settings.NetworkTier != "" {
+		body, err := toFields(instance)
+		if err != nil {
+			return nil, 0, err
+		}
+		for key, value := range fields {
+			body[key] = value
+		}
+		setNetworkTier(body, settings.NetworkTier)
+
+		call = g.rawCall(ctx, "POST", g.project+"/zones/"+g.zone+"/instances", body)
+	}
+
+	op, err := call.Do()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return op, timeout, nil
+}
+
+// serviceAccounts is the service account of an instance, or of the instances
+// created from a template, with the aliases of its scopes expanded.
+func serviceAccounts(settings *InstanceSettings) []*compute.ServiceAccount {
+	email := settings.ServiceAccount
+	if email == "" {
+		email = "default"
+	}
+
+	return []*compute.ServiceAccount{
+		{
+			Email:  email,
+			Scopes: ExpandScopes(settings.Scopes),
+		},
+	}
+}
+
+// checkPrivateIP makes sure that the private IP of an instance is in the
+// primary range of its subnetwork, rather than letting GCE reject it.
+func (g *computeServiceWrapper) checkPrivateIP(ctx context.Context, settings *InstanceSettings) error {
+	ip := net.ParseIP(settings.PrivateIP)
+	if ip == nil || settings.Subnetwork == "" {
+		return nil
+	}
+
+	subnetworkName := last(settings.Subnetwork)
+	region := resourceRegion(settings.Subnetwork, g.region())
+	sub, err := g.service.Subnetworks.Get(resourceProject(settings.Subnetwork, g.project), region, subnetworkName).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Unable to find subnetwork %s in region %s: %w", subnetworkName, region, err)
+	}
+
+	_, ipRange, err := net.ParseCIDR(sub.IpCidrRange)
+	if err != nil {
+		return fmt.Errorf("Invalid range %q of subnetwork %s: %v", sub.IpCidrRange, subnetworkName, err)
+	}
+	if !ipRange.Contains(ip) {
+		return fmt.Errorf("Private IP %s is outside of the range %s of subnetwork %s", settings.PrivateIP, sub.IpCidrRange, subnetworkName)
+	}
+
+	return nil
+}
+
+// unknownFields are the fields of an instance, or of the properties of a
+// template, that the compute client doesn't know: the accelerators, the
+// labels, the minimum CPU platform, the resource policies and the Shielded VM
+// features. Templates refer to the accelerator types and the resource policies
+// by their bare names rather than by urls.
+func (g *computeServiceWrapper) unknownFields(settings *InstanceSettings, template bool) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if len(settings.Accelerators) > 0 {
+		accelerators := []map[string]interface{}{}
+		for _, accelerator := range settings.Accelerators {
+			acceleratorType := last(accelerator.Type)
+			if !template {
+				acceleratorType = g.addAPIUrlPrefix(accelerator.Type, g.project+"/zones/"+g.zone+"/acceleratorTypes/")
+			}
+
+			accelerators = append(accelerators, map[string]interface{}{
+				"acceleratorType":  acceleratorType,
+				"acceleratorCount": accelerator.Count,
+			})
+		}
+		fields["guestAccelerators"] = accelerators
+	}
+	if len(settings.Labels) > 0 {
+		fields["labels"] = settings.Labels
+	}
+	if settings.MinCPUPlatform != "" {
+		fields["minCpuPlatform"] = settings.MinCPUPlatform
+	}
+	if len(settings.ResourcePolicies) > 0 {
+		policies := []string{}
+		for _, policy := range settings.ResourcePolicies {
+			if template {
+				policies = append(policies, last(policy))
+			} else {
+				policies = append(policies, g.addAPIUrlPrefix(policy, g.project+"/regions/"+g.region()+"/resourcePolicies/"))
+			}
+		}
+		fields["resourcePolicies"] = policies
+	}
+	if settings.Shielded != nil {
+		fields["shieldedInstanceConfig"] = map[string]bool{
+			"enableSecureBoot":          settings.Shielded.SecureBoot,
+			"enableVtpm":                settings.Shielded.VTPM,
+			"enableIntegrityMonitoring": settings.Shielded.IntegrityMonitoring,
+		}
+	}
+	return fields
+}
+
+// setNetworkTier sets the tier of the external IPs in the access configs of an
+// instance, or of the properties of a template. The compute client doesn't
+// know the network tiers.
+func setNetworkTier(fields map[string]interface{}, tier string) {
+	if tier == "" {
+		return
+	}
+
+	networkInterfaces, _ := fields["networkInterfaces"].([]interface{})
+	for _, networkInterface := range networkInterfaces {
+		accessConfigs, _ := networkInterface.(map[string]interface{})["accessConfigs"].([]interface{})
+		for _, accessConfig := range accessConfigs {
+			accessConfig.(map[string]interface{})["networkTier"] = tier
+		}
+	}
+}
+
+// scheduling is how GCE restarts an instance and maintains its host.
+func scheduling(settings *InstanceSettings) *compute.Scheduling {
+	automaticRestart := !settings.Preemptible
+	if settings.AutomaticRestart != nil {
+		automaticRestart = *settings.AutomaticRestart
+	}
+
+	onHostMaintenance := settings.OnHostMaintenance
+	if onHostMaintenance == "" {
+		onHostMaintenance = "MIGRATE"
+		if settings.Preemptible {
+			onHostMaintenance = "TERMINATE"
+		}
+	}
+	// Instances with accelerators can't be live migrated.
+	if len(settings.Accelerators) > 0 {
+		onHostMaintenance = "TERMINATE"
+	}
+
+	return &compute.Scheduling{
+		AutomaticRestart:  automaticRestart,
+		OnHostMaintenance: onHostMaintenance,
+		Preemptible:       settings.Preemptible,
+		// GCE restarts the instances unless told otherwise.
+		ForceSendFields: []string{"AutomaticRestart"},
+	}
+}
+
+// accessConfigs gives an instance an external IP unless it should only have
+// private networking. The IP is ephemeral unless a static IP is requested.
+func (g *computeServiceWrapper) accessConfigs(ctx context.Context, settings *InstanceSettings) ([]*compute.AccessConfig, error) {
+	if settings.NoExternalIP {
+		if settings.StaticIP != "" {
+			return nil, errors.New("A static IP can't be used on an instance without external IP")
+		}
+
+		return nil, nil
+	}
+
+	natIP, err := g.staticIP(ctx, settings.StaticIP)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*compute.AccessConfig{
+		{
+			Type:  "ONE_TO_ONE_NAT",
+			NatIP: natIP,
+		},
+	}, nil
+}
+
+// templateAccessConfigs gives the instances of a template an ephemeral
+// external IP unless they should only have private networking. Static IPs
+// can't be shared by the instances of a template.
+func templateAccessConfigs(settings *InstanceSettings) []*compute.AccessConfig {
+	if settings.NoExternalIP {
+		return nil
+	}
+
+	return []*compute.AccessConfig{
+		{
+			Type: "ONE_TO_ONE_NAT",
+		},
+	}
+}
+
+// staticIP resolves a static IP given either as a literal IP or as the name,
+// or the path, of an external address reserved in the instance's region.
+func (g *computeServiceWrapper) staticIP(ctx context.Context, staticIP string) (string, error) {
+	if staticIP == "" || net.ParseIP(staticIP) != nil {
+		return staticIP, nil
+	}
+
+	name := last(staticIP)
+	if strings.HasPrefix(staticIP, "global/") || strings.Contains(staticIP, "/global/") {
+		return "", fmt.Errorf("Static IP address %s is global, instances need an address of region %s", name, g.region())
+	}
+	if region := resourceRegion(staticIP, g.region()); region != g.region() {
+		return "", fmt.Errorf("Static IP address %s is in region %s but zone %s is in region %s", name, region, g.zone, g.region())
+	}
+
+	address, err := g.GetAddress(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("Unable to find static IP address %s in region %s: %v", name, g.region(), err)
+	}
+
+	if address.Internal {
+		return "", fmt.Errorf("Static IP address %s (%s) is internal, instances need an external address", name, address.Address)
+	}
+	if address.Status == AddressInUse {
+		return "", fmt.Errorf("Static IP address %s (%s) is already in use by %s", name, address.Address, strings.Join(address.Users, ", "))
+	}
+
+	return address.Address, nil
+}
+
+// templateDisks are the disks of the instances created from a template. Each
+// instance creates its own disks, blank or from an image or a snapshot, named
+// after the instance. Templates are global and take the bare disk type, which
+// can then be used by regional groups in any zone.
+func (g *computeServiceWrapper) templateDisks(disksSettings []DiskSettings) []*compute.AttachedDisk {
+	disks := []*compute.AttachedDisk{}
+
+	for _, settings := range disksSettings {
+		initializeParams := &compute.AttachedDiskInitializeParams{
+			DiskSizeGb: settings.SizeGb,
+			DiskType:   last(settings.Type),
+		}
+		if settings.Image != "" {
+			initializeParams.SourceImage = g.addAPIUrlPrefix(settings.Image, "")
+		}
+
+		disks = append(disks, &compute.AttachedDisk{
+			Boot:             settings.Boot,
+			Mode:             settings.Mode,
+			AutoDelete:       settings.AutoDelete,
+			DeviceName:       settings.DeviceName,
+			Type:             "PERSISTENT",
+			InitializeParams: initializeParams,
+		})
+	}
+
+	return disks
+}
+
+// hasSnapshot tells if a disk is created from a snapshot.
+func hasSnapshot(disks []DiskSettings) bool {
+	for _, disk := range disks {
+		if disk.Snapshot != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *computeServiceWrapper) attachedDisks(ctx context.Context, instanceName string, disksSettings []DiskSettings) ([]*compute.AttachedDisk, error) {
+	disks := []*compute.AttachedDisk{}
+
+	for _, diskSettings := range disksSettings {
+		disk, err := g.attachedDisk(ctx, instanceName, diskSettings)
+		if err != nil {
+			return nil, err
 		}
 
 		disks = append(disks, disk)
@@ -309,14 +1513,16 @@ func (g *computeServiceWrapper) attachedDisks(instanceName string, disksSettings
 	return disks, nil
 }
 
-func (g *computeServiceWrapper) attachedDisk(instanceName string, settings DiskSettings) (*compute.AttachedDisk, error) {
-	sourceImage := g.addAPIUrlPrefix(settings.Image, "")
+func (g *computeServiceWrapper) attachedDisk(ctx context.Context, instanceName string, settings DiskSettings) (*compute.AttachedDisk, error) {
+	sourceImage := g.imageURL(settings.Image)
+	sourceSnapshot := g.addAPIUrlPrefix(settings.Snapshot, g.project+"/global/snapshots/")
 	diskType := g.addAPIUrlPrefix(settings.Type, g.project+"/zones/"+g.zone+"/diskTypes/")
 
 	disk := &compute.AttachedDisk{
 		Boot:       settings.Boot,
 		Mode:       settings.Mode,
 		AutoDelete: settings.AutoDelete,
+		DeviceName: settings.DeviceName,
 		Type:       "PERSISTENT",
 	}
 
@@ -327,12 +1533,12 @@ func (g *computeServiceWrapper) attachedDisk(instanceName string, settings DiskS
 	if settings.ReuseExisting {
 		log.Debugln("Trying to reuse disk", diskName)
 
-		disk, err := g.service.Disks.Get(g.project, g.zone, diskName).Do()
+		disk, err := g.service.Disks.Get(g.project, g.zone, diskName).Context(ctx).Do()
 		if err != nil || disk == nil {
 			log.Debugln("Couldn't find existing disk", diskName)
-		} else if disk.SourceImage != sourceImage {
-			log.Debugln("Found existing disk that uses a wrong image. Let's delete", diskName)
-			if err := g.doCall(g.service.Disks.Delete(g.project, g.zone, disk.Name)); err != nil {
+		} else if disk.SourceImage != sourceImage || disk.SourceSnapshot != sourceSnapshot {
+			log.Debugln("Found existing disk that uses a wrong image or snapshot. Let's delete", diskName)
+			if err := g.doCall(ctx, g.service.Disks.Delete(g.project, g.zone, disk.Name).Context(ctx)); err != nil {
 				return nil, err
 			}
 		} else {
@@ -344,97 +1550,795 @@ func (g *computeServiceWrapper) attachedDisk(instanceName string, settings DiskS
 	if existingDisk != nil {
 		disk.Source = existingDisk.SelfLink
 	} else if settings.Image == "" {
+		// The compute client can't create a disk from a snapshot alongside
+		// the instance, so these disks are created beforehand too.
 		log.Debugln("Creating standalone disk", diskName)
 
-		if err := g.doCall(g.service.Disks.Insert(g.project, g.zone, &compute.Disk{
-			Name:   diskName,
-			SizeGb: settings.SizeGb,
-			Type:   diskType,
-		})); err != nil {
-			return nil, err
-		}
+		if err := g.doCall(ctx, g.service.Disks.Insert(g.project, g.zone, &compute.Disk{
+			Name:           diskName,
+			SizeGb:         settings.SizeGb,
+			Type:           diskType,
+			SourceSnapshot: sourceSnapshot,
+		}).Context(ctx)); err != nil {
+			return nil, err
+		}
+
+		disk.Source = "projects/" + g.project + "/zones/" + g.zone + "/disks/" + diskName
+	} else {
+		// Create the disk alongside the instance
+		disk.InitializeParams = &compute.AttachedDiskInitializeParams{
+			DiskName:    diskName,
+			SourceImage: sourceImage,
+			DiskSizeGb:  settings.SizeGb,
+			DiskType:    diskType,
+		}
+	}
+
+	return disk, nil
+}
+
+func (g *computeServiceWrapper) GetTargetPool(ctx context.Context, name string) (*compute.TargetPool, error) {
+	pool, err := g.service.TargetPools.Get(g.project, g.region(), name).Context(ctx).Do()
+	return pool, callError("GetTargetPool", err)
+}
+
+func (g *computeServiceWrapper) AddInstanceToTargetPool(ctx context.Context, targetPool string, instances ...string) error {
+	pool, err := g.service.TargetPools.Get(g.project, g.region(), targetPool).Context(ctx).Do()
+	if err != nil {
+		return callError("AddInstanceToTargetPool", err)
+	}
+
+	references := []*compute.InstanceReference{}
+	for _, instance := range g.poolReferences(instances) {
+		if !inTargetPool(pool, instance) {
+			references = append(references, &compute.InstanceReference{Instance: instance})
+		}
+	}
+	if len(references) == 0 {
+		return nil
+	}
+
+	request := &compute.TargetPoolsAddInstanceRequest{
+		Instances: references,
+	}
+
+	return callError("AddInstanceToTargetPool", g.doCall(ctx, g.service.TargetPools.AddInstance(g.project, g.region(), targetPool, request).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) RemoveInstanceFromTargetPool(ctx context.Context, targetPool string, instances ...string) error {
+	pool, err := g.service.TargetPools.Get(g.project, g.region(), targetPool).Context(ctx).Do()
+	if err != nil {
+		return callError("RemoveInstanceFromTargetPool", err)
+	}
+
+	references := []*compute.InstanceReference{}
+	for _, instance := range g.poolReferences(instances) {
+		if inTargetPool(pool, instance) {
+			references = append(references, &compute.InstanceReference{Instance: instance})
+		}
+	}
+	if len(references) == 0 {
+		return nil
+	}
+
+	request := &compute.TargetPoolsRemoveInstanceRequest{
+		Instances: references,
+	}
+
+	return callError("RemoveInstanceFromTargetPool", g.doCall(ctx, g.service.TargetPools.RemoveInstance(g.project, g.region(), targetPool, request).Context(ctx)))
+}
+
+// poolReferences turns the instances of the zone, given by their name, into
+// the relative urls that target pools refer to.
+func (g *computeServiceWrapper) poolReferences(instances []string) []string {
+	references := []string{}
+	for _, instance := range instances {
+		if !strings.Contains(instance, "/") {
+			instance = fmt.Sprintf("projects/%s/zones/%s/instances/%s", g.project, g.zone, instance)
+		}
+		references = append(references, instance)
+	}
+
+	return references
+}
+
+// inTargetPool tells if an instance, given by its relative url, is a member
+// of a target pool.
+func inTargetPool(pool *compute.TargetPool, instance string) bool {
+	for _, member := range pool.Instances {
+		if member == instance || strings.HasSuffix(member, "/"+instance) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *computeServiceWrapper) AddInstanceMetadata(ctx context.Context, instanceName string, items []*compute.MetadataItems) error {
+	err := g.addInstanceMetadata(ctx, instanceName, items)
+	if isFingerprintConflict(err) {
+		err = g.addInstanceMetadata(ctx, instanceName, items)
+	}
+
+	return callError("AddInstanceMetadata", err)
+}
+
+// addInstanceMetadata merges metadata items with the current metadata of an
+// instance, guarded by the fingerprint of that metadata.
+func (g *computeServiceWrapper) addInstanceMetadata(ctx context.Context, instanceName string, items []*compute.MetadataItems) error {
+	instance, err := g.GetInstance(ctx, instanceName)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		found := false
+		for _, existingItem := range instance.Metadata.Items {
+			if existingItem.Key == item.Key {
+				existingItem.Value = item.Value
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			instance.Metadata.Items = append(instance.Metadata.Items, item)
+		}
+
+	}
+
+	return g.doCall(ctx, g.service.Instances.SetMetadata(g.project, g.zone, instanceName, instance.Metadata).Context(ctx))
+}
+
+func (g *computeServiceWrapper) AddInstanceLabels(ctx context.Context, instanceName string, labels map[string]string) error {
+	err := g.addInstanceLabels(ctx, instanceName, labels)
+	if isFingerprintConflict(err) {
+		err = g.addInstanceLabels(ctx, instanceName, labels)
+	}
+
+	return callError("AddInstanceLabels", err)
+}
+
+// addInstanceLabels merges labels with the current labels of an instance,
+// guarded by the fingerprint of those labels.
+func (g *computeServiceWrapper) addInstanceLabels(ctx context.Context, instanceName string, labels map[string]string) error {
+	// The vendored compute client doesn't know about labels.
+	path := g.project + "/zones/" + g.zone + "/instances/" + instanceName
+
+	instance := struct {
+		Labels           map[string]string `json:"labels"`
+		LabelFingerprint string            `json:"labelFingerprint"`
+	}{}
+	if err := g.send(ctx, "GET", path, nil, &instance); err != nil {
+		return err
+	}
+
+	merged := map[string]string{}
+	for k, v := range instance.Labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+
+	return g.doCall(ctx, g.rawCall(ctx, "POST", path+"/setLabels", map[string]interface{}{
+		"labels":           merged,
+		"labelFingerprint": instance.LabelFingerprint,
+	}))
+}
+
+func (g *computeServiceWrapper) SetInstanceTags(ctx context.Context, instanceName string, tags []string) error {
+	instance, err := g.GetInstance(ctx, instanceName)
+	if err != nil {
+		return callError("SetInstanceTags", err)
+	}
+
+	fingerprint := ""
+	if instance.Tags != nil {
+		fingerprint = instance.Tags.Fingerprint
+	}
+
+	return callError("SetInstanceTags", g.doCall(ctx, g.service.Instances.SetTags(g.project, g.zone, instanceName, &compute.Tags{
+		Items:       tags,
+		Fingerprint: fingerprint,
+	}).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) DeleteInstance(ctx context.Context, name string) error {
+	return callError("DeleteInstance", g.doCall(ctx, g.service.Instances.Delete(g.project, g.zone, name).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) DeleteInstanceInZone(ctx context.Context, zone string, name string) error {
+	return callError("DeleteInstanceInZone", g.doCall(ctx, g.service.Instances.Delete(g.project, zone, name).Context(ctx)))
+}
+
+// The compute client doesn't know the deletion protection of the instances.
+func (g *computeServiceWrapper) GetDeletionProtection(ctx context.Context, zone string, name string) (bool, error) {
+	current := struct {
+		DeletionProtection bool `json:"deletionProtection"`
+	}{}
+	if err := g.send(ctx, "GET", g.project+"/zones/"+zone+"/instances/"+name, nil, &current); err != nil {
+		return false, callError("GetDeletionProtection", err)
+	}
+
+	return current.DeletionProtection, nil
+}
+
+func (g *computeServiceWrapper) SetDeletionProtection(ctx context.Context, zone string, name string, protected bool) error {
+	path := fmt.Sprintf("%s/zones/%s/instances/%s/setDeletionProtection?deletionProtection=%t", g.project, zone, name, protected)
+
+	return callError("SetDeletionProtection", g.doCall(ctx, g.rawCall(ctx, "POST", path, nil)))
+}
+
+func (g *computeServiceWrapper) CreateDisk(ctx context.Context, name string, settings *PersistentDiskSettings) error {
+	if settings.Image != "" && settings.Snapshot != "" {
+		return errors.New("A disk can't be created from both an image and a snapshot")
+	}
+
+	disk := &compute.Disk{
+		Name:           name,
+		SizeGb:         settings.SizeGb,
+		Type:           g.addAPIUrlPrefix(settings.Type, g.project+"/zones/"+g.zone+"/diskTypes/"),
+		SourceImage:    g.imageURL(settings.Image),
+		SourceSnapshot: g.addAPIUrlPrefix(settings.Snapshot, g.project+"/global/snapshots/"),
+	}
+
+	return callError("CreateDisk", g.doCall(ctx, g.service.Disks.Insert(g.project, g.zone, disk).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) DeleteDisk(ctx context.Context, name string) error {
+	return callError("DeleteDisk", g.doCall(ctx, g.service.Disks.Delete(g.project, g.zone, name).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) AttachDisk(ctx context.Context, instanceName string, diskName string, deviceName string) error {
+	disk := &compute.AttachedDisk{
+		Source:     "projects/" + g.project + "/zones/" + g.zone + "/disks/" + diskName,
+		DeviceName: deviceName,
+		Mode:       "READ_WRITE",
+		Type:       "PERSISTENT",
+	}
+
+	return callError("AttachDisk", g.doCall(ctx, g.service.Instances.AttachDisk(g.project, g.zone, instanceName, disk).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) DetachDisk(ctx context.Context, instanceName string, deviceName string) error {
+	return callError("DetachDisk", g.doCall(ctx, g.service.Instances.DetachDisk(g.project, g.zone, instanceName, deviceName).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) CreateSnapshot(ctx context.Context, diskName string, snapshotName string) error {
+	snapshot := &compute.Snapshot{
+		Name: snapshotName,
+	}
+
+	return callError("CreateSnapshot", g.doCall(ctx, g.service.Disks.CreateSnapshot(g.project, g.zone, diskName, snapshot).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) DeleteSnapshot(ctx context.Context, name string) error {
+	return callError("DeleteSnapshot", g.doCall(ctx, g.service.Snapshots.Delete(g.project, name).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) ListSnapshots(ctx context.Context, filter string) ([]*compute.Snapshot, error) {
+	items := []*compute.Snapshot{}
+
+	pageToken := ""
+	for {
+		call := g.service.Snapshots.List(g.project).PageToken(pageToken)
+		if filter != "" {
+			call = call.Filter(filter)
+		}
+
+		list, err := call.Context(ctx).Do()
+		if err != nil {
+			return nil, callError("ListSnapshots", err)
+		}
+
+		items = append(items, list.Items...)
+		if err := g.checkListResults(len(items)); err != nil {
+			return nil, callError("ListSnapshots", err)
+		}
+
+		pageToken = list.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	return items, nil
+}
+
+func (g *computeServiceWrapper) DeleteInstanceGroupManager(ctx context.Context, name string) error {
+	return callError("DeleteInstanceGroupManager", g.doCall(ctx, g.service.InstanceGroupManagers.Delete(g.project, g.zone, name).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) DeleteInstanceTemplate(ctx context.Context, name string) error {
+	return callError("DeleteInstanceTemplate", g.doCall(ctx, g.service.InstanceTemplates.Delete(g.project, name).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) ListInstanceGroupInstances(ctx context.Context, name string) ([]*compute.InstanceWithNamedPorts, error) {
+	items := []*compute.InstanceWithNamedPorts{}
+
+	pageToken := ""
+	for {
+		instances, err := g.service.InstanceGroups.ListInstances(g.project, g.zone, name, &compute.InstanceGroupsListInstancesRequest{
+			InstanceState: "ALL",
+		}).PageToken(pageToken).Context(ctx).Do()
+		if err != nil {
+			return nil, callError("ListInstanceGroupInstances", err)
+		}
+
+		for i := range instances.Items {
+			items = append(items, instances.Items[i])
+		}
+		if err := g.checkListResults(len(items)); err != nil {
+			return nil, callError("ListInstanceGroupInstances", err)
+		}
+
+		pageToken = instances.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	return items, nil
+}
+
+func templateDescription(settings *InstanceSettings) string {
+	if settings.TemplateDescription != "" {
+		return settings.TemplateDescription
+	}
+	return settings.Description
+}
+
+func (g *computeServiceWrapper) ListManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error) {
+	response, err := g.service.InstanceGroupManagers.ListManagedInstances(g.project, g.zone, name).Context(ctx).Do()
+	if err != nil {
+		return nil, callError("ListManagedInstances", err)
+	}
+
+	return response.ManagedInstances, nil
+}
+
+func (g *computeServiceWrapper) checkListResults(count int) error {
+	if g.maxListResults > 0 && count > g.maxListResults {
+		return fmt.Errorf("Listed more than %d items", g.maxListResults)
+	}
+	return nil
+}
+
+func (g *computeServiceWrapper) CreateInstanceTemplate(ctx context.Context, name string, settings *InstanceSettings) error {
+	if settings.SourceInstance != "" {
+		// The vendored compute client doesn't know about sourceInstance.
+		return callError("CreateInstanceTemplate", g.doCall(ctx, g.rawCall(ctx, "POST", g.project+"/global/instanceTemplates", map[string]interface{}{
+			"name":           name,
+			"description":    templateDescription(settings),
+			"sourceInstance": g.addAPIUrlPrefix(settings.SourceInstance, g.project+"/zones/"+g.zone+"/instances/"),
+		})))
+	}
+
+	network := g.networkURL(settings.Network)
+	subnetwork := g.subnetworkURL(settings.Subnetwork)
+
+	disks := g.templateDisks(settings.Disks)
+
+	template := &compute.InstanceTemplate{
+		Name:        name,
+		Description: templateDescription(settings),
+		Properties: &compute.InstanceProperties{
+			Description:  settings.Description,
+			MachineType:  last(settings.MachineType),
+			CanIpForward: settings.CanIPForward,
+			Tags: &compute.Tags{
+				Items: settings.Tags,
+			},
+			Disks: disks,
+			NetworkInterfaces: []*compute.NetworkInterface{
+				{
+					Network:       network,
+					Subnetwork:    subnetwork,
+					AccessConfigs: templateAccessConfigs(settings),
+				},
+			},
+			Metadata: &compute.Metadata{
+				Items: settings.MetaData,
+			},
+			ServiceAccounts: serviceAccounts(settings),
+			Scheduling:      scheduling(settings),
+		},
+	}
+
+	var call Call = g.service.InstanceTemplates.Insert(g.project, template).Context(ctx)
+	if fields := g.unknownFields(settings, true); len(fields) > 0 || hasSnapshot(settings.Disks) || settings.NetworkTier != "" {
+		body, err := toFields(template)
+		if err != nil {
+			return callError("CreateInstanceTemplate", err)
+		}
+		properties := body["properties"].(map[string]interface{})
+		for key, value := range fields {
+			properties[key] = value
+		}
+		setNetworkTier(properties, settings.NetworkTier)
+
+		// The vendored compute client doesn't know about the snapshots that
+		// the disks of the instances are created from.
+		for i, disk := range settings.Disks {
+			if disk.Snapshot != "" {
+				initializeParams := properties["disks"].([]interface{})[i].(map[string]interface{})["initializeParams"].(map[string]interface{})
+				initializeParams["sourceSnapshot"] = g.addAPIUrlPrefix(disk.Snapshot, g.project+"/global/snapshots/")
+			}
+		}
+
+		call = g.rawCall(ctx, "POST", g.project+"/global/instanceTemplates", body)
+	}
+
+	return callError("CreateInstanceTemplate", g.doCall(ctx, call))
+}
+
+func (g *computeServiceWrapper) ListInstanceTemplates(ctx context.Context, filter string) ([]*compute.InstanceTemplate, error) {
+	items := []*compute.InstanceTemplate{}
+
+	pageToken := ""
+	for {
+		call := g.service.InstanceTemplates.List(g.project).PageToken(pageToken)
+		if filter != "" {
+			call = call.Filter(filter)
+		}
+
+		list, err := call.Context(ctx).Do()
+		if err != nil {
+			return nil, callError("ListInstanceTemplates", err)
+		}
+
+		for i := range list.Items {
+			items = append(items, list.Items[i])
+		}
+		if err := g.checkListResults(len(items)); err != nil {
+			return nil, callError("ListInstanceTemplates", err)
+		}
+
+		pageToken = list.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	return items, nil
+}
+
+func (g *computeServiceWrapper) GetInstanceTemplate(ctx context.Context, name string) (*compute.InstanceTemplate, error) {
+	template, err := g.service.InstanceTemplates.Get(g.project, name).Context(ctx).Do()
+	return template, callError("GetInstanceTemplate", err)
+}
+
+func (g *computeServiceWrapper) CreateInstanceGroupManager(ctx context.Context, name string, settings *InstanceManagerSettings) error {
+	groupManager := &compute.InstanceGroupManager{
+		Name:             name,
+		Description:      settings.Description,
+		Zone:             g.zone,
+		InstanceTemplate: "projects/" + g.project + "/global/instanceTemplates/" + settings.TemplateName,
+		BaseInstanceName: settings.BaseInstanceName,
+		TargetPools:      settings.TargetPools,
+		TargetSize:       settings.TargetSize,
+	}
+
+	return callError("CreateInstanceGroupManager", g.doCall(ctx, g.service.InstanceGroupManagers.Insert(g.project, g.zone, groupManager).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) GetInstanceGroupManager(ctx context.Context, name string) (*compute.InstanceGroupManager, error) {
+	groupManager, err := g.service.InstanceGroupManagers.Get(g.project, g.zone, name).Context(ctx).Do()
+	return groupManager, callError("GetInstanceGroupManager", err)
+}
+
+func (g *computeServiceWrapper) ListInstanceGroupManagers(ctx context.Context) ([]*compute.InstanceGroupManager, error) {
+	items := []*compute.InstanceGroupManager{}
+
+	pageToken := ""
+	for {
+		list, err := g.service.InstanceGroupManagers.List(g.project, g.zone).PageToken(pageToken).Context(ctx).Do()
+		if err != nil {
+			return nil, callError("ListInstanceGroupManagers", err)
+		}
+
+		for i := range list.Items {
+			items = append(items, list.Items[i])
+		}
+		if err := g.checkListResults(len(items)); err != nil {
+			return nil, callError("ListInstanceGroupManagers", err)
+		}
+
+		pageToken = list.NextPageToken
+		if pageToken == "" {
+			break
+		}
+	}
+
+	return items, nil
+}
+
+func (g *computeServiceWrapper) SetNamedPorts(ctx context.Context, name string, ports []NamedPort) error {
+	request := &compute.InstanceGroupsSetNamedPortsRequest{
+		NamedPorts:      namedPorts(ports),
+		ForceSendFields: []string{"NamedPorts"},
+	}
+
+	return callError("SetNamedPorts", g.doCall(ctx, g.service.InstanceGroups.SetNamedPorts(g.project, g.zone, name, request).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) SetTargetPools(ctx context.Context, name string, targetPools []string) error {
+	request := &compute.InstanceGroupManagersSetTargetPoolsRequest{
+		TargetPools:     targetPools,
+		ForceSendFields: []string{"TargetPools"},
+	}
+
+	return callError("SetTargetPools", g.doCall(ctx, g.service.InstanceGroupManagers.SetTargetPools(g.project, g.zone, name, request).Context(ctx)))
+}
+
+// namedPorts are the named ports of the Compute API.
+func namedPorts(ports []NamedPort) []*compute.NamedPort {
+	namedPorts := []*compute.NamedPort{}
+	for _, port := range ports {
+		namedPorts = append(namedPorts, &compute.NamedPort{Name: port.Name, Port: port.Port})
+	}
+	return namedPorts
+}
+
+func (g *computeServiceWrapper) SetInstanceTemplate(ctx context.Context, name string, templateName string) error {
+	request := &compute.InstanceGroupManagersSetInstanceTemplateRequest{
+		InstanceTemplate: "projects/" + g.project + "/global/instanceTemplates/" + templateName,
+	}
+
+	return callError("SetInstanceTemplate", g.doCall(ctx, g.service.InstanceGroupManagers.SetInstanceTemplate(g.project, g.zone, name, request).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) RecreateInstances(ctx context.Context, name string, instances ...string) error {
+	request := &compute.InstanceGroupManagersRecreateInstancesRequest{
+		Instances: g.instanceURLs(instances),
+	}
+
+	return callError("RecreateInstances", g.doCall(ctx, g.service.InstanceGroupManagers.RecreateInstances(g.project, g.zone, name, request).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) AbandonInstances(ctx context.Context, name string, instances ...string) error {
+	request := &compute.InstanceGroupManagersAbandonInstancesRequest{
+		Instances: g.instanceURLs(instances),
+	}
+
+	return callError("AbandonInstances", g.doCall(ctx, g.service.InstanceGroupManagers.AbandonInstances(g.project, g.zone, name, request).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) DeleteGroupInstances(ctx context.Context, name string, instances ...string) error {
+	request := &compute.InstanceGroupManagersDeleteInstancesRequest{
+		Instances: g.instanceURLs(instances),
+	}
+
+	return callError("DeleteGroupInstances", g.doCall(ctx, g.service.InstanceGroupManagers.DeleteInstances(g.project, g.zone, name, request).Context(ctx)))
+}
+
+// instanceURLs turns the names of instances of the zone into the urls the
+// group manager methods of the Compute API take. Urls are kept as is.
+func (g *computeServiceWrapper) instanceURLs(instances []string) []string {
+	urls := []string{}
+	for _, instance := range instances {
+		if strings.Contains(instance, "/") {
+			urls = append(urls, instance)
+		} else {
+			urls = append(urls, "zones/"+g.zone+"/instances/"+instance)
+		}
+	}
+	return urls
+}
+
+func (g *computeServiceWrapper) StartRollingUpdate(ctx context.Context, name string, templateName string, maxSurge, maxUnavailable int) error {
+	// The vendored compute client doesn't know about update policies.
+	patch := map[string]interface{}{
+		"updatePolicy": map[string]interface{}{
+			"type":           "PROACTIVE",
+			"minimalAction":  "REPLACE",
+			"maxSurge":       map[string]interface{}{"fixed": maxSurge},
+			"maxUnavailable": map[string]interface{}{"fixed": maxUnavailable},
+		},
+		"versions": []map[string]interface{}{
+			{"instanceTemplate": "projects/" + g.project + "/global/instanceTemplates/" + templateName},
+		},
+	}
+	path := g.project + "/zones/" + g.zone + "/instanceGroupManagers/" + name
+
+	return callError("StartRollingUpdate", g.doCall(ctx, g.rawCall(ctx, "PATCH", path, patch)))
+}
+
+func (g *computeServiceWrapper) ResizeInstanceGroupManager(ctx context.Context, name string, targetSize int64) error {
+	return callError("ResizeInstanceGroupManager", g.doCall(ctx, g.service.InstanceGroupManagers.Resize(g.project, g.zone, name, targetSize).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) CreateSpreadPlacementPolicy(ctx context.Context, name string, availabilityDomains int64) error {
+	// The vendored compute client doesn't know about resource policies.
+	policy := map[string]interface{}{
+		"name": name,
+		"groupPlacementPolicy": map[string]interface{}{
+			"availabilityDomainCount": availabilityDomains,
+		},
+	}
+	path := g.project + "/regions/" + g.region() + "/resourcePolicies"
+
+	return callError("CreateSpreadPlacementPolicy", g.doCall(ctx, g.rawCall(ctx, "POST", path, policy)))
+}
+
+func (g *computeServiceWrapper) GetSpreadPlacementPolicy(ctx context.Context, name string) (int64, error) {
+	policy := struct {
+		GroupPlacementPolicy *struct {
+			AvailabilityDomainCount int64 `json:"availabilityDomainCount"`
+		} `json:"groupPlacementPolicy"`
+	}{}
+	if err := g.send(ctx, "GET", g.project+"/regions/"+g.region()+"/resourcePolicies/"+name, nil, &policy); err != nil {
+		return 0, callError("GetSpreadPlacementPolicy", err)
+	}
+
+	if policy.GroupPlacementPolicy == nil {
+		return 0, nil
+	}
+
+	return policy.GroupPlacementPolicy.AvailabilityDomainCount, nil
+}
+
+func (g *computeServiceWrapper) DeleteResourcePolicy(ctx context.Context, name string) error {
+	path := g.project + "/regions/" + g.region() + "/resourcePolicies/" + name
+
+	return callError("DeleteResourcePolicy", g.doCall(ctx, g.rawCall(ctx, "DELETE", path, nil)))
+}
+
+func (g *computeServiceWrapper) CreateAutoscaler(ctx context.Context, name string, settings *AutoscalerSettings) error {
+	return callError("CreateAutoscaler", g.doCall(ctx, g.service.Autoscalers.Insert(g.project, g.zone, g.autoscaler(name, settings)).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) GetAutoscaler(ctx context.Context, name string) (*compute.Autoscaler, error) {
+	autoscaler, err := g.service.Autoscalers.Get(g.project, g.zone, name).Context(ctx).Do()
+	return autoscaler, callError("GetAutoscaler", err)
+}
+
+func (g *computeServiceWrapper) UpdateAutoscaler(ctx context.Context, name string, settings *AutoscalerSettings) error {
+	return callError("UpdateAutoscaler", g.doCall(ctx, g.service.Autoscalers.Update(g.project, g.zone, g.autoscaler(name, settings)).Autoscaler(name).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) DeleteAutoscaler(ctx context.Context, name string) error {
+	return callError("DeleteAutoscaler", g.doCall(ctx, g.service.Autoscalers.Delete(g.project, g.zone, name).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) autoscaler(name string, settings *AutoscalerSettings) *compute.Autoscaler {
+	return &compute.Autoscaler{
+		Name:   name,
+		Target: "projects/" + g.project + "/zones/" + g.zone + "/instanceGroupManagers/" + name,
+		AutoscalingPolicy: &compute.AutoscalingPolicy{
+			MinNumReplicas: settings.MinReplicas,
+			MaxNumReplicas: settings.MaxReplicas,
+			CpuUtilization: &compute.AutoscalingPolicyCpuUtilization{
+				UtilizationTarget: settings.CPUUtilization,
+			},
+		},
+	}
+}
+
+func (g *computeServiceWrapper) CreateHealthCheck(ctx context.Context, name string, settings *HealthCheckSettings) error {
+	return callError("CreateHealthCheck", g.doCall(ctx, g.service.HealthChecks.Insert(g.project, healthCheck(name, settings)).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) UpdateHealthCheck(ctx context.Context, name string, settings *HealthCheckSettings) error {
+	return callError("UpdateHealthCheck", g.doCall(ctx, g.service.HealthChecks.Update(g.project, name, healthCheck(name, settings)).Context(ctx)))
+}
 
-		disk.Source = "projects/" + g.project + "/zones/" + g.zone + "/disks/" + diskName
-	} else {
-		// Create the disk alongside the instance
-		disk.InitializeParams = &compute.AttachedDiskInitializeParams{
-			DiskName:    diskName,
-			SourceImage: sourceImage,
-			DiskSizeGb:  settings.SizeGb,
-			DiskType:    diskType,
-		}
+func (g *computeServiceWrapper) DeleteHealthCheck(ctx context.Context, name string) error {
+	return callError("DeleteHealthCheck", g.doCall(ctx, g.service.HealthChecks.Delete(g.project, name).Context(ctx)))
+}
+
+func healthCheck(name string, settings *HealthCheckSettings) *compute.HealthCheck {
+	check := &compute.HealthCheck{
+		Name:               name,
+		Type:               settings.Protocol,
+		CheckIntervalSec:   settings.CheckIntervalSec,
+		TimeoutSec:         settings.TimeoutSec,
+		HealthyThreshold:   settings.HealthyThreshold,
+		UnhealthyThreshold: settings.UnhealthyThreshold,
 	}
 
-	return disk, nil
+	switch settings.Protocol {
+	case "HTTP":
+		check.HttpHealthCheck = &compute.HTTPHealthCheck{Port: settings.Port, RequestPath: settings.RequestPath}
+	case "HTTPS":
+		check.HttpsHealthCheck = &compute.HTTPSHealthCheck{Port: settings.Port, RequestPath: settings.RequestPath}
+	case "TCP":
+		check.TcpHealthCheck = &compute.TCPHealthCheck{Port: settings.Port}
+	}
+
+	return check
 }
 
-func (g *computeServiceWrapper) AddInstanceToTargetPool(targetPool string, instances ...string) error {
-	references := []*compute.InstanceReference{}
-	for _, instance := range instances {
-		references = append(references, &compute.InstanceReference{
-			Instance: fmt.Sprintf("projects/%s/zones/%s/instances/%s", g.project, g.zone, instance),
+// autoHealingPolicy is the autohealing policy of an instance group manager.
+// The vendored compute client doesn't know about it yet, so the manager is
+// patched with a raw call.
+type autoHealingPolicy struct {
+	HealthCheck     string `json:"healthCheck"`
+	InitialDelaySec int64  `json:"initialDelaySec,omitempty"`
+}
+
+func (g *computeServiceWrapper) SetAutoHealingPolicy(ctx context.Context, name string, healthCheck string, initialDelaySec int64) error {
+	policies := []autoHealingPolicy{}
+	if healthCheck != "" {
+		policies = append(policies, autoHealingPolicy{
+			HealthCheck:     "projects/" + g.project + "/global/healthChecks/" + healthCheck,
+			InitialDelaySec: initialDelaySec,
 		})
 	}
 
-	request := &compute.TargetPoolsAddInstanceRequest{
-		Instances: references,
-	}
+	path := g.project + "/zones/" + g.zone + "/instanceGroupManagers/" + name
 
-	return g.doCall(g.service.TargetPools.AddInstance(g.project, g.region(), targetPool, request))
+	return callError("SetAutoHealingPolicy", g.doCall(ctx, g.rawCall(ctx, "PATCH", path, map[string]interface{}{"autoHealingPolicies": policies})))
 }
 
-func (g *computeServiceWrapper) AddInstanceMetadata(instanceName string, items []*compute.MetadataItems) error {
-	instance, err := g.GetInstance(instanceName)
-	if err != nil {
-		return err
+func (g *computeServiceWrapper) CreateRegionalInstanceGroupManager(ctx context.Context, name string, settings *InstanceManagerSettings) error {
+	groupManager := &compute.InstanceGroupManager{
+		Name:             name,
+		Description:      settings.Description,
+		Region:           g.region(),
+		InstanceTemplate: "projects/" + g.project + "/global/instanceTemplates/" + settings.TemplateName,
+		BaseInstanceName: settings.BaseInstanceName,
+		TargetPools:      settings.TargetPools,
+		TargetSize:       settings.TargetSize,
 	}
 
-	for _, item := range items {
-		found := false
-		for _, existingItem := range instance.Metadata.Items {
-			if existingItem.Key == item.Key {
-				existingItem.Value = item.Value
-				found = true
-				break
-			}
-		}
+	if len(settings.Zones) == 0 {
+		return callError("CreateRegionalInstanceGroupManager", g.doCall(ctx, g.service.RegionInstanceGroupManagers.Insert(g.project, g.region(), groupManager).Context(ctx)))
+	}
 
-		if !found {
-			instance.Metadata.Items = append(instance.Metadata.Items, item)
-		}
+	if err := ValidateZones(g.region(), settings.Zones); err != nil {
+		return err
+	}
 
+	body, err := toFields(groupManager)
+	if err != nil {
+		return callError("CreateRegionalInstanceGroupManager", err)
+	}
+	zones := []map[string]string{}
+	for _, zone := range settings.Zones {
+		zones = append(zones, map[string]string{"zone": "zones/" + zone})
 	}
+	body["distributionPolicy"] = map[string]interface{}{"zones": zones}
 
-	return g.doCall(g.service.Instances.SetMetadata(g.project, g.zone, instanceName, instance.Metadata))
+	return callError("CreateRegionalInstanceGroupManager", g.doCall(ctx, g.rawCall(ctx, "POST", g.project+"/regions/"+g.region()+"/instanceGroupManagers", body)))
 }
 
-func (g *computeServiceWrapper) DeleteInstance(name string) error {
-	return g.doCall(g.service.Instances.Delete(g.project, g.zone, name))
-}
+// ValidateZones checks that the zones a regional group is restricted to
+// belong to its region.
+func ValidateZones(region string, zones []string) error {
+	for _, zone := range zones {
+		if !strings.HasPrefix(zone, region+"-") || len(zone) <= len(region)+1 {
+			return fmt.Errorf("Zone %s is not a zone of region %s", zone, region)
+		}
+	}
 
-func (g *computeServiceWrapper) DeleteInstanceGroupManager(name string) error {
-	return g.doCall(g.service.InstanceGroupManagers.Delete(g.project, g.zone, name))
+	return nil
 }
 
-func (g *computeServiceWrapper) DeleteInstanceTemplate(name string) error {
-	return g.doCall(g.service.InstanceTemplates.Delete(g.project, name))
+func (g *computeServiceWrapper) GetRegionalInstanceGroupManager(ctx context.Context, name string) (*compute.InstanceGroupManager, error) {
+	groupManager, err := g.service.RegionInstanceGroupManagers.Get(g.project, g.region(), name).Context(ctx).Do()
+	return groupManager, callError("GetRegionalInstanceGroupManager", err)
 }
 
-func (g *computeServiceWrapper) ListInstanceGroupInstances(name string) ([]*compute.InstanceWithNamedPorts, error) {
+func (g *computeServiceWrapper) ListRegionalInstanceGroupInstances(ctx context.Context, name string) ([]*compute.InstanceWithNamedPorts, error) {
 	items := []*compute.InstanceWithNamedPorts{}
 
 	pageToken := ""
 	for {
-		instances, err := g.service.InstanceGroups.ListInstances(g.project, g.zone, name, &compute.InstanceGroupsListInstancesRequest{
+		instances, err := g.service.RegionInstanceGroups.ListInstances(g.project, g.region(), name, &compute.RegionInstanceGroupsListInstancesRequest{
 			InstanceState: "ALL",
-		}).PageToken(pageToken).Do()
+		}).PageToken(pageToken).Context(ctx).Do()
 		if err != nil {
-			return nil, err
+			return nil, callError("ListRegionalInstanceGroupInstances", err)
 		}
 
 		for i := range instances.Items {
 			items = append(items, instances.Items[i])
 		}
+		if err := g.checkListResults(len(items)); err != nil {
+			return nil, callError("ListRegionalInstanceGroupInstances", err)
+		}
 
 		pageToken = instances.NextPageToken
 		if pageToken == "" {
@@ -445,83 +2349,119 @@ func (g *computeServiceWrapper) ListInstanceGroupInstances(name string) ([]*comp
 	return items, nil
 }
 
-func (g *computeServiceWrapper) CreateInstanceTemplate(name string, settings *InstanceSettings) error {
-	network := g.addAPIUrlPrefix(settings.Network, g.project+"/global/networks/")
-	subnetwork := g.addAPIUrlPrefix(settings.Subnetwork, g.project+"/regions/"+g.region()+"/subnetworks/")
-
-	disks, err := g.attachedDisks(name, settings.Disks)
+func (g *computeServiceWrapper) ListRegionalManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error) {
+	response, err := g.service.RegionInstanceGroupManagers.ListManagedInstances(g.project, g.region(), name).Context(ctx).Do()
 	if err != nil {
-		return err
+		return nil, callError("ListRegionalManagedInstances", err)
 	}
 
-	template := &compute.InstanceTemplate{
-		Name:        name,
-		Description: settings.Description,
-		Properties: &compute.InstanceProperties{
-			Description: settings.Description,
-			MachineType: settings.MachineType,
-			Tags: &compute.Tags{
-				Items: settings.Tags,
-			},
-			Disks: disks,
-			NetworkInterfaces: []*compute.NetworkInterface{
-				{
-					Network:    network,
-					Subnetwork: subnetwork,
-					AccessConfigs: []*compute.AccessConfig{
-						{
-							Type: "ONE_TO_ONE_NAT",
-						},
-					},
-				},
-			},
-			Metadata: &compute.Metadata{
-				Items: settings.MetaData,
-			},
-			ServiceAccounts: []*compute.ServiceAccount{
-				{
-					Email:  "default",
-					Scopes: settings.Scopes,
-				},
-			},
-			Scheduling: &compute.Scheduling{
-				AutomaticRestart:  true,
-				OnHostMaintenance: "MIGRATE",
-				Preemptible:       settings.Preemptible,
-			},
-		},
+	return response.ManagedInstances, nil
+}
+
+func (g *computeServiceWrapper) SetRegionalNamedPorts(ctx context.Context, name string, ports []NamedPort) error {
+	request := &compute.RegionInstanceGroupsSetNamedPortsRequest{
+		NamedPorts:      namedPorts(ports),
+		ForceSendFields: []string{"NamedPorts"},
 	}
 
-	return g.doCall(g.service.InstanceTemplates.Insert(g.project, template))
+	return callError("SetRegionalNamedPorts", g.doCall(ctx, g.service.RegionInstanceGroups.SetNamedPorts(g.project, g.region(), name, request).Context(ctx)))
 }
 
-func (g *computeServiceWrapper) CreateInstanceGroupManager(name string, settings *InstanceManagerSettings) error {
-	groupManager := &compute.InstanceGroupManager{
-		Name:             name,
-		Description:      settings.Description,
-		Zone:             g.zone,
-		InstanceTemplate: "projects/" + g.project + "/global/instanceTemplates/" + settings.TemplateName,
-		BaseInstanceName: settings.BaseInstanceName,
-		TargetPools:      settings.TargetPools,
-		TargetSize:       settings.TargetSize,
+func (g *computeServiceWrapper) SetRegionalTargetPools(ctx context.Context, name string, targetPools []string) error {
+	request := &compute.RegionInstanceGroupManagersSetTargetPoolsRequest{
+		TargetPools:     targetPools,
+		ForceSendFields: []string{"TargetPools"},
 	}
 
-	return g.doCall(g.service.InstanceGroupManagers.Insert(g.project, g.zone, groupManager))
+	return callError("SetRegionalTargetPools", g.doCall(ctx, g.service.RegionInstanceGroupManagers.SetTargetPools(g.project, g.region(), name, request).Context(ctx)))
 }
 
-func (g *computeServiceWrapper) SetInstanceTemplate(name string, templateName string) error {
-	request := &compute.InstanceGroupManagersSetInstanceTemplateRequest{
-		InstanceTemplate: templateName,
+func (g *computeServiceWrapper) SetRegionalInstanceTemplate(ctx context.Context, name string, templateName string) error {
+	request := &compute.RegionInstanceGroupManagersSetTemplateRequest{
+		InstanceTemplate: "projects/" + g.project + "/global/instanceTemplates/" + templateName,
+	}
+
+	return callError("SetRegionalInstanceTemplate", g.doCall(ctx, g.service.RegionInstanceGroupManagers.SetInstanceTemplate(g.project, g.region(), name, request).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) RecreateRegionalInstances(ctx context.Context, name string, instances ...string) error {
+	request := &compute.RegionInstanceGroupManagersRecreateRequest{
+		Instances: instances,
+	}
+
+	return callError("RecreateRegionalInstances", g.doCall(ctx, g.service.RegionInstanceGroupManagers.RecreateInstances(g.project, g.region(), name, request).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) ResizeRegionalInstanceGroupManager(ctx context.Context, name string, targetSize int64) error {
+	return callError("ResizeRegionalInstanceGroupManager", g.doCall(ctx, g.service.RegionInstanceGroupManagers.Resize(g.project, g.region(), name, targetSize).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) DeleteRegionalInstanceGroupManager(ctx context.Context, name string) error {
+	return callError("DeleteRegionalInstanceGroupManager", g.doCall(ctx, g.service.RegionInstanceGroupManagers.Delete(g.project, g.region(), name).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) AddInstanceGroupToBackendService(ctx context.Context, backendService string, instanceGroup string, drainingTimeoutSec int64) error {
+	service, err := g.service.BackendServices.Get(g.project, backendService).Context(ctx).Do()
+	if err != nil {
+		return callError("AddInstanceGroupToBackendService", err)
+	}
+
+	groupURL := g.instanceGroupURL(instanceGroup)
+	changed := false
+	if backendIndex(service, groupURL) < 0 {
+		service.Backends = append(service.Backends, &compute.Backend{Group: groupURL})
+		changed = true
+	}
+	if drainingTimeoutSec > 0 && (service.ConnectionDraining == nil || service.ConnectionDraining.DrainingTimeoutSec != drainingTimeoutSec) {
+		service.ConnectionDraining = &compute.ConnectionDraining{DrainingTimeoutSec: drainingTimeoutSec}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	return callError("AddInstanceGroupToBackendService", g.doCall(ctx, g.service.BackendServices.Update(g.project, backendService, service).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) RemoveInstanceGroupFromBackendService(ctx context.Context, backendService string, instanceGroup string) error {
+	service, err := g.service.BackendServices.Get(g.project, backendService).Context(ctx).Do()
+	if err != nil {
+		return callError("RemoveInstanceGroupFromBackendService", err)
+	}
+
+	index := backendIndex(service, g.instanceGroupURL(instanceGroup))
+	if index < 0 {
+		return nil
 	}
+	service.Backends = append(service.Backends[:index], service.Backends[index+1:]...)
 
-	return g.doCall(g.service.InstanceGroupManagers.SetInstanceTemplate(g.project, g.zone, name, request))
+	return callError("RemoveInstanceGroupFromBackendService", g.doCall(ctx, g.service.BackendServices.Update(g.project, backendService, service).Context(ctx)))
 }
 
-func (g *computeServiceWrapper) ResizeInstanceGroupManager(name string, targetSize int64) error {
-	return g.doCall(g.service.InstanceGroupManagers.Resize(g.project, g.zone, name, targetSize))
+// instanceGroupURL turns the name of an instance group of the zone, or the
+// relative url of another instance group, into its project relative url.
+func (g *computeServiceWrapper) instanceGroupURL(name string) string {
+	if strings.Contains(name, "/") {
+		return "projects/" + g.project + "/" + name
+	}
+	return "projects/" + g.project + "/zones/" + g.zone + "/instanceGroups/" + name
+}
+
+// backendIndex finds the backend of a backend service that points to an
+// instance group, given by its relative url. -1 means there's none.
+func backendIndex(service *compute.BackendService, groupURL string) int {
+	for i, backend := range service.Backends {
+		if backend.Group == groupURL || strings.HasSuffix(backend.Group, "/"+groupURL) {
+			return i
+		}
+	}
+	return -1
 }
 
 func (g *computeServiceWrapper) region() string {
+	if g.regionName != "" {
+		return g.regionName
+	}
 	return g.zone[:len(g.zone)-2]
 }
 
@@ -530,39 +2470,367 @@ type Call interface {
 	Do(opts ...googleapi.CallOption) (*compute.Operation, error)
 }
 
-func (g *computeServiceWrapper) doCall(call Call) error {
+// TimeoutError is returned when an operation doesn't complete in time.
+type TimeoutError struct {
+	Operation string
+	Timeout   time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Timeout waiting for operation %s after %v", e.Operation, e.Timeout)
+}
+
+func (g *computeServiceWrapper) ReserveAddress(ctx context.Context, name string, settings *AddressSettings) error {
+	if settings.Subnetwork != "" && !settings.Internal {
+		return errors.New("Only internal addresses can be reserved in a subnetwork")
+	}
+
+	subnetwork := g.subnetworkURL(settings.Subnetwork)
+	address := &compute.Address{
+		Name:   name,
+		Region: g.region(),
+	}
+
+	var call Call = g.service.Addresses.Insert(g.project, g.region(), address).Context(ctx)
+	if settings.Internal {
+		body, err := toFields(address)
+		if err != nil {
+			return err
+		}
+		body["addressType"] = "INTERNAL"
+		if subnetwork != "" {
+			body["subnetwork"] = subnetwork
+		}
+
+		call = g.rawCall(ctx, "POST", g.project+"/regions/"+g.region()+"/addresses", body)
+	}
+
+	err := callError("ReserveAddress", g.doCall(ctx, call))
+	if !errors.Is(err, ErrAlreadyExists) {
+		return err
+	}
+
+	existing, getErr := g.GetAddress(ctx, name)
+	if getErr != nil {
+		return err
+	}
+	if existing.Internal != settings.Internal || (settings.Subnetwork != "" && last(existing.Subnetwork) != last(settings.Subnetwork)) {
+		return typed(fmt.Errorf("Address %s already exists with other settings: internal=%t, subnetwork=%s", name, existing.Internal, last(existing.Subnetwork)), ErrConflict)
+	}
+
+	return nil
+}
+
+func (g *computeServiceWrapper) GetAddress(ctx context.Context, name string) (*Address, error) {
+	// The vendored compute client doesn't know about internal addresses.
+	address := struct {
+		Name        string   `json:"name"`
+		Address     string   `json:"address"`
+		Region      string   `json:"region"`
+		AddressType string   `json:"addressType"`
+		Subnetwork  string   `json:"subnetwork"`
+		Status      string   `json:"status"`
+		Users       []string `json:"users"`
+	}{}
+	if err := g.send(ctx, "GET", g.project+"/regions/"+g.region()+"/addresses/"+name, nil, &address); err != nil {
+		return nil, callError("GetAddress", err)
+	}
+
+	return &Address{
+		Name:       address.Name,
+		Address:    address.Address,
+		Region:     last(address.Region),
+		Internal:   address.AddressType == "INTERNAL",
+		Subnetwork: address.Subnetwork,
+		Status:     AddressStatus(address.Status),
+		Users:      address.Users,
+	}, nil
+}
+
+func (g *computeServiceWrapper) ReleaseAddress(ctx context.Context, name string) error {
+	return callError("ReleaseAddress", g.doCall(ctx, g.service.Addresses.Delete(g.project, g.region(), name).Context(ctx)))
+}
+
+func (g *computeServiceWrapper) CreateFirewallRule(ctx context.Context, name string, settings *FirewallSettings) error {
+	allowed, err := firewallAllowed(settings.AllowedPorts)
+	if err != nil {
+		return err
+	}
+
+	network := settings.Network
+	if network == "" {
+		network = "default"
+	}
+
+	// GCE allows every source in a rule without source ranges, and reports
+	// it with 0.0.0.0/0.
+	sourceRanges := settings.SourceRanges
+	if len(sourceRanges) == 0 {
+		sourceRanges = []string{"0.0.0.0/0"}
+	}
+
+	firewall := &compute.Firewall{
+		Name:         name,
+		Network:      g.addAPIUrlPrefix(network, g.project+"/global/networks/"),
+		SourceRanges: sourceRanges,
+		TargetTags:   settings.TargetTags,
+		Allowed:      allowed,
+	}
+
+	err = callError("CreateFirewallRule", g.doCall(ctx, g.service.Firewalls.Insert(g.project, firewall).Context(ctx)))
+	if !errors.Is(err, ErrAlreadyExists) {
+		return err
+	}
+
+	existing, getErr := g.service.Firewalls.Get(g.project, name).Context(ctx).Do()
+	if getErr != nil {
+		return err
+	}
+	if !sameFirewall(existing, firewall) {
+		return typed(fmt.Errorf("Firewall rule %s already exists with other settings", name), ErrConflict)
+	}
+
+	return nil
+}
+
+func (g *computeServiceWrapper) DeleteFirewallRule(ctx context.Context, name string) error {
+	return callError("DeleteFirewallRule", g.doCall(ctx, g.service.Firewalls.Delete(g.project, name).Context(ctx)))
+}
+
+// firewallAllowed parses the allowed ports of a firewall rule, given as
+// protocol[:ports].
+func firewallAllowed(ports []string) ([]*compute.FirewallAllowed, error) {
+	allowed := []*compute.FirewallAllowed{}
+	for _, port := range ports {
+		parts := strings.SplitN(port, ":", 2)
+		if parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+			return nil, fmt.Errorf("Invalid allowed port %s: should be protocol[:ports]", port)
+		}
+
+		rule := &compute.FirewallAllowed{IPProtocol: parts[0]}
+		if len(parts) == 2 {
+			rule.Ports = []string{parts[1]}
+		}
+		allowed = append(allowed, rule)
+	}
+
+	return allowed, nil
+}
+
+// sameFirewall compares the network, sources, targets and allowed ports of two
+// firewall rules, regardless of their order.
+func sameFirewall(a, b *compute.Firewall) bool {
+	return last(a.Network) == last(b.Network) &&
+		sameStrings(a.SourceRanges, b.SourceRanges) &&
+		sameStrings(a.TargetTags, b.TargetTags) &&
+		sameStrings(allowedPorts(a.Allowed), allowedPorts(b.Allowed))
+}
+
+func allowedPorts(allowed []*compute.FirewallAllowed) []string {
+	ports := []string{}
+	for _, rule := range allowed {
+		if len(rule.Ports) == 0 {
+			ports = append(ports, rule.IPProtocol)
+		}
+		for _, port := range rule.Ports {
+			ports = append(ports, rule.IPProtocol+":"+port)
+		}
+	}
+	return ports
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	sortedA := append([]string(nil), a...)
+	sortedB := append([]string(nil), b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rawCall is a Call that bypasses the compute client, to send the fields
+// that the vendored client doesn't know about yet.
+type rawCall struct {
+	ctx    context.Context
+	g      *computeServiceWrapper
+	method string
+	path   string
+	body   interface{}
+}
+
+func (g *computeServiceWrapper) rawCall(ctx context.Context, method, path string, body interface{}) Call {
+	return &rawCall{ctx: ctx, g: g, method: method, path: path, body: body}
+}
+
+func (c *rawCall) Do(opts ...googleapi.CallOption) (*compute.Operation, error) {
+	op := &compute.Operation{}
+	if err := c.g.send(c.ctx, c.method, c.path, c.body, op); err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+// send sends a request to the compute API, given the path of a resource
+// relative to the base path of the API, and decodes the response.
+func (g *computeServiceWrapper) send(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, googleapi.ResolveRelative(g.service.BasePath, path), reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("User-Agent", g.UserAgent())
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := g.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := googleapi.CheckResponse(response); err != nil {
+		return err
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// toFields turns a resource of the compute client into a map of its json
+// fields, so that fields unknown to the client can be added.
+func toFields(resource interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+func (g *computeServiceWrapper) doCall(ctx context.Context, call Call) error {
+	return g.doCallWithTimeout(ctx, call, g.operationTimeout)
+}
+
+func (g *computeServiceWrapper) doCallWithTimeout(ctx context.Context, call Call, timeout time.Duration) error {
 	op, err := call.Do()
 	if err != nil {
 		return err
 	}
 
+	return g.waitForOperation(ctx, op, timeout)
+}
+
+// waitForOperation polls an operation until it's DONE or until the timeout
+// expires, backing off from the poll interval up to maxPollInterval. An
+// operation that completes with errors is reported as a Go error.
+func (g *computeServiceWrapper) waitForOperation(ctx context.Context, op *compute.Operation, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	interval := g.pollInterval
+
 	for {
 		if op.Status == "DONE" {
-			if op.Error != nil {
-				return fmt.Errorf("Operation error: %v", *op.Error.Errors[0])
-			}
+			return operationError(op)
+		}
 
-			return nil
+		remaining := time.Until(deadline)
+		if remaining < 0 {
+			return &TimeoutError{Operation: op.Name, Timeout: timeout}
+		}
+		if interval > remaining {
+			interval = remaining
 		}
 
-		time.Sleep(1 * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		interval = backoff(interval)
 
-		op, err = g.getOperationCall(op).Do()
+		var err error
+		op, err = g.getOperationCall(ctx, op).Do()
 		if err != nil {
 			return err
 		}
 	}
 }
 
-func (g *computeServiceWrapper) getOperationCall(op *compute.Operation) Call {
+// backoff doubles the delay between two checks of an operation, up to
+// maxPollInterval.
+func backoff(interval time.Duration) time.Duration {
+	if interval *= 2; interval <= 0 || interval > maxPollInterval {
+		return maxPollInterval
+	}
+	return interval
+}
+
+func operationError(op *compute.Operation) error {
+	if op.Error == nil || len(op.Error.Errors) == 0 {
+		return nil
+	}
+
+	var kind error
+	for _, e := range op.Error.Errors {
+		if kind == nil {
+			kind = operationErrorKinds[e.Code]
+		}
+	}
+
+	return typed(&OperationError{Operation: op.Name, Errors: op.Error.Errors}, kind)
+}
+
+func (g *computeServiceWrapper) getOperationCall(ctx context.Context, op *compute.Operation) Call {
 	switch {
 	case op.Zone != "":
-		return g.service.ZoneOperations.Get(g.project, last(op.Zone), op.Name)
+		return g.service.ZoneOperations.Get(g.project, last(op.Zone), op.Name).Context(ctx)
 	case op.Region != "":
-		return g.service.RegionOperations.Get(g.project, last(op.Region), op.Name)
+		return g.service.RegionOperations.Get(g.project, last(op.Region), op.Name).Context(ctx)
 	default:
-		return g.service.GlobalOperations.Get(g.project, op.Name)
+		return g.service.GlobalOperations.Get(g.project, op.Name).Context(ctx)
+	}
+}
+
+// resourceProject extracts the project of a resource url, if any.
+func resourceProject(url, defaultProject string) string {
+	return pathSegment(url, "projects", defaultProject)
+}
+
+// resourceRegion extracts the region of a regional resource url, if any.
+func resourceRegion(url, defaultRegion string) string {
+	return pathSegment(url, "regions", defaultRegion)
+}
+
+func pathSegment(url, collection, defaultValue string) string {
+	parts := strings.Split(url, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == collection {
+			return parts[i+1]
+		}
 	}
+	return defaultValue
 }
 
 func last(url string) string {
@@ -0,0 +1,2232 @@
+package gcloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/infrakit.gcp/plugin/internal/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+)
+
+func newTestAPI(t *testing.T, handler http.Handler) (*computeServiceWrapper, func()) {
+	server := httptest.NewServer(handler)
+
+	service, err := compute.New(server.Client())
+	require.NoError(t, err)
+	service.BasePath = server.URL + "/"
+
+	return &computeServiceWrapper{
+		project:          "PROJECT",
+		zone:             "us-central1-f",
+		service:          service,
+		client:           server.Client(),
+		operationTimeout: DefaultOperationTimeout,
+		pollInterval:     time.Millisecond,
+	}, server.Close
+}
+
+func TestDeleteInstanceWaitsForOperation(t *testing.T) {
+	polls := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "PENDING"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/operations/op", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		if polls < 3 {
+			testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "RUNNING"})
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.DeleteInstance(context.Background(), "vm")
+
+	require.NoError(t, err)
+	require.Equal(t, 3, polls)
+}
+
+func TestCreateInstanceOperationError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "PENDING"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/operations/op", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Operation{
+			Name:   "op",
+			Zone:   "zones/us-central1-f",
+			Status: "DONE",
+			Error: &compute.OperationError{
+				Errors: []*compute.OperationErrorErrors{
+					{Code: "QUOTA_EXCEEDED", Message: "Quota 'CPUS' exceeded"},
+				},
+			},
+		})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{})
+
+	require.EqualError(t, err, "Operation op failed: QUOTA_EXCEEDED: Quota 'CPUS' exceeded")
+}
+
+func TestOperationTimeout(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "PENDING"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/operations/op", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "RUNNING"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+	WithOperationTimeout(10 * time.Millisecond)(api)
+
+	err := api.DeleteInstance(context.Background(), "vm")
+
+	require.EqualError(t, err, "Timeout waiting for operation op after 10ms")
+}
+
+func TestOperationPollingBacksOff(t *testing.T) {
+	polls := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "PENDING"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/operations/op", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "RUNNING"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+	WithOperationTimeout(100 * time.Millisecond)(api)
+
+	err := api.DeleteInstance(context.Background(), "vm")
+
+	require.EqualError(t, err, "Timeout waiting for operation op after 100ms")
+	require.True(t, polls < 15, "%d polls", polls)
+}
+
+func TestBackoff(t *testing.T) {
+	require.Equal(t, 2*time.Second, backoff(time.Second))
+	require.Equal(t, 8*time.Second, backoff(4*time.Second))
+	require.Equal(t, maxPollInterval, backoff(8*time.Second))
+	require.Equal(t, maxPollInterval, backoff(maxPollInterval))
+}
+
+func TestCreateInstanceWithoutExternalIP(t *testing.T) {
+	var inserted compute.Instance
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{NoExternalIP: true})
+
+	require.NoError(t, err)
+	require.Len(t, inserted.NetworkInterfaces, 1)
+	require.Empty(t, inserted.NetworkInterfaces[0].AccessConfigs)
+}
+
+func TestCreateInstanceWithExternalIP(t *testing.T) {
+	var inserted compute.Instance
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{})
+
+	require.NoError(t, err)
+	require.Len(t, inserted.NetworkInterfaces, 1)
+	require.Equal(t, "ONE_TO_ONE_NAT", inserted.NetworkInterfaces[0].AccessConfigs[0].Type)
+}
+
+func TestCreateInstanceTemplateWithoutExternalIP(t *testing.T) {
+	var inserted compute.InstanceTemplate
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		inserted = compute.InstanceTemplate{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstanceTemplate(context.Background(), "workers-1", &InstanceSettings{NoExternalIP: true})
+
+	require.NoError(t, err)
+	require.Len(t, inserted.Properties.NetworkInterfaces, 1)
+	require.Empty(t, inserted.Properties.NetworkInterfaces[0].AccessConfigs)
+
+	err = api.CreateInstanceTemplate(context.Background(), "workers-2", &InstanceSettings{})
+
+	require.NoError(t, err)
+	require.Len(t, inserted.Properties.NetworkInterfaces, 1)
+	require.Equal(t, "ONE_TO_ONE_NAT", inserted.Properties.NetworkInterfaces[0].AccessConfigs[0].Type)
+}
+
+func TestCreateInstanceWithIPForwarding(t *testing.T) {
+	var inserted compute.Instance
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		inserted = compute.Instance{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "nat", &InstanceSettings{CanIPForward: true})
+
+	require.NoError(t, err)
+	require.True(t, inserted.CanIpForward)
+
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{})
+
+	require.NoError(t, err)
+	require.False(t, inserted.CanIpForward)
+}
+
+func TestCreateInstanceTemplateWithIPForwarding(t *testing.T) {
+	var inserted compute.InstanceTemplate
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		inserted = compute.InstanceTemplate{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstanceTemplate(context.Background(), "routers-1", &InstanceSettings{CanIPForward: true, Labels: map[string]string{"role": "router"}})
+
+	require.NoError(t, err)
+	require.True(t, inserted.Properties.CanIpForward)
+
+	err = api.CreateInstanceTemplate(context.Background(), "workers-1", &InstanceSettings{})
+
+	require.NoError(t, err)
+	require.False(t, inserted.Properties.CanIpForward)
+}
+
+func TestCreateInstanceWithServiceAccount(t *testing.T) {
+	var inserted compute.Instance
+	var template compute.InstanceTemplate
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		inserted = compute.Instance{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		template = compute.InstanceTemplate{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&template))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	email := "workers@PROJECT.iam.gserviceaccount.com"
+	scopes := []string{"https://www.googleapis.com/auth/cloud-platform"}
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{ServiceAccount: email, Scopes: scopes})
+
+	require.NoError(t, err)
+	require.Equal(t, []*compute.ServiceAccount{{Email: email, Scopes: scopes}}, inserted.ServiceAccounts)
+
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{Scopes: scopes})
+
+	require.NoError(t, err)
+	require.Equal(t, []*compute.ServiceAccount{{Email: "default", Scopes: scopes}}, inserted.ServiceAccounts)
+
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{ServiceAccount: email})
+
+	require.NoError(t, err)
+	require.Equal(t, []*compute.ServiceAccount{{Email: email}}, inserted.ServiceAccounts)
+
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{Scopes: []string{"compute-ro", "https://www.googleapis.com/auth/cloud-platform"}})
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://www.googleapis.com/auth/compute.readonly", "https://www.googleapis.com/auth/cloud-platform"}, inserted.ServiceAccounts[0].Scopes)
+
+	err = api.CreateInstanceTemplate(context.Background(), "workers-1", &InstanceSettings{ServiceAccount: email, Scopes: scopes})
+
+	require.NoError(t, err)
+	require.Equal(t, []*compute.ServiceAccount{{Email: email, Scopes: scopes}}, template.Properties.ServiceAccounts)
+
+	err = api.CreateInstanceTemplate(context.Background(), "workers-2", &InstanceSettings{Scopes: []string{"storage-ro", "https://www.googleapis.com/auth/logging.write"}})
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://www.googleapis.com/auth/devstorage.read_only", "https://www.googleapis.com/auth/logging.write"}, template.Properties.ServiceAccounts[0].Scopes)
+}
+
+func TestCreateInstanceWithNetworkTier(t *testing.T) {
+	var inserted struct {
+		NetworkInterfaces []struct {
+			AccessConfigs []map[string]string
+		}
+		Properties struct {
+			NetworkInterfaces []struct {
+				AccessConfigs []map[string]string
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	}
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", handler)
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", handler)
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	for _, tier := range []string{"PREMIUM", "STANDARD"} {
+		err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{NetworkTier: tier})
+
+		require.NoError(t, err)
+		require.Equal(t, "ONE_TO_ONE_NAT", inserted.NetworkInterfaces[0].AccessConfigs[0]["type"])
+		require.Equal(t, tier, inserted.NetworkInterfaces[0].AccessConfigs[0]["networkTier"])
+
+		err = api.CreateInstanceTemplate(context.Background(), "workers-1", &InstanceSettings{NetworkTier: tier})
+
+		require.NoError(t, err)
+		require.Equal(t, tier, inserted.Properties.NetworkInterfaces[0].AccessConfigs[0]["networkTier"])
+	}
+}
+
+func WriteCredentialsFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "credentials")
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = file.WriteString(content)
+	require.NoError(t, err)
+
+	return file.Name()
+}
+
+const serviceAccountKey = `{
+  "type": "service_account",
+  "project_id": "PROJECT",
+  "private_key_id": "KEY_ID",
+  "private_key": "PRIVATE_KEY",
+  "client_email": "infrakit@PROJECT.iam.gserviceaccount.com",
+  "client_id": "CLIENT_ID"
+}`
+
+func TestNewAPIWithCredentialsFile(t *testing.T) {
+	path := WriteCredentialsFile(t, serviceAccountKey)
+	defer os.Remove(path)
+
+	api, err := NewAPI("PROJECT", "us-central1-f", WithCredentialsFile(path))
+
+	require.NoError(t, err)
+	require.Equal(t, "PROJECT", api.GetProject())
+}
+
+func TestNewAPIWithDefaultCredentials(t *testing.T) {
+	path := WriteCredentialsFile(t, serviceAccountKey)
+	defer os.Remove(path)
+
+	previous, set := os.LookupEnv("GOOGLE_APPLICATION_CREDENTIALS")
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+	defer func() {
+		if set {
+			os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", previous)
+		} else {
+			os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+		}
+	}()
+
+	api, err := NewAPI("PROJECT", "us-central1-f", WithCredentialsFile(""))
+
+	require.NoError(t, err)
+	require.Equal(t, "PROJECT", api.GetProject())
+}
+
+func TestNewAPIWithMalformedCredentialsFile(t *testing.T) {
+	path := WriteCredentialsFile(t, "{not json")
+	defer os.Remove(path)
+
+	_, err := NewAPI("PROJECT", "us-central1-f", WithCredentialsFile(path))
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Invalid credentials file "+path)
+}
+
+func TestNewAPIWithCredentialsFileForAnotherProject(t *testing.T) {
+	path := WriteCredentialsFile(t, serviceAccountKey)
+	defer os.Remove(path)
+
+	_, err := NewAPI("OTHER", "us-central1-f", WithCredentialsFile(path))
+
+	require.EqualError(t, err, "Credentials file "+path+" is for project PROJECT, not OTHER")
+}
+
+func TestNewAPIWithMissingCredentialsFile(t *testing.T) {
+	_, err := NewAPI("PROJECT", "us-central1-f", WithCredentialsFile("/missing/credentials.json"))
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Unable to read credentials file /missing/credentials.json")
+}
+
+func TestCreateInstanceWithStaticIP(t *testing.T) {
+	var inserted compute.Instance
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/addresses/manager", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Address{Name: "manager", Address: "35.1.2.3", Status: "RESERVED"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{StaticIP: "manager"})
+
+	require.NoError(t, err)
+	require.Equal(t, "35.1.2.3", inserted.NetworkInterfaces[0].AccessConfigs[0].NatIP)
+}
+
+func TestCreateInstanceWithLiteralStaticIP(t *testing.T) {
+	var inserted compute.Instance
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{StaticIP: "35.1.2.3"})
+
+	require.NoError(t, err)
+	require.Equal(t, "35.1.2.3", inserted.NetworkInterfaces[0].AccessConfigs[0].NatIP)
+}
+
+func TestCreateInstanceWithStaticIPInUse(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/addresses/manager", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Address{Name: "manager", Address: "35.1.2.3", Status: "IN_USE", Users: []string{"vm-1"}})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{StaticIP: "manager"})
+
+	require.EqualError(t, err, "Static IP address manager (35.1.2.3) is already in use by vm-1")
+}
+
+func TestCreateInstanceWithStaticIPPath(t *testing.T) {
+	var inserted compute.Instance
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/addresses/manager", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Address{Name: "manager", Address: "35.1.2.3", Status: "RESERVED"})
+	})
+	mux.HandleFunc("/PROJECT/regions/us-central1/addresses/internal", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, map[string]string{"name": "internal", "address": "10.128.0.10", "addressType": "INTERNAL", "status": "RESERVED"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{StaticIP: "projects/PROJECT/regions/us-central1/addresses/manager"})
+
+	require.NoError(t, err)
+	require.Equal(t, "35.1.2.3", inserted.NetworkInterfaces[0].AccessConfigs[0].NatIP)
+
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{StaticIP: "regions/europe-west1/addresses/manager"})
+
+	require.EqualError(t, err, "Static IP address manager is in region europe-west1 but zone us-central1-f is in region us-central1")
+
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{StaticIP: "projects/PROJECT/global/addresses/manager"})
+
+	require.EqualError(t, err, "Static IP address manager is global, instances need an address of region us-central1")
+
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{StaticIP: "internal"})
+
+	require.EqualError(t, err, "Static IP address internal (10.128.0.10) is internal, instances need an external address")
+}
+
+func TestCreateInstanceWithPrivateIP(t *testing.T) {
+	var inserted compute.Instance
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/subnetworks/managers", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Subnetwork{Name: "managers", IpCidrRange: "10.0.1.0/24"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "PENDING"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/operations/op", func(w http.ResponseWriter, r *http.Request) {
+		if inserted.NetworkInterfaces[0].NetworkIP != "10.0.1.3" {
+			testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "DONE"})
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.Operation{
+			Name:   "op",
+			Zone:   "zones/us-central1-f",
+			Status: "DONE",
+			Error: &compute.OperationError{
+				Errors: []*compute.OperationErrorErrors{
+					{Code: "IP_IN_USE_BY_ANOTHER_RESOURCE", Message: "IP '10.0.1.3' is already being used by another resource."},
+				},
+			},
+		})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{Network: "default", Subnetwork: "managers", PrivateIP: "10.0.1.2"})
+
+	require.NoError(t, err)
+	require.Equal(t, "10.0.1.2", inserted.NetworkInterfaces[0].NetworkIP)
+
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{Network: "default", Subnetwork: "managers", PrivateIP: "10.0.1.3"})
+
+	require.True(t, errors.Is(err, ErrIPInUse))
+
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{Network: "default", Subnetwork: "managers", PrivateIP: "10.0.2.2"})
+
+	require.EqualError(t, err, "Private IP 10.0.2.2 is outside of the range 10.0.1.0/24 of subnetwork managers")
+}
+
+func TestCreateInstanceWithUnknownStaticIP(t *testing.T) {
+	api, done := newTestAPI(t, http.NotFoundHandler())
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{StaticIP: "manager"})
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Unable to find static IP address manager in region us-central1")
+}
+
+func TestListMaintenanceOperations(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/operations", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, `operationType eq compute\.instances\.(migrate|terminate)OnHostMaintenance`, r.URL.Query().Get("filter"))
+		testutil.ReplyJSON(t, w, &compute.OperationList{Items: []*compute.Operation{
+			{Name: "op-1", TargetLink: "projects/PROJECT/zones/us-central1-f/instances/vm-1"},
+			{Name: "op-2", TargetLink: "projects/PROJECT/zones/us-central1-f/instances/vm-2"},
+		}})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	operations, err := api.ListMaintenanceOperations(context.Background(), "us-central1-f", "vm-1")
+
+	require.NoError(t, err)
+	require.Len(t, operations, 1)
+	require.Equal(t, "op-1", operations[0].Name)
+}
+
+func TestGetInstanceMaintenance(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm-1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name": "vm-1", "scheduling": {"onHostMaintenance": "MIGRATE"}, "resourceStatus": {"upcomingMaintenance": {
+			"type": "SCHEDULED",
+			"maintenanceStatus": "PENDING",
+			"windowStartTime": "2017-06-14T02:00:00.000-07:00",
+			"windowEndTime": "2017-06-14T06:00:00.000-07:00",
+			"canReschedule": true
+		}}}`))
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm-2", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name": "vm-2", "scheduling": {"onHostMaintenance": "TERMINATE"}}`))
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	maintenance, err := api.GetInstanceMaintenance(context.Background(), "us-central1-f", "vm-1")
+
+	require.NoError(t, err)
+	require.Equal(t, &InstanceMaintenance{
+		OnHostMaintenance: "MIGRATE",
+		Upcoming: &UpcomingMaintenance{
+			Type:          "SCHEDULED",
+			Status:        "PENDING",
+			WindowStart:   "2017-06-14T02:00:00.000-07:00",
+			WindowEnd:     "2017-06-14T06:00:00.000-07:00",
+			CanReschedule: true,
+		},
+	}, maintenance)
+
+	maintenance, err = api.GetInstanceMaintenance(context.Background(), "us-central1-f", "vm-2")
+
+	require.NoError(t, err)
+	require.Equal(t, &InstanceMaintenance{OnHostMaintenance: "TERMINATE"}, maintenance)
+}
+
+func TestDeleteInstanceDeadlineExceeded(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "PENDING"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/operations/op", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "zones/us-central1-f", Status: "RUNNING"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := api.DeleteInstance(ctx, "vm")
+
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.Contains(t, err.Error(), "DeleteInstance")
+}
+
+func TestCreateInstanceTemplateFromSourceInstance(t *testing.T) {
+	var template map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&template))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstanceTemplate(context.Background(), "workers-1", &InstanceSettings{
+		Description:    "golden",
+		MachineType:    "n1-standard-1",
+		SourceInstance: "golden-vm",
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"name":           "workers-1",
+		"description":    "golden",
+		"sourceInstance": api.service.BasePath + "PROJECT/zones/us-central1-f/instances/golden-vm",
+	}, template)
+}
+
+func TestCreateInstanceWithAccelerators(t *testing.T) {
+	var inserted struct {
+		GuestAccelerators []map[string]interface{}
+		Scheduling        map[string]interface{}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{
+		MachineType:  "n1-standard-4",
+		Accelerators: []Accelerator{{Type: "nvidia-tesla-t4", Count: 1}},
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, []map[string]interface{}{
+		{
+			"acceleratorType":  api.service.BasePath + "PROJECT/zones/us-central1-f/acceleratorTypes/nvidia-tesla-t4",
+			"acceleratorCount": 1.0,
+		},
+	}, inserted.GuestAccelerators)
+	require.Equal(t, "TERMINATE", inserted.Scheduling["onHostMaintenance"])
+}
+
+func TestCreateInstanceTemplateWithAccelerators(t *testing.T) {
+	var inserted struct {
+		Properties struct {
+			GuestAccelerators []map[string]interface{}
+			Scheduling        map[string]interface{}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstanceTemplate(context.Background(), "workers-1", &InstanceSettings{
+		MachineType:  "n1-standard-4",
+		Accelerators: []Accelerator{{Type: "nvidia-tesla-t4", Count: 1}},
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, []map[string]interface{}{
+		{"acceleratorType": "nvidia-tesla-t4", "acceleratorCount": 1.0},
+	}, inserted.Properties.GuestAccelerators)
+	require.Equal(t, "TERMINATE", inserted.Properties.Scheduling["onHostMaintenance"])
+}
+
+func TestCreateInstanceWithLabels(t *testing.T) {
+	var inserted struct {
+		Name   string
+		Labels map[string]string
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{
+		MachineType: "n1-standard-1",
+		Labels:      map[string]string{"role": "worker"},
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "vm", inserted.Name)
+	require.Equal(t, map[string]string{"role": "worker"}, inserted.Labels)
+}
+
+func TestAddInstanceLabels(t *testing.T) {
+	var set map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, map[string]interface{}{
+			"name":             "vm",
+			"labels":           map[string]string{"role": "worker", "env": "dev"},
+			"labelFingerprint": "42",
+		})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm/setLabels", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&set))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.AddInstanceLabels(context.Background(), "vm", map[string]string{"env": "prod"})
+
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"labels":           map[string]interface{}{"role": "worker", "env": "prod"},
+		"labelFingerprint": "42",
+	}, set)
+}
+
+func TestAddInstanceLabelsRetriesOnFingerprintConflict(t *testing.T) {
+	gets := 0
+	fingerprints := []interface{}{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm", func(w http.ResponseWriter, r *http.Request) {
+		gets++
+		testutil.ReplyJSON(t, w, map[string]interface{}{
+			"name":             "vm",
+			"labelFingerprint": fmt.Sprintf("%d", gets),
+		})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm/setLabels", func(w http.ResponseWriter, r *http.Request) {
+		set := map[string]interface{}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&set))
+		fingerprints = append(fingerprints, set["labelFingerprint"])
+
+		// The labels changed since the first read.
+		if len(fingerprints) == 1 {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`{"error": {"code": 412, "message": "Labels fingerprint either invalid or resource labels have changed"}}`))
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.AddInstanceLabels(context.Background(), "vm", map[string]string{"env": "prod"})
+
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{"1", "2"}, fingerprints)
+}
+
+func TestAddInstanceMetadataRetriesOnlyOnce(t *testing.T) {
+	updates := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Instance{Name: "vm", Metadata: &compute.Metadata{Fingerprint: "42"}})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm/setMetadata", func(w http.ResponseWriter, r *http.Request) {
+		updates++
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write([]byte(`{"error": {"code": 412, "message": "Supplied fingerprint does not match current metadata fingerprint."}}`))
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	value := "worker"
+	err := api.AddInstanceMetadata(context.Background(), "vm", []*compute.MetadataItems{{Key: "role", Value: &value}})
+
+	require.Error(t, err)
+	require.Equal(t, 2, updates)
+}
+
+func TestCreateInstanceWithResourcePolicies(t *testing.T) {
+	var inserted struct {
+		ResourcePolicies []string
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{MachineType: "n2-standard-4", ResourcePolicies: []string{"workers-spread-3"}})
+
+	require.NoError(t, err)
+	require.Equal(t, []string{api.service.BasePath + "PROJECT/regions/us-central1/resourcePolicies/workers-spread-3"}, inserted.ResourcePolicies)
+}
+
+func TestCreateInstanceTemplateWithResourcePolicies(t *testing.T) {
+	var inserted struct {
+		Properties struct {
+			ResourcePolicies []string
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstanceTemplate(context.Background(), "workers-1", &InstanceSettings{MachineType: "n2-standard-4", ResourcePolicies: []string{"workers-spread-3"}})
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"workers-spread-3"}, inserted.Properties.ResourcePolicies)
+}
+
+func TestCreateInstanceTemplateWithAdditionalDisks(t *testing.T) {
+	var inserted struct {
+		Properties struct {
+			Disks []struct {
+				Boot             bool
+				DeviceName       string
+				Source           string
+				InitializeParams map[string]interface{}
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/disks", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("No disk should be created along with the template")
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstanceTemplate(context.Background(), "workers-1", &InstanceSettings{
+		Disks: []DiskSettings{
+			{Boot: true, SizeGb: 10, Image: "docker", Type: "pd-standard", AutoDelete: true},
+			{SizeGb: 100, Snapshot: "backup", Type: "pd-ssd", NameSuffix: "-data", DeviceName: "data"},
+			{SizeGb: 50, NameSuffix: "-scratch"},
+		},
+	})
+
+	require.NoError(t, err)
+	disks := inserted.Properties.Disks
+	require.Len(t, disks, 3)
+	require.Equal(t, map[string]interface{}{"sourceImage": api.service.BasePath + "docker", "diskSizeGb": "10", "diskType": "pd-standard"}, disks[0].InitializeParams)
+	require.Equal(t, "data", disks[1].DeviceName)
+	require.Empty(t, disks[1].Source)
+	require.Equal(t, map[string]interface{}{"sourceSnapshot": api.service.BasePath + "PROJECT/global/snapshots/backup", "diskSizeGb": "100", "diskType": "pd-ssd"}, disks[1].InitializeParams)
+	require.Equal(t, map[string]interface{}{"diskSizeGb": "50"}, disks[2].InitializeParams)
+}
+
+func TestSpreadPlacementPolicy(t *testing.T) {
+	var created map[string]interface{}
+	deleted := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/resourcePolicies", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+	mux.HandleFunc("/PROJECT/regions/us-central1/resourcePolicies/workers-spread-3", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			testutil.ReplyJSON(t, w, map[string]interface{}{
+				"name":                 "workers-spread-3",
+				"groupPlacementPolicy": map[string]interface{}{"availabilityDomainCount": 3},
+			})
+		case "DELETE":
+			deleted = true
+			testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+		}
+	})
+	mux.HandleFunc("/PROJECT/regions/us-central1/resourcePolicies/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, map[string]interface{}{"name": "snapshots"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateSpreadPlacementPolicy(context.Background(), "workers-spread-3", 3)
+
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"name":                 "workers-spread-3",
+		"groupPlacementPolicy": map[string]interface{}{"availabilityDomainCount": 3.0},
+	}, created)
+
+	availabilityDomains, err := api.GetSpreadPlacementPolicy(context.Background(), "workers-spread-3")
+
+	require.NoError(t, err)
+	require.Equal(t, int64(3), availabilityDomains)
+
+	availabilityDomains, err = api.GetSpreadPlacementPolicy(context.Background(), "snapshots")
+
+	require.NoError(t, err)
+	require.Equal(t, int64(0), availabilityDomains)
+
+	_, err = api.GetSpreadPlacementPolicy(context.Background(), "unknown")
+
+	require.True(t, errors.Is(err, ErrNotFound))
+
+	require.NoError(t, api.DeleteResourcePolicy(context.Background(), "workers-spread-3"))
+	require.True(t, deleted)
+}
+
+func TestStartRollingUpdate(t *testing.T) {
+	var patched map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroupManagers/workers", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "PATCH", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&patched))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.StartRollingUpdate(context.Background(), "workers", "workers-2", 2, 0)
+
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"updatePolicy": map[string]interface{}{
+			"type":           "PROACTIVE",
+			"minimalAction":  "REPLACE",
+			"maxSurge":       map[string]interface{}{"fixed": 2.0},
+			"maxUnavailable": map[string]interface{}{"fixed": 0.0},
+		},
+		"versions": []interface{}{
+			map[string]interface{}{"instanceTemplate": "projects/PROJECT/global/instanceTemplates/workers-2"},
+		},
+	}, patched)
+}
+
+func TestAggregatedListInstances(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/aggregated/instances", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pageToken") == "" {
+			testutil.ReplyJSON(t, w, &compute.InstanceAggregatedList{
+				Items: map[string]compute.InstancesScopedList{
+					"zones/us-central1-f":  {Instances: []*compute.Instance{{Name: "vm-1", Zone: "zones/us-central1-f"}}},
+					"zones/europe-west1-b": {Instances: []*compute.Instance{{Name: "vm-2", Zone: "zones/europe-west1-b"}}},
+					"zones/asia-east1-a":   {},
+				},
+				NextPageToken: "next",
+			})
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.InstanceAggregatedList{
+			Items: map[string]compute.InstancesScopedList{
+				"zones/us-central1-f": {Instances: []*compute.Instance{{Name: "vm-3", Zone: "zones/us-central1-f"}}},
+			},
+		})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	instances, err := api.AggregatedListInstances(context.Background(), "")
+
+	require.NoError(t, err)
+	names := []string{}
+	for _, instance := range instances {
+		names = append(names, instance.Name+"@"+last(instance.Zone))
+	}
+	require.Equal(t, []string{"vm-2@europe-west1-b", "vm-1@us-central1-f", "vm-3@us-central1-f"}, names)
+}
+
+func TestListInstancesWithFilter(t *testing.T) {
+	var filter string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		filter = r.URL.Query().Get("filter")
+		testutil.ReplyJSON(t, w, &compute.InstanceList{Items: []*compute.Instance{{Name: "manager-1"}}})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	instances, err := api.ListInstances(context.Background(), "name eq manager-1")
+
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	require.Equal(t, "name eq manager-1", filter)
+}
+
+func TestListInstanceTemplatesWithFilter(t *testing.T) {
+	var filter string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		filter = r.URL.Query().Get("filter")
+		testutil.ReplyJSON(t, w, &compute.InstanceTemplateList{Items: []*compute.InstanceTemplate{{Name: "workers-1"}}})
+	})
+	mux.HandleFunc("/PROJECT/global/instanceTemplates/workers-1", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.InstanceTemplate{Name: "workers-1", Properties: &compute.InstanceProperties{MachineType: "n1-standard-1"}})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	templates, err := api.ListInstanceTemplates(context.Background(), "name eq workers-[0-9]+")
+
+	require.NoError(t, err)
+	require.Len(t, templates, 1)
+	require.Equal(t, "name eq workers-[0-9]+", filter)
+
+	template, err := api.GetInstanceTemplate(context.Background(), "workers-1")
+
+	require.NoError(t, err)
+	require.Equal(t, "n1-standard-1", template.Properties.MachineType)
+
+	_, err = api.GetInstanceTemplate(context.Background(), "workers-2")
+
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestAddInstanceGroupToBackendService(t *testing.T) {
+	var updated compute.BackendService
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/backendServices/web", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			testutil.ReplyJSON(t, w, &compute.BackendService{
+				Name:        "web",
+				Fingerprint: "FINGERPRINT",
+				Backends:    []*compute.Backend{{Group: "https://www.googleapis.com/compute/v1/projects/PROJECT/zones/us-central1-a/instanceGroups/other"}},
+			})
+		case "PUT":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&updated))
+			testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+		}
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.AddInstanceGroupToBackendService(context.Background(), "web", "workers", 300)
+
+	require.NoError(t, err)
+	require.Equal(t, "FINGERPRINT", updated.Fingerprint)
+	require.Len(t, updated.Backends, 2)
+	require.Equal(t, "projects/PROJECT/zones/us-central1-f/instanceGroups/workers", updated.Backends[1].Group)
+	require.Equal(t, int64(300), updated.ConnectionDraining.DrainingTimeoutSec)
+}
+
+func TestAddInstanceGroupAlreadyInBackendService(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/backendServices/web", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "GET", r.Method)
+		testutil.ReplyJSON(t, w, &compute.BackendService{
+			Name:     "web",
+			Backends: []*compute.Backend{{Group: "https://www.googleapis.com/compute/v1/projects/PROJECT/zones/us-central1-f/instanceGroups/workers"}},
+		})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.AddInstanceGroupToBackendService(context.Background(), "web", "workers", 0)
+
+	require.NoError(t, err)
+}
+
+func TestValidateNetwork(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/networks/default", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Network{Name: "default", AutoCreateSubnetworks: true})
+	})
+	mux.HandleFunc("/PROJECT/global/networks/other", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Network{Name: "other", AutoCreateSubnetworks: true})
+	})
+	mux.HandleFunc("/PROJECT/global/networks/legacy", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Network{Name: "legacy", IPv4Range: "10.240.0.0/16"})
+	})
+	mux.HandleFunc("/PROJECT/global/networks/custom", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Network{Name: "custom"})
+	})
+	mux.HandleFunc("/PROJECT/regions/us-central1/subnetworks/sub", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Subnetwork{Name: "sub", Network: "https://www.googleapis.com/compute/v1/projects/PROJECT/global/networks/default"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+	ctx := context.Background()
+
+	require.NoError(t, api.ValidateNetwork(ctx, "default", ""))
+	require.NoError(t, api.ValidateNetwork(ctx, "default", "sub"))
+	require.NoError(t, api.ValidateNetwork(ctx, "projects/PROJECT/global/networks/default", "projects/PROJECT/regions/us-central1/subnetworks/sub"))
+
+	err := api.ValidateNetwork(ctx, "missing", "")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Unable to find network missing")
+	require.True(t, errors.Is(err, ErrNotFound))
+
+	err = api.ValidateNetwork(ctx, "default", "projects/PROJECT/regions/europe-west1/subnetworks/sub")
+	require.EqualError(t, err, "Subnetwork sub is in region europe-west1 but zone us-central1-f is in region us-central1")
+
+	err = api.ValidateNetwork(ctx, "default", "missing")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Unable to find subnetwork missing in region us-central1")
+
+	err = api.ValidateNetwork(ctx, "other", "sub")
+	require.EqualError(t, err, "Subnetwork sub belongs to network default, not other")
+
+	require.NoError(t, api.ValidateNetwork(ctx, "legacy", ""))
+
+	err = api.ValidateNetwork(ctx, "custom", "")
+	require.EqualError(t, err, "Network custom is a custom mode network, a Subnetwork must be set")
+}
+
+func TestCreateInstanceNetworkPaths(t *testing.T) {
+	var inserted compute.Instance
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		inserted = compute.Instance{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	base := api.service.BasePath
+	shared := "https://www.googleapis.com/compute/v1/projects/HOST/"
+	for _, test := range []struct {
+		network, subnetwork                 string
+		expectedNetwork, expectedSubnetwork string
+	}{
+		// Auto mode
+		{"default", "", base + "PROJECT/global/networks/default", ""},
+		// Custom mode
+		{"vpc", "sub", base + "PROJECT/global/networks/vpc", base + "PROJECT/regions/us-central1/subnetworks/sub"},
+		{"global/networks/vpc", "regions/us-central1/subnetworks/sub", base + "PROJECT/global/networks/vpc", base + "PROJECT/regions/us-central1/subnetworks/sub"},
+		{"PROJECT/global/networks/vpc", "PROJECT/regions/us-central1/subnetworks/sub", base + "PROJECT/global/networks/vpc", base + "PROJECT/regions/us-central1/subnetworks/sub"},
+		// Shared VPC
+		{"projects/HOST/global/networks/shared", "projects/HOST/regions/us-central1/subnetworks/sub", base + "HOST/global/networks/shared", base + "HOST/regions/us-central1/subnetworks/sub"},
+		{shared + "global/networks/shared", shared + "regions/us-central1/subnetworks/sub", shared + "global/networks/shared", shared + "regions/us-central1/subnetworks/sub"},
+	} {
+		err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{MachineType: "n1-standard-1", Network: test.network, Subnetwork: test.subnetwork})
+
+		require.NoError(t, err)
+		require.Equal(t, test.expectedNetwork, inserted.NetworkInterfaces[0].Network)
+		require.Equal(t, test.expectedSubnetwork, inserted.NetworkInterfaces[0].Subnetwork)
+	}
+}
+
+func TwoPagesOfInstances(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pageToken") == "" {
+			testutil.ReplyJSON(t, w, &compute.InstanceList{Items: []*compute.Instance{{Name: "vm-1"}, {Name: "vm-2"}}, NextPageToken: "page-2"})
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.InstanceList{Items: []*compute.Instance{{Name: "vm-3"}}})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroups/workers/listInstances", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pageToken") == "" {
+			testutil.ReplyJSON(t, w, &compute.InstanceGroupsListInstances{Items: []*compute.InstanceWithNamedPorts{{Instance: "vm-1"}}, NextPageToken: "page-2"})
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.InstanceGroupsListInstances{Items: []*compute.InstanceWithNamedPorts{{Instance: "vm-2"}}})
+	})
+	return mux
+}
+
+func TestListInstancesPages(t *testing.T) {
+	api, done := newTestAPI(t, TwoPagesOfInstances(t))
+	defer done()
+
+	instances, err := api.ListInstances(context.Background(), "")
+
+	require.NoError(t, err)
+	require.Len(t, instances, 3)
+	require.Equal(t, "vm-3", instances[2].Name)
+}
+
+func TestListInstanceGroupInstancesPages(t *testing.T) {
+	api, done := newTestAPI(t, TwoPagesOfInstances(t))
+	defer done()
+
+	instances, err := api.ListInstanceGroupInstances(context.Background(), "workers")
+
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	require.Equal(t, "vm-2", instances[1].Instance)
+}
+
+func TestListInstancesMaxResults(t *testing.T) {
+	api, done := newTestAPI(t, TwoPagesOfInstances(t))
+	defer done()
+	WithMaxListResults(2)(api)
+
+	_, err := api.ListInstances(context.Background(), "")
+
+	require.EqualError(t, err, "Listed more than 2 items")
+}
+
+func TestListInstanceGroupManagersPages(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroupManagers", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pageToken") == "" {
+			testutil.ReplyJSON(t, w, &compute.InstanceGroupManagerList{
+				Items:         []*compute.InstanceGroupManager{{Name: "workers", TargetSize: 3}},
+				NextPageToken: "page-2",
+			})
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.InstanceGroupManagerList{
+			Items: []*compute.InstanceGroupManager{{Name: "managers", TargetSize: 1}},
+		})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	managers, err := api.ListInstanceGroupManagers(context.Background())
+
+	require.NoError(t, err)
+	require.Len(t, managers, 2)
+	require.Equal(t, "managers", managers[1].Name)
+	require.Equal(t, int64(1), managers[1].TargetSize)
+}
+
+func TestCreateRegionalInstanceGroupManager(t *testing.T) {
+	var created compute.InstanceGroupManager
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/europe-west1/instanceGroupManagers", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Region: "europe-west1", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+	api.zone = ""
+	api.regionName = "europe-west1"
+
+	err := api.CreateRegionalInstanceGroupManager(context.Background(), "workers", &InstanceManagerSettings{
+		TemplateName:     "workers-1",
+		TargetSize:       3,
+		BaseInstanceName: "worker",
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "europe-west1", created.Region)
+	require.Empty(t, created.Zone)
+	require.Equal(t, "projects/PROJECT/global/instanceTemplates/workers-1", created.InstanceTemplate)
+	require.Equal(t, int64(3), created.TargetSize)
+}
+
+func TestCreateRegionalInstanceGroupManagerInZones(t *testing.T) {
+	var created map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/europe-west1/instanceGroupManagers", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Region: "europe-west1", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+	api.zone = ""
+	api.regionName = "europe-west1"
+
+	err := api.CreateRegionalInstanceGroupManager(context.Background(), "workers", &InstanceManagerSettings{
+		TemplateName: "workers-1",
+		TargetSize:   3,
+		Zones:        []string{"europe-west1-b", "europe-west1-d"},
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "europe-west1", created["region"])
+	require.Equal(t, map[string]interface{}{"zones": []interface{}{
+		map[string]interface{}{"zone": "zones/europe-west1-b"},
+		map[string]interface{}{"zone": "zones/europe-west1-d"},
+	}}, created["distributionPolicy"])
+
+	err = api.CreateRegionalInstanceGroupManager(context.Background(), "workers", &InstanceManagerSettings{
+		TemplateName: "workers-1",
+		Zones:        []string{"europe-west1-b", "us-central1-f"},
+	})
+
+	require.EqualError(t, err, "Zone us-central1-f is not a zone of region europe-west1")
+}
+
+func TestValidateZones(t *testing.T) {
+	require.NoError(t, ValidateZones("us-central1", nil))
+	require.NoError(t, ValidateZones("us-central1", []string{"us-central1-a", "us-central1-f"}))
+	require.EqualError(t, ValidateZones("us-central1", []string{"us-central1-a", "us-east1-b"}), "Zone us-east1-b is not a zone of region us-central1")
+	require.EqualError(t, ValidateZones("us-central1", []string{"us-central1"}), "Zone us-central1 is not a zone of region us-central1")
+	require.EqualError(t, ValidateZones("us-central1", []string{"us-central10-a"}), "Zone us-central10-a is not a zone of region us-central1")
+}
+
+func TestGetRegion(t *testing.T) {
+	api, done := newTestAPI(t, http.NewServeMux())
+	defer done()
+
+	require.Equal(t, "us-central1", api.GetRegion())
+
+	api.regionName = "europe-west1"
+	require.Equal(t, "europe-west1", api.GetRegion())
+}
+
+func TestListRegionalInstanceGroupInstancesPages(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/instanceGroups/workers/listInstances", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pageToken") == "" {
+			testutil.ReplyJSON(t, w, &compute.RegionInstanceGroupsListInstances{Items: []*compute.InstanceWithNamedPorts{{Instance: "zones/us-central1-a/instances/vm-1"}}, NextPageToken: "page-2"})
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.RegionInstanceGroupsListInstances{Items: []*compute.InstanceWithNamedPorts{{Instance: "zones/us-central1-b/instances/vm-2"}}})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	instances, err := api.ListRegionalInstanceGroupInstances(context.Background(), "workers")
+
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	require.Equal(t, "zones/us-central1-b/instances/vm-2", instances[1].Instance)
+}
+
+func TestAddRegionalInstanceGroupToBackendService(t *testing.T) {
+	var updated compute.BackendService
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/backendServices/web", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			testutil.ReplyJSON(t, w, &compute.BackendService{Name: "web"})
+		case "PUT":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&updated))
+			testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+		}
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.AddInstanceGroupToBackendService(context.Background(), "web", "regions/us-central1/instanceGroups/workers", 0)
+
+	require.NoError(t, err)
+	require.Len(t, updated.Backends, 1)
+	require.Equal(t, "projects/PROJECT/regions/us-central1/instanceGroups/workers", updated.Backends[0].Group)
+}
+
+func TestNewAPIWithRegionDoesntNeedZone(t *testing.T) {
+	path := WriteCredentialsFile(t, serviceAccountKey)
+	defer os.Remove(path)
+
+	api, err := NewAPI("PROJECT", "", WithRegion("europe-west1"), WithCredentialsFile(path))
+
+	require.NoError(t, err)
+	require.Equal(t, "", api.GetZone())
+}
+
+func TestRecreateInstancesByNameOrURL(t *testing.T) {
+	var request compute.InstanceGroupManagersRecreateInstancesRequest
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroupManagers/workers/recreateInstances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.RecreateInstances(context.Background(), "workers", "vm-1", "https://www.googleapis.com/compute/v1/projects/PROJECT/zones/us-central1-f/instances/vm-2")
+
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"zones/us-central1-f/instances/vm-1",
+		"https://www.googleapis.com/compute/v1/projects/PROJECT/zones/us-central1-f/instances/vm-2",
+	}, request.Instances)
+}
+
+func TestRecreateInstancesErrorsPerInstance(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroupManagers/workers/recreateInstances", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE", Error: &compute.OperationError{
+			Errors: []*compute.OperationErrorErrors{{
+				Code:     "RESOURCE_NOT_FOUND",
+				Location: "projects/PROJECT/zones/us-central1-f/instances/vm-2",
+				Message:  "The instance is not a member of the group",
+			}},
+		}})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.RecreateInstances(context.Background(), "workers", "vm-1", "vm-2")
+
+	require.EqualError(t, err, "Operation op failed: RESOURCE_NOT_FOUND: The instance is not a member of the group")
+	require.True(t, errors.Is(err, ErrNotFound))
+	var opErr *OperationError
+	require.True(t, errors.As(err, &opErr))
+	require.Equal(t, map[string]string{"vm-2": "The instance is not a member of the group"}, opErr.Instances())
+}
+
+func TestCreateAutoscaler(t *testing.T) {
+	var created compute.Autoscaler
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/autoscalers", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateAutoscaler(context.Background(), "workers", &AutoscalerSettings{MinReplicas: 1, MaxReplicas: 5, CPUUtilization: 0.6})
+
+	require.NoError(t, err)
+	require.Equal(t, "workers", created.Name)
+	require.Equal(t, "projects/PROJECT/zones/us-central1-f/instanceGroupManagers/workers", created.Target)
+	require.Equal(t, int64(1), created.AutoscalingPolicy.MinNumReplicas)
+	require.Equal(t, int64(5), created.AutoscalingPolicy.MaxNumReplicas)
+	require.Equal(t, 0.6, created.AutoscalingPolicy.CpuUtilization.UtilizationTarget)
+}
+
+func TestAbandonAndDeleteGroupInstancesByNameOrURL(t *testing.T) {
+	requests := map[string][]string{}
+
+	mux := http.NewServeMux()
+	for _, method := range []string{"abandonInstances", "deleteInstances"} {
+		method := method
+		mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroupManagers/workers/"+method, func(w http.ResponseWriter, r *http.Request) {
+			var request struct{ Instances []string }
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			requests[method] = request.Instances
+			testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+		})
+	}
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	require.NoError(t, api.AbandonInstances(context.Background(), "workers", "vm-1"))
+	require.NoError(t, api.DeleteGroupInstances(context.Background(), "workers", "vm-2", "projects/PROJECT/zones/us-central1-f/instances/vm-3"))
+
+	require.Equal(t, []string{"zones/us-central1-f/instances/vm-1"}, requests["abandonInstances"])
+	require.Equal(t, []string{"zones/us-central1-f/instances/vm-2", "projects/PROJECT/zones/us-central1-f/instances/vm-3"}, requests["deleteInstances"])
+}
+
+func TestCreateHealthCheck(t *testing.T) {
+	var created compute.HealthCheck
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/healthChecks", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateHealthCheck(context.Background(), "workers-autohealing", &HealthCheckSettings{Protocol: "HTTP", Port: 8080, RequestPath: "/health", UnhealthyThreshold: 3})
+
+	require.NoError(t, err)
+	require.Equal(t, "workers-autohealing", created.Name)
+	require.Equal(t, "HTTP", created.Type)
+	require.Equal(t, int64(8080), created.HttpHealthCheck.Port)
+	require.Equal(t, "/health", created.HttpHealthCheck.RequestPath)
+	require.Equal(t, int64(3), created.UnhealthyThreshold)
+}
+
+func TestSetAutoHealingPolicy(t *testing.T) {
+	var patch map[string][]map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroupManagers/workers", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "PATCH", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&patch))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	require.NoError(t, api.SetAutoHealingPolicy(context.Background(), "workers", "workers-autohealing", 300))
+	require.Equal(t, []map[string]interface{}{{
+		"healthCheck":     "projects/PROJECT/global/healthChecks/workers-autohealing",
+		"initialDelaySec": 300.0,
+	}}, patch["autoHealingPolicies"])
+
+	require.NoError(t, api.SetAutoHealingPolicy(context.Background(), "workers", "", 0))
+	require.Empty(t, patch["autoHealingPolicies"])
+}
+
+func TestSetNamedPorts(t *testing.T) {
+	var request map[string][]map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroups/workers/setNamedPorts", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	require.NoError(t, api.SetNamedPorts(context.Background(), "workers", []NamedPort{{Name: "http", Port: 8080}}))
+	require.Equal(t, []map[string]interface{}{{
+		"name": "http",
+		"port": 8080.0,
+	}}, request["namedPorts"])
+
+	require.NoError(t, api.SetNamedPorts(context.Background(), "workers", nil))
+	require.Contains(t, request, "namedPorts")
+	require.Empty(t, request["namedPorts"])
+}
+
+func TestSetTargetPools(t *testing.T) {
+	var request map[string][]string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroupManagers/workers/setTargetPools", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	require.NoError(t, api.SetTargetPools(context.Background(), "workers", []string{"regional", "global"}))
+	require.Equal(t, []string{"regional", "global"}, request["targetPools"])
+
+	require.NoError(t, api.SetTargetPools(context.Background(), "workers", nil))
+	require.Contains(t, request, "targetPools")
+	require.Empty(t, request["targetPools"])
+}
+
+func TestCreateInstanceWithAdditionalDisks(t *testing.T) {
+	var inserted compute.Instance
+	var created compute.Disk
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/disks", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{
+		Disks: []DiskSettings{
+			{Boot: true, SizeGb: 10, Image: "docker", Type: "pd-standard", AutoDelete: true},
+			{SizeGb: 100, Snapshot: "backup", Type: "pd-ssd", NameSuffix: "-data", DeviceName: "data"},
+		},
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "vm-data", created.Name)
+	require.Equal(t, int64(100), created.SizeGb)
+	require.True(t, strings.HasSuffix(created.SourceSnapshot, "/PROJECT/global/snapshots/backup"))
+	require.Len(t, inserted.Disks, 2)
+	require.Equal(t, "vm", inserted.Disks[0].InitializeParams.DiskName)
+	require.True(t, inserted.Disks[0].AutoDelete)
+	require.Equal(t, "projects/PROJECT/zones/us-central1-f/disks/vm-data", inserted.Disks[1].Source)
+	require.Equal(t, "data", inserted.Disks[1].DeviceName)
+	require.False(t, inserted.Disks[1].AutoDelete)
+}
+
+func TestCreateDiskFromSnapshot(t *testing.T) {
+	var created compute.Disk
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/disks", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateDisk(context.Background(), "data", &PersistentDiskSettings{SizeGb: 100, Type: "pd-ssd", Snapshot: "backup"})
+
+	require.NoError(t, err)
+	require.Equal(t, "data", created.Name)
+	require.Equal(t, int64(100), created.SizeGb)
+	require.True(t, strings.HasSuffix(created.Type, "/PROJECT/zones/us-central1-f/diskTypes/pd-ssd"))
+	require.True(t, strings.HasSuffix(created.SourceSnapshot, "/PROJECT/global/snapshots/backup"))
+	require.Empty(t, created.SourceImage)
+
+	err = api.CreateDisk(context.Background(), "data", &PersistentDiskSettings{Image: "debian", Snapshot: "backup"})
+
+	require.EqualError(t, err, "A disk can't be created from both an image and a snapshot")
+}
+
+func TestGetSerialPortOutput(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm/serialPort", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "1", r.URL.Query().Get("port"))
+		testutil.ReplyJSON(t, w, &compute.SerialPortOutput{Contents: "Booting"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	output, err := api.GetSerialPortOutput(context.Background(), "vm", 1)
+
+	require.NoError(t, err)
+	require.Equal(t, "Booting", output)
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	var created compute.Snapshot
+	polls := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/disks/data/createSnapshot", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "RUNNING"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/operations/op", func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateSnapshot(context.Background(), "data", "data-backup")
+
+	require.NoError(t, err)
+	require.Equal(t, "data-backup", created.Name)
+	require.Equal(t, 1, polls)
+}
+
+func TestListSnapshots(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "name eq data-.*", r.URL.Query().Get("filter"))
+		if r.URL.Query().Get("pageToken") == "" {
+			testutil.ReplyJSON(t, w, &compute.SnapshotList{Items: []*compute.Snapshot{{Name: "data-1"}}, NextPageToken: "next"})
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.SnapshotList{Items: []*compute.Snapshot{{Name: "data-2"}}})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	snapshots, err := api.ListSnapshots(context.Background(), "name eq data-.*")
+
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	require.Equal(t, "data-1", snapshots[0].Name)
+	require.Equal(t, "data-2", snapshots[1].Name)
+}
+
+func TestAttachDiskToMissingInstance(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm-1/attachDisk", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"code": 404, "message": "The resource 'projects/PROJECT/zones/us-central1-f/instances/vm-1' was not found"}}`))
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.AttachDisk(context.Background(), "vm-1", "data", "data")
+
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestDeleteAttachedDisk(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/disks/data", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "DELETE", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"code": 400, "message": "The disk resource 'projects/PROJECT/zones/us-central1-f/disks/data' is already being used by 'projects/PROJECT/zones/us-central1-f/instances/vm-1'", "errors": [{"reason": "resourceInUseByAnotherResource"}]}}`))
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.DeleteDisk(context.Background(), "data")
+
+	require.True(t, errors.Is(err, ErrInUse))
+	require.Contains(t, err.Error(), "is already being used by")
+}
+
+func TestReserveInternalAddress(t *testing.T) {
+	var reserved map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/addresses", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&reserved))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Region: "us-central1", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.ReserveAddress(context.Background(), "manager", &AddressSettings{Internal: true, Subnetwork: "backend"})
+
+	require.NoError(t, err)
+	require.Equal(t, "manager", reserved["name"])
+	require.Equal(t, "INTERNAL", reserved["addressType"])
+	require.True(t, strings.HasSuffix(reserved["subnetwork"].(string), "/PROJECT/regions/us-central1/subnetworks/backend"))
+
+	err = api.ReserveAddress(context.Background(), "manager", &AddressSettings{Subnetwork: "backend"})
+
+	require.EqualError(t, err, "Only internal addresses can be reserved in a subnetwork")
+}
+
+func TestGetAddress(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/addresses/manager", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, map[string]interface{}{
+			"name":        "manager",
+			"address":     "10.128.0.10",
+			"region":      "https://www.googleapis.com/compute/v1/projects/PROJECT/regions/us-central1",
+			"addressType": "INTERNAL",
+			"subnetwork":  "https://www.googleapis.com/compute/v1/projects/PROJECT/regions/us-central1/subnetworks/backend",
+			"status":      "IN_USE",
+			"users":       []string{"projects/PROJECT/zones/us-central1-f/instances/vm-1"},
+		})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	address, err := api.GetAddress(context.Background(), "manager")
+
+	require.NoError(t, err)
+	require.Equal(t, "10.128.0.10", address.Address)
+	require.Equal(t, "us-central1", address.Region)
+	require.True(t, address.Internal)
+	require.Equal(t, AddressInUse, address.Status)
+	require.Equal(t, []string{"projects/PROJECT/zones/us-central1-f/instances/vm-1"}, address.Users)
+
+	_, err = api.GetAddress(context.Background(), "unknown")
+
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestReserveExistingAddress(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/addresses", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": {"code": 409, "message": "The resource 'projects/PROJECT/regions/us-central1/addresses/manager' already exists"}}`))
+	})
+	mux.HandleFunc("/PROJECT/regions/us-central1/addresses/manager", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, map[string]interface{}{"name": "manager", "address": "35.1.2.3", "status": "RESERVED"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	require.NoError(t, api.ReserveAddress(context.Background(), "manager", &AddressSettings{}))
+
+	err := api.ReserveAddress(context.Background(), "manager", &AddressSettings{Internal: true})
+
+	require.True(t, errors.Is(err, ErrConflict))
+	require.EqualError(t, err, "Address manager already exists with other settings: internal=false, subnetwork=")
+}
+
+func TestAddInstanceToMissingTargetPool(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/targetPools/pool", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"code": 404, "message": "The resource 'projects/PROJECT/regions/us-central1/targetPools/pool' was not found"}}`))
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.AddInstanceToTargetPool(context.Background(), "pool", "vm-1")
+
+	require.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestAddInstanceAlreadyInTargetPool(t *testing.T) {
+	var added compute.TargetPoolsAddInstanceRequest
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/targetPools/pool", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "GET", r.Method)
+		testutil.ReplyJSON(t, w, &compute.TargetPool{
+			Name:      "pool",
+			Instances: []string{"https://www.googleapis.com/compute/v1/projects/PROJECT/zones/us-central1-f/instances/vm-1"},
+		})
+	})
+	mux.HandleFunc("/PROJECT/regions/us-central1/targetPools/pool/addInstance", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&added))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	require.NoError(t, api.AddInstanceToTargetPool(context.Background(), "pool", "vm-1"))
+	require.Empty(t, added.Instances)
+
+	require.NoError(t, api.AddInstanceToTargetPool(context.Background(), "pool", "vm-1", "vm-2"))
+	require.Len(t, added.Instances, 1)
+	require.Equal(t, "projects/PROJECT/zones/us-central1-f/instances/vm-2", added.Instances[0].Instance)
+}
+
+func TestRemoveInstanceFromTargetPool(t *testing.T) {
+	var removed compute.TargetPoolsRemoveInstanceRequest
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/regions/us-central1/targetPools/pool", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.TargetPool{
+			Name:      "pool",
+			Instances: []string{"https://www.googleapis.com/compute/v1/projects/PROJECT/zones/us-central1-f/instances/vm-1"},
+		})
+	})
+	mux.HandleFunc("/PROJECT/regions/us-central1/targetPools/pool/removeInstance", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&removed))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	require.NoError(t, api.RemoveInstanceFromTargetPool(context.Background(), "pool", "vm-1", "vm-2"))
+	require.Len(t, removed.Instances, 1)
+	require.Equal(t, "projects/PROJECT/zones/us-central1-f/instances/vm-1", removed.Instances[0].Instance)
+}
+
+func TestCreateFirewallRule(t *testing.T) {
+	var created compute.Firewall
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/firewalls", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateFirewallRule(context.Background(), "swarm", &FirewallSettings{
+		SourceRanges: []string{"10.0.0.0/8"},
+		TargetTags:   []string{"swarm"},
+		AllowedPorts: []string{"tcp:2377", "udp:7946", "icmp"},
+	})
+
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(created.Network, "/PROJECT/global/networks/default"))
+	require.Equal(t, []string{"10.0.0.0/8"}, created.SourceRanges)
+	require.Equal(t, []string{"swarm"}, created.TargetTags)
+	require.Len(t, created.Allowed, 3)
+	require.Equal(t, &compute.FirewallAllowed{IPProtocol: "tcp", Ports: []string{"2377"}}, created.Allowed[0])
+	require.Equal(t, &compute.FirewallAllowed{IPProtocol: "icmp"}, created.Allowed[2])
+
+	err = api.CreateFirewallRule(context.Background(), "swarm", &FirewallSettings{AllowedPorts: []string{"tcp:"}})
+
+	require.EqualError(t, err, "Invalid allowed port tcp:: should be protocol[:ports]")
+}
+
+func TestCreateExistingFirewallRule(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/firewalls", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": {"code": 409, "message": "The resource 'projects/PROJECT/global/firewalls/swarm' already exists"}}`))
+	})
+	mux.HandleFunc("/PROJECT/global/firewalls/swarm", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Firewall{
+			Name:         "swarm",
+			Network:      "https://www.googleapis.com/compute/v1/projects/PROJECT/global/networks/default",
+			SourceRanges: []string{"0.0.0.0/0"},
+			TargetTags:   []string{"swarm"},
+			Allowed: []*compute.FirewallAllowed{
+				{IPProtocol: "udp", Ports: []string{"7946"}},
+				{IPProtocol: "tcp", Ports: []string{"2377"}},
+			},
+		})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	require.NoError(t, api.CreateFirewallRule(context.Background(), "swarm", &FirewallSettings{
+		TargetTags:   []string{"swarm"},
+		AllowedPorts: []string{"tcp:2377", "udp:7946"},
+	}))
+
+	err := api.CreateFirewallRule(context.Background(), "swarm", &FirewallSettings{
+		TargetTags:   []string{"swarm"},
+		AllowedPorts: []string{"tcp:2377"},
+	})
+
+	require.True(t, errors.Is(err, ErrConflict))
+	require.EqualError(t, err, "Firewall rule swarm already exists with other settings")
+}
+
+func TestUserAgent(t *testing.T) {
+	userAgents := []string{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm", func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		testutil.ReplyJSON(t, w, &compute.Instance{Name: "vm"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm/setLabels", func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	api, err := NewAPI("PROJECT", "us-central1-f",
+		WithEndpoint(server.URL),
+		WithHTTPClient(server.Client()),
+		WithUserAgent("infrakit.gcp/v1.0.0 (instance)"))
+	require.NoError(t, err)
+
+	_, err = api.GetInstance(context.Background(), "vm")
+	require.NoError(t, err)
+	require.NoError(t, api.AddInstanceLabels(context.Background(), "vm", map[string]string{"team": "infra"}))
+
+	require.True(t, strings.HasSuffix(api.UserAgent(), " infrakit.gcp/v1.0.0 (instance)"), api.UserAgent())
+	require.Len(t, userAgents, 3)
+	for _, userAgent := range userAgents {
+		require.Equal(t, api.UserAgent(), userAgent)
+	}
+}
+
+func TestCreateInstanceWithBootDiskFromSnapshot(t *testing.T) {
+	var inserted compute.Instance
+	var created compute.Disk
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/disks", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{
+		Disks: []DiskSettings{{Boot: true, SizeGb: 10, Snapshot: "projects/OTHER/global/snapshots/vm-backup", AutoDelete: true}},
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "vm", created.Name)
+	require.True(t, strings.HasSuffix(created.SourceSnapshot, "/projects/OTHER/global/snapshots/vm-backup"))
+	require.Len(t, inserted.Disks, 1)
+	require.True(t, inserted.Disks[0].Boot)
+	require.True(t, inserted.Disks[0].AutoDelete)
+	require.Nil(t, inserted.Disks[0].InitializeParams)
+	require.Equal(t, "projects/PROJECT/zones/us-central1-f/disks/vm", inserted.Disks[0].Source)
+}
+
+func TestCreateInstanceImages(t *testing.T) {
+	var inserted compute.Instance
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Zone: "us-central1-f", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	images := map[string]string{
+		"docker": "/PROJECT/global/images/docker",
+		"projects/debian-cloud/global/images/family/debian-9":                                      "/debian-cloud/global/images/family/debian-9",
+		"debian-cloud/global/images/family/debian-9":                                               "/debian-cloud/global/images/family/debian-9",
+		"https://www.googleapis.com/compute/v1/projects/ubuntu-os-cloud/global/images/ubuntu-1404": "https://www.googleapis.com/compute/v1/projects/ubuntu-os-cloud/global/images/ubuntu-1404",
+	}
+	for image, expected := range images {
+		err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{
+			Disks: []DiskSettings{{Boot: true, SizeGb: 10, Image: image, AutoDelete: true}},
+		})
+
+		require.NoError(t, err)
+		require.True(t, strings.HasSuffix(inserted.Disks[0].InitializeParams.SourceImage, expected), inserted.Disks[0].InitializeParams.SourceImage)
+		require.NotContains(t, inserted.Disks[0].InitializeParams.SourceImage, "projects/projects/")
+	}
+}
+
+func TestCreateInstanceWithMinCPUPlatform(t *testing.T) {
+	var inserted map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{MachineType: "n1-standard-1", MinCPUPlatform: "Intel Skylake"})
+
+	require.NoError(t, err)
+	require.Equal(t, "Intel Skylake", inserted["minCpuPlatform"])
+	require.NotContains(t, inserted, "labels")
+
+	inserted = nil
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{MachineType: "n1-standard-1"})
+
+	require.NoError(t, err)
+	require.NotContains(t, inserted, "minCpuPlatform")
+}
+
+func TestCreateInstanceTemplateWithMinCPUPlatform(t *testing.T) {
+	var inserted struct {
+		Properties struct {
+			MinCPUPlatform string `json:"minCpuPlatform"`
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstanceTemplate(context.Background(), "workers-1", &InstanceSettings{MachineType: "n1-standard-1", MinCPUPlatform: "Intel Skylake"})
+
+	require.NoError(t, err)
+	require.Equal(t, "Intel Skylake", inserted.Properties.MinCPUPlatform)
+}
+
+func TestCreateInstanceScheduling(t *testing.T) {
+	var inserted struct {
+		Scheduling map[string]interface{}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		inserted.Scheduling = nil
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	noRestart := false
+	tests := []struct {
+		settings InstanceSettings
+		expected map[string]interface{}
+	}{
+		{
+			settings: InstanceSettings{},
+			expected: map[string]interface{}{"automaticRestart": true, "onHostMaintenance": "MIGRATE"},
+		},
+		{
+			settings: InstanceSettings{Preemptible: true},
+			expected: map[string]interface{}{"automaticRestart": false, "onHostMaintenance": "TERMINATE", "preemptible": true},
+		},
+		{
+			settings: InstanceSettings{AutomaticRestart: &noRestart, OnHostMaintenance: "TERMINATE"},
+			expected: map[string]interface{}{"automaticRestart": false, "onHostMaintenance": "TERMINATE"},
+		},
+	}
+	for _, test := range tests {
+		err := api.CreateInstance(context.Background(), "vm", &test.settings)
+
+		require.NoError(t, err)
+		require.Equal(t, test.expected, inserted.Scheduling)
+	}
+}
+
+func TestCreateShieldedInstance(t *testing.T) {
+	var inserted struct {
+		ShieldedInstanceConfig map[string]bool
+		Properties             struct {
+			ShieldedInstanceConfig map[string]bool
+		}
+	}
+
+	mux := http.NewServeMux()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	}
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", handler)
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", handler)
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	settings := &InstanceSettings{
+		MachineType: "n1-standard-1",
+		Shielded:    &ShieldedSettings{SecureBoot: true, IntegrityMonitoring: true},
+	}
+	expected := map[string]bool{"enableSecureBoot": true, "enableVtpm": false, "enableIntegrityMonitoring": true}
+
+	err := api.CreateInstance(context.Background(), "vm", settings)
+
+	require.NoError(t, err)
+	require.Equal(t, expected, inserted.ShieldedInstanceConfig)
+
+	err = api.CreateInstanceTemplate(context.Background(), "workers-1", settings)
+
+	require.NoError(t, err)
+	require.Equal(t, expected, inserted.Properties.ShieldedInstanceConfig)
+}
+
+func TestDeletionProtection(t *testing.T) {
+	var inserted map[string]interface{}
+	var cleared string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&inserted))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-b/instances/vm", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "GET", r.Method)
+		testutil.ReplyJSON(t, w, map[string]interface{}{"name": "vm", "deletionProtection": true})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-b/instances/vm/setDeletionProtection", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST", r.Method)
+		cleared = r.URL.Query().Get("deletionProtection")
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+
+	api, done := newTestAPI(t, mux)
+	defer done()
+
+	err := api.CreateInstance(context.Background(), "vm", &InstanceSettings{MachineType: "n1-standard-1", DeletionProtection: true})
+
+	require.NoError(t, err)
+	require.Equal(t, true, inserted["deletionProtection"])
+
+	protected, err := api.GetDeletionProtection(context.Background(), "us-central1-b", "vm")
+
+	require.NoError(t, err)
+	require.True(t, protected)
+
+	err = api.SetDeletionProtection(context.Background(), "us-central1-b", "vm", false)
+
+	require.NoError(t, err)
+	require.Equal(t, "false", cleared)
+}
@@ -0,0 +1,290 @@
+package gcloud
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// batchPath is the path of the batch endpoint of the Compute API, relative
+	// to the host of the API.
+	batchPath = "/batch/compute/v1"
+
+	// maxBatchSize is how many calls are sent in a single batch request. The
+	// Compute API accepts up to 1000.
+	maxBatchSize = 100
+
+	// fanOutConcurrency bounds the instances fetched concurrently when the
+	// endpoint doesn't support batch requests.
+	fanOutConcurrency = 10
+
+	// mutationConcurrency bounds the instances inserted, or deleted,
+	// concurrently by CreateInstances and DeleteInstances.
+	mutationConcurrency = 10
+)
+
+// errBatchUnsupported is returned when the endpoint, an emulator for example,
+// has no batch endpoint.
+var errBatchUnsupported = errors.New("Batch requests are not supported")
+
+func (g *computeServiceWrapper) BatchGetInstances(ctx context.Context, names []string) (map[string]*compute.Instance, map[string]error, error) {
+	instances := map[string]*compute.Instance{}
+	failures := map[string]error{}
+
+	for start := 0; start < len(names); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(names) {
+			end = len(names)
+		}
+
+		err := g.batchGetInstances(ctx, names[start:end], instances, failures)
+		if errors.Is(err, errBatchUnsupported) {
+			log.Debugln("The endpoint doesn't support batch requests, the instances are fetched one by one")
+
+			g.fanOutGetInstances(ctx, names[start:], instances, failures)
+			break
+		}
+		if err != nil {
+			return nil, nil, callError("BatchGetInstances", err)
+		}
+	}
+
+	return instances, failures, nil
+}
+
+// batchGetInstances fetches instances of the zone with a single batch
+// request.
+func (g *computeServiceWrapper) batchGetInstances(ctx context.Context, names []string, instances map[string]*compute.Instance, failures map[string]error) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for i, name := range names {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "application/http")
+		header.Set("Content-ID", fmt.Sprintf("<%d>", i))
+
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			return err
+		}
+
+		instanceURL, err := url.Parse(googleapi.ResolveRelative(g.service.BasePath, g.project+"/zones/"+g.zone+"/instances/"+name))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(part, "GET %s HTTP/1.1\r\n\r\n", instanceURL.EscapedPath())
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	batchURL, err := g.batchURL()
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, batchURL, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("User-Agent", g.UserAgent())
+	request.Header.Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+
+	response, err := g.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return errBatchUnsupported
+	}
+	if err := googleapi.CheckResponse(response); err != nil {
+		return err
+	}
+
+	_, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("Invalid batch response: %v", err)
+	}
+
+	reader := multipart.NewReader(response.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid batch response: %v", err)
+		}
+
+		index, err := strconv.Atoi(strings.TrimPrefix(strings.Trim(part.Header.Get("Content-ID"), "<>"), "response-"))
+		if err != nil || index < 0 || index >= len(names) {
+			return fmt.Errorf("Invalid batch response: unexpected Content-ID %q", part.Header.Get("Content-ID"))
+		}
+		name := names[index]
+
+		partResponse, err := http.ReadResponse(bufio.NewReader(part), request)
+		if err != nil {
+			return fmt.Errorf("Invalid batch response: %v", err)
+		}
+
+		if err := googleapi.CheckResponse(partResponse); err != nil {
+			failures[name] = callError("BatchGetInstances", err)
+			partResponse.Body.Close()
+			continue
+		}
+
+		instance := &compute.Instance{}
+		err = json.NewDecoder(partResponse.Body).Decode(instance)
+		partResponse.Body.Close()
+		if err != nil {
+			return fmt.Errorf("Invalid batch response: %v", err)
+		}
+		instances[name] = instance
+	}
+
+	for _, name := range names {
+		if instances[name] == nil && failures[name] == nil {
+			failures[name] = fmt.Errorf("No response for instance %s in the batch response", name)
+		}
+	}
+
+	return nil
+}
+
+// fanOutGetInstances fetches instances of the zone a few at a time.
+func (g *computeServiceWrapper) fanOutGetInstances(ctx context.Context, names []string, instances map[string]*compute.Instance, failures map[string]error) {
+	var lock sync.Mutex
+	slots := make(chan struct{}, fanOutConcurrency)
+	var wg sync.WaitGroup
+
+	for _, name := range names {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			instance, err := g.GetInstance(ctx, name)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				failures[name] = err
+				return
+			}
+			instances[name] = instance
+		}(name)
+	}
+
+	wg.Wait()
+}
+
+func (g *computeServiceWrapper) CreateInstances(ctx context.Context, instances map[string]*InstanceSettings) map[string]error {
+	names := []string{}
+	for name := range instances {
+		names = append(names, name)
+	}
+
+	return g.mutateInstances(ctx, "CreateInstances", names, func(name string) (*compute.Operation, time.Duration, error) {
+		return g.insertInstance(ctx, name, instances[name])
+	})
+}
+
+func (g *computeServiceWrapper) DeleteInstances(ctx context.Context, names []string) map[string]error {
+	return g.mutateInstances(ctx, "DeleteInstances", names, func(name string) (*compute.Operation, time.Duration, error) {
+		op, err := g.service.Instances.Delete(g.project, g.zone, name).Context(ctx).Do()
+		return op, g.operationTimeout, err
+	})
+}
+
+// mutateInstances sends a mutation for each instance, a few at a time, then
+// waits for all of their operations together. It returns the error for each
+// instance whose mutation failed. A failure doesn't stop the others.
+func (g *computeServiceWrapper) mutateInstances(ctx context.Context, method string, names []string, send func(name string) (*compute.Operation, time.Duration, error)) map[string]error {
+	var lock sync.Mutex
+	failures := map[string]error{}
+	fail := func(name string, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		failures[name] = callError(method, err)
+	}
+
+	type sent struct {
+		op      *compute.Operation
+		timeout time.Duration
+	}
+	operations := map[string]sent{}
+	slots := make(chan struct{}, mutationConcurrency)
+	var wg sync.WaitGroup
+
+	for _, name := range names {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			op, timeout, err := send(name)
+			if err != nil {
+				fail(name, err)
+				return
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			operations[name] = sent{op: op, timeout: timeout}
+		}(name)
+	}
+
+	wg.Wait()
+
+	for name, operation := range operations {
+		wg.Add(1)
+
+		go func(name string, operation sent) {
+			defer wg.Done()
+
+			if err := g.waitForOperation(ctx, operation.op, operation.timeout); err != nil {
+				fail(name, err)
+			}
+		}(name, operation)
+	}
+
+	wg.Wait()
+
+	return failures
+}
+
+// batchURL is the url of the batch endpoint on the host of the API.
+func (g *computeServiceWrapper) batchURL() (string, error) {
+	base, err := url.Parse(g.service.BasePath)
+	if err != nil {
+		return "", err
+	}
+
+	return base.Scheme + "://" + base.Host + batchPath, nil
+}
@@ -0,0 +1,199 @@
+package gcloud
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/infrakit.gcp/plugin/internal/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+)
+
+// batchHandler answers batch requests for the instances named vm-*, and
+// counts the batch requests.
+func batchHandler(t *testing.T, batches *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*batches++
+
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		require.NoError(t, err)
+
+		ids := []string{}
+		names := []string{}
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+
+			line, err := bufio.NewReader(part).ReadString('\n')
+			require.NoError(t, err)
+			fields := strings.Fields(line)
+			require.Equal(t, "GET", fields[0])
+			require.True(t, strings.HasPrefix(fields[1], "/PROJECT/zones/us-central1-f/instances/"))
+
+			ids = append(ids, strings.Trim(part.Header.Get("Content-ID"), "<>"))
+			names = append(names, path.Base(fields[1]))
+		}
+
+		writer := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+
+		for i, name := range names {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Type", "application/http")
+			header.Set("Content-ID", "<response-"+ids[i]+">")
+			answer, err := writer.CreatePart(header)
+			require.NoError(t, err)
+
+			if strings.HasPrefix(name, "vm-") {
+				fmt.Fprintf(answer, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n{\"name\":%q,\"status\":\"RUNNING\"}", name)
+			} else {
+				fmt.Fprintf(answer, "HTTP/1.1 404 Not Found\r\nContent-Type: application/json\r\n\r\n{\"error\":{\"code\":404,\"message\":\"The resource '%s' was not found\"}}", name)
+			}
+		}
+
+		require.NoError(t, writer.Close())
+	}
+}
+
+func TestBatchGetInstances(t *testing.T) {
+	batches := 0
+	gets := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/batch/compute/v1", batchHandler(t, &batches))
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/", func(w http.ResponseWriter, r *http.Request) {
+		gets++
+	})
+	api, closeServer := newTestAPI(t, mux)
+	defer closeServer()
+
+	names := []string{"missing"}
+	for i := 0; i < 149; i++ {
+		names = append(names, fmt.Sprintf("vm-%d", i))
+	}
+
+	instances, failures, err := api.BatchGetInstances(context.Background(), names)
+
+	require.NoError(t, err)
+	require.Equal(t, 2, batches)
+	require.Equal(t, 0, gets)
+	require.Len(t, instances, 149)
+	require.Equal(t, "vm-42", instances["vm-42"].Name)
+	require.Equal(t, "RUNNING", instances["vm-42"].Status)
+	require.Len(t, failures, 1)
+	require.True(t, errors.Is(failures["missing"], ErrNotFound))
+}
+
+func TestBatchGetInstancesWithoutBatchEndpoint(t *testing.T) {
+	gets := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/", func(w http.ResponseWriter, r *http.Request) {
+		gets++
+		testutil.ReplyJSON(t, w, &compute.Instance{Name: path.Base(r.URL.Path)})
+	})
+	api, closeServer := newTestAPI(t, mux)
+	defer closeServer()
+
+	instances, failures, err := api.BatchGetInstances(context.Background(), []string{"vm-1", "vm-2", "vm-3"})
+
+	require.NoError(t, err)
+	require.Equal(t, 3, gets)
+	require.Len(t, instances, 3)
+	require.Equal(t, "vm-2", instances["vm-2"].Name)
+	require.Empty(t, failures)
+}
+
+func TestCreateInstances(t *testing.T) {
+	var lock sync.Mutex
+	inserted := []string{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		instance := &compute.Instance{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(instance))
+
+		lock.Lock()
+		inserted = append(inserted, instance.Name)
+		lock.Unlock()
+
+		if instance.Name == "vm-3" {
+			w.WriteHeader(http.StatusConflict)
+			testutil.ReplyJSON(t, w, map[string]interface{}{"error": map[string]interface{}{"code": 409, "message": "The resource 'vm-3' already exists"}})
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op-" + instance.Name, Zone: "zones/us-central1-f", Status: "PENDING"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/operations/", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		require.Len(t, inserted, 25, "operations are waited for once all the inserts are sent")
+		lock.Unlock()
+
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: path.Base(r.URL.Path), Zone: "zones/us-central1-f", Status: "DONE"})
+	})
+	api, closeServer := newTestAPI(t, mux)
+	defer closeServer()
+
+	instances := map[string]*InstanceSettings{}
+	for i := 0; i < 25; i++ {
+		instances[fmt.Sprintf("vm-%d", i)] = &InstanceSettings{MachineType: "g1-small"}
+	}
+
+	failures := api.CreateInstances(context.Background(), instances)
+
+	require.Len(t, inserted, 25)
+	require.Len(t, failures, 1)
+	require.Contains(t, failures["vm-3"].Error(), "already exists")
+}
+
+func TestDeleteInstancesCarriesOnPastFailures(t *testing.T) {
+	var lock sync.Mutex
+	deleted := []string{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodDelete, r.Method)
+		name := path.Base(r.URL.Path)
+
+		lock.Lock()
+		deleted = append(deleted, name)
+		lock.Unlock()
+
+		if name == "vm-0" {
+			w.WriteHeader(http.StatusBadRequest)
+			testutil.ReplyJSON(t, w, map[string]interface{}{"error": map[string]interface{}{"code": 400, "message": "Invalid value for field 'resource.deletionProtection'"}})
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op-" + name, Zone: "zones/us-central1-f", Status: "DONE"})
+	})
+	api, closeServer := newTestAPI(t, mux)
+	defer closeServer()
+
+	names := []string{}
+	for i := 0; i < 25; i++ {
+		names = append(names, fmt.Sprintf("vm-%d", i))
+	}
+
+	failures := api.DeleteInstances(context.Background(), names)
+
+	sort.Strings(names)
+	sort.Strings(deleted)
+	require.Equal(t, names, deleted)
+	require.Len(t, failures, 1)
+	require.Contains(t, failures["vm-0"].Error(), "deletionProtection")
+}
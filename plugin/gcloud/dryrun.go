@@ -0,0 +1,122 @@
+package gcloud
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"google.golang.org/api/compute/v1"
+)
+
+// readVerbs are the custom methods that read through a POST. Batch requests
+// are only used for reads.
+var readVerbs = []string{"/listInstances", "/listManagedInstances", "/getHealth", "/batch/compute/v1"}
+
+// Intent is a mutation of the Compute API that a dry run didn't send.
+type Intent struct {
+	Method string
+	URL    string
+	Body   json.RawMessage `json:",omitempty"`
+}
+
+// DryRun keeps the mutations of an API from reaching GCE. They are logged and
+// recorded as intents, and answered with a completed operation. Reads still
+// go to the Compute API. Use it with WithDryRun.
+type DryRun struct {
+	lock      sync.Mutex
+	transport http.RoundTripper
+	intents   []Intent
+}
+
+// NewDryRun creates a DryRun with no intents.
+func NewDryRun() *DryRun {
+	return &DryRun{}
+}
+
+// RoundTrip sends the reads and records the mutations.
+func (d *DryRun) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isRead(req) {
+		return d.transport.RoundTrip(req)
+	}
+
+	intent := Intent{
+		Method: req.Method,
+		URL:    req.URL.Scheme + "://" + req.URL.Host + req.URL.Path,
+	}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > 0 {
+			intent.Body = json.RawMessage(strings.TrimSpace(string(body)))
+		}
+	}
+
+	log.Infoln("Dry run:", intent.Method, intent.URL, string(intent.Body))
+
+	d.lock.Lock()
+	d.intents = append(d.intents, intent)
+	d.lock.Unlock()
+
+	operation, err := json.Marshal(&compute.Operation{
+		Kind:       "compute#operation",
+		Name:       "dry-run",
+		Status:     "DONE",
+		TargetLink: intent.URL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(strings.NewReader(string(operation))),
+		ContentLength: int64(len(operation)),
+		Request:       req,
+	}, nil
+}
+
+// Intents returns the mutations that were not sent so far.
+func (d *DryRun) Intents() []Intent {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]Intent(nil), d.intents...)
+}
+
+// wrap keeps the mutations of a client from being sent.
+func (d *DryRun) wrap(client *http.Client) *http.Client {
+	d.transport = client.Transport
+	if d.transport == nil {
+		d.transport = http.DefaultTransport
+	}
+
+	dryRun := *client
+	dryRun.Transport = d
+	return &dryRun
+}
+
+// isRead tells the requests that don't change any resource.
+func isRead(req *http.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return true
+	}
+
+	for _, verb := range readVerbs {
+		if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, verb) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,61 @@
+package gcloud
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/infrakit.gcp/plugin/internal/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestDryRun(t *testing.T) {
+	mutations := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			mutations++
+		}
+		testutil.ReplyJSON(t, w, &compute.Instance{Name: "vm"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		mutations++
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroups/group/listInstances", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.InstanceGroupsListInstances{Items: []*compute.InstanceWithNamedPorts{{Instance: "vm"}}})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dryRun := NewDryRun()
+	api, err := NewAPI("PROJECT", "us-central1-f",
+		WithEndpoint(server.URL),
+		WithHTTPClient(server.Client()),
+		WithDryRun(dryRun))
+	require.NoError(t, err)
+
+	inst, err := api.GetInstance(context.Background(), "vm")
+	require.NoError(t, err)
+	require.Equal(t, "vm", inst.Name)
+
+	members, err := api.ListInstanceGroupInstances(context.Background(), "group")
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+
+	require.NoError(t, api.CreateInstance(context.Background(), "vm", &InstanceSettings{MachineType: "n1-standard-1"}))
+	require.NoError(t, api.DeleteInstance(context.Background(), "vm"))
+
+	require.Equal(t, 0, mutations)
+	intents := dryRun.Intents()
+	require.Len(t, intents, 2)
+	require.Equal(t, "POST", intents[0].Method)
+	require.Equal(t, server.URL+"/PROJECT/zones/us-central1-f/instances", intents[0].URL)
+	require.Contains(t, string(intents[0].Body), `"name":"vm"`)
+	require.Contains(t, string(intents[0].Body), "/machineTypes/n1-standard-1")
+	require.Equal(t, "DELETE", intents[1].Method)
+	require.Equal(t, server.URL+"/PROJECT/zones/us-central1-f/instances/vm", intents[1].URL)
+	require.Empty(t, intents[1].Body)
+}
@@ -0,0 +1,157 @@
+package gcloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// ErrNotFound is returned when a resource doesn't exist.
+	ErrNotFound = errors.New("Not found")
+
+	// ErrAlreadyExists is returned when a resource with the same name already exists.
+	ErrAlreadyExists = errors.New("Already exists")
+
+	// ErrQuotaExceeded is returned when a project or region quota is exceeded.
+	ErrQuotaExceeded = errors.New("Quota exceeded")
+
+	// ErrStockout is returned when a zone doesn't have enough capacity left for
+	// the requested resources.
+	ErrStockout = errors.New("Zone resource pool exhausted")
+
+	// ErrInUse is returned when a resource can't be deleted because another
+	// resource uses it, for example a disk attached to an instance.
+	ErrInUse = errors.New("In use by another resource")
+
+	// ErrConflict is returned when a resource already exists, but with other
+	// settings than the requested ones.
+	ErrConflict = errors.New("Conflicts with an existing resource")
+
+	// ErrIPInUse is returned when the private or external IP requested for an
+	// instance is already used by another resource.
+	ErrIPInUse = errors.New("IP address in use by another resource")
+)
+
+// operationErrorKinds maps the error codes of failed operations to typed errors.
+var operationErrorKinds = map[string]error{
+	"RESOURCE_NOT_FOUND":      ErrNotFound,
+	"RESOURCE_ALREADY_EXISTS": ErrAlreadyExists,
+	"QUOTA_EXCEEDED":          ErrQuotaExceeded,
+
+	"RESOURCE_IN_USE_BY_ANOTHER_RESOURCE": ErrInUse,
+	"IP_IN_USE_BY_ANOTHER_RESOURCE":       ErrIPInUse,
+
+	"ZONE_RESOURCE_POOL_EXHAUSTED":              ErrStockout,
+	"ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS": ErrStockout,
+}
+
+// OperationError is returned when an operation completes with errors. Each
+// error may tell which resource it's about, for example one of the instances
+// given to RecreateInstances.
+type OperationError struct {
+	Operation string
+	Errors    []*compute.OperationErrorErrors
+}
+
+func (e *OperationError) Error() string {
+	messages := []string{}
+	for _, err := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s: %s", err.Code, err.Message))
+	}
+
+	return fmt.Sprintf("Operation %s failed: %s", e.Operation, strings.Join(messages, ", "))
+}
+
+// Instances returns the messages of the errors about instances, by instance
+// name.
+func (e *OperationError) Instances() map[string]string {
+	instances := map[string]string{}
+	for _, err := range e.Errors {
+		if pathSegment(err.Location, "instances", "") != "" {
+			instances[last(err.Location)] = err.Message
+		}
+	}
+
+	return instances
+}
+
+// typedError keeps the message of an API error while matching one of the
+// typed errors with errors.Is. It unwraps to the API error, so that
+// errors.As still finds the googleapi error.
+type typedError struct {
+	err  error
+	kind error
+}
+
+func (e *typedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *typedError) Unwrap() error {
+	return e.err
+}
+
+func (e *typedError) Is(target error) bool {
+	return target == e.kind
+}
+
+func typed(err error, kind error) error {
+	if kind == nil {
+		return err
+	}
+	return &typedError{err: err, kind: kind}
+}
+
+// apiErrorKind maps a googleapi error to a typed error, using its HTTP
+// status code and its reasons.
+func apiErrorKind(err *googleapi.Error) error {
+	switch err.Code {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrAlreadyExists
+	case http.StatusBadRequest:
+		for _, item := range err.Errors {
+			if item.Reason == "resourceInUseByAnotherResource" {
+				return ErrInUse
+			}
+		}
+	case http.StatusForbidden, http.StatusTooManyRequests:
+		for _, item := range err.Errors {
+			if item.Reason == "quotaExceeded" || item.Reason == "rateLimitExceeded" {
+				return ErrQuotaExceeded
+			}
+		}
+	}
+
+	return nil
+}
+
+// isFingerprintConflict tells if an update was rejected because the
+// fingerprint it was given is stale.
+func isFingerprintConflict(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
+
+// callError names the API call that was interrupted when the caller's
+// context is canceled or its deadline is exceeded, and maps googleapi errors
+// to typed errors.
+func callError(call string, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%s: %w", call, err)
+	}
+
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return typed(err, apiErrorKind(apiErr))
+	}
+
+	return err
+}
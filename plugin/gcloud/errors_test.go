@@ -0,0 +1,65 @@
+package gcloud
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestCallErrorKinds(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{&googleapi.Error{Code: 404, Message: "The resource 'vm' was not found"}, ErrNotFound},
+		{&googleapi.Error{Code: 409, Message: "The resource 'vm' already exists"}, ErrAlreadyExists},
+		{&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}}, ErrQuotaExceeded},
+		{&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, ErrQuotaExceeded},
+		{&googleapi.Error{Code: 429, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, ErrQuotaExceeded},
+		{&googleapi.Error{Code: 400, Errors: []googleapi.ErrorItem{{Reason: "resourceInUseByAnotherResource"}}}, ErrInUse},
+		{&googleapi.Error{Code: 400, Errors: []googleapi.ErrorItem{{Reason: "invalid"}}}, nil},
+		{&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, nil},
+		{&googleapi.Error{Code: 500}, nil},
+		{errors.New("BUG"), nil},
+	}
+
+	for _, test := range tests {
+		err := callError("GetInstance", test.err)
+
+		require.EqualError(t, err, test.err.Error())
+		for _, kind := range []error{ErrNotFound, ErrAlreadyExists, ErrQuotaExceeded, ErrInUse} {
+			require.Equal(t, kind == test.kind, errors.Is(err, kind), "%v is %v", test.err, kind)
+		}
+	}
+}
+
+func TestOperationErrorKinds(t *testing.T) {
+	tests := []struct {
+		code string
+		kind error
+	}{
+		{"RESOURCE_NOT_FOUND", ErrNotFound},
+		{"RESOURCE_ALREADY_EXISTS", ErrAlreadyExists},
+		{"QUOTA_EXCEEDED", ErrQuotaExceeded},
+		{"ZONE_RESOURCE_POOL_EXHAUSTED", ErrStockout},
+		{"ZONE_RESOURCE_POOL_EXHAUSTED_WITH_DETAILS", ErrStockout},
+		{"RESOURCE_IN_USE_BY_ANOTHER_RESOURCE", ErrInUse},
+		{"IP_IN_USE_BY_ANOTHER_RESOURCE", ErrIPInUse},
+		{"INVALID_FIELD_VALUE", nil},
+	}
+
+	for _, test := range tests {
+		err := operationError(&compute.Operation{
+			Name:  "op",
+			Error: &compute.OperationError{Errors: []*compute.OperationErrorErrors{{Code: test.code, Message: "failed"}}},
+		})
+
+		require.EqualError(t, err, "Operation op failed: "+test.code+": failed")
+		for _, kind := range []error{ErrNotFound, ErrAlreadyExists, ErrQuotaExceeded, ErrStockout, ErrInUse, ErrIPInUse} {
+			require.Equal(t, kind == test.kind, errors.Is(err, kind), "%s is %v", test.code, kind)
+		}
+	}
+}
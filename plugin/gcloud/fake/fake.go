@@ -0,0 +1,2049 @@
+// Package fake provides an in-memory implementation of gcloud.API for tests.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/infrakit.gcp/plugin/gcloud"
+	"google.golang.org/api/compute/v1"
+)
+
+// API is an in-memory gcloud.API that keeps track of instances, instance
+// templates, instance group managers and spread placement policies. Names are unique per kind of
+// resource, and missing or duplicate resources fail with errors that match
+// gcloud.ErrNotFound and gcloud.ErrAlreadyExists. Networks are not modeled.
+
+// API is an in-memory gcloud.API that keeps track of instances, disks,
+// instance templates and instance group managers. Names are unique per kind
+// of resource, and missing, duplicate or used resources fail with errors that
+// match gcloud.ErrNotFound, gcloud.ErrAlreadyExists and gcloud.ErrInUse.
+// Networks are not modeled.
+type API struct {
+	project     string
+	zone        string
+	region      string
+	regionZones []string
+
+	lock             sync.Mutex
+	instances        map[string]*fakeInstance
+	disks            map[string]*compute.Disk
+	snapshots        map[string]*compute.Snapshot
+	addresses        map[string]*gcloud.Address
+	firewalls        map[string]*gcloud.FirewallSettings
+	templates        map[string]*gcloud.InstanceSettings
+	managers         map[string]*fakeManager
+	regionalManagers map[string]*fakeManager
+	autoscalers      map[string]*compute.Autoscaler
+	healthChecks     map[string]*gcloud.HealthCheckSettings
+	pools            map[string][]string
+	policies         map[string]int64
+	backends         map[string]*compute.BackendService
+	failures         map[string]error
+}
+
+type fakeInstance struct {
+	instance *compute.Instance
+	settings gcloud.InstanceSettings
+	console  string
+}
+
+type fakeManager struct {
+	manager  compute.InstanceGroupManager
+	template string
+	members  []string
+	created  int
+	zones    []string
+
+	healthCheck     string
+	initialDelaySec int64
+}
+
+// location holds the instance group managers of the zone or of the region.
+type location struct {
+	path     string
+	regional bool
+	managers map[string]*fakeManager
+	zones    []string
+}
+
+var _ gcloud.API = &API{}
+
+// New creates an empty fake API for a given project and zone.
+func New(project, zone string) *API {
+	return &API{
+		project:   project,
+		zone:      zone,
+		region:    regionOf(zone),
+		instances: map[string]*fakeInstance{},
+		disks:     map[string]*compute.Disk{},
+		snapshots: map[string]*compute.Snapshot{},
+		addresses: map[string]*gcloud.Address{},
+		firewalls: map[string]*gcloud.FirewallSettings{},
+		templates: map[string]*gcloud.InstanceSettings{},
+		managers:  map[string]*fakeManager{},
+		pools:     map[string][]string{},
+		policies:  map[string]int64{},
+		backends:  map[string]*compute.BackendService{},
+		failures:  map[string]error{},
+
+		regionalManagers: map[string]*fakeManager{},
+		autoscalers:      map[string]*compute.Autoscaler{},
+		healthChecks:     map[string]*gcloud.HealthCheckSettings{},
+	}
+}
+
+// regionOf is the region of a zone, eg. us-central1 for us-central1-f.
+func regionOf(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return ""
+}
+
+// SetRegion sets the region of the regional methods. The instances of the
+// regional groups are spread across the given zones, in turn.
+func (f *API) SetRegion(region string, zones ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.region = region
+	f.regionZones = zones
+}
+
+// Fail makes every following call to the given method, eg. "CreateInstance",
+// return err without changing anything. A nil err removes the failure.
+func (f *API) Fail(method string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err == nil {
+		delete(f.failures, method)
+	} else {
+		f.failures[method] = err
+	}
+}
+
+// Template returns the settings an instance template was created with.
+func (f *API) Template(name string) (*gcloud.InstanceSettings, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	settings, present := f.templates[name]
+	return settings, present
+}
+
+// Templates returns the sorted names of the instance templates.
+func (f *API) Templates() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	names := []string{}
+	for name := range f.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// CreateTargetPool creates an empty target pool. The API can't create target
+// pools, which are set up with the load balancer.
+func (f *API) CreateTargetPool(name string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.pools[name] = []string{}
+}
+
+// TargetPool returns the names of the instances added to a target pool.
+func (f *API) TargetPool(name string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]string(nil), f.pools[name]...)
+}
+
+// AddBackendService creates an empty backend service, since the API can't.
+func (f *API) AddBackendService(name string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.backends[name] = &compute.BackendService{Name: name}
+}
+
+// HealthCheck returns the settings a health check was created or updated with.
+func (f *API) HealthCheck(name string) (*gcloud.HealthCheckSettings, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	settings, present := f.healthChecks[name]
+	return settings, present
+}
+
+// FirewallRule returns the settings a firewall rule was created with.
+func (f *API) FirewallRule(name string) (*gcloud.FirewallSettings, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	settings, present := f.firewalls[name]
+	return settings, present
+}
+
+// SetConsoleOutput sets the output of the serial console of an instance of
+// the zone. The other serial ports have no output.
+func (f *API) SetConsoleOutput(instanceName string, output string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if inst, present := f.instances[instanceName]; present {
+		inst.console = output
+	}
+}
+
+// AutoHealingPolicy returns the health check and the initial delay of the
+// autohealing policy of an instance group manager.
+func (f *API) AutoHealingPolicy(name string) (string, int64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	manager, present := f.managers[name]
+	if !present {
+		return "", 0
+	}
+
+	return manager.healthCheck, manager.initialDelaySec
+}
+
+// BackendService returns a backend service.
+func (f *API) BackendService(name string) (*compute.BackendService, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	service, present := f.backends[name]
+	if !present {
+		return nil, false
+	}
+
+	copied := *service
+	return &copied, true
+}
+
+func (f *API) GetProject() string {
+	return f.project
+}
+
+func (f *API) GetZone() string {
+	return f.zone
+}
+
+func (f *API) UserAgent() string {
+	return "fake"
+}
+
+func (f *API) GetRegion() string {
+	if f.region == "" && len(f.zone) > 2 {
+		return f.zone[:len(f.zone)-2]
+	}
+	return f.region
+}
+
+func (f *API) ListInstances(ctx context.Context, filter string) ([]*compute.Instance, error) {
+	return f.listInstances("ListInstances", filter, func(inst *compute.Instance) bool {
+		return last(inst.Zone) == f.zone
+	})
+}
+
+func (f *API) AggregatedListInstances(ctx context.Context, filter string) ([]*compute.Instance, error) {
+	return f.listInstances("AggregatedListInstances", filter, func(inst *compute.Instance) bool {
+		return true
+	})
+}
+
+func (f *API) GetInstance(ctx context.Context, name string) (*compute.Instance, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("GetInstance"); err != nil {
+		return nil, err
+	}
+
+	inst, err := f.instance(f.zone, name)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *inst.instance
+	return &copied, nil
+}
+
+func (f *API) BatchGetInstances(ctx context.Context, names []string) (map[string]*compute.Instance, map[string]error, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("BatchGetInstances"); err != nil {
+		return nil, nil, err
+	}
+
+	instances := map[string]*compute.Instance{}
+	failures := map[string]error{}
+	for _, name := range names {
+		inst, err := f.instance(f.zone, name)
+		if err != nil {
+			failures[name] = err
+			continue
+		}
+
+		copied := *inst.instance
+		instances[name] = &copied
+	}
+
+	return instances, failures, nil
+}
+
+func (f *API) GetInstanceInZone(ctx context.Context, zone string, name string) (*compute.Instance, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("GetInstanceInZone"); err != nil {
+		return nil, err
+	}
+
+	inst, err := f.instance(zone, name)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *inst.instance
+	return &copied, nil
+}
+
+// ListMaintenanceOperations returns no operations, since the fake hosts are
+// never under maintenance.
+func (f *API) ListMaintenanceOperations(ctx context.Context, zone string, name string) ([]*compute.Operation, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("ListMaintenanceOperations"); err != nil {
+		return nil, err
+	}
+
+	if _, err := f.instance(zone, name); err != nil {
+		return nil, err
+	}
+
+	return []*compute.Operation{}, nil
+}
+
+// GetInstanceMaintenance reports no upcoming maintenance, like
+// ListMaintenanceOperations.
+func (f *API) GetInstanceMaintenance(ctx context.Context, zone string, name string) (*gcloud.InstanceMaintenance, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("GetInstanceMaintenance"); err != nil {
+		return nil, err
+	}
+
+	inst, err := f.instance(zone, name)
+	if err != nil {
+		return nil, err
+	}
+
+	onHostMaintenance := inst.settings.OnHostMaintenance
+	if onHostMaintenance == "" {
+		onHostMaintenance = "MIGRATE"
+	}
+
+	return &gcloud.InstanceMaintenance{OnHostMaintenance: onHostMaintenance}, nil
+}
+
+func (f *API) GetSerialPortOutput(ctx context.Context, name string, port int64) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("GetSerialPortOutput"); err != nil {
+		return "", err
+	}
+
+	inst, err := f.instance(f.zone, name)
+	if err != nil {
+		return "", err
+	}
+	if port != 1 {
+		return "", nil
+	}
+
+	return inst.console, nil
+}
+
+func (f *API) ValidateNetwork(ctx context.Context, network, subnetwork string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.failure("ValidateNetwork")
+}
+
+func (f *API) CreateInstance(ctx context.Context, name string, settings *gcloud.InstanceSettings) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("CreateInstance"); err != nil {
+		return err
+	}
+
+	return f.createInstance(f.zone, name, settings)
+}
+
+func (f *API) CreateInstances(ctx context.Context, instances map[string]*gcloud.InstanceSettings) map[string]error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	failures := map[string]error{}
+	for name, settings := range instances {
+		err := f.failure("CreateInstances")
+		if err == nil {
+			err = f.createInstance(f.zone, name, settings)
+		}
+		if err != nil {
+			failures[name] = err
+		}
+	}
+
+	return failures
+}
+
+func (f *API) CreateInstanceInZone(ctx context.Context, zone string, name string, settings *gcloud.InstanceSettings) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("CreateInstanceInZone"); err != nil {
+		return err
+	}
+
+	return f.createInstance(zone, name, settings)
+}
+
+func (f *API) GetTargetPool(ctx context.Context, name string) (*compute.TargetPool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("GetTargetPool"); err != nil {
+		return nil, err
+	}
+
+	members, present := f.pools[name]
+	if !present {
+		return nil, notFound("projects/%s/regions/%s/targetPools/%s", f.project, f.region, name)
+	}
+
+	return &compute.TargetPool{Name: name, Instances: append([]string{}, members...)}, nil
+}
+
+func (f *API) AddInstanceToTargetPool(ctx context.Context, targetPool string, instances ...string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("AddInstanceToTargetPool"); err != nil {
+		return err
+	}
+
+	members, present := f.pools[targetPool]
+	if !present {
+		return notFound("projects/%s/regions/%s/targetPools/%s", f.project, f.region, targetPool)
+	}
+
+	for _, instance := range instances {
+		zone := f.zone
+		if strings.Contains(instance, "/zones/") {
+			zone = pathSegment(instance, "zones")
+		}
+		if _, err := f.instance(zone, last(instance)); err != nil {
+			return err
+		}
+		if !contains(members, last(instance)) {
+			members = append(members, last(instance))
+		}
+	}
+	f.pools[targetPool] = members
+
+	return nil
+}
+
+func (f *API) RemoveInstanceFromTargetPool(ctx context.Context, targetPool string, instances ...string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("RemoveInstanceFromTargetPool"); err != nil {
+		return err
+	}
+
+	members, present := f.pools[targetPool]
+	if !present {
+		return notFound("projects/%s/regions/%s/targetPools/%s", f.project, f.region, targetPool)
+	}
+
+	for _, instance := range instances {
+		members = without(members, last(instance))
+	}
+	f.pools[targetPool] = members
+
+	return nil
+}
+
+func (f *API) AddInstanceMetadata(ctx context.Context, instanceName string, items []*compute.MetadataItems) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("AddInstanceMetadata"); err != nil {
+		return err
+	}
+
+	inst, err := f.instance(f.zone, instanceName)
+	if err != nil {
+		return err
+	}
+
+	merged := []*compute.MetadataItems{}
+	for _, item := range inst.instance.Metadata.Items {
+		if !hasKey(items, item.Key) {
+			merged = append(merged, item)
+		}
+	}
+	inst.instance.Metadata = &compute.Metadata{Items: append(merged, items...)}
+
+	return nil
+}
+
+// Labels returns the labels of an instance.
+func (f *API) Labels(name string) map[string]string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	labels := map[string]string{}
+	if inst, present := f.instances[name]; present {
+		for k, v := range inst.settings.Labels {
+			labels[k] = v
+		}
+	}
+
+	return labels
+}
+
+func (f *API) AddInstanceLabels(ctx context.Context, instanceName string, labels map[string]string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("AddInstanceLabels"); err != nil {
+		return err
+	}
+
+	inst, err := f.instance(f.zone, instanceName)
+	if err != nil {
+		return err
+	}
+
+	merged := map[string]string{}
+	for k, v := range inst.settings.Labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	inst.settings.Labels = merged
+
+	return nil
+}
+
+func (f *API) SetInstanceTags(ctx context.Context, instanceName string, tags []string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("SetInstanceTags"); err != nil {
+		return err
+	}
+
+	inst, err := f.instance(f.zone, instanceName)
+	if err != nil {
+		return err
+	}
+
+	inst.instance.Tags = &compute.Tags{Items: tags}
+	inst.settings.Tags = tags
+
+	return nil
+}
+
+func (f *API) DeleteInstance(ctx context.Context, name string) error {
+	return f.deleteInstance("DeleteInstance", f.zone, name)
+}
+
+func (f *API) DeleteInstances(ctx context.Context, names []string) map[string]error {
+	failures := map[string]error{}
+	for _, name := range names {
+		if err := f.deleteInstance("DeleteInstances", f.zone, name); err != nil {
+			failures[name] = err
+		}
+	}
+
+	return failures
+}
+
+func (f *API) DeleteInstanceInZone(ctx context.Context, zone string, name string) error {
+	return f.deleteInstance("DeleteInstanceInZone", zone, name)
+}
+
+func (f *API) GetDeletionProtection(ctx context.Context, zone string, name string) (bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("GetDeletionProtection"); err != nil {
+		return false, err
+	}
+
+	inst, err := f.instance(zone, name)
+	if err != nil {
+		return false, err
+	}
+
+	return inst.settings.DeletionProtection, nil
+}
+
+func (f *API) SetDeletionProtection(ctx context.Context, zone string, name string, protected bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("SetDeletionProtection"); err != nil {
+		return err
+	}
+
+	inst, err := f.instance(zone, name)
+	if err != nil {
+		return err
+	}
+	inst.settings.DeletionProtection = protected
+
+	return nil
+}
+
+func (f *API) deleteInstance(method, zone, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return err
+	}
+
+	inst, err := f.instance(zone, name)
+	if err != nil {
+		return err
+	}
+	if inst.settings.DeletionProtection {
+		return fmt.Errorf("The resource 'projects/%s/zones/%s/instances/%s' is protected against deletion", f.project, zone, name)
+	}
+	delete(f.instances, name)
+
+	for _, loc := range []location{f.zonal(), f.regional()} {
+		for _, manager := range loc.managers {
+			manager.members = without(manager.members, name)
+		}
+	}
+
+	return nil
+}
+
+func (f *API) CreateDisk(ctx context.Context, name string, settings *gcloud.PersistentDiskSettings) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("CreateDisk"); err != nil {
+		return err
+	}
+
+	if _, present := f.disks[name]; present {
+		return alreadyExists("projects/%s/zones/%s/disks/%s", f.project, f.zone, name)
+	}
+
+	zoneURL := "https://www.googleapis.com/compute/v1/projects/" + f.project + "/zones/" + f.zone
+	f.disks[name] = &compute.Disk{
+		Name:           name,
+		Zone:           zoneURL,
+		SizeGb:         settings.SizeGb,
+		Type:           zoneURL + "/diskTypes/" + last(settings.Type),
+		SourceImage:    settings.Image,
+		SourceSnapshot: settings.Snapshot,
+		SelfLink:       zoneURL + "/disks/" + name,
+	}
+
+	return nil
+}
+
+func (f *API) DeleteDisk(ctx context.Context, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("DeleteDisk"); err != nil {
+		return err
+	}
+
+	disk, present := f.disks[name]
+	if !present {
+		return notFound("projects/%s/zones/%s/disks/%s", f.project, f.zone, name)
+	}
+	if user, attached := f.diskUser(disk); attached {
+		return inUse("disk", fmt.Sprintf("projects/%s/zones/%s/disks/%s", f.project, f.zone, name), fmt.Sprintf("projects/%s/zones/%s/instances/%s", f.project, f.zone, user))
+	}
+	delete(f.disks, name)
+
+	return nil
+}
+
+func (f *API) AttachDisk(ctx context.Context, instanceName string, diskName string, deviceName string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("AttachDisk"); err != nil {
+		return err
+	}
+
+	inst, err := f.instance(f.zone, instanceName)
+	if err != nil {
+		return err
+	}
+	disk, present := f.disks[diskName]
+	if !present {
+		return notFound("projects/%s/zones/%s/disks/%s", f.project, f.zone, diskName)
+	}
+	if user, attached := f.diskUser(disk); attached {
+		return inUse("disk", fmt.Sprintf("projects/%s/zones/%s/disks/%s", f.project, f.zone, diskName), fmt.Sprintf("projects/%s/zones/%s/instances/%s", f.project, f.zone, user))
+	}
+
+	inst.instance.Disks = append(inst.instance.Disks, &compute.AttachedDisk{
+		Source:     disk.SelfLink,
+		DeviceName: deviceName,
+		Mode:       "READ_WRITE",
+		Type:       "PERSISTENT",
+	})
+
+	return nil
+}
+
+func (f *API) DetachDisk(ctx context.Context, instanceName string, deviceName string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("DetachDisk"); err != nil {
+		return err
+	}
+
+	inst, err := f.instance(f.zone, instanceName)
+	if err != nil {
+		return err
+	}
+
+	for i, disk := range inst.instance.Disks {
+		if disk.DeviceName == deviceName {
+			inst.instance.Disks = append(inst.instance.Disks[:i], inst.instance.Disks[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No attached disk found with device name '%s'", deviceName)
+}
+
+func (f *API) CreateSnapshot(ctx context.Context, diskName string, snapshotName string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("CreateSnapshot"); err != nil {
+		return err
+	}
+
+	disk, present := f.disks[diskName]
+	if !present {
+		return notFound("projects/%s/zones/%s/disks/%s", f.project, f.zone, diskName)
+	}
+	if _, present := f.snapshots[snapshotName]; present {
+		return alreadyExists("projects/%s/global/snapshots/%s", f.project, snapshotName)
+	}
+
+	f.snapshots[snapshotName] = &compute.Snapshot{
+		Name:       snapshotName,
+		DiskSizeGb: disk.SizeGb,
+		SourceDisk: disk.SelfLink,
+		Status:     "READY",
+		SelfLink:   "https://www.googleapis.com/compute/v1/projects/" + f.project + "/global/snapshots/" + snapshotName,
+	}
+
+	return nil
+}
+
+func (f *API) DeleteSnapshot(ctx context.Context, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("DeleteSnapshot"); err != nil {
+		return err
+	}
+
+	if _, present := f.snapshots[name]; !present {
+		return notFound("projects/%s/global/snapshots/%s", f.project, name)
+	}
+	delete(f.snapshots, name)
+
+	return nil
+}
+
+func (f *API) ListSnapshots(ctx context.Context, filter string) ([]*compute.Snapshot, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("ListSnapshots"); err != nil {
+		return nil, err
+	}
+
+	match, err := nameMatcher(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []*compute.Snapshot{}
+	for name, snapshot := range f.snapshots {
+		if match(name) {
+			listed := *snapshot
+			snapshots = append(snapshots, &listed)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name < snapshots[j].Name })
+
+	return snapshots, nil
+}
+
+// diskUser finds the instance that a disk is attached to.
+func (f *API) diskUser(disk *compute.Disk) (string, bool) {
+	for name, inst := range f.instances {
+		for _, attached := range inst.instance.Disks {
+			if attached.Source == disk.SelfLink {
+				return name, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func (f *API) DeleteInstanceGroupManager(ctx context.Context, name string) error {
+	return f.deleteManager("DeleteInstanceGroupManager", f.zonal(), name)
+}
+
+func (f *API) DeleteRegionalInstanceGroupManager(ctx context.Context, name string) error {
+	return f.deleteManager("DeleteRegionalInstanceGroupManager", f.regional(), name)
+}
+
+func (f *API) deleteManager(method string, loc location, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return err
+	}
+
+	manager, err := f.manager(loc, name)
+	if err != nil {
+		return err
+	}
+	if _, present := f.autoscalers[name]; present && !loc.regional {
+		return inUse("instance_group_manager", fmt.Sprintf("projects/%s/%s/instanceGroupManagers/%s", f.project, loc.path, name), fmt.Sprintf("projects/%s/%s/autoscalers/%s", f.project, loc.path, name))
+	}
+	groupURL := f.instanceGroupURL(loc, name)
+	if backendService, used := f.inBackendService(groupURL); used {
+		return inUse("instance_group", groupURL, fmt.Sprintf("projects/%s/global/backendServices/%s", f.project, backendService))
+	}
+
+	for _, member := range manager.members {
+		delete(f.instances, member)
+	}
+	delete(loc.managers, name)
+
+	return nil
+}
+
+func (f *API) DeleteInstanceTemplate(ctx context.Context, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("DeleteInstanceTemplate"); err != nil {
+		return err
+	}
+
+	if _, present := f.templates[name]; !present {
+		return notFound("projects/%s/global/instanceTemplates/%s", f.project, name)
+	}
+	for _, loc := range []location{f.zonal(), f.regional()} {
+		for managerName, manager := range loc.managers {
+			if manager.template == name {
+				return inUse("instance_template", fmt.Sprintf("projects/%s/global/instanceTemplates/%s", f.project, name), fmt.Sprintf("projects/%s/%s/instanceGroupManagers/%s", f.project, loc.path, managerName))
+			}
+		}
+	}
+	delete(f.templates, name)
+
+	return nil
+}
+
+func (f *API) ListInstanceGroupInstances(ctx context.Context, name string) ([]*compute.InstanceWithNamedPorts, error) {
+	return f.listManagerInstances("ListInstanceGroupInstances", f.zonal(), name)
+}
+
+func (f *API) ListRegionalInstanceGroupInstances(ctx context.Context, name string) ([]*compute.InstanceWithNamedPorts, error) {
+	return f.listManagerInstances("ListRegionalInstanceGroupInstances", f.regional(), name)
+}
+
+func (f *API) listManagerInstances(method string, loc location, name string) ([]*compute.InstanceWithNamedPorts, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return nil, err
+	}
+
+	manager, err := f.manager(loc, name)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []*compute.InstanceWithNamedPorts{}
+	for _, member := range manager.members {
+		items = append(items, &compute.InstanceWithNamedPorts{
+			Instance: f.instances[member].instance.SelfLink,
+			Status:   "RUNNING",
+		})
+	}
+
+	return items, nil
+}
+
+func (f *API) ListManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error) {
+	return f.listManagedInstances("ListManagedInstances", f.zonal(), name)
+}
+
+func (f *API) ListRegionalManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error) {
+	return f.listManagedInstances("ListRegionalManagedInstances", f.regional(), name)
+}
+
+// listManagedInstances reports the instances of a group as idle, since the
+// fake managers apply their changes right away.
+func (f *API) listManagedInstances(method string, loc location, name string) ([]*compute.ManagedInstance, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return nil, err
+	}
+
+	manager, err := f.manager(loc, name)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []*compute.ManagedInstance{}
+	for _, member := range manager.members {
+		instance := f.instances[member].instance
+		items = append(items, &compute.ManagedInstance{
+			Instance:       instance.SelfLink,
+			InstanceStatus: instance.Status,
+			CurrentAction:  "NONE",
+		})
+	}
+
+	return items, nil
+}
+
+func (f *API) CreateInstanceTemplate(ctx context.Context, name string, settings *gcloud.InstanceSettings) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("CreateInstanceTemplate"); err != nil {
+		return err
+	}
+
+	if _, present := f.templates[name]; present {
+		return alreadyExists("projects/%s/global/instanceTemplates/%s", f.project, name)
+	}
+
+	copied := *settings
+	f.templates[name] = &copied
+
+	return nil
+}
+
+func (f *API) ListInstanceTemplates(ctx context.Context, filter string) ([]*compute.InstanceTemplate, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("ListInstanceTemplates"); err != nil {
+		return nil, err
+	}
+
+	match, err := nameMatcher(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	templates := []*compute.InstanceTemplate{}
+	for name := range f.templates {
+		if match(name) {
+			templates = append(templates, f.template(name))
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+
+	return templates, nil
+}
+
+func (f *API) GetInstanceTemplate(ctx context.Context, name string) (*compute.InstanceTemplate, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("GetInstanceTemplate"); err != nil {
+		return nil, err
+	}
+
+	if _, present := f.templates[name]; !present {
+		return nil, notFound("projects/%s/global/instanceTemplates/%s", f.project, name)
+	}
+
+	return f.template(name), nil
+}
+
+func (f *API) CreateInstanceGroupManager(ctx context.Context, name string, settings *gcloud.InstanceManagerSettings) error {
+	return f.createManager("CreateInstanceGroupManager", f.zonal(), name, settings)
+}
+
+func (f *API) CreateAutoscaler(ctx context.Context, name string, settings *gcloud.AutoscalerSettings) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("CreateAutoscaler"); err != nil {
+		return err
+	}
+
+	if _, present := f.autoscalers[name]; present {
+		return alreadyExists("projects/%s/zones/%s/autoscalers/%s", f.project, f.zone, name)
+	}
+	if _, err := f.manager(f.zonal(), name); err != nil {
+		return err
+	}
+
+	f.autoscalers[name] = f.autoscaler(name, settings)
+
+	return nil
+}
+
+func (f *API) GetAutoscaler(ctx context.Context, name string) (*compute.Autoscaler, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("GetAutoscaler"); err != nil {
+		return nil, err
+	}
+
+	autoscaler, present := f.autoscalers[name]
+	if !present {
+		return nil, notFound("projects/%s/zones/%s/autoscalers/%s", f.project, f.zone, name)
+	}
+
+	copied := *autoscaler
+	policy := *autoscaler.AutoscalingPolicy
+	copied.AutoscalingPolicy = &policy
+	return &copied, nil
+}
+
+func (f *API) UpdateAutoscaler(ctx context.Context, name string, settings *gcloud.AutoscalerSettings) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("UpdateAutoscaler"); err != nil {
+		return err
+	}
+
+	if _, present := f.autoscalers[name]; !present {
+		return notFound("projects/%s/zones/%s/autoscalers/%s", f.project, f.zone, name)
+	}
+
+	f.autoscalers[name] = f.autoscaler(name, settings)
+
+	return nil
+}
+
+func (f *API) DeleteAutoscaler(ctx context.Context, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("DeleteAutoscaler"); err != nil {
+		return err
+	}
+
+	if _, present := f.autoscalers[name]; !present {
+		return notFound("projects/%s/zones/%s/autoscalers/%s", f.project, f.zone, name)
+	}
+	delete(f.autoscalers, name)
+
+	return nil
+}
+
+func (f *API) autoscaler(name string, settings *gcloud.AutoscalerSettings) *compute.Autoscaler {
+	return &compute.Autoscaler{
+		Name:   name,
+		Zone:   f.zone,
+		Target: "https://www.googleapis.com/compute/v1/projects/" + f.project + "/zones/" + f.zone + "/instanceGroupManagers/" + name,
+		AutoscalingPolicy: &compute.AutoscalingPolicy{
+			MinNumReplicas: settings.MinReplicas,
+			MaxNumReplicas: settings.MaxReplicas,
+			CpuUtilization: &compute.AutoscalingPolicyCpuUtilization{UtilizationTarget: settings.CPUUtilization},
+		},
+	}
+}
+
+func (f *API) CreateHealthCheck(ctx context.Context, name string, settings *gcloud.HealthCheckSettings) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("CreateHealthCheck"); err != nil {
+		return err
+	}
+
+	if _, present := f.healthChecks[name]; present {
+		return alreadyExists("projects/%s/global/healthChecks/%s", f.project, name)
+	}
+
+	copied := *settings
+	f.healthChecks[name] = &copied
+
+	return nil
+}
+
+func (f *API) UpdateHealthCheck(ctx context.Context, name string, settings *gcloud.HealthCheckSettings) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("UpdateHealthCheck"); err != nil {
+		return err
+	}
+
+	if _, present := f.healthChecks[name]; !present {
+		return notFound("projects/%s/global/healthChecks/%s", f.project, name)
+	}
+
+	copied := *settings
+	f.healthChecks[name] = &copied
+
+	return nil
+}
+
+func (f *API) DeleteHealthCheck(ctx context.Context, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("DeleteHealthCheck"); err != nil {
+		return err
+	}
+
+	if _, present := f.healthChecks[name]; !present {
+		return notFound("projects/%s/global/healthChecks/%s", f.project, name)
+	}
+	for managerName, manager := range f.managers {
+		if manager.healthCheck == name {
+			return inUse("health_check", fmt.Sprintf("projects/%s/global/healthChecks/%s", f.project, name), fmt.Sprintf("projects/%s/zones/%s/instanceGroupManagers/%s", f.project, f.zone, managerName))
+		}
+	}
+	delete(f.healthChecks, name)
+
+	return nil
+}
+
+func (f *API) SetAutoHealingPolicy(ctx context.Context, name string, healthCheck string, initialDelaySec int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("SetAutoHealingPolicy"); err != nil {
+		return err
+	}
+
+	manager, err := f.manager(f.zonal(), name)
+	if err != nil {
+		return err
+	}
+	if _, present := f.healthChecks[healthCheck]; healthCheck != "" && !present {
+		return notFound("projects/%s/global/healthChecks/%s", f.project, healthCheck)
+	}
+
+	manager.healthCheck = healthCheck
+	manager.initialDelaySec = initialDelaySec
+
+	return nil
+}
+
+func (f *API) CreateRegionalInstanceGroupManager(ctx context.Context, name string, settings *gcloud.InstanceManagerSettings) error {
+	return f.createManager("CreateRegionalInstanceGroupManager", f.regional(), name, settings)
+}
+
+func (f *API) createManager(method string, loc location, name string, settings *gcloud.InstanceManagerSettings) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return err
+	}
+
+	if _, present := loc.managers[name]; present {
+		return alreadyExists("projects/%s/%s/instanceGroupManagers/%s", f.project, loc.path, name)
+	}
+	if _, present := f.templates[settings.TemplateName]; !present {
+		return notFound("projects/%s/global/instanceTemplates/%s", f.project, settings.TemplateName)
+	}
+	zones := loc.zones
+	if loc.regional && len(settings.Zones) > 0 {
+		if err := gcloud.ValidateZones(f.region, settings.Zones); err != nil {
+			return err
+		}
+		zones = settings.Zones
+	}
+	if len(zones) == 0 {
+		return fmt.Errorf("No zone to create the instances of 'projects/%s/%s/instanceGroupManagers/%s'", f.project, loc.path, name)
+	}
+
+	manager := &fakeManager{
+		manager: compute.InstanceGroupManager{
+			Name:             name,
+			Description:      settings.Description,
+			BaseInstanceName: settings.BaseInstanceName,
+			TargetPools:      settings.TargetPools,
+		},
+		template: settings.TemplateName,
+		zones:    zones,
+	}
+	if loc.regional {
+		manager.manager.Region = f.region
+	} else {
+		manager.manager.Zone = f.zone
+	}
+	loc.managers[name] = manager
+
+	return f.resize(manager, settings.TargetSize)
+}
+
+func (f *API) GetInstanceGroupManager(ctx context.Context, name string) (*compute.InstanceGroupManager, error) {
+	return f.getManager("GetInstanceGroupManager", f.zonal(), name)
+}
+
+func (f *API) GetRegionalInstanceGroupManager(ctx context.Context, name string) (*compute.InstanceGroupManager, error) {
+	return f.getManager("GetRegionalInstanceGroupManager", f.regional(), name)
+}
+
+func (f *API) getManager(method string, loc location, name string) (*compute.InstanceGroupManager, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return nil, err
+	}
+
+	manager, err := f.manager(loc, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.describeManager(manager), nil
+}
+
+func (f *API) ListInstanceGroupManagers(ctx context.Context) ([]*compute.InstanceGroupManager, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("ListInstanceGroupManagers"); err != nil {
+		return nil, err
+	}
+
+	managers := []*compute.InstanceGroupManager{}
+	for _, manager := range f.managers {
+		managers = append(managers, f.describeManager(manager))
+	}
+	sort.Slice(managers, func(i, j int) bool { return managers[i].Name < managers[j].Name })
+
+	return managers, nil
+}
+
+// describeManager describes an instance group manager the way the Compute
+// API does. Its instances are always up to date.
+func (f *API) describeManager(manager *fakeManager) *compute.InstanceGroupManager {
+	copied := manager.manager
+	copied.InstanceTemplate = "projects/" + f.project + "/global/instanceTemplates/" + manager.template
+	copied.TargetSize = int64(len(manager.members))
+	copied.CurrentActions = &compute.InstanceGroupManagerActionsSummary{None: int64(len(manager.members))}
+
+	return &copied
+}
+
+func (f *API) SetNamedPorts(ctx context.Context, name string, ports []gcloud.NamedPort) error {
+	return f.setNamedPorts("SetNamedPorts", f.zonal(), name, ports)
+}
+
+func (f *API) SetRegionalNamedPorts(ctx context.Context, name string, ports []gcloud.NamedPort) error {
+	return f.setNamedPorts("SetRegionalNamedPorts", f.regional(), name, ports)
+}
+
+func (f *API) setNamedPorts(method string, loc location, name string, ports []gcloud.NamedPort) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return err
+	}
+
+	manager, err := f.manager(loc, name)
+	if err != nil {
+		return err
+	}
+
+	manager.manager.NamedPorts = nil
+	for _, port := range ports {
+		manager.manager.NamedPorts = append(manager.manager.NamedPorts, &compute.NamedPort{Name: port.Name, Port: port.Port})
+	}
+
+	return nil
+}
+
+func (f *API) SetTargetPools(ctx context.Context, name string, targetPools []string) error {
+	return f.setTargetPools("SetTargetPools", f.zonal(), name, targetPools)
+}
+
+func (f *API) SetRegionalTargetPools(ctx context.Context, name string, targetPools []string) error {
+	return f.setTargetPools("SetRegionalTargetPools", f.regional(), name, targetPools)
+}
+
+func (f *API) setTargetPools(method string, loc location, name string, targetPools []string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return err
+	}
+
+	manager, err := f.manager(loc, name)
+	if err != nil {
+		return err
+	}
+
+	manager.manager.TargetPools = append([]string{}, targetPools...)
+
+	return nil
+}
+
+func (f *API) SetInstanceTemplate(ctx context.Context, name string, templateName string) error {
+	return f.setTemplate("SetInstanceTemplate", f.zonal(), name, templateName)
+}
+
+func (f *API) SetRegionalInstanceTemplate(ctx context.Context, name string, templateName string) error {
+	return f.setTemplate("SetRegionalInstanceTemplate", f.regional(), name, templateName)
+}
+
+func (f *API) setTemplate(method string, loc location, name string, templateName string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return err
+	}
+
+	manager, err := f.manager(loc, name)
+	if err != nil {
+		return err
+	}
+	if _, present := f.templates[templateName]; !present {
+		return notFound("projects/%s/global/instanceTemplates/%s", f.project, templateName)
+	}
+
+	manager.template = templateName
+
+	return nil
+}
+
+// RecreateInstances accepts the names or the URLs of the instances, like the
+// Compute API.
+func (f *API) RecreateInstances(ctx context.Context, name string, instances ...string) error {
+	return f.recreate("RecreateInstances", f.zonal(), name, instances)
+}
+
+func (f *API) RecreateRegionalInstances(ctx context.Context, name string, instances ...string) error {
+	return f.recreate("RecreateRegionalInstances", f.regional(), name, instances)
+}
+
+func (f *API) recreate(method string, loc location, name string, instances []string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return err
+	}
+
+	manager, err := f.manager(loc, name)
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		if !contains(manager.members, last(instance)) {
+			return notFound("projects/%s/%s/instances/%s", f.project, loc.path, last(instance))
+		}
+	}
+
+	// Instances are recreated in their zone.
+	for _, instance := range instances {
+		zone := last(f.instances[last(instance)].instance.Zone)
+		delete(f.instances, last(instance))
+		if err := f.createInstance(zone, last(instance), f.templates[manager.template]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StartRollingUpdate replaces every instance of the group at once, since the
+// fake creates instances synchronously.
+func (f *API) StartRollingUpdate(ctx context.Context, name string, templateName string, maxSurge, maxUnavailable int) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("StartRollingUpdate"); err != nil {
+		return err
+	}
+
+	manager, err := f.manager(f.zonal(), name)
+	if err != nil {
+		return err
+	}
+	if _, present := f.templates[templateName]; !present {
+		return notFound("projects/%s/global/instanceTemplates/%s", f.project, templateName)
+	}
+
+	manager.template = templateName
+	for _, member := range manager.members {
+		delete(f.instances, member)
+		if err := f.createInstance(f.zone, member, f.templates[templateName]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *API) ResizeInstanceGroupManager(ctx context.Context, name string, targetSize int64) error {
+	return f.resizeManager("ResizeInstanceGroupManager", f.zonal(), name, targetSize)
+}
+
+func (f *API) ResizeRegionalInstanceGroupManager(ctx context.Context, name string, targetSize int64) error {
+	return f.resizeManager("ResizeRegionalInstanceGroupManager", f.regional(), name, targetSize)
+}
+
+func (f *API) resizeManager(method string, loc location, name string, targetSize int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return err
+	}
+
+	manager, err := f.manager(loc, name)
+	if err != nil {
+		return err
+	}
+
+	return f.resize(manager, targetSize)
+}
+
+func (f *API) CreateSpreadPlacementPolicy(ctx context.Context, name string, availabilityDomains int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("CreateSpreadPlacementPolicy"); err != nil {
+		return err
+	}
+
+	if _, present := f.policies[name]; present {
+		return alreadyExists("projects/%s/regions/%s/resourcePolicies/%s", f.project, f.region, name)
+	}
+	f.policies[name] = availabilityDomains
+
+	return nil
+}
+
+func (f *API) GetSpreadPlacementPolicy(ctx context.Context, name string) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("GetSpreadPlacementPolicy"); err != nil {
+		return 0, err
+	}
+
+	availabilityDomains, present := f.policies[name]
+	if !present {
+		return 0, notFound("projects/%s/regions/%s/resourcePolicies/%s", f.project, f.region, name)
+	}
+
+	return availabilityDomains, nil
+}
+
+func (f *API) DeleteResourcePolicy(ctx context.Context, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("DeleteResourcePolicy"); err != nil {
+		return err
+	}
+
+	if _, present := f.policies[name]; !present {
+		return notFound("projects/%s/regions/%s/resourcePolicies/%s", f.project, f.region, name)
+	}
+	for templateName, settings := range f.templates {
+		if contains(settings.ResourcePolicies, name) {
+			return fmt.Errorf("The resource_policy resource 'projects/%s/regions/%s/resourcePolicies/%s' is already being used by 'projects/%s/global/instanceTemplates/%s'", f.project, f.region, name, f.project, templateName)
+		}
+	}
+	delete(f.policies, name)
+
+	return nil
+}
+
+// AbandonInstances accepts the names or the URLs of the instances, like the
+// Compute API. The abandoned instances are kept.
+func (f *API) AbandonInstances(ctx context.Context, name string, instances ...string) error {
+	return f.removeMembers("AbandonInstances", name, instances, false)
+}
+
+// DeleteGroupInstances accepts the names or the URLs of the instances, like
+// the Compute API.
+func (f *API) DeleteGroupInstances(ctx context.Context, name string, instances ...string) error {
+	return f.removeMembers("DeleteGroupInstances", name, instances, true)
+}
+
+func (f *API) removeMembers(method string, name string, instances []string, deleteInstances bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return err
+	}
+
+	manager, err := f.manager(f.zonal(), name)
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		if !contains(manager.members, last(instance)) {
+			return notFound("projects/%s/zones/%s/instances/%s", f.project, f.zone, last(instance))
+		}
+	}
+
+	for _, instance := range instances {
+		manager.members = without(manager.members, last(instance))
+		if deleteInstances {
+			delete(f.instances, last(instance))
+		}
+	}
+
+	return nil
+}
+
+func (f *API) AddInstanceGroupToBackendService(ctx context.Context, backendService string, instanceGroup string, drainingTimeoutSec int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("AddInstanceGroupToBackendService"); err != nil {
+		return err
+	}
+
+	service, err := f.backendService(backendService)
+	if err != nil {
+		return err
+	}
+	loc, name := f.instanceGroup(instanceGroup)
+	groupURL := f.instanceGroupURL(loc, name)
+	if _, present := loc.managers[name]; !present {
+		return notFound("%s", groupURL)
+	}
+
+	if f.backendIndex(service, groupURL) < 0 {
+		service.Backends = append(service.Backends, &compute.Backend{Group: groupURL})
+	}
+	if drainingTimeoutSec > 0 {
+		service.ConnectionDraining = &compute.ConnectionDraining{DrainingTimeoutSec: drainingTimeoutSec}
+	}
+
+	return nil
+}
+
+func (f *API) RemoveInstanceGroupFromBackendService(ctx context.Context, backendService string, instanceGroup string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("RemoveInstanceGroupFromBackendService"); err != nil {
+		return err
+	}
+
+	service, err := f.backendService(backendService)
+	if err != nil {
+		return err
+	}
+
+	groupURL := f.instanceGroupURL(f.instanceGroup(instanceGroup))
+	if index := f.backendIndex(service, groupURL); index >= 0 {
+		service.Backends = append(service.Backends[:index], service.Backends[index+1:]...)
+	}
+
+	return nil
+}
+
+func (f *API) ReserveAddress(ctx context.Context, name string, settings *gcloud.AddressSettings) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("ReserveAddress"); err != nil {
+		return err
+	}
+
+	if existing, present := f.addresses[name]; present {
+		if existing.Internal != settings.Internal || existing.Subnetwork != settings.Subnetwork {
+			return &resourceError{
+				message: fmt.Sprintf("Address %s already exists with other settings: internal=%t, subnetwork=%s", name, existing.Internal, existing.Subnetwork),
+				kind:    gcloud.ErrConflict,
+			}
+		}
+		return nil
+	}
+
+	// Addresses are handed out in turn, from a private or a public range.
+	ip := fmt.Sprintf("35.0.0.%d", len(f.addresses)+1)
+	if settings.Internal {
+		ip = fmt.Sprintf("10.0.0.%d", len(f.addresses)+1)
+	}
+
+	f.addresses[name] = &gcloud.Address{
+		Name:       name,
+		Address:    ip,
+		Region:     f.region,
+		Internal:   settings.Internal,
+		Subnetwork: settings.Subnetwork,
+		Status:     gcloud.AddressReserved,
+	}
+
+	return nil
+}
+
+func (f *API) GetAddress(ctx context.Context, name string) (*gcloud.Address, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("GetAddress"); err != nil {
+		return nil, err
+	}
+
+	address, present := f.addresses[name]
+	if !present {
+		return nil, notFound("projects/%s/regions/%s/addresses/%s", f.project, f.region, name)
+	}
+
+	copied := *address
+	return &copied, nil
+}
+
+func (f *API) ReleaseAddress(ctx context.Context, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("ReleaseAddress"); err != nil {
+		return err
+	}
+
+	if _, present := f.addresses[name]; !present {
+		return notFound("projects/%s/regions/%s/addresses/%s", f.project, f.region, name)
+	}
+	delete(f.addresses, name)
+
+	return nil
+}
+
+func (f *API) CreateFirewallRule(ctx context.Context, name string, settings *gcloud.FirewallSettings) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("CreateFirewallRule"); err != nil {
+		return err
+	}
+
+	if existing, present := f.firewalls[name]; present {
+		if !reflect.DeepEqual(existing, settings) {
+			return &resourceError{
+				message: fmt.Sprintf("Firewall rule %s already exists with other settings", name),
+				kind:    gcloud.ErrConflict,
+			}
+		}
+		return nil
+	}
+
+	copied := *settings
+	f.firewalls[name] = &copied
+
+	return nil
+}
+
+func (f *API) DeleteFirewallRule(ctx context.Context, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure("DeleteFirewallRule"); err != nil {
+		return err
+	}
+
+	if _, present := f.firewalls[name]; !present {
+		return notFound("projects/%s/global/firewalls/%s", f.project, name)
+	}
+	delete(f.firewalls, name)
+
+	return nil
+}
+
+func (f *API) failure(method string) error {
+	return f.failures[method]
+}
+
+func (f *API) listInstances(method, filter string, inScope func(*compute.Instance) bool) ([]*compute.Instance, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.failure(method); err != nil {
+		return nil, err
+	}
+
+	match, err := instanceMatcher(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name, inst := range f.instances {
+		if inScope(inst.instance) && match(inst) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	instances := []*compute.Instance{}
+	for _, name := range names {
+		copied := *f.instances[name].instance
+		instances = append(instances, &copied)
+	}
+
+	return instances, nil
+}
+
+// instanceMatcher supports the filters on the name of the instances, and on
+// the value of a label, for example "labels.role eq worker".
+func instanceMatcher(filter string) (func(*fakeInstance) bool, error) {
+	if strings.HasPrefix(filter, "labels.") {
+		parts := strings.SplitN(strings.TrimPrefix(filter, "labels."), " eq ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Unsupported filter: %s", filter)
+		}
+
+		return func(inst *fakeInstance) bool {
+			value, present := inst.settings.Labels[parts[0]]
+			return present && value == parts[1]
+		}, nil
+	}
+
+	match, err := nameMatcher(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(inst *fakeInstance) bool { return match(inst.instance.Name) }, nil
+}
+
+// nameMatcher supports the filters on the name of the resources, with a
+// regular expression.
+func nameMatcher(filter string) (func(string) bool, error) {
+	if filter == "" {
+		return func(name string) bool { return true }, nil
+	}
+
+	expression := strings.TrimPrefix(filter, "name eq ")
+	if expression == filter {
+		return nil, fmt.Errorf("Unsupported filter: %s", filter)
+	}
+
+	pattern, err := regexp.Compile("^(?:" + expression + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid filter: %s", filter)
+	}
+
+	return pattern.MatchString, nil
+}
+
+func (f *API) instance(zone, name string) (*fakeInstance, error) {
+	inst, present := f.instances[name]
+	if !present || last(inst.instance.Zone) != zone {
+		return nil, notFound("projects/%s/zones/%s/instances/%s", f.project, zone, name)
+	}
+
+	return inst, nil
+}
+
+func (f *API) backendService(name string) (*compute.BackendService, error) {
+	service, present := f.backends[name]
+	if !present {
+		return nil, notFound("projects/%s/global/backendServices/%s", f.project, name)
+	}
+
+	return service, nil
+}
+
+func (f *API) backendIndex(service *compute.BackendService, groupURL string) int {
+	for i, backend := range service.Backends {
+		if backend.Group == groupURL {
+			return i
+		}
+	}
+	return -1
+}
+
+// inBackendService tells whether an instance group, given by its url, is a
+// backend of a backend service.
+func (f *API) inBackendService(groupURL string) (string, bool) {
+	for name, service := range f.backends {
+		if f.backendIndex(service, groupURL) >= 0 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (f *API) manager(loc location, name string) (*fakeManager, error) {
+	manager, present := loc.managers[name]
+	if !present {
+		return nil, notFound("projects/%s/%s/instanceGroupManagers/%s", f.project, loc.path, name)
+	}
+
+	return manager, nil
+}
+
+func (f *API) zonal() location {
+	return location{
+		path:     "zones/" + f.zone,
+		managers: f.managers,
+		zones:    []string{f.zone},
+	}
+}
+
+func (f *API) regional() location {
+	return location{
+		path:     "regions/" + f.region,
+		regional: true,
+		managers: f.regionalManagers,
+		zones:    f.regionZones,
+	}
+}
+
+// instanceGroup resolves the name of an instance group of the zone, or the
+// relative url of a regional one.
+func (f *API) instanceGroup(ref string) (location, string) {
+	if pathSegment(ref, "regions") != "" {
+		return f.regional(), last(ref)
+	}
+	return f.zonal(), ref
+}
+
+func (f *API) instanceGroupURL(loc location, name string) string {
+	return "projects/" + f.project + "/" + loc.path + "/instanceGroups/" + name
+}
+
+// template describes an instance template the way the Compute API does.
+func (f *API) template(name string) *compute.InstanceTemplate {
+	settings := f.templates[name]
+
+	disks := []*compute.AttachedDisk{}
+	for _, disk := range settings.Disks {
+		disks = append(disks, &compute.AttachedDisk{
+			Boot:       disk.Boot,
+			AutoDelete: disk.AutoDelete,
+			InitializeParams: &compute.AttachedDiskInitializeParams{
+				DiskSizeGb:  disk.SizeGb,
+				DiskType:    disk.Type,
+				SourceImage: disk.Image,
+			},
+		})
+	}
+
+	description := settings.TemplateDescription
+	if description == "" {
+		description = settings.Description
+	}
+
+	return &compute.InstanceTemplate{
+		Name:        name,
+		Description: description,
+		SelfLink:    "https://www.googleapis.com/compute/v1/projects/" + f.project + "/global/instanceTemplates/" + name,
+		Properties: &compute.InstanceProperties{
+			Description: settings.Description,
+			MachineType: last(settings.MachineType),
+			Tags:        &compute.Tags{Items: settings.Tags},
+			Disks:       disks,
+			Metadata:    &compute.Metadata{Items: settings.MetaData},
+			Scheduling:  &compute.Scheduling{Preemptible: settings.Preemptible},
+		},
+	}
+}
+
+func (f *API) createInstance(zone, name string, settings *gcloud.InstanceSettings) error {
+	if _, present := f.instances[name]; present {
+		return alreadyExists("projects/%s/zones/%s/instances/%s", f.project, zone, name)
+	}
+
+	zoneURL := "https://www.googleapis.com/compute/v1/projects/" + f.project + "/zones/" + zone
+
+	disks := []*compute.AttachedDisk{}
+	for i, disk := range settings.Disks {
+		diskName := name
+		if i > 0 {
+			diskName = fmt.Sprintf("%s-disk-%d", name, i)
+		}
+		disks = append(disks, &compute.AttachedDisk{
+			Boot:       disk.Boot,
+			AutoDelete: disk.AutoDelete,
+			Source:     zoneURL + "/disks/" + diskName,
+		})
+	}
+
+	f.instances[name] = &fakeInstance{
+		instance: &compute.Instance{
+			Name:        name,
+			Description: settings.Description,
+			Zone:        zoneURL,
+			MachineType: zoneURL + "/machineTypes/" + last(settings.MachineType),
+			Status:      "RUNNING",
+			SelfLink:    zoneURL + "/instances/" + name,
+			Tags:        &compute.Tags{Items: settings.Tags},
+			Metadata:    &compute.Metadata{Items: settings.MetaData},
+			Disks:       disks,
+			Scheduling:  &compute.Scheduling{Preemptible: settings.Preemptible},
+		},
+		settings: *settings,
+	}
+
+	return nil
+}
+
+func (f *API) resize(manager *fakeManager, targetSize int64) error {
+	for int64(len(manager.members)) > targetSize {
+		member := manager.members[len(manager.members)-1]
+		delete(f.instances, member)
+		manager.members = manager.members[:len(manager.members)-1]
+	}
+
+	for int64(len(manager.members)) < targetSize {
+		manager.created++
+		member := fmt.Sprintf("%s-%04d", manager.manager.BaseInstanceName, manager.created)
+		zone := manager.zones[(manager.created-1)%len(manager.zones)]
+		if err := f.createInstance(zone, member, f.templates[manager.template]); err != nil {
+			return err
+		}
+		manager.members = append(manager.members, member)
+	}
+
+	return nil
+}
+
+// resourceError keeps the message of the Compute API while matching one of
+// the typed errors of the gcloud package with errors.Is.
+type resourceError struct {
+	message string
+	kind    error
+}
+
+func (e *resourceError) Error() string {
+	return e.message
+}
+
+func (e *resourceError) Unwrap() error {
+	return e.kind
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &resourceError{
+		message: fmt.Sprintf("The resource '"+format+"' was not found", args...),
+		kind:    gcloud.ErrNotFound,
+	}
+}
+
+func alreadyExists(format string, args ...interface{}) error {
+	return &resourceError{
+		message: fmt.Sprintf("The resource '"+format+"' already exists", args...),
+		kind:    gcloud.ErrAlreadyExists,
+	}
+}
+
+func inUse(kind, resource, user string) error {
+	return &resourceError{
+		message: fmt.Sprintf("The %s resource '%s' is already being used by '%s'", kind, resource, user),
+		kind:    gcloud.ErrInUse,
+	}
+}
+
+func hasKey(items []*compute.MetadataItems, key string) bool {
+	for _, item := range items {
+		if item.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func without(values []string, value string) []string {
+	result := []string{}
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// pathSegment returns the segment that follows a given one in a resource url.
+func pathSegment(url, after string) string {
+	parts := strings.Split(url, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == after {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+func last(url string) string {
+	parts := strings.Split(url, "/")
+	return parts[len(parts)-1]
+}
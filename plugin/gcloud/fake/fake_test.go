@@ -0,0 +1,292 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/infrakit.gcp/plugin/gcloud"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+func TestInstances(t *testing.T) {
+	api := New("PROJECT", "ZONE")
+
+	require.NoError(t, api.CreateInstance(ctx, "vm-1", &gcloud.InstanceSettings{MachineType: "n1-standard-1"}))
+	require.NoError(t, api.CreateInstance(ctx, "vm-2", &gcloud.InstanceSettings{MachineType: "n1-standard-2"}))
+
+	err := api.CreateInstance(ctx, "vm-1", &gcloud.InstanceSettings{})
+	require.EqualError(t, err, "The resource 'projects/PROJECT/zones/ZONE/instances/vm-1' already exists")
+	require.True(t, errors.Is(err, gcloud.ErrAlreadyExists))
+
+	inst, err := api.GetInstance(ctx, "vm-2")
+	require.NoError(t, err)
+	require.Equal(t, "n1-standard-2", last(inst.MachineType))
+	require.Equal(t, "ZONE", last(inst.Zone))
+
+	instances, err := api.ListInstances(ctx, "name eq vm-1")
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	require.Equal(t, "vm-1", instances[0].Name)
+
+	require.NoError(t, api.DeleteInstance(ctx, "vm-1"))
+
+	err = api.DeleteInstance(ctx, "vm-1")
+	require.EqualError(t, err, "The resource 'projects/PROJECT/zones/ZONE/instances/vm-1' was not found")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+
+	instances, err = api.ListInstances(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+}
+
+func TestDeletionProtection(t *testing.T) {
+	api := New("PROJECT", "ZONE")
+
+	require.NoError(t, api.CreateInstance(ctx, "vm", &gcloud.InstanceSettings{DeletionProtection: true}))
+
+	protected, err := api.GetDeletionProtection(ctx, "ZONE", "vm")
+	require.NoError(t, err)
+	require.True(t, protected)
+
+	err = api.DeleteInstance(ctx, "vm")
+	require.EqualError(t, err, "The resource 'projects/PROJECT/zones/ZONE/instances/vm' is protected against deletion")
+
+	require.NoError(t, api.SetDeletionProtection(ctx, "ZONE", "vm", false))
+	require.NoError(t, api.DeleteInstance(ctx, "vm"))
+}
+
+func TestListInstancesByLabel(t *testing.T) {
+	api := New("PROJECT", "ZONE")
+
+	require.NoError(t, api.CreateInstance(ctx, "vm-1", &gcloud.InstanceSettings{Labels: map[string]string{"role": "worker"}}))
+	require.NoError(t, api.CreateInstance(ctx, "vm-2", &gcloud.InstanceSettings{Labels: map[string]string{"role": "manager"}}))
+	require.NoError(t, api.CreateInstance(ctx, "vm-3", &gcloud.InstanceSettings{}))
+
+	instances, err := api.ListInstances(ctx, "labels.role eq worker")
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	require.Equal(t, "vm-1", instances[0].Name)
+
+	_, err = api.ListInstances(ctx, "labels.role ne worker")
+	require.EqualError(t, err, "Unsupported filter: labels.role ne worker")
+}
+
+func TestSerialPortOutput(t *testing.T) {
+	api := New("PROJECT", "ZONE")
+
+	_, err := api.GetSerialPortOutput(ctx, "vm", 1)
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+
+	require.NoError(t, api.CreateInstance(ctx, "vm", &gcloud.InstanceSettings{}))
+	api.SetConsoleOutput("vm", "Booting")
+
+	output, err := api.GetSerialPortOutput(ctx, "vm", 1)
+	require.NoError(t, err)
+	require.Equal(t, "Booting", output)
+
+	output, err = api.GetSerialPortOutput(ctx, "vm", 2)
+	require.NoError(t, err)
+	require.Empty(t, output)
+}
+
+func TestInstanceGroupManager(t *testing.T) {
+	api := New("PROJECT", "ZONE")
+
+	err := api.CreateInstanceGroupManager(ctx, "group", &gcloud.InstanceManagerSettings{TemplateName: "group-1", TargetSize: 2})
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+
+	require.NoError(t, api.CreateInstanceTemplate(ctx, "group-1", &gcloud.InstanceSettings{MachineType: "n1-standard-1"}))
+	require.NoError(t, api.CreateInstanceGroupManager(ctx, "group", &gcloud.InstanceManagerSettings{
+		TemplateName:     "group-1",
+		TargetSize:       2,
+		BaseInstanceName: "group",
+	}))
+
+	members, err := api.ListInstanceGroupInstances(ctx, "group")
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+
+	managers, err := api.ListInstanceGroupManagers(ctx)
+	require.NoError(t, err)
+	require.Len(t, managers, 1)
+	require.Equal(t, "projects/PROJECT/global/instanceTemplates/group-1", managers[0].InstanceTemplate)
+
+	require.NoError(t, api.ResizeInstanceGroupManager(ctx, "group", 3))
+	manager, err := api.GetInstanceGroupManager(ctx, "group")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), manager.TargetSize)
+	require.Equal(t, int64(3), manager.CurrentActions.None)
+
+	require.NoError(t, api.CreateInstanceTemplate(ctx, "group-2", &gcloud.InstanceSettings{MachineType: "n1-standard-2"}))
+	require.NoError(t, api.SetInstanceTemplate(ctx, "group", "group-2"))
+	require.NoError(t, api.RecreateInstances(ctx, "group", members[0].Instance))
+
+	inst, err := api.GetInstance(ctx, last(members[0].Instance))
+	require.NoError(t, err)
+	require.Equal(t, "n1-standard-2", last(inst.MachineType))
+
+	err = api.DeleteInstanceTemplate(ctx, "group-2")
+	require.EqualError(t, err, "The instance_template resource 'projects/PROJECT/global/instanceTemplates/group-2' is already being used by 'projects/PROJECT/zones/ZONE/instanceGroupManagers/group'")
+
+	require.NoError(t, api.DeleteInstanceGroupManager(ctx, "group"))
+	require.NoError(t, api.DeleteInstanceTemplate(ctx, "group-2"))
+	require.Equal(t, []string{"group-1"}, api.Templates())
+
+	instances, err := api.ListInstances(ctx, "")
+	require.NoError(t, err)
+	require.Empty(t, instances)
+}
+
+func TestSpreadPlacementPolicy(t *testing.T) {
+	api := New("PROJECT", "us-central1-f")
+
+	_, err := api.GetSpreadPlacementPolicy(ctx, "group-spread-3")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+
+	require.NoError(t, api.CreateSpreadPlacementPolicy(ctx, "group-spread-3", 3))
+	require.NoError(t, api.CreateInstanceTemplate(ctx, "group-1", &gcloud.InstanceSettings{ResourcePolicies: []string{"group-spread-3"}}))
+
+	availabilityDomains, err := api.GetSpreadPlacementPolicy(ctx, "group-spread-3")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), availabilityDomains)
+
+	err = api.DeleteResourcePolicy(ctx, "group-spread-3")
+	require.EqualError(t, err, "The resource_policy resource 'projects/PROJECT/regions/us-central1/resourcePolicies/group-spread-3' is already being used by 'projects/PROJECT/global/instanceTemplates/group-1'")
+
+	require.NoError(t, api.DeleteInstanceTemplate(ctx, "group-1"))
+	require.NoError(t, api.DeleteResourcePolicy(ctx, "group-spread-3"))
+}
+
+func TestFail(t *testing.T) {
+	api := New("PROJECT", "ZONE")
+
+	api.Fail("CreateInstance", errors.New("BUG"))
+	require.EqualError(t, api.CreateInstance(ctx, "vm", &gcloud.InstanceSettings{}), "BUG")
+
+	_, err := api.GetInstance(ctx, "vm")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+
+	api.Fail("CreateInstance", nil)
+	require.NoError(t, api.CreateInstance(ctx, "vm", &gcloud.InstanceSettings{}))
+}
+
+func TestAbandonAndDeleteGroupInstances(t *testing.T) {
+	api := New("PROJECT", "ZONE")
+
+	require.NoError(t, api.CreateInstanceTemplate(ctx, "group-1", &gcloud.InstanceSettings{}))
+	require.NoError(t, api.CreateInstanceGroupManager(ctx, "group", &gcloud.InstanceManagerSettings{
+		TemplateName:     "group-1",
+		TargetSize:       3,
+		BaseInstanceName: "group",
+	}))
+
+	require.NoError(t, api.AbandonInstances(ctx, "group", "group-0001"))
+	require.NoError(t, api.DeleteGroupInstances(ctx, "group", "projects/PROJECT/zones/ZONE/instances/group-0002"))
+
+	err := api.AbandonInstances(ctx, "group", "group-0001")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+
+	manager, err := api.GetInstanceGroupManager(ctx, "group")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), manager.TargetSize)
+
+	instances, err := api.ListInstances(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	require.Equal(t, "group-0001", instances[0].Name)
+	require.Equal(t, "group-0003", instances[1].Name)
+}
+
+func TestDisks(t *testing.T) {
+	api := New("PROJECT", "ZONE")
+
+	require.NoError(t, api.CreateInstance(ctx, "vm", &gcloud.InstanceSettings{}))
+	require.NoError(t, api.CreateDisk(ctx, "data", &gcloud.PersistentDiskSettings{SizeGb: 10, Type: "pd-ssd"}))
+
+	err := api.CreateDisk(ctx, "data", &gcloud.PersistentDiskSettings{})
+	require.True(t, errors.Is(err, gcloud.ErrAlreadyExists))
+
+	err = api.AttachDisk(ctx, "missing", "data", "data")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+
+	require.NoError(t, api.AttachDisk(ctx, "vm", "data", "data"))
+
+	err = api.DeleteDisk(ctx, "data")
+	require.EqualError(t, err, "The disk resource 'projects/PROJECT/zones/ZONE/disks/data' is already being used by 'projects/PROJECT/zones/ZONE/instances/vm'")
+	require.True(t, errors.Is(err, gcloud.ErrInUse))
+
+	require.NoError(t, api.DetachDisk(ctx, "vm", "data"))
+	require.NoError(t, api.DeleteDisk(ctx, "data"))
+
+	err = api.DeleteDisk(ctx, "data")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+}
+
+func TestSnapshots(t *testing.T) {
+	api := New("PROJECT", "ZONE")
+
+	err := api.CreateSnapshot(ctx, "data", "data-1")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+
+	require.NoError(t, api.CreateDisk(ctx, "data", &gcloud.PersistentDiskSettings{SizeGb: 10}))
+	require.NoError(t, api.CreateSnapshot(ctx, "data", "data-1"))
+	require.NoError(t, api.CreateSnapshot(ctx, "data", "data-2"))
+
+	err = api.CreateSnapshot(ctx, "data", "data-1")
+	require.True(t, errors.Is(err, gcloud.ErrAlreadyExists))
+
+	snapshots, err := api.ListSnapshots(ctx, "name eq data-1")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	require.Equal(t, int64(10), snapshots[0].DiskSizeGb)
+	require.Equal(t, "data", last(snapshots[0].SourceDisk))
+
+	require.NoError(t, api.DeleteSnapshot(ctx, "data-1"))
+
+	err = api.DeleteSnapshot(ctx, "data-1")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+
+	snapshots, err = api.ListSnapshots(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	require.Equal(t, "data-2", snapshots[0].Name)
+}
+
+func TestTargetPools(t *testing.T) {
+	api := New("PROJECT", "ZONE")
+
+	require.NoError(t, api.CreateInstance(ctx, "vm", &gcloud.InstanceSettings{}))
+
+	err := api.AddInstanceToTargetPool(ctx, "pool", "vm")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+
+	api.CreateTargetPool("pool")
+	require.NoError(t, api.AddInstanceToTargetPool(ctx, "pool", "vm"))
+	require.NoError(t, api.AddInstanceToTargetPool(ctx, "pool", "vm"))
+	require.Equal(t, []string{"vm"}, api.TargetPool("pool"))
+
+	require.NoError(t, api.RemoveInstanceFromTargetPool(ctx, "pool", "vm"))
+	require.NoError(t, api.RemoveInstanceFromTargetPool(ctx, "pool", "vm"))
+	require.Empty(t, api.TargetPool("pool"))
+}
+
+func TestFirewallRules(t *testing.T) {
+	api := New("PROJECT", "ZONE")
+
+	settings := &gcloud.FirewallSettings{TargetTags: []string{"swarm"}, AllowedPorts: []string{"tcp:2377"}}
+	require.NoError(t, api.CreateFirewallRule(ctx, "swarm", settings))
+	require.NoError(t, api.CreateFirewallRule(ctx, "swarm", settings))
+
+	err := api.CreateFirewallRule(ctx, "swarm", &gcloud.FirewallSettings{AllowedPorts: []string{"tcp:2377"}})
+	require.True(t, errors.Is(err, gcloud.ErrConflict))
+
+	require.NoError(t, api.DeleteFirewallRule(ctx, "swarm"))
+	_, present := api.FirewallRule("swarm")
+	require.False(t, present)
+
+	err = api.DeleteFirewallRule(ctx, "swarm")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+}
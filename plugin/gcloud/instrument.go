@@ -0,0 +1,612 @@
+package gcloud
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// Hook is called after each call to the API, with the name of the method, the
+// time the call started and the error it returned, if any.
+type Hook func(method string, start time.Time, err error)
+
+// latencyBuckets are the upper bounds, in milliseconds, of the latency
+// histogram maintained by ExpvarHook.
+var latencyBuckets = []int64{10, 50, 100, 500, 1000, 5000, 30000, 120000}
+
+// ExpvarHook returns a Hook that maintains, for each method, in the given map:
+// the number of calls (<method>.calls), the number of errors (<method>.errors),
+// the total latency (<method>.latency_ms_sum) and a cumulative latency
+// histogram (<method>.latency_ms_le_<bound> and <method>.latency_ms_le_inf).
+// The map is typically created with expvar.NewMap so that it's published.
+func ExpvarHook(m *expvar.Map) Hook {
+	return func(method string, start time.Time, err error) {
+		latency := time.Since(start)
+		ms := int64(latency / time.Millisecond)
+
+		m.Add(method+".calls", 1)
+		if err != nil {
+			m.Add(method+".errors", 1)
+		}
+		m.AddFloat(method+".latency_ms_sum", latency.Seconds()*1000)
+		for _, bound := range latencyBuckets {
+			if ms <= bound {
+				m.Add(fmt.Sprintf("%s.latency_ms_le_%d", method, bound), 1)
+			}
+		}
+		m.Add(method+".latency_ms_le_inf", 1)
+	}
+}
+
+type instrumentedAPI struct {
+	api  API
+	hook Hook
+}
+
+// Instrument wraps an API so that the hook is called after each of its calls.
+func Instrument(api API, hook Hook) API {
+	return &instrumentedAPI{
+		api:  api,
+		hook: hook,
+	}
+}
+
+func (i *instrumentedAPI) GetProject() string {
+	return i.api.GetProject()
+}
+
+func (i *instrumentedAPI) GetZone() string {
+	return i.api.GetZone()
+}
+
+func (i *instrumentedAPI) GetRegion() string {
+	return i.api.GetRegion()
+}
+
+func (i *instrumentedAPI) UserAgent() string {
+	return i.api.UserAgent()
+}
+
+func (i *instrumentedAPI) ListInstances(ctx context.Context, filter string) ([]*compute.Instance, error) {
+	start := time.Now()
+	instances, err := i.api.ListInstances(ctx, filter)
+	i.hook("ListInstances", start, err)
+	return instances, err
+}
+
+func (i *instrumentedAPI) AggregatedListInstances(ctx context.Context, filter string) ([]*compute.Instance, error) {
+	start := time.Now()
+	instances, err := i.api.AggregatedListInstances(ctx, filter)
+	i.hook("AggregatedListInstances", start, err)
+	return instances, err
+}
+
+func (i *instrumentedAPI) GetInstance(ctx context.Context, name string) (*compute.Instance, error) {
+	start := time.Now()
+	instance, err := i.api.GetInstance(ctx, name)
+	i.hook("GetInstance", start, err)
+	return instance, err
+}
+
+func (i *instrumentedAPI) GetInstanceMaintenance(ctx context.Context, zone string, name string) (*InstanceMaintenance, error) {
+	start := time.Now()
+	maintenance, err := i.api.GetInstanceMaintenance(ctx, zone, name)
+	i.hook("GetInstanceMaintenance", start, err)
+	return maintenance, err
+}
+
+func (i *instrumentedAPI) BatchGetInstances(ctx context.Context, names []string) (map[string]*compute.Instance, map[string]error, error) {
+	start := time.Now()
+	instances, failures, err := i.api.BatchGetInstances(ctx, names)
+	i.hook("BatchGetInstances", start, err)
+	return instances, failures, err
+}
+
+func (i *instrumentedAPI) GetInstanceInZone(ctx context.Context, zone string, name string) (*compute.Instance, error) {
+	start := time.Now()
+	instance, err := i.api.GetInstanceInZone(ctx, zone, name)
+	i.hook("GetInstanceInZone", start, err)
+	return instance, err
+}
+
+func (i *instrumentedAPI) ListMaintenanceOperations(ctx context.Context, zone string, name string) ([]*compute.Operation, error) {
+	start := time.Now()
+	operations, err := i.api.ListMaintenanceOperations(ctx, zone, name)
+	i.hook("ListMaintenanceOperations", start, err)
+	return operations, err
+}
+
+func (i *instrumentedAPI) GetSerialPortOutput(ctx context.Context, name string, port int64) (string, error) {
+	start := time.Now()
+	output, err := i.api.GetSerialPortOutput(ctx, name, port)
+	i.hook("GetSerialPortOutput", start, err)
+	return output, err
+}
+
+func (i *instrumentedAPI) ValidateNetwork(ctx context.Context, network, subnetwork string) error {
+	start := time.Now()
+	err := i.api.ValidateNetwork(ctx, network, subnetwork)
+	i.hook("ValidateNetwork", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) CreateInstance(ctx context.Context, name string, settings *InstanceSettings) error {
+	start := time.Now()
+	err := i.api.CreateInstance(ctx, name, settings)
+	i.hook("CreateInstance", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) CreateInstances(ctx context.Context, instances map[string]*InstanceSettings) map[string]error {
+	start := time.Now()
+	failures := i.api.CreateInstances(ctx, instances)
+	i.hook("CreateInstances", start, anyFailure(failures))
+	return failures
+}
+
+func (i *instrumentedAPI) CreateInstanceInZone(ctx context.Context, zone string, name string, settings *InstanceSettings) error {
+	start := time.Now()
+	err := i.api.CreateInstanceInZone(ctx, zone, name, settings)
+	i.hook("CreateInstanceInZone", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) GetTargetPool(ctx context.Context, name string) (*compute.TargetPool, error) {
+	start := time.Now()
+	pool, err := i.api.GetTargetPool(ctx, name)
+	i.hook("GetTargetPool", start, err)
+	return pool, err
+}
+
+func (i *instrumentedAPI) AddInstanceToTargetPool(ctx context.Context, targetPool string, instances ...string) error {
+	start := time.Now()
+	err := i.api.AddInstanceToTargetPool(ctx, targetPool, instances...)
+	i.hook("AddInstanceToTargetPool", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) RemoveInstanceFromTargetPool(ctx context.Context, targetPool string, instances ...string) error {
+	start := time.Now()
+	err := i.api.RemoveInstanceFromTargetPool(ctx, targetPool, instances...)
+	i.hook("RemoveInstanceFromTargetPool", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) AddInstanceMetadata(ctx context.Context, instanceName string, items []*compute.MetadataItems) error {
+	start := time.Now()
+	err := i.api.AddInstanceMetadata(ctx, instanceName, items)
+	i.hook("AddInstanceMetadata", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) AddInstanceLabels(ctx context.Context, instanceName string, labels map[string]string) error {
+	start := time.Now()
+	err := i.api.AddInstanceLabels(ctx, instanceName, labels)
+	i.hook("AddInstanceLabels", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) SetInstanceTags(ctx context.Context, instanceName string, tags []string) error {
+	start := time.Now()
+	err := i.api.SetInstanceTags(ctx, instanceName, tags)
+	i.hook("SetInstanceTags", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) DeleteInstance(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.api.DeleteInstance(ctx, name)
+	i.hook("DeleteInstance", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) DeleteInstances(ctx context.Context, names []string) map[string]error {
+	start := time.Now()
+	failures := i.api.DeleteInstances(ctx, names)
+	i.hook("DeleteInstances", start, anyFailure(failures))
+	return failures
+}
+
+func (i *instrumentedAPI) DeleteInstanceInZone(ctx context.Context, zone string, name string) error {
+	start := time.Now()
+	err := i.api.DeleteInstanceInZone(ctx, zone, name)
+	i.hook("DeleteInstanceInZone", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) GetDeletionProtection(ctx context.Context, zone string, name string) (bool, error) {
+	start := time.Now()
+	protected, err := i.api.GetDeletionProtection(ctx, zone, name)
+	i.hook("GetDeletionProtection", start, err)
+	return protected, err
+}
+
+func (i *instrumentedAPI) SetDeletionProtection(ctx context.Context, zone string, name string, protected bool) error {
+	start := time.Now()
+	err := i.api.SetDeletionProtection(ctx, zone, name, protected)
+	i.hook("SetDeletionProtection", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) CreateDisk(ctx context.Context, name string, settings *PersistentDiskSettings) error {
+	start := time.Now()
+	err := i.api.CreateDisk(ctx, name, settings)
+	i.hook("CreateDisk", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) DeleteDisk(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.api.DeleteDisk(ctx, name)
+	i.hook("DeleteDisk", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) AttachDisk(ctx context.Context, instanceName string, diskName string, deviceName string) error {
+	start := time.Now()
+	err := i.api.AttachDisk(ctx, instanceName, diskName, deviceName)
+	i.hook("AttachDisk", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) DetachDisk(ctx context.Context, instanceName string, deviceName string) error {
+	start := time.Now()
+	err := i.api.DetachDisk(ctx, instanceName, deviceName)
+	i.hook("DetachDisk", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) CreateSnapshot(ctx context.Context, diskName string, snapshotName string) error {
+	start := time.Now()
+	err := i.api.CreateSnapshot(ctx, diskName, snapshotName)
+	i.hook("CreateSnapshot", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) DeleteSnapshot(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.api.DeleteSnapshot(ctx, name)
+	i.hook("DeleteSnapshot", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) ListSnapshots(ctx context.Context, filter string) ([]*compute.Snapshot, error) {
+	start := time.Now()
+	snapshots, err := i.api.ListSnapshots(ctx, filter)
+	i.hook("ListSnapshots", start, err)
+	return snapshots, err
+}
+
+func (i *instrumentedAPI) DeleteInstanceGroupManager(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.api.DeleteInstanceGroupManager(ctx, name)
+	i.hook("DeleteInstanceGroupManager", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) DeleteInstanceTemplate(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.api.DeleteInstanceTemplate(ctx, name)
+	i.hook("DeleteInstanceTemplate", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) ListInstanceGroupInstances(ctx context.Context, name string) ([]*compute.InstanceWithNamedPorts, error) {
+	start := time.Now()
+	instances, err := i.api.ListInstanceGroupInstances(ctx, name)
+	i.hook("ListInstanceGroupInstances", start, err)
+	return instances, err
+}
+
+func (i *instrumentedAPI) ListManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error) {
+	start := time.Now()
+	instances, err := i.api.ListManagedInstances(ctx, name)
+	i.hook("ListManagedInstances", start, err)
+	return instances, err
+}
+
+func (i *instrumentedAPI) CreateInstanceTemplate(ctx context.Context, name string, settings *InstanceSettings) error {
+	start := time.Now()
+	err := i.api.CreateInstanceTemplate(ctx, name, settings)
+	i.hook("CreateInstanceTemplate", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) ListInstanceTemplates(ctx context.Context, filter string) ([]*compute.InstanceTemplate, error) {
+	start := time.Now()
+	templates, err := i.api.ListInstanceTemplates(ctx, filter)
+	i.hook("ListInstanceTemplates", start, err)
+	return templates, err
+}
+
+func (i *instrumentedAPI) GetInstanceTemplate(ctx context.Context, name string) (*compute.InstanceTemplate, error) {
+	start := time.Now()
+	template, err := i.api.GetInstanceTemplate(ctx, name)
+	i.hook("GetInstanceTemplate", start, err)
+	return template, err
+}
+
+func (i *instrumentedAPI) CreateInstanceGroupManager(ctx context.Context, name string, settings *InstanceManagerSettings) error {
+	start := time.Now()
+	err := i.api.CreateInstanceGroupManager(ctx, name, settings)
+	i.hook("CreateInstanceGroupManager", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) GetInstanceGroupManager(ctx context.Context, name string) (*compute.InstanceGroupManager, error) {
+	start := time.Now()
+	manager, err := i.api.GetInstanceGroupManager(ctx, name)
+	i.hook("GetInstanceGroupManager", start, err)
+	return manager, err
+}
+
+func (i *instrumentedAPI) ListInstanceGroupManagers(ctx context.Context) ([]*compute.InstanceGroupManager, error) {
+	start := time.Now()
+	managers, err := i.api.ListInstanceGroupManagers(ctx)
+	i.hook("ListInstanceGroupManagers", start, err)
+	return managers, err
+}
+
+func (i *instrumentedAPI) SetNamedPorts(ctx context.Context, name string, ports []NamedPort) error {
+	start := time.Now()
+	err := i.api.SetNamedPorts(ctx, name, ports)
+	i.hook("SetNamedPorts", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) SetTargetPools(ctx context.Context, name string, targetPools []string) error {
+	start := time.Now()
+	err := i.api.SetTargetPools(ctx, name, targetPools)
+	i.hook("SetTargetPools", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) SetInstanceTemplate(ctx context.Context, name string, templateName string) error {
+	start := time.Now()
+	err := i.api.SetInstanceTemplate(ctx, name, templateName)
+	i.hook("SetInstanceTemplate", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) RecreateInstances(ctx context.Context, name string, instances ...string) error {
+	start := time.Now()
+	err := i.api.RecreateInstances(ctx, name, instances...)
+	i.hook("RecreateInstances", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) StartRollingUpdate(ctx context.Context, name string, templateName string, maxSurge, maxUnavailable int) error {
+	start := time.Now()
+	err := i.api.StartRollingUpdate(ctx, name, templateName, maxSurge, maxUnavailable)
+	i.hook("StartRollingUpdate", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) ResizeInstanceGroupManager(ctx context.Context, name string, targetSize int64) error {
+	start := time.Now()
+	err := i.api.ResizeInstanceGroupManager(ctx, name, targetSize)
+	i.hook("ResizeInstanceGroupManager", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) CreateSpreadPlacementPolicy(ctx context.Context, name string, availabilityDomains int64) error {
+	start := time.Now()
+	err := i.api.CreateSpreadPlacementPolicy(ctx, name, availabilityDomains)
+	i.hook("CreateSpreadPlacementPolicy", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) GetSpreadPlacementPolicy(ctx context.Context, name string) (int64, error) {
+	start := time.Now()
+	availabilityDomains, err := i.api.GetSpreadPlacementPolicy(ctx, name)
+	i.hook("GetSpreadPlacementPolicy", start, err)
+	return availabilityDomains, err
+}
+
+func (i *instrumentedAPI) DeleteResourcePolicy(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.api.DeleteResourcePolicy(ctx, name)
+	i.hook("DeleteResourcePolicy", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) AbandonInstances(ctx context.Context, name string, instances ...string) error {
+	start := time.Now()
+	err := i.api.AbandonInstances(ctx, name, instances...)
+	i.hook("AbandonInstances", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) DeleteGroupInstances(ctx context.Context, name string, instances ...string) error {
+	start := time.Now()
+	err := i.api.DeleteGroupInstances(ctx, name, instances...)
+	i.hook("DeleteGroupInstances", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) CreateAutoscaler(ctx context.Context, name string, settings *AutoscalerSettings) error {
+	start := time.Now()
+	err := i.api.CreateAutoscaler(ctx, name, settings)
+	i.hook("CreateAutoscaler", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) GetAutoscaler(ctx context.Context, name string) (*compute.Autoscaler, error) {
+	start := time.Now()
+	autoscaler, err := i.api.GetAutoscaler(ctx, name)
+	i.hook("GetAutoscaler", start, err)
+	return autoscaler, err
+}
+
+func (i *instrumentedAPI) UpdateAutoscaler(ctx context.Context, name string, settings *AutoscalerSettings) error {
+	start := time.Now()
+	err := i.api.UpdateAutoscaler(ctx, name, settings)
+	i.hook("UpdateAutoscaler", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) DeleteAutoscaler(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.api.DeleteAutoscaler(ctx, name)
+	i.hook("DeleteAutoscaler", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) CreateHealthCheck(ctx context.Context, name string, settings *HealthCheckSettings) error {
+	start := time.Now()
+	err := i.api.CreateHealthCheck(ctx, name, settings)
+	i.hook("CreateHealthCheck", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) UpdateHealthCheck(ctx context.Context, name string, settings *HealthCheckSettings) error {
+	start := time.Now()
+	err := i.api.UpdateHealthCheck(ctx, name, settings)
+	i.hook("UpdateHealthCheck", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) DeleteHealthCheck(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.api.DeleteHealthCheck(ctx, name)
+	i.hook("DeleteHealthCheck", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) SetAutoHealingPolicy(ctx context.Context, name string, healthCheck string, initialDelaySec int64) error {
+	start := time.Now()
+	err := i.api.SetAutoHealingPolicy(ctx, name, healthCheck, initialDelaySec)
+	i.hook("SetAutoHealingPolicy", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) CreateRegionalInstanceGroupManager(ctx context.Context, name string, settings *InstanceManagerSettings) error {
+	start := time.Now()
+	err := i.api.CreateRegionalInstanceGroupManager(ctx, name, settings)
+	i.hook("CreateRegionalInstanceGroupManager", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) GetRegionalInstanceGroupManager(ctx context.Context, name string) (*compute.InstanceGroupManager, error) {
+	start := time.Now()
+	manager, err := i.api.GetRegionalInstanceGroupManager(ctx, name)
+	i.hook("GetRegionalInstanceGroupManager", start, err)
+	return manager, err
+}
+
+func (i *instrumentedAPI) ListRegionalInstanceGroupInstances(ctx context.Context, name string) ([]*compute.InstanceWithNamedPorts, error) {
+	start := time.Now()
+	instances, err := i.api.ListRegionalInstanceGroupInstances(ctx, name)
+	i.hook("ListRegionalInstanceGroupInstances", start, err)
+	return instances, err
+}
+
+func (i *instrumentedAPI) ListRegionalManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error) {
+	start := time.Now()
+	instances, err := i.api.ListRegionalManagedInstances(ctx, name)
+	i.hook("ListRegionalManagedInstances", start, err)
+	return instances, err
+}
+
+func (i *instrumentedAPI) SetRegionalNamedPorts(ctx context.Context, name string, ports []NamedPort) error {
+	start := time.Now()
+	err := i.api.SetRegionalNamedPorts(ctx, name, ports)
+	i.hook("SetRegionalNamedPorts", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) SetRegionalTargetPools(ctx context.Context, name string, targetPools []string) error {
+	start := time.Now()
+	err := i.api.SetRegionalTargetPools(ctx, name, targetPools)
+	i.hook("SetRegionalTargetPools", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) SetRegionalInstanceTemplate(ctx context.Context, name string, templateName string) error {
+	start := time.Now()
+	err := i.api.SetRegionalInstanceTemplate(ctx, name, templateName)
+	i.hook("SetRegionalInstanceTemplate", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) RecreateRegionalInstances(ctx context.Context, name string, instances ...string) error {
+	start := time.Now()
+	err := i.api.RecreateRegionalInstances(ctx, name, instances...)
+	i.hook("RecreateRegionalInstances", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) ResizeRegionalInstanceGroupManager(ctx context.Context, name string, targetSize int64) error {
+	start := time.Now()
+	err := i.api.ResizeRegionalInstanceGroupManager(ctx, name, targetSize)
+	i.hook("ResizeRegionalInstanceGroupManager", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) DeleteRegionalInstanceGroupManager(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.api.DeleteRegionalInstanceGroupManager(ctx, name)
+	i.hook("DeleteRegionalInstanceGroupManager", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) AddInstanceGroupToBackendService(ctx context.Context, backendService string, instanceGroup string, drainingTimeoutSec int64) error {
+	start := time.Now()
+	err := i.api.AddInstanceGroupToBackendService(ctx, backendService, instanceGroup, drainingTimeoutSec)
+	i.hook("AddInstanceGroupToBackendService", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) RemoveInstanceGroupFromBackendService(ctx context.Context, backendService string, instanceGroup string) error {
+	start := time.Now()
+	err := i.api.RemoveInstanceGroupFromBackendService(ctx, backendService, instanceGroup)
+	i.hook("RemoveInstanceGroupFromBackendService", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) ReserveAddress(ctx context.Context, name string, settings *AddressSettings) error {
+	start := time.Now()
+	err := i.api.ReserveAddress(ctx, name, settings)
+	i.hook("ReserveAddress", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) GetAddress(ctx context.Context, name string) (*Address, error) {
+	start := time.Now()
+	address, err := i.api.GetAddress(ctx, name)
+	i.hook("GetAddress", start, err)
+	return address, err
+}
+
+func (i *instrumentedAPI) ReleaseAddress(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.api.ReleaseAddress(ctx, name)
+	i.hook("ReleaseAddress", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) CreateFirewallRule(ctx context.Context, name string, settings *FirewallSettings) error {
+	start := time.Now()
+	err := i.api.CreateFirewallRule(ctx, name, settings)
+	i.hook("CreateFirewallRule", start, err)
+	return err
+}
+
+func (i *instrumentedAPI) DeleteFirewallRule(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.api.DeleteFirewallRule(ctx, name)
+	i.hook("DeleteFirewallRule", start, err)
+	return err
+}
+
+// anyFailure is one of the errors of a call that mutates many instances, if
+// any, so that the hook counts the call as failed.
+func anyFailure(failures map[string]error) error {
+	for _, err := range failures {
+		return err
+	}
+	return nil
+}
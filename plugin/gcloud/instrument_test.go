@@ -0,0 +1,30 @@
+package gcloud
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpvarHook(t *testing.T) {
+	m := new(expvar.Map).Init()
+	hook := ExpvarHook(m)
+
+	hook("GetInstance", time.Now(), nil)
+	hook("GetInstance", time.Now().Add(-time.Second), errors.New("BUG"))
+	hook("DeleteInstance", time.Now().Add(-time.Hour), nil)
+
+	require.Equal(t, "2", m.Get("GetInstance.calls").String())
+	require.Equal(t, "1", m.Get("GetInstance.errors").String())
+	require.Equal(t, "1", m.Get("GetInstance.latency_ms_le_10").String())
+	require.Equal(t, "2", m.Get("GetInstance.latency_ms_le_5000").String())
+	require.Equal(t, "2", m.Get("GetInstance.latency_ms_le_inf").String())
+
+	require.Equal(t, "1", m.Get("DeleteInstance.calls").String())
+	require.Nil(t, m.Get("DeleteInstance.errors"))
+	require.Nil(t, m.Get("DeleteInstance.latency_ms_le_120000"))
+	require.Equal(t, "1", m.Get("DeleteInstance.latency_ms_le_inf").String())
+}
@@ -0,0 +1,100 @@
+package gcloud
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenBucket lets calls go through at a steady rate. Calls block, instead of
+// failing, until a token is available.
+type tokenBucket struct {
+	lock     sync.Mutex
+	interval time.Duration
+	next     time.Time
+
+	// now and after are the clock of the bucket, replaced in tests.
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+}
+
+func newTokenBucket(perSecond float64) *tokenBucket {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return &tokenBucket{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		now:      time.Now,
+		after:    time.After,
+	}
+}
+
+// wait blocks until a call can go through or the request is canceled.
+func (b *tokenBucket) wait(req *http.Request) error {
+	if b == nil {
+		return nil
+	}
+
+	b.lock.Lock()
+	now := b.now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	delay := b.next.Sub(now)
+	b.next = b.next.Add(b.interval)
+	b.lock.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-b.after(delay):
+		return nil
+	}
+}
+
+// rateLimitedTransport throttles reads and mutations with separate buckets, so
+// that polling doesn't starve the calls that change resources.
+type rateLimitedTransport struct {
+	transport http.RoundTripper
+	reads     *tokenBucket
+	mutations *tokenBucket
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	bucket := t.mutations
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		bucket = t.reads
+	}
+
+	if err := bucket.wait(req); err != nil {
+		return nil, err
+	}
+
+	return t.transport.RoundTrip(req)
+}
+
+// rateLimited wraps a client to apply the configured rate limits, if any.
+func (g *computeServiceWrapper) rateLimited(client *http.Client) *http.Client {
+	if g.readsPerSecond <= 0 && g.mutationsPerSecond <= 0 {
+		return client
+	}
+
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	limited := *client
+	limited.Transport = &rateLimitedTransport{
+		transport: transport,
+		reads:     newTokenBucket(g.readsPerSecond),
+		mutations: newTokenBucket(g.mutationsPerSecond),
+	}
+
+	return &limited
+}
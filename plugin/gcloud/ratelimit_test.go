@@ -0,0 +1,101 @@
+package gcloud
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/infrakit.gcp/plugin/internal/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+)
+
+// fakeClock stands still and records the delays the buckets wait for.
+type fakeClock struct {
+	now    time.Time
+	delays []time.Duration
+}
+
+func (c *fakeClock) install(b *tokenBucket) *tokenBucket {
+	b.now = func() time.Time { return c.now }
+	b.after = func(delay time.Duration) <-chan time.Time {
+		c.delays = append(c.delays, delay)
+
+		ready := make(chan time.Time, 1)
+		ready <- c.now.Add(delay)
+		return ready
+	}
+
+	return b
+}
+
+func TestTokenBucketSpacesCalls(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	bucket := clock.install(newTokenBucket(50))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, bucket.wait(req))
+	}
+
+	require.Equal(t, []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 60 * time.Millisecond, 80 * time.Millisecond}, clock.delays)
+
+	// The tokens that weren't used in the meantime are lost.
+	clock.delays = nil
+	clock.now = clock.now.Add(time.Second)
+	require.NoError(t, bucket.wait(req))
+	require.NoError(t, bucket.wait(req))
+
+	require.Equal(t, []time.Duration{20 * time.Millisecond}, clock.delays)
+}
+
+func TestRateLimitedReadsAndMutations(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Instance{Name: "vm"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/vm/setTags", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	api, err := NewAPI("PROJECT", "us-central1-f",
+		WithEndpoint(server.URL),
+		WithHTTPClient(server.Client()),
+		WithRateLimits(10, 1))
+	require.NoError(t, err)
+
+	transport := api.(*computeServiceWrapper).client.Transport.(*rateLimitedTransport)
+	reads, mutations := &fakeClock{now: time.Now()}, &fakeClock{now: time.Now()}
+	reads.install(transport.reads)
+	mutations.install(transport.mutations)
+
+	// Each update reads the instance, then sets its tags.
+	require.NoError(t, api.SetInstanceTags(context.Background(), "vm", []string{"web"}))
+	require.NoError(t, api.SetInstanceTags(context.Background(), "vm", []string{"db"}))
+
+	require.Equal(t, []time.Duration{100 * time.Millisecond}, reads.delays)
+	require.Equal(t, []time.Duration{time.Second}, mutations.delays)
+}
+
+func TestRateLimitedCallCanceled(t *testing.T) {
+	bucket := newTokenBucket(1)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	require.NoError(t, bucket.wait(req))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.Equal(t, context.Canceled, bucket.wait(req.WithContext(ctx)))
+}
+
+func TestNoRateLimit(t *testing.T) {
+	bucket := newTokenBucket(0)
+
+	require.Nil(t, bucket)
+	require.NoError(t, bucket.wait(httptest.NewRequest("GET", "/", nil)))
+}
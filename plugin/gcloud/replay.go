@@ -0,0 +1,259 @@
+package gcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// scrubbedProject replaces the project in the recorded exchanges.
+	scrubbedProject = "PROJECT"
+
+	// recordedEndpoint replaces the endpoint in the urls of the recorded
+	// exchanges, so that fixtures recorded against an emulator can be replayed.
+	recordedEndpoint = "https://www.googleapis.com/compute/v1/projects/"
+)
+
+// serviceAccount matches the emails of service accounts, which carry the
+// project id or number.
+var serviceAccount = regexp.MustCompile(`[a-zA-Z0-9._-]+@[a-zA-Z0-9.-]*gserviceaccount\.com`)
+
+// Exchange is a request to the Compute API and its response, as recorded in a
+// fixture. Headers aren't recorded, so credentials never make it to a fixture.
+type Exchange struct {
+	Method       string
+	Path         string
+	RequestBody  json.RawMessage `json:",omitempty"`
+	Status       int
+	ResponseBody json.RawMessage `json:",omitempty"`
+}
+
+// Recorder captures the exchanges of an API with a real project, scrubbed of
+// the project id and of the service accounts. Use it with WithRecorder.
+type Recorder struct {
+	lock      sync.Mutex
+	project   string
+	transport http.RoundTripper
+	exchanges []Exchange
+}
+
+// NewRecorder creates a Recorder for the exchanges with a project.
+func NewRecorder(project string) *Recorder {
+	return &Recorder{project: project}
+}
+
+// RoundTrip sends a request and records it with its response.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		requestBody = body
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.exchanges = append(r.exchanges, Exchange{
+		Method:       req.Method,
+		Path:         relativePath(req, r.project),
+		RequestBody:  scrub(requestBody, req, r.project),
+		Status:       resp.StatusCode,
+		ResponseBody: scrub(responseBody, req, r.project),
+	})
+
+	return resp, nil
+}
+
+// wrap records the exchanges of a client.
+func (r *Recorder) wrap(client *http.Client) *http.Client {
+	r.transport = client.Transport
+	if r.transport == nil {
+		r.transport = http.DefaultTransport
+	}
+
+	recorded := *client
+	recorded.Transport = r
+	return &recorded
+}
+
+// Exchanges returns the exchanges recorded so far.
+func (r *Recorder) Exchanges() []Exchange {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]Exchange(nil), r.exchanges...)
+}
+
+// Save writes the exchanges recorded so far to a fixture file.
+func (r *Recorder) Save(path string) error {
+	data, err := json.MarshalIndent(r.Exchanges(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Replayer answers the requests of an API with recorded exchanges, in order,
+// instead of calling GCE. Each request must match the next exchange, body
+// included. Use it with WithReplayer.
+type Replayer struct {
+	lock      sync.Mutex
+	project   string
+	exchanges []Exchange
+	next      int
+}
+
+// NewReplayer creates a Replayer that answers the requests for a project.
+func NewReplayer(project string, exchanges []Exchange) *Replayer {
+	return &Replayer{project: project, exchanges: exchanges}
+}
+
+// LoadReplayer creates a Replayer from a fixture file.
+func LoadReplayer(project, path string) (*Replayer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	exchanges := []Exchange{}
+	if err := json.Unmarshal(data, &exchanges); err != nil {
+		return nil, fmt.Errorf("Invalid fixture %s: %v", path, err)
+	}
+
+	return NewReplayer(project, exchanges), nil
+}
+
+// RoundTrip answers a request with the next recorded exchange.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		requestBody = body
+	}
+	path := relativePath(req, r.project)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.next >= len(r.exchanges) {
+		return nil, fmt.Errorf("Unexpected request %s %s: no exchange left", req.Method, path)
+	}
+	exchange := r.exchanges[r.next]
+
+	if req.Method != exchange.Method || path != exchange.Path {
+		return nil, fmt.Errorf("Unexpected request %s %s, expected %s %s", req.Method, path, exchange.Method, exchange.Path)
+	}
+	if scrubbed := scrub(requestBody, req, r.project); !sameJSON(scrubbed, exchange.RequestBody) {
+		return nil, fmt.Errorf("Unexpected body for %s %s: %s, expected %s", req.Method, path, scrubbed, exchange.RequestBody)
+	}
+	r.next++
+
+	body := strings.Replace(string(exchange.ResponseBody), recordedEndpoint, endpoint(req, r.project), -1)
+	body = strings.Replace(body, scrubbedProject, r.project, -1)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// Remaining returns how many recorded exchanges weren't replayed.
+func (r *Replayer) Remaining() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return len(r.exchanges) - r.next
+}
+
+// relativePath is the path of a request from the project on, so that the
+// exchanges don't depend on the endpoint. The query follows, in the order of
+// its keys, so that filters and page tokens are matched too. The format of the
+// response is left out since every call asks for json.
+func relativePath(req *http.Request, project string) string {
+	path := strings.Replace(req.URL.Path, "/"+project+"/", "/"+scrubbedProject+"/", 1)
+	if i := strings.Index(path, "/"+scrubbedProject+"/"); i >= 0 {
+		path = path[i+1:]
+	} else {
+		path = strings.TrimPrefix(path, "/")
+	}
+
+	query := req.URL.Query()
+	query.Del("alt")
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + strings.Replace(query.Encode(), url.QueryEscape(project), scrubbedProject, -1)
+}
+
+// endpoint is the url of a request up to the project.
+func endpoint(req *http.Request, project string) string {
+	address := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	if i := strings.Index(address, "/"+project+"/"); i >= 0 {
+		return address[:i+1]
+	}
+	return recordedEndpoint
+}
+
+// scrub replaces the endpoint, the project and the service accounts of the
+// body of an exchange.
+func scrub(body []byte, req *http.Request, project string) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+
+	scrubbed := strings.Replace(strings.TrimSpace(string(body)), endpoint(req, project), recordedEndpoint, -1)
+	scrubbed = strings.Replace(scrubbed, project, scrubbedProject, -1)
+	scrubbed = serviceAccount.ReplaceAllString(scrubbed, "service-account@"+scrubbedProject+".iam.gserviceaccount.com")
+
+	return json.RawMessage(scrubbed)
+}
+
+// sameJSON compares two json documents, whatever their formatting.
+func sameJSON(actual, expected json.RawMessage) bool {
+	if len(actual) == 0 || len(expected) == 0 {
+		return len(actual) == len(expected)
+	}
+
+	var a, e interface{}
+	if json.Unmarshal(actual, &a) != nil || json.Unmarshal(expected, &e) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(a, e)
+}
@@ -0,0 +1,130 @@
+package gcloud
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/infrakit.gcp/plugin/internal/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestRecordScrubsProject(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/my-project-123/zones/us-central1-f/instances", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Operation{
+			Name:       "op",
+			Status:     "DONE",
+			TargetLink: "https://www.googleapis.com/compute/v1/projects/my-project-123/zones/us-central1-f/instances/vm",
+			User:       "123456-compute@developer.gserviceaccount.com",
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	recorder := NewRecorder("my-project-123")
+	api, err := NewAPI("my-project-123", "us-central1-f",
+		WithEndpoint(server.URL),
+		WithHTTPClient(server.Client()),
+		WithRecorder(recorder))
+	require.NoError(t, err)
+
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{MachineType: "n1-standard-1"})
+
+	require.NoError(t, err)
+	exchanges := recorder.Exchanges()
+	require.Len(t, exchanges, 1)
+	require.Equal(t, "POST", exchanges[0].Method)
+	require.Equal(t, "PROJECT/zones/us-central1-f/instances", exchanges[0].Path)
+	require.Equal(t, http.StatusOK, exchanges[0].Status)
+	require.Contains(t, string(exchanges[0].RequestBody), "https://www.googleapis.com/compute/v1/projects/PROJECT/zones/us-central1-f/machineTypes/n1-standard-1")
+	require.NotContains(t, string(exchanges[0].RequestBody), "my-project-123")
+	require.NotContains(t, string(exchanges[0].ResponseBody), "my-project-123")
+	require.NotContains(t, string(exchanges[0].ResponseBody), "123456-compute")
+
+	dir, err := ioutil.TempDir("", "fixtures")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "create_instance.json")
+	require.NoError(t, recorder.Save(path))
+
+	replayer, err := LoadReplayer("other-project", path)
+	require.NoError(t, err)
+	api, err = NewAPI("other-project", "us-central1-f", WithReplayer(replayer))
+	require.NoError(t, err)
+
+	err = api.CreateInstance(context.Background(), "vm", &InstanceSettings{MachineType: "n1-standard-1"})
+
+	require.NoError(t, err)
+	require.Equal(t, 0, replayer.Remaining())
+}
+
+func TestReplay(t *testing.T) {
+	operation, _ := json.Marshal(&compute.Operation{Name: "op", Status: "DONE"})
+	notFound := json.RawMessage(`{"error":{"code":404,"message":"The resource 'projects/PROJECT/zones/us-central1-f/instances/vm' was not found"}}`)
+
+	replayer := NewReplayer("PROJECT", []Exchange{
+		{Method: "POST", Path: "PROJECT/zones/us-central1-f/disks", RequestBody: json.RawMessage(`{"name":"data","sizeGb":"10"}`), Status: 200, ResponseBody: operation},
+		{Method: "DELETE", Path: "PROJECT/zones/us-central1-f/instances/vm", Status: 404, ResponseBody: notFound},
+	})
+	api, err := NewAPI("PROJECT", "us-central1-f", WithReplayer(replayer))
+	require.NoError(t, err)
+
+	err = api.CreateDisk(context.Background(), "data", &PersistentDiskSettings{SizeGb: 10})
+
+	require.NoError(t, err)
+	require.Equal(t, 1, replayer.Remaining())
+
+	err = api.DeleteInstance(context.Background(), "vm")
+
+	require.EqualError(t, err, "googleapi: Error 404: The resource 'projects/PROJECT/zones/us-central1-f/instances/vm' was not found")
+	require.Equal(t, 0, replayer.Remaining())
+
+	err = api.DeleteInstance(context.Background(), "vm")
+
+	require.Contains(t, err.Error(), "Unexpected request DELETE PROJECT/zones/us-central1-f/instances/vm: no exchange left")
+}
+
+func TestReplayUnexpectedRequest(t *testing.T) {
+	replayer := NewReplayer("PROJECT", []Exchange{
+		{Method: "POST", Path: "PROJECT/zones/us-central1-f/disks", RequestBody: json.RawMessage(`{"name":"data","sizeGb":"10"}`), Status: 200},
+	})
+	api, err := NewAPI("PROJECT", "us-central1-f", WithReplayer(replayer))
+	require.NoError(t, err)
+
+	err = api.DeleteInstance(context.Background(), "vm")
+
+	require.Contains(t, err.Error(), "Unexpected request DELETE PROJECT/zones/us-central1-f/instances/vm, expected POST PROJECT/zones/us-central1-f/disks")
+
+	err = api.CreateDisk(context.Background(), "data", &PersistentDiskSettings{SizeGb: 20})
+
+	require.Contains(t, err.Error(), `Unexpected body for POST PROJECT/zones/us-central1-f/disks: {"name":"data","sizeGb":"20"}, expected {"name":"data","sizeGb":"10"}`)
+	require.Equal(t, 1, replayer.Remaining())
+}
+
+func TestReplayMatchesQuery(t *testing.T) {
+	list, _ := json.Marshal(&compute.InstanceList{Items: []*compute.Instance{{Name: "vm"}}})
+
+	replayer := NewReplayer("PROJECT", []Exchange{
+		{Method: "GET", Path: "PROJECT/zones/us-central1-f/instances?filter=name+eq+vm&pageToken=", Status: 200, ResponseBody: list},
+	})
+	api, err := NewAPI("PROJECT", "us-central1-f", WithReplayer(replayer))
+	require.NoError(t, err)
+
+	_, err = api.ListInstances(context.Background(), "name eq other")
+
+	require.Contains(t, err.Error(), "Unexpected request GET PROJECT/zones/us-central1-f/instances?filter=name+eq+other&pageToken=, expected GET PROJECT/zones/us-central1-f/instances?filter=name+eq+vm&pageToken=")
+
+	instances, err := api.ListInstances(context.Background(), "name eq vm")
+
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	require.Equal(t, 0, replayer.Remaining())
+}
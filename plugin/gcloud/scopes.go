@@ -0,0 +1,107 @@
+package gcloud
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// scopePrefix starts the URLs of the OAuth scopes of Google APIs.
+const scopePrefix = "https://www.googleapis.com/auth/"
+
+// scopeAliases are the aliases of the gcloud CLI, and the scopes they stand
+// for, without the scopePrefix.
+var scopeAliases = map[string][]string{
+	"bigquery":              {"bigquery"},
+	"cloud-platform":        {"cloud-platform"},
+	"cloud-source-repos":    {"source.full_control"},
+	"cloud-source-repos-ro": {"source.read_only"},
+	"compute-ro":            {"compute.readonly"},
+	"compute-rw":            {"compute"},
+	"datastore":             {"datastore"},
+	"default":               {"devstorage.read_only", "logging.write", "monitoring.write", "pubsub", "service.management.readonly", "servicecontrol", "trace.append"},
+	"gke-default":           {"devstorage.read_only", "logging.write", "monitoring", "service.management.readonly", "servicecontrol", "trace.append"},
+	"logging-write":         {"logging.write"},
+	"monitoring":            {"monitoring"},
+	"monitoring-read":       {"monitoring.read"},
+	"monitoring-write":      {"monitoring.write"},
+	"pubsub":                {"pubsub"},
+	"service-control":       {"servicecontrol"},
+	"service-management":    {"service.management.readonly"},
+	"sql-admin":             {"sqlservice.admin"},
+	"storage-full":          {"devstorage.full_control"},
+	"storage-ro":            {"devstorage.read_only"},
+	"storage-rw":            {"devstorage.read_write"},
+	"taskqueue":             {"taskqueue"},
+	"trace":                 {"trace.append"},
+	"userinfo-email":        {"userinfo.email"},
+}
+
+// ExpandScopes replaces the scope aliases of the gcloud CLI, for example
+// storage-ro, with the URLs of their scopes. URLs are kept as is, and each
+// scope is only listed once.
+func ExpandScopes(scopes []string) []string {
+	if scopes == nil {
+		return nil
+	}
+
+	expanded := []string{}
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		urls := []string{scope}
+		if aliased, present := scopeAliases[scope]; present {
+			urls = []string{}
+			for _, name := range aliased {
+				urls = append(urls, scopePrefix+name)
+			}
+		}
+
+		for _, url := range urls {
+			if !seen[url] {
+				seen[url] = true
+				expanded = append(expanded, url)
+			}
+		}
+	}
+
+	return expanded
+}
+
+// ValidateScopes checks that scopes are either URLs or aliases of the gcloud
+// CLI.
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if strings.Contains(scope, "://") {
+			continue
+		}
+		if _, present := scopeAliases[scope]; !present {
+			return fmt.Errorf("Unknown scope %q: should be a %s URL or one of %s", scope, scopePrefix, strings.Join(aliasesLike(scope), ", "))
+		}
+	}
+
+	return nil
+}
+
+// aliasesLike lists the aliases that share a word with a scope, or all of
+// them when none does.
+func aliasesLike(scope string) []string {
+	words := strings.FieldsFunc(scope, func(r rune) bool { return r == '-' || r == '.' || r == '_' })
+
+	aliases := []string{}
+	like := []string{}
+	for alias := range scopeAliases {
+		aliases = append(aliases, alias)
+		for _, word := range words {
+			if strings.Contains(alias, word) {
+				like = append(like, alias)
+				break
+			}
+		}
+	}
+	if len(like) == 0 {
+		like = aliases
+	}
+	sort.Strings(like)
+
+	return like
+}
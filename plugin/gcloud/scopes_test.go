@@ -0,0 +1,44 @@
+package gcloud
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandScopes(t *testing.T) {
+	require.Nil(t, ExpandScopes(nil))
+
+	scopes := ExpandScopes([]string{
+		"storage-ro",
+		"https://www.googleapis.com/auth/logging.write",
+		"logging-write",
+		"https://www.googleapis.com/auth/custom",
+		"cloud-platform",
+	})
+
+	require.Equal(t, []string{
+		"https://www.googleapis.com/auth/devstorage.read_only",
+		"https://www.googleapis.com/auth/logging.write",
+		"https://www.googleapis.com/auth/custom",
+		"https://www.googleapis.com/auth/cloud-platform",
+	}, scopes)
+}
+
+func TestExpandDefaultScopes(t *testing.T) {
+	scopes := ExpandScopes([]string{"default", "storage-ro"})
+
+	require.Len(t, scopes, 7)
+	require.Equal(t, "https://www.googleapis.com/auth/devstorage.read_only", scopes[0])
+	require.Equal(t, "https://www.googleapis.com/auth/trace.append", scopes[6])
+}
+
+func TestValidateScopes(t *testing.T) {
+	require.NoError(t, ValidateScopes(nil))
+	require.NoError(t, ValidateScopes([]string{"storage-rw", "https://www.googleapis.com/auth/compute"}))
+
+	require.EqualError(t, ValidateScopes([]string{"storage-write"}),
+		`Unknown scope "storage-write": should be a https://www.googleapis.com/auth/ URL or one of logging-write, monitoring-write, storage-full, storage-ro, storage-rw`)
+	require.EqualError(t, ValidateScopes([]string{"compute", "xyz"}),
+		`Unknown scope "compute": should be a https://www.googleapis.com/auth/ URL or one of compute-ro, compute-rw`)
+}
@@ -1,12 +1,83 @@
 package gcloud
 
 import (
+	"fmt"
+	"regexp"
 	"sort"
 	"strings"
 
 	"google.golang.org/api/compute/v1"
 )
 
+const (
+	// MatchTag is a reserved tag key that tells how the tags of a describe
+	// request are matched. By default, an instance must have all the tags.
+	MatchTag = "__match__"
+
+	// MatchAny, as the value of MatchTag, selects the instances that have at
+	// least one of the tags.
+	MatchAny = "or"
+
+	// MaxMetaDataValueSize is the maximum size of a metadata value, in bytes.
+	MaxMetaDataValueSize = 256 * 1024
+
+	// MaxMetaDataSize is the maximum size of the metadata of an instance, keys
+	// and values included, in bytes.
+	MaxMetaDataSize = 512 * 1024
+)
+
+// The label keys and values GCE accepts: at most 63 lowercase letters, digits,
+// underscores and dashes. Keys also start with a letter.
+var (
+	labelKeyPattern   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValuePattern = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+)
+
+// maxLabels is the number of labels that a resource can have.
+const maxLabels = 64
+
+// ValidateLabels checks that labels follow the rules of GCE: at most 64
+// labels, keys starting with a lowercase letter, and keys and values of at
+// most 63 lowercase letters, digits, underscores or dashes.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return fmt.Errorf("Too many labels: %d, at most %d are allowed", len(labels), maxLabels)
+	}
+
+	for key, value := range labels {
+		if !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("Invalid label key %q: should start with a lowercase letter and have at most 63 lowercase letters, digits, _ or -", key)
+		}
+		if !labelValuePattern.MatchString(value) {
+			return fmt.Errorf("Invalid value %q for label %s: should have at most 63 lowercase letters, digits, _ or -", value, key)
+		}
+	}
+
+	return nil
+}
+
+// TagsToLabels converts the tags that make valid labels, once their key is
+// escaped like a metadata key, into labels. The other tags are left out.
+func TagsToLabels(tags map[string]string) map[string]string {
+	labels := map[string]string{}
+
+	for k, v := range tags {
+		key := escapeKey(k)
+		if labelKeyPattern.MatchString(key) && labelValuePattern.MatchString(v) {
+			labels[key] = v
+		}
+	}
+
+	return labels
+}
+
+// ReservedMetaDataKeys are the metadata keys that hold scripts and keys rather
+// than tags. Their values can be large, so describe calls leave them out.
+var ReservedMetaDataKeys = []string{"startup-script", "user-data", "userdata", "ssh-keys"}
+
+// metaDataKey is the syntax of a metadata key.
+var metaDataKey = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,128}$`)
+
 // TagsToMetaData converts a tag map into VM Metadata items.
 func TagsToMetaData(tags map[string]string) []*compute.MetadataItems {
 	items := []*compute.MetadataItems{}
@@ -24,6 +95,54 @@ func TagsToMetaData(tags map[string]string) []*compute.MetadataItems {
 	return items
 }
 
+// ValidateMetaData checks that metadata items are accepted by GCE: keys of at
+// most 128 letters, digits, _ or -, values of at most 256KB and 512KB in total.
+func ValidateMetaData(items []*compute.MetadataItems) error {
+	total := 0
+	tooLarge := []string{}
+	for _, item := range items {
+		if !metaDataKey.MatchString(item.Key) {
+			return fmt.Errorf("Invalid metadata key %q: should have 1 to 128 letters, digits, _ or -", item.Key)
+		}
+
+		size := 0
+		if item.Value != nil {
+			size = len(*item.Value)
+		}
+		if size > MaxMetaDataValueSize {
+			tooLarge = append(tooLarge, fmt.Sprintf("%s (%d bytes)", item.Key, size))
+		}
+		total += len(item.Key) + size
+	}
+
+	if len(tooLarge) > 0 {
+		return fmt.Errorf("Metadata values larger than %d bytes: %s", MaxMetaDataValueSize, strings.Join(tooLarge, ", "))
+	}
+	if total > MaxMetaDataSize {
+		return fmt.Errorf("Metadata of %d bytes, larger than %d bytes: %s", total, MaxMetaDataSize, strings.Join(metaDataSizes(items), ", "))
+	}
+
+	return nil
+}
+
+// metaDataSizes describes the size of metadata items, largest first.
+func metaDataSizes(items []*compute.MetadataItems) []string {
+	sorted := append([]*compute.MetadataItems(nil), items...)
+	size := func(item *compute.MetadataItems) int {
+		if item.Value == nil {
+			return len(item.Key)
+		}
+		return len(item.Key) + len(*item.Value)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return size(sorted[i]) > size(sorted[j]) })
+
+	sizes := []string{}
+	for _, item := range sorted {
+		sizes = append(sizes, fmt.Sprintf("%s (%d bytes)", item.Key, size(item)))
+	}
+	return sizes
+}
+
 // MetaDataToTags converts VM Metadata items into a tag map.
 func MetaDataToTags(metaData []*compute.MetadataItems) map[string]string {
 	tags := map[string]string{}
@@ -36,6 +155,30 @@ func MetaDataToTags(metaData []*compute.MetadataItems) map[string]string {
 	return tags
 }
 
+// MetaDataToTagsWithout converts VM Metadata items into a tag map, leaving out
+// the skipped keys.
+func MetaDataToTagsWithout(metaData []*compute.MetadataItems, skipped []string) map[string]string {
+	tags := MetaDataToTags(metaData)
+	for _, key := range skipped {
+		delete(tags, key)
+	}
+
+	return tags
+}
+
+// UnrequestedReservedKeys returns the reserved metadata keys that are not part
+// of the requested tags, so that callers can still match on the others.
+func UnrequestedReservedKeys(requested map[string]string) []string {
+	keys := []string{}
+	for _, key := range ReservedMetaDataKeys {
+		if _, present := requested[key]; !present {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
 // HasDifferentTag compares two sets of tags.
 func HasDifferentTag(expected, actual map[string]string) bool {
 	for k, v := range expected {
@@ -47,6 +190,22 @@ func HasDifferentTag(expected, actual map[string]string) bool {
 	return false
 }
 
+// HasNoMatchingTag returns true if none of the expected tags is found. An empty
+// set of expected tags matches any set of tags.
+func HasNoMatchingTag(expected, actual map[string]string) bool {
+	if len(expected) == 0 {
+		return false
+	}
+
+	for k, v := range expected {
+		if value, present := actual[k]; present && value == v {
+			return false
+		}
+	}
+
+	return true
+}
+
 func escapeKey(key string) string {
 	return strings.Replace(key, ".", "--", -1)
 }
@@ -1,6 +1,8 @@
 package gcloud
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -34,3 +36,112 @@ func TestConvertNil(t *testing.T) {
 
 	require.Empty(t, tagsFromMetata)
 }
+
+func TestConvertWithoutReservedKeys(t *testing.T) {
+	metaData := TagsToMetaData(map[string]string{
+		"startup-script": "echo 'Startup'",
+		"userdata":       "echo 'Startup'",
+		"ssh-keys":       "admin:ssh-rsa AAAA",
+		"infrakit.group": "workers",
+		"role":           "worker",
+	})
+
+	tags := MetaDataToTagsWithout(metaData, ReservedMetaDataKeys)
+	require.Equal(t, map[string]string{"infrakit.group": "workers", "role": "worker"}, tags)
+
+	skipped := UnrequestedReservedKeys(map[string]string{"role": "worker", "ssh-keys": "admin:ssh-rsa AAAA"})
+	require.Equal(t, []string{"startup-script", "user-data", "userdata"}, skipped)
+
+	tags = MetaDataToTagsWithout(metaData, skipped)
+	require.Equal(t, map[string]string{"infrakit.group": "workers", "role": "worker", "ssh-keys": "admin:ssh-rsa AAAA"}, tags)
+}
+
+func TestHasNoMatchingTag(t *testing.T) {
+	actual := map[string]string{"role": "worker", "env": "prod"}
+
+	require.False(t, HasNoMatchingTag(map[string]string{"role": "manager", "env": "prod"}, actual))
+	require.True(t, HasNoMatchingTag(map[string]string{"role": "manager", "env": "dev"}, actual))
+	require.True(t, HasNoMatchingTag(map[string]string{"zone": ""}, actual))
+	require.False(t, HasNoMatchingTag(map[string]string{}, actual))
+}
+
+func TestTagsToLabels(t *testing.T) {
+	labels := TagsToLabels(map[string]string{
+		"role":                "worker",
+		"infrakit.group":      "workers",
+		"infrakit-logical-id": "10.20.2.100",
+		"Env":                 "dev",
+		"empty":               "",
+	})
+
+	require.Equal(t, map[string]string{
+		"role":            "worker",
+		"infrakit--group": "workers",
+		"empty":           "",
+	}, labels)
+}
+
+func TestValidateLabels(t *testing.T) {
+	require.NoError(t, ValidateLabels(nil))
+	require.NoError(t, ValidateLabels(map[string]string{"team": "infra", "cost_center": "", "env": "prod-1"}))
+
+	require.EqualError(t, ValidateLabels(map[string]string{"Team": "infra"}), `Invalid label key "Team": should start with a lowercase letter and have at most 63 lowercase letters, digits, _ or -`)
+	require.EqualError(t, ValidateLabels(map[string]string{"1st": "infra"}), `Invalid label key "1st": should start with a lowercase letter and have at most 63 lowercase letters, digits, _ or -`)
+	require.EqualError(t, ValidateLabels(map[string]string{"team": "Infra"}), `Invalid value "Infra" for label team: should have at most 63 lowercase letters, digits, _ or -`)
+
+	tooMany := map[string]string{}
+	for i := 0; i < 65; i++ {
+		tooMany[fmt.Sprintf("label-%d", i)] = ""
+	}
+	require.EqualError(t, ValidateLabels(tooMany), "Too many labels: 65, at most 64 are allowed")
+}
+
+func TestValidateMetaDataValueSize(t *testing.T) {
+	require.NoError(t, ValidateMetaData(TagsToMetaData(map[string]string{
+		"startup-script": strings.Repeat("x", MaxMetaDataValueSize),
+	})))
+
+	err := ValidateMetaData(TagsToMetaData(map[string]string{
+		"startup-script": strings.Repeat("x", MaxMetaDataValueSize+1),
+		"userdata":       strings.Repeat("x", MaxMetaDataValueSize+1),
+		"key":            "value",
+	}))
+
+	require.EqualError(t, err, "Metadata values larger than 262144 bytes: startup-script (262145 bytes), userdata (262145 bytes)")
+}
+
+func TestValidateMetaDataTotalSize(t *testing.T) {
+	// Keys count in the total size.
+	value := strings.Repeat("x", MaxMetaDataSize/2-len("key-1"))
+
+	require.NoError(t, ValidateMetaData(TagsToMetaData(map[string]string{
+		"key-1": value,
+		"key-2": value,
+	})))
+
+	err := ValidateMetaData(TagsToMetaData(map[string]string{
+		"key-1": value,
+		"key-2": value,
+		"k":     "",
+	}))
+
+	require.EqualError(t, err, "Metadata of 524289 bytes, larger than 524288 bytes: key-1 (262144 bytes), key-2 (262144 bytes), k (1 bytes)")
+}
+
+func TestValidateMetaDataKeys(t *testing.T) {
+	require.NoError(t, ValidateMetaData(TagsToMetaData(map[string]string{
+		"infrakit.group":         "workers",
+		strings.Repeat("k", 128): "value",
+	})))
+
+	invalid := []string{
+		"",
+		"with space",
+		strings.Repeat("k", 129),
+	}
+	for _, key := range invalid {
+		err := ValidateMetaData(TagsToMetaData(map[string]string{key: "value"}))
+
+		require.EqualError(t, err, `Invalid metadata key "`+key+`": should have 1 to 128 letters, digits, _ or -`)
+	}
+}
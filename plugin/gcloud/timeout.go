@@ -0,0 +1,83 @@
+package gcloud
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultReadTimeout is how long a read of the Compute API, including the
+	// polling of operations, waits for a response
+	DefaultReadTimeout = 30 * time.Second
+
+	// DefaultMutationTimeout is how long a mutation of the Compute API waits for
+	// a response. Waiting for its operation is bounded separately.
+	DefaultMutationTimeout = 2 * time.Minute
+)
+
+// timeLimitedTransport bounds each request, so that a connection that hangs
+// doesn't block a call forever. Reads and mutations have separate bounds.
+type timeLimitedTransport struct {
+	transport http.RoundTripper
+	reads     time.Duration
+	mutations time.Duration
+}
+
+func (t *timeLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timeout := t.mutations
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		timeout = t.reads
+	}
+	if timeout <= 0 {
+		return t.transport.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+
+	resp, err := t.transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded && req.Context().Err() == nil {
+			err = fmt.Errorf("No response after %v: %w", timeout, context.DeadlineExceeded)
+		}
+		cancel()
+		return nil, err
+	}
+
+	// The body is read after RoundTrip returns, still within the bound.
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// timeLimited wraps a client to apply the configured timeouts, if any.
+func (g *computeServiceWrapper) timeLimited(client *http.Client) *http.Client {
+	if g.readTimeout <= 0 && g.mutationTimeout <= 0 {
+		return client
+	}
+
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	limited := *client
+	limited.Transport = &timeLimitedTransport{
+		transport: transport,
+		reads:     g.readTimeout,
+		mutations: g.mutationTimeout,
+	}
+
+	return &limited
+}
@@ -0,0 +1,50 @@
+package gcloud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallTimeout(t *testing.T) {
+	hung := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer server.Close()
+	defer close(hung)
+
+	api, err := NewAPI("PROJECT", "us-central1-f",
+		WithEndpoint(server.URL),
+		WithHTTPClient(server.Client()),
+		WithCallTimeouts(50*time.Millisecond, 100*time.Millisecond))
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = api.GetInstance(context.Background(), "vm")
+
+	require.True(t, time.Since(start) < time.Second, "returned after %v", time.Since(start))
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+	require.True(t, strings.HasPrefix(err.Error(), "GetInstance: "), err.Error())
+	require.Contains(t, err.Error(), "No response after 50ms")
+
+	start = time.Now()
+	err = api.DeleteInstance(context.Background(), "vm")
+
+	require.True(t, time.Since(start) < time.Second, "returned after %v", time.Since(start))
+	require.True(t, strings.HasPrefix(err.Error(), "DeleteInstance: "), err.Error())
+	require.Contains(t, err.Error(), "No response after 100ms")
+}
+
+func TestCallWithoutTimeout(t *testing.T) {
+	client := &http.Client{}
+	api := &computeServiceWrapper{}
+
+	require.Equal(t, client, api.timeLimited(client))
+}
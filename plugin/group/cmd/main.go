@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 
+	gcp_plugin "github.com/docker/infrakit.gcp/plugin"
 	"github.com/docker/infrakit.gcp/plugin/group"
 	"github.com/docker/infrakit/pkg/cli"
 	"github.com/docker/infrakit/pkg/discovery/local"
@@ -10,6 +14,7 @@ import (
 	flavor_client "github.com/docker/infrakit/pkg/rpc/flavor"
 	group_plugin "github.com/docker/infrakit/pkg/rpc/group"
 	"github.com/docker/infrakit/pkg/spi/flavor"
+	spi_group "github.com/docker/infrakit/pkg/spi/group"
 	"github.com/spf13/cobra"
 )
 
@@ -23,6 +28,10 @@ func main() {
 	logLevel := cmd.Flags().Int("log", cli.DefaultLogLevel, "Logging level. 0 is least verbose. Max is 5")
 	project := cmd.Flags().String("project", "", "Google Cloud project")
 	zone := cmd.Flags().String("zone", "", "Google Cloud zone")
+	region := cmd.Flags().String("region", "", "Google Cloud region, to spread the instances of each group across its zones")
+	pricing := cmd.Flags().String("pricing", "", "Path to a JSON file with the prices used to estimate the cost of pretend commits")
+	requestTimeout := cmd.Flags().Duration("request-timeout", 0, "Bound the Compute API calls made for each request. 10m if 0")
+	gcloudOptions := gcp_plugin.GCloudOptions(cmd.Flags(), "group")
 
 	cmd.RunE = func(c *cobra.Command, args []string) error {
 		cli.SetLogLevel(*logLevel)
@@ -40,7 +49,26 @@ func main() {
 			return flavor_client.NewClient(n, endpoint.Address)
 		}
 
-		cli.RunPlugin(*name, group_plugin.PluginServer(group.NewGCEGroupPlugin(*project, *zone, flavorPluginLookup)))
+		options := gcloudOptions()
+
+		var groupPlugin spi_group.Plugin
+		if *region != "" {
+			groupPlugin = group.NewGCERegionalGroupPlugin(*project, *region, flavorPluginLookup, options...)
+		} else {
+			groupPlugin = group.NewGCEGroupPlugin(*project, *zone, flavorPluginLookup, options...)
+		}
+
+		groupPlugin.(gcp_plugin.RequestTimeouts).SetRequestTimeout(*requestTimeout)
+
+		if *pricing != "" {
+			prices, err := loadPricing(*pricing)
+			if err != nil {
+				return err
+			}
+			groupPlugin.(group.CostEstimator).SetPricing(prices)
+		}
+
+		cli.RunPlugin(*name, group_plugin.PluginServer(groupPlugin))
 
 		return nil
 	}
@@ -52,3 +80,17 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func loadPricing(path string) (*group.StaticPricing, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read pricing file %s: %v", path, err)
+	}
+
+	prices := &group.StaticPricing{}
+	if err := json.Unmarshal(content, prices); err != nil {
+		return nil, fmt.Errorf("Invalid pricing file %s: %v", path, err)
+	}
+
+	return prices, nil
+}
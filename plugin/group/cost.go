@@ -0,0 +1,123 @@
+package group
+
+import (
+	"fmt"
+
+	"github.com/docker/infrakit.gcp/plugin/gcloud"
+)
+
+// hoursPerMonth converts the monthly prices of the disks to hourly prices.
+const hoursPerMonth = 730
+
+// Pricing gives the prices of the resources of a group, for example the
+// negotiated rates of an account.
+type Pricing interface {
+	// InstanceHourly returns the hourly price of an instance of a machine type.
+	InstanceHourly(machineType string, preemptible bool) (float64, error)
+
+	// DiskHourly returns the hourly price of a persistent disk.
+	DiskHourly(diskType string, sizeGb int64) (float64, error)
+}
+
+// CostEstimator adds the estimated hourly cost of a group, before and after
+// the change, to the description of pretend commits.
+type CostEstimator interface {
+	// SetPricing sets the prices used by the estimates. nil disables them.
+	SetPricing(pricing Pricing)
+}
+
+// StaticPricing is a Pricing with fixed rates, typically loaded from a JSON
+// file. Machine types and disk types are given by name.
+type StaticPricing struct {
+	// MachineTypes are the hourly prices of the machine types.
+	MachineTypes map[string]float64
+
+	// PreemptibleMachineTypes are the hourly prices of the preemptible
+	// machine types.
+	PreemptibleMachineTypes map[string]float64
+
+	// DiskTypes are the monthly prices of a GB of the disk types.
+	DiskTypes map[string]float64
+}
+
+// InstanceHourly returns the hourly price of an instance of a machine type.
+func (s *StaticPricing) InstanceHourly(machineType string, preemptible bool) (float64, error) {
+	prices := s.MachineTypes
+	if preemptible {
+		prices = s.PreemptibleMachineTypes
+	}
+
+	price, present := prices[machineType]
+	if !present {
+		if preemptible {
+			return 0, fmt.Errorf("No price for preemptible machine type %s", machineType)
+		}
+		return 0, fmt.Errorf("No price for machine type %s", machineType)
+	}
+
+	return price, nil
+}
+
+// DiskHourly returns the hourly price of a persistent disk.
+func (s *StaticPricing) DiskHourly(diskType string, sizeGb int64) (float64, error) {
+	price, present := s.DiskTypes[diskType]
+	if !present {
+		return 0, fmt.Errorf("No price for disk type %s", diskType)
+	}
+
+	return price * float64(sizeGb) / hoursPerMonth, nil
+}
+
+func (p *plugin) SetPricing(pricing Pricing) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.pricing = pricing
+}
+
+// estimateCost describes the hourly cost of a group before and after a
+// commit. A group that isn't present yet costs nothing.
+func (p *plugin) estimateCost(current settings, present bool, desired settings) string {
+	currentCost := 0.0
+	if present {
+		cost, err := p.hourlyCost(current)
+		if err != nil {
+			return fmt.Sprintf("Estimated cost unavailable: %v", err)
+		}
+		currentCost = cost
+	}
+
+	desiredCost, err := p.hourlyCost(desired)
+	if err != nil {
+		return fmt.Sprintf("Estimated cost unavailable: %v", err)
+	}
+
+	return fmt.Sprintf("Estimated cost: %.4f/h, currently %.4f/h (%+.4f/h)", desiredCost, currentCost, desiredCost-currentCost)
+}
+
+// hourlyCost is the cost of the instances of a group and of their disks.
+func (p *plugin) hourlyCost(s settings) (float64, error) {
+	instanceCost, err := p.instanceHourlyCost(s.instanceProperties.InstanceSettings)
+	if err != nil {
+		return 0, err
+	}
+
+	return instanceCost * float64(s.spec.Allocation.Size), nil
+}
+
+func (p *plugin) instanceHourlyCost(instanceSettings *gcloud.InstanceSettings) (float64, error) {
+	cost, err := p.pricing.InstanceHourly(last(instanceSettings.MachineType), instanceSettings.Preemptible)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, disk := range instanceSettings.Disks {
+		diskCost, err := p.pricing.DiskHourly(last(disk.Type), disk.SizeGb)
+		if err != nil {
+			return 0, err
+		}
+		cost += diskCost
+	}
+
+	return cost, nil
+}
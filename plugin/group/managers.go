@@ -0,0 +1,203 @@
+package group
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/docker/infrakit.gcp/plugin/gcloud"
+	"google.golang.org/api/compute/v1"
+)
+
+// instanceGroupManagers are the operations of the plugin on its instance
+// group managers, which are either zonal or regional.
+type instanceGroupManagers interface {
+	Create(ctx context.Context, name string, settings *gcloud.InstanceManagerSettings) error
+	Get(ctx context.Context, name string) (*compute.InstanceGroupManager, error)
+	ListInstances(ctx context.Context, name string) ([]*compute.InstanceWithNamedPorts, error)
+	ListManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error)
+	SetInstanceTemplate(ctx context.Context, name string, templateName string) error
+	SetNamedPorts(ctx context.Context, name string, ports []gcloud.NamedPort) error
+	SetTargetPools(ctx context.Context, name string, targetPools []string) error
+	RecreateInstances(ctx context.Context, name string, instances ...string) error
+	Resize(ctx context.Context, name string, targetSize int64) error
+	Delete(ctx context.Context, name string) error
+
+	// GetInstances returns instances of a group, given by their url, and the
+	// error for each instance that couldn't be found.
+	GetInstances(ctx context.Context, instanceURLs []string) (map[string]*compute.Instance, map[string]error, error)
+
+	// BackendGroup is how backend services refer to the instance group.
+	BackendGroup(name string) string
+}
+
+type zonalManagers struct {
+	API gcloud.API
+}
+
+func (z *zonalManagers) Create(ctx context.Context, name string, settings *gcloud.InstanceManagerSettings) error {
+	return z.API.CreateInstanceGroupManager(ctx, name, settings)
+}
+
+func (z *zonalManagers) Get(ctx context.Context, name string) (*compute.InstanceGroupManager, error) {
+	return z.API.GetInstanceGroupManager(ctx, name)
+}
+
+func (z *zonalManagers) ListInstances(ctx context.Context, name string) ([]*compute.InstanceWithNamedPorts, error) {
+	return z.API.ListInstanceGroupInstances(ctx, name)
+}
+
+func (z *zonalManagers) ListManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error) {
+	return z.API.ListManagedInstances(ctx, name)
+}
+
+func (z *zonalManagers) SetInstanceTemplate(ctx context.Context, name string, templateName string) error {
+	return z.API.SetInstanceTemplate(ctx, name, templateName)
+}
+
+func (z *zonalManagers) SetNamedPorts(ctx context.Context, name string, ports []gcloud.NamedPort) error {
+	return z.API.SetNamedPorts(ctx, name, ports)
+}
+
+func (z *zonalManagers) SetTargetPools(ctx context.Context, name string, targetPools []string) error {
+	return z.API.SetTargetPools(ctx, name, targetPools)
+}
+
+func (z *zonalManagers) RecreateInstances(ctx context.Context, name string, instances ...string) error {
+	return z.API.RecreateInstances(ctx, name, instances...)
+}
+
+func (z *zonalManagers) Resize(ctx context.Context, name string, targetSize int64) error {
+	return z.API.ResizeInstanceGroupManager(ctx, name, targetSize)
+}
+
+func (z *zonalManagers) Delete(ctx context.Context, name string) error {
+	return z.API.DeleteInstanceGroupManager(ctx, name)
+}
+
+func (z *zonalManagers) GetInstances(ctx context.Context, instanceURLs []string) (map[string]*compute.Instance, map[string]error, error) {
+	names := make([]string, len(instanceURLs))
+	for i, instanceURL := range instanceURLs {
+		names[i] = last(instanceURL)
+	}
+
+	found, failed, err := z.API.BatchGetInstances(ctx, names)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instances := map[string]*compute.Instance{}
+	failures := map[string]error{}
+	for _, instanceURL := range instanceURLs {
+		if inst, present := found[last(instanceURL)]; present {
+			instances[instanceURL] = inst
+		}
+		if err, present := failed[last(instanceURL)]; present {
+			failures[instanceURL] = err
+		}
+	}
+
+	return instances, failures, nil
+}
+
+func (z *zonalManagers) BackendGroup(name string) string {
+	return name
+}
+
+// regionalManagers spread the instances of the groups across the zones of a
+// region, so that a group survives the outage of a zone.
+type regionalManagers struct {
+	API    gcloud.API
+	region string
+}
+
+func (r *regionalManagers) Create(ctx context.Context, name string, settings *gcloud.InstanceManagerSettings) error {
+	return r.API.CreateRegionalInstanceGroupManager(ctx, name, settings)
+}
+
+func (r *regionalManagers) Get(ctx context.Context, name string) (*compute.InstanceGroupManager, error) {
+	return r.API.GetRegionalInstanceGroupManager(ctx, name)
+}
+
+func (r *regionalManagers) ListInstances(ctx context.Context, name string) ([]*compute.InstanceWithNamedPorts, error) {
+	return r.API.ListRegionalInstanceGroupInstances(ctx, name)
+}
+
+func (r *regionalManagers) ListManagedInstances(ctx context.Context, name string) ([]*compute.ManagedInstance, error) {
+	return r.API.ListRegionalManagedInstances(ctx, name)
+}
+
+func (r *regionalManagers) SetInstanceTemplate(ctx context.Context, name string, templateName string) error {
+	return r.API.SetRegionalInstanceTemplate(ctx, name, templateName)
+}
+
+func (r *regionalManagers) SetNamedPorts(ctx context.Context, name string, ports []gcloud.NamedPort) error {
+	return r.API.SetRegionalNamedPorts(ctx, name, ports)
+}
+
+func (r *regionalManagers) SetTargetPools(ctx context.Context, name string, targetPools []string) error {
+	return r.API.SetRegionalTargetPools(ctx, name, targetPools)
+}
+
+func (r *regionalManagers) RecreateInstances(ctx context.Context, name string, instances ...string) error {
+	return r.API.RecreateRegionalInstances(ctx, name, instances...)
+}
+
+func (r *regionalManagers) Resize(ctx context.Context, name string, targetSize int64) error {
+	return r.API.ResizeRegionalInstanceGroupManager(ctx, name, targetSize)
+}
+
+func (r *regionalManagers) Delete(ctx context.Context, name string) error {
+	return r.API.DeleteRegionalInstanceGroupManager(ctx, name)
+}
+
+// GetInstances fetches the instances a few at a time, since they are spread
+// across zones.
+func (r *regionalManagers) GetInstances(ctx context.Context, instanceURLs []string) (map[string]*compute.Instance, map[string]error, error) {
+	instances := map[string]*compute.Instance{}
+	failures := map[string]error{}
+
+	var lock sync.Mutex
+	slots := make(chan struct{}, describeConcurrency)
+	var wg sync.WaitGroup
+
+	for _, instanceURL := range instanceURLs {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(instanceURL string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			inst, err := r.API.GetInstanceInZone(ctx, zoneOf(instanceURL), last(instanceURL))
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			if err != nil {
+				failures[instanceURL] = err
+				return
+			}
+			instances[instanceURL] = inst
+		}(instanceURL)
+	}
+
+	wg.Wait()
+
+	return instances, failures, nil
+}
+
+func (r *regionalManagers) BackendGroup(name string) string {
+	return "regions/" + r.region + "/instanceGroups/" + name
+}
+
+// zoneOf extracts the zone of an instance url.
+func zoneOf(instanceURL string) string {
+	parts := strings.Split(instanceURL, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "zones" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
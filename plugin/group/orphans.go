@@ -0,0 +1,135 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/infrakit.gcp/plugin/gcloud"
+	"github.com/docker/infrakit/pkg/spi/group"
+	"github.com/docker/infrakit/pkg/spi/instance"
+	"google.golang.org/api/compute/v1"
+)
+
+// OrphanDetector finds the instances left behind by a group, for example
+// after a crash or a failed update. They carry the GroupLabel of the group but
+// its instance group manager doesn't manage them.
+type OrphanDetector interface {
+	// DetectOrphans returns the orphan instances of a group. With cleanup,
+	// they are deleted.
+	DetectOrphans(id group.ID, cleanup bool) ([]instance.ID, error)
+}
+
+func (p *plugin) DetectOrphans(id group.ID, cleanup bool) ([]instance.ID, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	_, present := p.groups[id]
+	if !present {
+		return nil, fmt.Errorf("This group is not being watched: '%s", id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	name := string(id)
+
+	// The labeled instances are listed first, so that an instance the manager
+	// creates in between is seen as a member rather than as an orphan.
+	labeled, err := p.labeledInstances(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without a manager, every labeled instance would look like an orphan.
+	managed, err := p.managers().ListManagedInstances(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	members := map[string]bool{}
+	for _, managedInstance := range managed {
+		members[last(managedInstance.Instance)] = true
+	}
+
+	orphans := []instance.ID{}
+	zones := map[string]string{}
+	for _, inst := range labeled {
+		if !members[inst.Name] {
+			orphans = append(orphans, instance.ID(inst.Name))
+			zones[inst.Name] = last(inst.Zone)
+		}
+	}
+
+	if cleanup && len(orphans) > 0 {
+		if err := p.deleteOrphans(ctx, name, zones); err != nil {
+			return nil, err
+		}
+	}
+
+	return orphans, nil
+}
+
+// deleteOrphans deletes the orphan instances of a group, given with their
+// zones. The deletions carry on past the failures, which are reported
+// together.
+func (p *plugin) deleteOrphans(ctx context.Context, name string, zones map[string]string) error {
+	names := []string{}
+	for orphan := range zones {
+		names = append(names, orphan)
+	}
+	sort.Strings(names)
+
+	log.Infoln("Deleting orphan instances", names, "of group", name)
+
+	var failures map[string]error
+	if p.region == "" {
+		failures = p.API.DeleteInstances(ctx, names)
+	} else {
+		failures = map[string]error{}
+		for _, orphan := range names {
+			if err := p.API.DeleteInstanceInZone(ctx, zones[orphan], orphan); err != nil {
+				failures[orphan] = err
+			}
+		}
+	}
+
+	errs := []string{}
+	for _, orphan := range names {
+		if err := failures[orphan]; err != nil && !errors.Is(err, gcloud.ErrNotFound) {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Failed to delete %d orphan instances of group %s: %s", len(errs), name, strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// labeledInstances lists the instances that carry the label of a group, in the
+// zone of a zonal group or in the region of a regional group.
+func (p *plugin) labeledInstances(ctx context.Context, name string) ([]*compute.Instance, error) {
+	filter := fmt.Sprintf("labels.%s eq %s", GroupLabel, name)
+
+	if p.region == "" {
+		return p.API.ListInstances(ctx, filter)
+	}
+
+	instances, err := p.API.AggregatedListInstances(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	inRegion := []*compute.Instance{}
+	for _, inst := range instances {
+		if strings.HasPrefix(last(inst.Zone), p.region+"-") {
+			inRegion = append(inRegion, inst)
+		}
+	}
+
+	return inRegion, nil
+}
@@ -1,11 +1,18 @@
 package group
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/infrakit.gcp/plugin/gcloud"
@@ -15,8 +22,52 @@ import (
 	"github.com/docker/infrakit/pkg/spi"
 	"github.com/docker/infrakit/pkg/spi/group"
 	"github.com/docker/infrakit/pkg/spi/instance"
+	"google.golang.org/api/compute/v1"
 )
 
+// defaultRequestTimeout bounds the Compute API calls made for a single plugin
+// request, unless the plugin is given another timeout.
+const defaultRequestTimeout = 10 * time.Minute
+
+// templateMarker starts the description of the instance templates created by
+// the plugin. The template version follows, encoded in JSON.
+const templateMarker = "infrakit-template-version:"
+
+// now dates the template versions. The replay tests pin it, so that a template
+// is described the same way on each run.
+var now = time.Now
+
+// defaultPollInterval is how often a group is checked during a rolling update.
+const defaultPollInterval = 5 * time.Second
+
+// rolloutTimeout bounds the rollout of a new template to the running instances
+// of a group, which goes on after CommitGroup returns.
+const rolloutTimeout = time.Hour
+
+const (
+	// CurrentActionTag is added to the tags of the instances described by
+	// DescribeGroup. It's the action that the group manager is performing on
+	// the instance, for example RECREATING, or NONE.
+	CurrentActionTag = "infrakit-gcp-current-action"
+
+	// InstanceStatusTag is added to the tags of the instances described by
+	// DescribeGroup. It's the status of the instance, for example RUNNING.
+	InstanceStatusTag = "infrakit-gcp-instance-status"
+
+	// GroupTag is added to the metadata of the instances of a group. It's the
+	// ID of the group. The tags of the flavors can't override it.
+	GroupTag = "infrakit.group"
+
+	// GroupLabel is added to the labels of the instances of a group. It's the
+	// ID of the group, so that billing and gcloud filters can break down by
+	// group. The labels of the properties can't override it.
+	GroupLabel = "infrakit-group"
+)
+
+// describeConcurrency bounds the instances fetched concurrently when a regional
+// group is described.
+const describeConcurrency = 10
+
 type settings struct {
 	spec               types.Spec
 	groupSpec          group.Spec
@@ -24,28 +75,323 @@ type settings struct {
 	instanceProperties instance_types.Properties
 	currentTemplate    int
 	createdTemplates   []string
+	templateHistory    []TemplateVersion
+	placement          PlacementSettings
+	updates            UpdateSettings
+	loadBalancing      LoadBalancingSettings
+	autoscaling        AutoscalingSettings
+	autoHealing        AutoHealingSettings
+}
+
+// PlacementSettings spread the instances of a group across distinct hosts,
+// with a spread placement policy attached to the group's instance template.
+// They are set with the Placement field of a group spec.
+type PlacementSettings struct {
+	// Spread enables the spread placement policy.
+	Spread bool
+
+	// AvailabilityDomains is the number of availability domains, sets of
+	// hosts that don't share power or network, the instances are spread
+	// across, between 2 and 8. Zero means 3.
+	AvailabilityDomains int64
+}
+
+const (
+	defaultAvailabilityDomains = int64(3)
+	minAvailabilityDomains     = int64(2)
+	maxAvailabilityDomains     = int64(8)
+)
+
+// spreadPlacementFamilies are the machine families that support spread
+// placement policies. Custom machine types without a family are N1.
+var spreadPlacementFamilies = []string{"n1", "n2", "n2d", "c2", "c2d", "c3", "t2d"}
+
+// machineFamily is the family of a predefined or custom machine type.
+func machineFamily(machineType string) string {
+	name := last(machineType)
+	if strings.HasPrefix(name, "custom-") {
+		return "n1"
+	}
+	return strings.SplitN(name, "-", 2)[0]
+}
+
+// placementPolicyName is the name of the spread placement policy created for
+// a group. Resource policies can't be updated, so the name changes with the
+// availability domains.
+func placementPolicyName(name string, placement PlacementSettings) string {
+	return fmt.Sprintf("%s-spread-%d", name, placement.AvailabilityDomains)
+}
+
+// UpdateSettings controls how the running instances of a group are moved to a
+// new instance template. They are set with the Updates field of a group spec.
+type UpdateSettings struct {
+	// MaxUnavailable is the number of instances recreated at once. The next
+	// batch waits for the group to be stable again. Zero leaves the running
+	// instances on their current template, unless MaxSurge is set.
+	MaxUnavailable int
+
+	// MaxSurge is the number of extra instances created during an update, so
+	// that the group keeps its size. The instances are then replaced by the
+	// instance group manager itself.
+	MaxSurge int
+}
+
+// rollout is the background update of the instances of a group to a new
+// template.
+type rollout struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+const defaultMaxUnavailable = 1
+
+// LoadBalancingSettings registers a group with load balancers. They are set
+// with the LoadBalancing field of a group spec.
+type LoadBalancingSettings struct {
+	// BackendServices are the names of the backend services the group is a
+	// backend of.
+	BackendServices []string
+
+	// ConnectionDrainingTimeoutSec is how long the backend services let the
+	// in-flight requests of an instance complete before it's deleted or
+	// recreated. Zero keeps the timeout of the backend services.
+	ConnectionDrainingTimeoutSec int64
+
+	// NamedPorts name the ports of the instances, for example http:80, that
+	// the backend services send their traffic to.
+	NamedPorts []gcloud.NamedPort
+}
+
+// sameBackends tells if two settings register a group with the same backend
+// services.
+func (l LoadBalancingSettings) sameBackends(other LoadBalancingSettings) bool {
+	return reflect.DeepEqual(l.BackendServices, other.BackendServices) && l.ConnectionDrainingTimeoutSec == other.ConnectionDrainingTimeoutSec
+}
+
+// namedPort matches the valid names of named ports.
+var namedPort = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+func validateNamedPorts(ports []gcloud.NamedPort) error {
+	names := map[string]bool{}
+	for _, port := range ports {
+		if !namedPort.MatchString(port.Name) {
+			return fmt.Errorf("Invalid LoadBalancing.NamedPorts name %q: should start with a lowercase letter and have at most 63 lowercase letters, digits or -", port.Name)
+		}
+		if port.Port < 1 || port.Port > 65535 {
+			return fmt.Errorf("LoadBalancing.NamedPorts port %s must be between 1 and 65535", port.Name)
+		}
+		if names[port.Name] {
+			return fmt.Errorf("LoadBalancing.NamedPorts has several ports named %s", port.Name)
+		}
+		names[port.Name] = true
+	}
+
+	return nil
+}
+
+// AutoscalingSettings let GCE resize a group to reach a CPU utilization. They
+// are set with the Autoscaling field of a group spec. Allocation.Size is then
+// only the initial size of the group.
+type AutoscalingSettings struct {
+	// MinReplicas and MaxReplicas bound the size of the group. A MaxReplicas
+	// of zero disables autoscaling.
+	MinReplicas int64
+	MaxReplicas int64
+
+	// CPUUtilization is the average CPU utilization of the instances the
+	// autoscaler aims for, between 0 and 1.
+	CPUUtilization float64
+}
+
+const defaultCPUUtilization = 0.6
+
+func (a AutoscalingSettings) enabled() bool {
+	return a.MaxReplicas > 0
+}
+
+func (a AutoscalingSettings) gcloudSettings() *gcloud.AutoscalerSettings {
+	return &gcloud.AutoscalerSettings{
+		MinReplicas:    a.MinReplicas,
+		MaxReplicas:    a.MaxReplicas,
+		CPUUtilization: a.CPUUtilization,
+	}
+}
+
+// AutoHealingSettings make the group manager recreate the instances that fail
+// a health check. They are set with the AutoHealing field of a group spec.
+type AutoHealingSettings struct {
+	// Protocol is the protocol of the health check: HTTP, HTTPS or TCP. An
+	// empty protocol disables autohealing.
+	Protocol string
+
+	// Port is the port that is checked, 80 or 443 by default for HTTP and
+	// HTTPS. RequestPath is the path requested over HTTP(S), / by default.
+	Port        int64
+	RequestPath string
+
+	// CheckIntervalSec, TimeoutSec, HealthyThreshold and UnhealthyThreshold
+	// tune the health check. Zero keeps the defaults of GCE.
+	CheckIntervalSec   int64
+	TimeoutSec         int64
+	HealthyThreshold   int64
+	UnhealthyThreshold int64
+
+	// InitialDelaySec lets the new instances start before they are checked.
+	InitialDelaySec int64
+}
+
+var defaultHealthCheckPorts = map[string]int64{
+	"HTTP":  80,
+	"HTTPS": 443,
+}
+
+func (a AutoHealingSettings) enabled() bool {
+	return a.Protocol != ""
+}
+
+func (a AutoHealingSettings) gcloudSettings() *gcloud.HealthCheckSettings {
+	return &gcloud.HealthCheckSettings{
+		Protocol:           a.Protocol,
+		Port:               a.Port,
+		RequestPath:        a.RequestPath,
+		CheckIntervalSec:   a.CheckIntervalSec,
+		TimeoutSec:         a.TimeoutSec,
+		HealthyThreshold:   a.HealthyThreshold,
+		UnhealthyThreshold: a.UnhealthyThreshold,
+	}
+}
+
+// healthCheckName is the name of the health check created for a group.
+func healthCheckName(name string) string {
+	return name + "-autohealing"
+}
+
+// TemplateVersion describes the instance settings that produced a version of
+// a group's instance template.
+type TemplateVersion struct {
+	// Name is the name of the instance template.
+	Name string
+
+	// Digest is the sha256 digest of the instance settings.
+	Digest string
+
+	// MachineType, Image and Tags are the key instance settings.
+	MachineType string
+	Image       string
+	Tags        []string
+
+	// Created is when the template was created.
+	Created time.Time
+}
+
+// TemplateHistory reports the versions of a group's instance template.
+type TemplateHistory interface {
+	// GetTemplateHistory returns the template versions of a group, oldest first.
+	GetTemplateHistory(id group.ID) ([]TemplateVersion, error)
+}
+
+// NetworkTagger updates the network tags of all the instances of a group in
+// place, without recreating them.
+type NetworkTagger interface {
+	// AddNetworkTag adds a network tag to the current and future instances of a group.
+	AddNetworkTag(id group.ID, tag string) error
+
+	// RemoveNetworkTag removes a network tag from the current and future instances of a group.
+	RemoveNetworkTag(id group.ID, tag string) error
+}
+
+// ResizeProgress tells how far a group is from its target size.
+type ResizeProgress struct {
+	// Current is the number of instances that are running with no pending action.
+	Current int64
+
+	// Target is the size the group is being resized to.
+	Target int64
+}
+
+// Done returns true when the group has reached its target size.
+func (r ResizeProgress) Done() bool {
+	return r.Current == r.Target
+}
+
+// ResizeTracker reports the progress of a group that is being resized, since
+// the instances are created or deleted asynchronously after CommitGroup returns.
+type ResizeTracker interface {
+	// ResizeProgress returns the current and target sizes of a group.
+	ResizeProgress(id group.ID) (ResizeProgress, error)
 }
 
 type plugin struct {
 	API           gcloud.API
+	region        string
 	flavorPlugins group_plugin.FlavorPluginLookup
 	groups        map[group.ID]settings
 	lock          sync.Mutex
+	pollInterval  time.Duration
+	rollouts      map[group.ID]*rollout
+	pricing       Pricing
+	timeout       time.Duration
 }
 
 // NewGCEGroupPlugin creates a new GCE group plugin for a given project
 // and zone.
-func NewGCEGroupPlugin(project, zone string, flavorPlugins group_plugin.FlavorPluginLookup) group.Plugin {
-	api, err := gcloud.NewAPI(project, zone)
+func NewGCEGroupPlugin(project, zone string, flavorPlugins group_plugin.FlavorPluginLookup, options ...gcloud.Option) group.Plugin {
+	api, err := gcloud.NewAPI(project, zone, options...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return &plugin{
+		API:           api,
+		flavorPlugins: flavorPlugins,
+		groups:        map[group.ID]settings{},
+		pollInterval:  defaultPollInterval,
+		rollouts:      map[group.ID]*rollout{},
+	}
+}
+
+// NewGCERegionalGroupPlugin creates a new GCE group plugin for a given project
+// and region. The instances of each group are spread across the zones of the
+// region.
+func NewGCERegionalGroupPlugin(project, region string, flavorPlugins group_plugin.FlavorPluginLookup, options ...gcloud.Option) group.Plugin {
+	api, err := gcloud.NewAPI(project, "", append(options, gcloud.WithRegion(region))...)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	return &plugin{
 		API:           api,
+		region:        region,
 		flavorPlugins: flavorPlugins,
 		groups:        map[group.ID]settings{},
+		pollInterval:  defaultPollInterval,
+		rollouts:      map[group.ID]*rollout{},
+	}
+}
+
+// managers returns the instance group managers of the plugin, which are
+// regional when the plugin was given a region.
+func (p *plugin) managers() instanceGroupManagers {
+	if p.region != "" {
+		return &regionalManagers{API: p.API, region: p.region}
+	}
+	return &zonalManagers{API: p.API}
+}
+
+// SetRequestTimeout bounds the Compute API calls made for a single plugin
+// request. Zero restores the default of 10 minutes.
+func (p *plugin) SetRequestTimeout(timeout time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.timeout = timeout
+}
+
+func (p *plugin) requestTimeout() time.Duration {
+	if p.timeout <= 0 {
+		return defaultRequestTimeout
 	}
+	return p.timeout
 }
 
 func (p *plugin) VendorInfo() *spi.VendorInfo {
@@ -58,7 +404,7 @@ func (p *plugin) VendorInfo() *spi.VendorInfo {
 	}
 }
 
-func (p *plugin) validate(groupSpec group.Spec) (settings, error) {
+func (p *plugin) validate(ctx context.Context, groupSpec group.Spec) (settings, error) {
 	noSettings := settings{}
 
 	if groupSpec.ID == "" {
@@ -93,8 +439,9 @@ func (p *plugin) validate(groupSpec group.Spec) (settings, error) {
 		Properties: spec.Instance.Properties,
 	}
 
-	instanceGroupInstances, err := p.API.ListInstanceGroupInstances(string(groupSpec.ID))
-	if err != nil {
+	// The instance group doesn't exist yet the first time a group is committed.
+	instanceGroupInstances, err := p.managers().ListInstances(ctx, string(groupSpec.ID))
+	if err != nil && !errors.Is(err, gcloud.ErrNotFound) {
 		return noSettings, err
 	}
 
@@ -111,6 +458,116 @@ func (p *plugin) validate(groupSpec group.Spec) (settings, error) {
 	if err != nil {
 		return noSettings, err
 	}
+	if err = instanceProperties.Validate(); err != nil {
+		return noSettings, err
+	}
+	if instanceProperties.DeletionProtection {
+		return noSettings, errors.New("DeletionProtection is not supported by instance templates")
+	}
+
+	if instanceProperties.SourceInstance != "" {
+		if _, err := p.API.GetInstance(ctx, instanceProperties.SourceInstance); err != nil {
+			return noSettings, fmt.Errorf("Invalid source instance '%s': %v", instanceProperties.SourceInstance, err)
+		}
+	}
+
+	for _, targetPool := range instanceProperties.TargetPools {
+		if _, err := p.API.GetTargetPool(ctx, targetPool); err != nil {
+			return noSettings, fmt.Errorf("Invalid target pool '%s': %v", targetPool, err)
+		}
+	}
+
+	groupProperties := struct {
+		Placement     PlacementSettings
+		Updates       UpdateSettings
+		LoadBalancing LoadBalancingSettings
+		Autoscaling   AutoscalingSettings
+		AutoHealing   AutoHealingSettings
+	}{
+		Updates: UpdateSettings{MaxUnavailable: defaultMaxUnavailable},
+	}
+	if err = groupSpec.Properties.Decode(&groupProperties); err != nil {
+		return noSettings, err
+	}
+
+	placement := groupProperties.Placement
+	if placement.Spread {
+		if placement.AvailabilityDomains == 0 {
+			placement.AvailabilityDomains = defaultAvailabilityDomains
+		}
+		if placement.AvailabilityDomains < minAvailabilityDomains || placement.AvailabilityDomains > maxAvailabilityDomains {
+			return noSettings, fmt.Errorf("Placement.AvailabilityDomains must be between %d and %d", minAvailabilityDomains, maxAvailabilityDomains)
+		}
+		if family := machineFamily(instanceProperties.MachineType); !contains(spreadPlacementFamilies, family) {
+			return noSettings, fmt.Errorf("Placement.Spread is not supported by machine type %s: its family should be one of %s", instanceProperties.MachineType, strings.Join(spreadPlacementFamilies, ", "))
+		}
+
+		// The policy is attached to the template, so that changing it
+		// updates the template.
+		instanceSettings := *instanceProperties.InstanceSettings
+		instanceSettings.ResourcePolicies = append(append([]string{}, instanceSettings.ResourcePolicies...), placementPolicyName(string(groupSpec.ID), placement))
+		instanceProperties.InstanceSettings = &instanceSettings
+	} else {
+		placement = PlacementSettings{}
+	}
+
+	updates := groupProperties.Updates
+	if updates.MaxUnavailable < 0 {
+		return noSettings, errors.New("Updates.MaxUnavailable must be >= 0")
+	}
+	if updates.MaxSurge < 0 {
+		return noSettings, errors.New("Updates.MaxSurge must be >= 0")
+	}
+	if updates.MaxSurge > 0 && p.region != "" {
+		return noSettings, errors.New("Updates.MaxSurge is not supported by regional groups")
+	}
+	if groupProperties.LoadBalancing.ConnectionDrainingTimeoutSec < 0 {
+		return noSettings, errors.New("LoadBalancing.ConnectionDrainingTimeoutSec must be >= 0")
+	}
+	if err := validateNamedPorts(groupProperties.LoadBalancing.NamedPorts); err != nil {
+		return noSettings, err
+	}
+
+	autoscaling := groupProperties.Autoscaling
+	if autoscaling.MinReplicas < 0 {
+		return noSettings, errors.New("Autoscaling.MinReplicas must be >= 0")
+	}
+	if autoscaling.MaxReplicas < autoscaling.MinReplicas {
+		return noSettings, errors.New("Autoscaling.MaxReplicas must be >= Autoscaling.MinReplicas")
+	}
+	if autoscaling.CPUUtilization < 0 || autoscaling.CPUUtilization > 1 {
+		return noSettings, errors.New("Autoscaling.CPUUtilization must be between 0 and 1")
+	}
+	if autoscaling.enabled() {
+		if p.region != "" {
+			return noSettings, errors.New("Autoscaling is not supported in regional groups")
+		}
+		if autoscaling.CPUUtilization == 0 {
+			autoscaling.CPUUtilization = defaultCPUUtilization
+		}
+	}
+
+	autoHealing := groupProperties.AutoHealing
+	if autoHealing.enabled() {
+		if autoHealing.Protocol != "HTTP" && autoHealing.Protocol != "HTTPS" && autoHealing.Protocol != "TCP" {
+			return noSettings, errors.New("AutoHealing.Protocol must be HTTP, HTTPS or TCP")
+		}
+		if autoHealing.Port == 0 {
+			autoHealing.Port = defaultHealthCheckPorts[autoHealing.Protocol]
+		}
+		if autoHealing.Port < 1 || autoHealing.Port > 65535 {
+			return noSettings, errors.New("AutoHealing.Port must be between 1 and 65535")
+		}
+		if autoHealing.RequestPath == "" && autoHealing.Protocol != "TCP" {
+			autoHealing.RequestPath = "/"
+		}
+		if autoHealing.CheckIntervalSec < 0 || autoHealing.TimeoutSec < 0 || autoHealing.HealthyThreshold < 0 || autoHealing.UnhealthyThreshold < 0 || autoHealing.InitialDelaySec < 0 {
+			return noSettings, errors.New("AutoHealing intervals, timeouts, thresholds and delays must be >= 0")
+		}
+		if p.region != "" {
+			return noSettings, errors.New("AutoHealing is not supported in regional groups")
+		}
+	}
 
 	return settings{
 		spec:               spec,
@@ -118,15 +575,22 @@ func (p *plugin) validate(groupSpec group.Spec) (settings, error) {
 		instanceSpec:       instanceSpec,
 		instanceProperties: instanceProperties,
 		currentTemplate:    1,
+		placement:          placement,
+		updates:            updates,
+		loadBalancing:      groupProperties.LoadBalancing,
+		autoscaling:        autoscaling,
+		autoHealing:        autoHealing,
 	}, nil
 }
 
-// TODO: handle reusing existing group
 func (p *plugin) CommitGroup(config group.Spec, pretend bool) (string, error) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	newSettings, err := p.validate(config)
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	newSettings, err := p.validate(ctx, config)
 	if err != nil {
 		return "", err
 	}
@@ -141,52 +605,184 @@ func (p *plugin) CommitGroup(config group.Spec, pretend bool) (string, error) {
 	createTemplate := false
 	updateManager := false
 	resize := false
+	registerBackends := false
+	setNamedPorts := false
+	setTargetPools := false
+	createAutoscaler := false
+	updateAutoscaler := false
+	deleteAutoscaler := false
+	setAutoHealing := false
+	deleteHealthCheck := false
+	removedBackends := []string{}
 
 	settings, present := p.groups[config.ID]
+	if !present {
+		adopted, found, err := p.adopt(ctx, name, newSettings)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			log.Infof("Adopting existing instance group manager %s", name)
+
+			settings, present = adopted, true
+		}
+	}
+
 	if !present {
 		settings = newSettings
 
 		operations = append(operations, fmt.Sprintf("Managing %d instances", targetSize))
 		createManager = true
 		createTemplate = true
+
+		if settings.placement.Spread {
+			operations = append(operations, placementOperation(settings.placement))
+		}
+
+		if len(settings.loadBalancing.NamedPorts) > 0 {
+			operations = append(operations, "Setting named ports")
+			setNamedPorts = true
+		}
+
+		if len(settings.loadBalancing.BackendServices) > 0 {
+			operations = append(operations, "Adding group to backend services")
+			registerBackends = true
+		}
+
+		if settings.autoscaling.enabled() {
+			operations = append(operations, autoscalingOperation(settings.autoscaling))
+			createAutoscaler = true
+		}
+
+		if settings.autoHealing.enabled() {
+			operations = append(operations, autoHealingOperation(settings.autoHealing))
+			setAutoHealing = true
+		}
 	} else {
-		if !reflect.DeepEqual(settings.instanceProperties, newSettings.instanceProperties) {
+		if !reflect.DeepEqual(settings.loadBalancing.NamedPorts, newSettings.loadBalancing.NamedPorts) {
+			operations = append(operations, "Setting named ports")
+			setNamedPorts = true
+		}
+
+		if !settings.loadBalancing.sameBackends(newSettings.loadBalancing) {
+			operations = append(operations, "Updating backend services")
+			registerBackends = true
+			for _, backendService := range settings.loadBalancing.BackendServices {
+				if !contains(newSettings.loadBalancing.BackendServices, backendService) {
+					removedBackends = append(removedBackends, backendService)
+				}
+			}
+		}
+
+		if settings.placement != newSettings.placement {
+			if newSettings.placement.Spread {
+				operations = append(operations, placementOperation(newSettings.placement))
+			} else {
+				operations = append(operations, "Removing the spread placement policy")
+			}
+			if !pretend {
+				settings.placement = newSettings.placement
+			}
+		}
+
+		// The target pools are set on the group manager, so that changing
+		// them doesn't recreate the instances.
+		if !sameTargetPools(settings.instanceProperties.TargetPools, newSettings.instanceProperties.TargetPools) {
+			operations = append(operations, "Updating target pools")
+			setTargetPools = true
+		}
+
+		if !sameTemplate(settings.instanceProperties, newSettings.instanceProperties) {
 			operations = append(operations, "Updating instance template")
+			if updates := newSettings.updates; updates.MaxSurge > 0 {
+				operations = append(operations, fmt.Sprintf("Replacing instances, with up to %d extra and %d unavailable", updates.MaxSurge, updates.MaxUnavailable))
+			} else if updates.MaxUnavailable > 0 {
+				operations = append(operations, fmt.Sprintf("Recreating instances, %d at a time", updates.MaxUnavailable))
+			}
 			createTemplate = true
+			updateManager = true
 			if !pretend {
 				settings.currentTemplate++
 			}
 		}
 
-		if settings.spec.Allocation.Size != newSettings.spec.Allocation.Size {
+		if settings.autoscaling != newSettings.autoscaling {
+			switch {
+			case !newSettings.autoscaling.enabled():
+				operations = append(operations, "Disabling autoscaling")
+				deleteAutoscaler = true
+			case !settings.autoscaling.enabled():
+				operations = append(operations, autoscalingOperation(newSettings.autoscaling))
+				createAutoscaler = true
+			default:
+				operations = append(operations, autoscalingOperation(newSettings.autoscaling))
+				updateAutoscaler = true
+			}
+		}
+
+		if settings.autoHealing != newSettings.autoHealing {
+			if newSettings.autoHealing.enabled() {
+				operations = append(operations, autoHealingOperation(newSettings.autoHealing))
+				setAutoHealing = true
+			} else {
+				operations = append(operations, "Disabling autohealing")
+				setAutoHealing = true
+				deleteHealthCheck = true
+			}
+		}
+
+		// The autoscaler owns the size of an autoscaled group. The static
+		// size is restored when autoscaling is disabled.
+		if !newSettings.autoscaling.enabled() && (deleteAutoscaler || settings.spec.Allocation.Size != newSettings.spec.Allocation.Size) {
 			operations = append(operations, fmt.Sprintf("Scaling group to %d instance.", targetSize))
 			resize = true
 		}
+
+		if !pretend {
+			settings.spec = newSettings.spec
+			settings.groupSpec = newSettings.groupSpec
+			settings.instanceSpec = newSettings.instanceSpec
+			settings.instanceProperties = newSettings.instanceProperties
+			settings.updates = newSettings.updates
+			settings.loadBalancing = newSettings.loadBalancing
+			settings.autoscaling = newSettings.autoscaling
+			settings.autoHealing = newSettings.autoHealing
+		}
+	}
+
+	if pretend && p.pricing != nil {
+		operations = append(operations, p.estimateCost(settings, present, newSettings))
 	}
 
 	if !pretend {
 		templateName := fmt.Sprintf("%s-%d", name, settings.currentTemplate)
-		settings.createdTemplates = append(settings.createdTemplates, templateName)
+
+		// The placement policy must exist before it's set on the template.
+		if createTemplate && settings.placement.Spread {
+			if err = p.savePlacementPolicy(ctx, placementPolicyName(name, settings.placement), settings.placement); err != nil {
+				return "", err
+			}
+		}
 
 		if createTemplate {
-			spec := settings.instanceSpec
-			settings := settings.instanceProperties.InstanceSettings
+			settings.createdTemplates = append(settings.createdTemplates, templateName)
 
-			// TODO - for now we overwrite, but support merging of MetaData field in the future, if the
-			// user provided some.
-			tags, err := instance_types.ParseTags(spec)
+			version, err := p.createTemplate(ctx, name, templateName, settings.instanceSpec, settings.instanceProperties.InstanceSettings)
 			if err != nil {
 				return "", err
 			}
-			settings.MetaData = gcloud.TagsToMetaData(tags)
+			settings.templateHistory = append(settings.templateHistory, version)
+		}
 
-			if err = p.API.CreateInstanceTemplate(templateName, settings); err != nil {
+		// The health check must exist before it's set on the group manager.
+		if setAutoHealing && settings.autoHealing.enabled() {
+			if err = p.saveHealthCheck(ctx, healthCheckName(name), settings.autoHealing); err != nil {
 				return "", err
 			}
 		}
 
 		if createManager {
-			if err = p.API.CreateInstanceGroupManager(name, &gcloud.InstanceManagerSettings{
+			if err = p.managers().Create(ctx, name, &gcloud.InstanceManagerSettings{
 				TemplateName:     fmt.Sprintf("%s-%d", name, settings.currentTemplate),
 				TargetSize:       targetSize,
 				Description:      settings.instanceProperties.Description,
@@ -197,20 +793,91 @@ func (p *plugin) CommitGroup(config group.Spec, pretend bool) (string, error) {
 			}
 		}
 
+		if setTargetPools {
+			if err = p.managers().SetTargetPools(ctx, name, settings.instanceProperties.TargetPools); err != nil {
+				return "", err
+			}
+		}
+
+		// The backend services refer to the ports by name.
+		if setNamedPorts {
+			if err = p.managers().SetNamedPorts(ctx, name, settings.loadBalancing.NamedPorts); err != nil {
+				return "", err
+			}
+		}
+
+		// Backends are registered before a rolling update, so that the
+		// recreated instances are drained.
+		if registerBackends {
+			for _, backendService := range removedBackends {
+				if err = p.API.RemoveInstanceGroupFromBackendService(ctx, backendService, p.managers().BackendGroup(name)); err != nil {
+					return "", err
+				}
+			}
+			for _, backendService := range settings.loadBalancing.BackendServices {
+				if err = p.API.AddInstanceGroupToBackendService(ctx, backendService, p.managers().BackendGroup(name), settings.loadBalancing.ConnectionDrainingTimeoutSec); err != nil {
+					return "", err
+				}
+			}
+		}
+
 		if updateManager {
-			// TODO: should we trigger a recreation of the VMS
-			// TODO: What about the instances already being updated
-			if err = p.API.SetInstanceTemplate(name, templateName); err != nil {
+			if err = p.managers().SetInstanceTemplate(ctx, name, templateName); err != nil {
+				// The template would otherwise be left behind, unused by the group.
+				if deleteErr := p.API.DeleteInstanceTemplate(ctx, templateName); deleteErr != nil {
+					log.Warningln("Failed to delete unused template", templateName, deleteErr)
+				}
+				return "", err
+			}
+		}
+
+		// The group uses the new template from now on, even if the rest fails.
+		p.groups[config.ID] = settings
+
+		if setAutoHealing {
+			healthCheck := ""
+			if settings.autoHealing.enabled() {
+				healthCheck = healthCheckName(name)
+			}
+			if err = p.API.SetAutoHealingPolicy(ctx, name, healthCheck, settings.autoHealing.InitialDelaySec); err != nil {
+				return "", err
+			}
+		}
+
+		if deleteHealthCheck {
+			if err = p.API.DeleteHealthCheck(ctx, healthCheckName(name)); err != nil && !errors.Is(err, gcloud.ErrNotFound) {
+				return "", err
+			}
+		}
+
+		if createAutoscaler {
+			if err = p.API.CreateAutoscaler(ctx, name, settings.autoscaling.gcloudSettings()); err != nil {
+				return "", err
+			}
+		}
+
+		if updateAutoscaler {
+			if err = p.API.UpdateAutoscaler(ctx, name, settings.autoscaling.gcloudSettings()); err != nil {
+				return "", err
+			}
+		}
+
+		if deleteAutoscaler {
+			if err = p.API.DeleteAutoscaler(ctx, name); err != nil {
 				return "", err
 			}
 		}
 
 		if resize {
-			err := p.API.ResizeInstanceGroupManager(name, targetSize)
+			err := p.managers().Resize(ctx, name, targetSize)
 			if err != nil {
 				return "", err
 			}
 		}
+
+		if updateManager {
+			p.startRollout(config.ID, templateName, settings.updates, settings.loadBalancing)
+		}
 	}
 
 	p.groups[config.ID] = settings
@@ -218,60 +885,655 @@ func (p *plugin) CommitGroup(config group.Spec, pretend bool) (string, error) {
 	return strings.Join(operations, "\n"), nil
 }
 
-func (p *plugin) FreeGroup(id group.ID) error {
-	p.lock.Lock()
-	defer p.lock.Unlock()
-
-	_, present := p.groups[id]
-	if !present {
-		return fmt.Errorf("This group is not being watched: '%s", id)
+// savePlacementPolicy looks up the spread placement policy of a group, and
+// creates it unless it exists.
+func (p *plugin) savePlacementPolicy(ctx context.Context, name string, placement PlacementSettings) error {
+	availabilityDomains, err := p.API.GetSpreadPlacementPolicy(ctx, name)
+	if errors.Is(err, gcloud.ErrNotFound) {
+		return p.API.CreateSpreadPlacementPolicy(ctx, name, placement.AvailabilityDomains)
+	}
+	if err != nil {
+		return err
 	}
 
-	delete(p.groups, id)
+	if availabilityDomains != placement.AvailabilityDomains {
+		return fmt.Errorf("Resource policy '%s' spreads instances across %d availability domains, not %d. Delete it or use another group ID", name, availabilityDomains, placement.AvailabilityDomains)
+	}
 
 	return nil
 }
 
-func (p *plugin) DescribeGroup(id group.ID) (group.Description, error) {
-	noDescription := group.Description{}
+func placementOperation(placement PlacementSettings) string {
+	return fmt.Sprintf("Spreading instances across %d availability domains", placement.AvailabilityDomains)
+}
 
-	p.lock.Lock()
-	defer p.lock.Unlock()
+// startRollout moves the running instances of a group to a new template in
+// the background, replacing the rollout of a previous template.
+func (p *plugin) startRollout(id group.ID, templateName string, updates UpdateSettings, loadBalancing LoadBalancingSettings) {
+	p.stopRollout(id)
 
-	currentSettings, present := p.groups[id]
-	if !present {
-		return noDescription, fmt.Errorf("This group is not being watched: '%s", id)
+	if updates.MaxUnavailable <= 0 && updates.MaxSurge <= 0 {
+		return
 	}
 
-	name := string(id)
+	ctx, cancel := context.WithTimeout(context.Background(), rolloutTimeout)
+	current := &rollout{cancel: cancel, done: make(chan struct{})}
+	p.rollouts[id] = current
 
-	instanceGroupInstances, err := p.API.ListInstanceGroupInstances(name)
-	if err != nil {
-		return noDescription, err
-	}
+	go func() {
+		defer close(current.done)
+		defer cancel()
 
-	instances := []instance.Description{}
+		if err := p.rollingUpdate(ctx, string(id), templateName, updates, loadBalancing); err != nil {
+			log.Warningln("Failed to roll out template", templateName, "to group", id, err)
+			return
+		}
+
+		log.Infoln("Rolled out template", templateName, "to group", id)
+	}()
+}
+
+// stopRollout cancels the rollout of a group, if any, and waits for it to stop.
+func (p *plugin) stopRollout(id group.ID) {
+	if current, present := p.rollouts[id]; present {
+		current.cancel()
+		<-current.done
+		delete(p.rollouts, id)
+	}
+}
+
+// adopt looks for an existing instance group manager, for example one created
+// before the plugin was restarted, and returns the settings of the group with
+// its current size and the history of its templates. Unless the current
+// template was created by the plugin with the same instance settings, the
+// instance properties are left out so that CommitGroup rolls out a new one.
+func (p *plugin) adopt(ctx context.Context, name string, newSettings settings) (settings, bool, error) {
+	groupManager, err := p.managers().Get(ctx, name)
+	if errors.Is(err, gcloud.ErrNotFound) {
+		return settings{}, false, nil
+	}
+	if err != nil {
+		return settings{}, false, err
+	}
+
+	adopted := newSettings
+	adopted.spec.Allocation.Size = uint(groupManager.TargetSize)
+	adopted.currentTemplate = 0
+	adopted.createdTemplates = nil
+	adopted.templateHistory = nil
+	adopted.autoscaling = AutoscalingSettings{}
+	adopted.loadBalancing.NamedPorts = nil
+	for _, port := range groupManager.NamedPorts {
+		adopted.loadBalancing.NamedPorts = append(adopted.loadBalancing.NamedPorts, gcloud.NamedPort{Name: port.Name, Port: port.Port})
+	}
+
+	if p.region == "" {
+		autoscaler, err := p.API.GetAutoscaler(ctx, name)
+		if err != nil && !errors.Is(err, gcloud.ErrNotFound) {
+			return settings{}, false, err
+		}
+		if err == nil && autoscaler.AutoscalingPolicy != nil {
+			policy := autoscaler.AutoscalingPolicy
+			adopted.autoscaling = AutoscalingSettings{
+				MinReplicas: policy.MinNumReplicas,
+				MaxReplicas: policy.MaxNumReplicas,
+			}
+			if policy.CpuUtilization != nil {
+				adopted.autoscaling.CPUUtilization = policy.CpuUtilization.UtilizationTarget
+			}
+		}
+	}
+
+	// Templates named after the group, with a version in their description,
+	// were created by the plugin.
+	templates, err := p.API.ListInstanceTemplates(ctx, templateFilter(name))
+	if err != nil {
+		return settings{}, false, err
+	}
+
+	sequences := map[string]int{}
+	for _, template := range templates {
+		sequence, err := strconv.Atoi(strings.TrimPrefix(template.Name, name+"-"))
+		if err != nil {
+			continue
+		}
+		// The next template must not reuse the name of an existing one.
+		if sequence > adopted.currentTemplate {
+			adopted.currentTemplate = sequence
+		}
+
+		if version, ok := parseTemplateVersion(template.Description); ok {
+			sequences[template.Name] = sequence
+			adopted.createdTemplates = append(adopted.createdTemplates, template.Name)
+			adopted.templateHistory = append(adopted.templateHistory, version)
+		}
+	}
+	sort.Slice(adopted.createdTemplates, func(i, j int) bool {
+		return sequences[adopted.createdTemplates[i]] < sequences[adopted.createdTemplates[j]]
+	})
+	sort.Slice(adopted.templateHistory, func(i, j int) bool {
+		return sequences[adopted.templateHistory[i].Name] < sequences[adopted.templateHistory[j].Name]
+	})
+
+	current, err := p.API.GetInstanceTemplate(ctx, last(groupManager.InstanceTemplate))
+	if err != nil {
+		return settings{}, false, err
+	}
+
+	instanceSettings, err := newTemplateSettings(name, newSettings.instanceSpec, newSettings.instanceProperties.InstanceSettings)
+	if err != nil {
+		return settings{}, false, err
+	}
+
+	version, ok := parseTemplateVersion(current.Description)
+	if !ok || version.Digest != newTemplateVersion(current.Name, instanceSettings).Digest {
+		log.Infof("The template %s of group %s doesn't match its spec", current.Name, name)
 
+		adopted.instanceProperties = instance_types.Properties{}
+	}
+
+	// The target pools belong to the group manager rather than to its
+	// template, so they are those the manager has live.
+	adopted.instanceProperties.TargetPools = nil
+	for _, targetPool := range groupManager.TargetPools {
+		adopted.instanceProperties.TargetPools = append(adopted.instanceProperties.TargetPools, last(targetPool))
+	}
+
+	return adopted, true, nil
+}
+
+// saveHealthCheck creates the health check of a group, or updates it when it
+// already exists, for example when a group is adopted.
+func (p *plugin) saveHealthCheck(ctx context.Context, name string, autoHealing AutoHealingSettings) error {
+	err := p.API.CreateHealthCheck(ctx, name, autoHealing.gcloudSettings())
+	if errors.Is(err, gcloud.ErrAlreadyExists) {
+		return p.API.UpdateHealthCheck(ctx, name, autoHealing.gcloudSettings())
+	}
+
+	return err
+}
+
+// sameTemplate tells if two instance properties make the same instance
+// template. The target pools belong to the group manager instead.
+func sameTemplate(properties, other instance_types.Properties) bool {
+	properties.TargetPools = nil
+	other.TargetPools = nil
+	return reflect.DeepEqual(properties, other)
+}
+
+// sameTargetPools tells if two lists of target pools, given by name or by
+// url, are the same.
+func sameTargetPools(targetPools, other []string) bool {
+	if len(targetPools) != len(other) {
+		return false
+	}
+	for i := range targetPools {
+		if last(targetPools[i]) != last(other[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func autoHealingOperation(autoHealing AutoHealingSettings) string {
+	return fmt.Sprintf("Autohealing with a %s health check on port %d", autoHealing.Protocol, autoHealing.Port)
+}
+
+func autoscalingOperation(autoscaling AutoscalingSettings) string {
+	return fmt.Sprintf("Autoscaling between %d and %d instances at %.0f%% CPU utilization", autoscaling.MinReplicas, autoscaling.MaxReplicas, autoscaling.CPUUtilization*100)
+}
+
+// rollingUpdate moves the instances of a group to a template. With a surge,
+// the instance group manager replaces them. Otherwise, they are recreated
+// MaxUnavailable instances at a time, waiting for the group to be stable
+// between batches. The backend services drain the recreated instances, so
+// each batch is given the connection draining timeout on top of the usual
+// request timeout.
+func (p *plugin) rollingUpdate(ctx context.Context, name string, templateName string, updates UpdateSettings, loadBalancing LoadBalancingSettings) error {
+	if updates.MaxSurge > 0 {
+		if err := p.API.StartRollingUpdate(ctx, name, templateName, updates.MaxSurge, updates.MaxUnavailable); err != nil {
+			return err
+		}
+		return p.waitUntilStable(ctx, name)
+	}
+
+	instanceGroupInstances, err := p.managers().ListInstances(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	instances := []string{}
 	for _, grpInst := range instanceGroupInstances {
-		name := last(grpInst.Instance)
+		instances = append(instances, grpInst.Instance)
+	}
+
+	batchTimeout := p.requestTimeout()
+	if len(loadBalancing.BackendServices) > 0 {
+		batchTimeout += time.Duration(loadBalancing.ConnectionDrainingTimeoutSec) * time.Second
+	}
+
+	for start := 0; start < len(instances); start += updates.MaxUnavailable {
+		end := start + updates.MaxUnavailable
+		if end > len(instances) {
+			end = len(instances)
+		}
+
+		log.Infoln("Recreating instances", instances[start:end])
+
+		if err = p.recreateBatch(ctx, name, instances[start:end], batchTimeout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recreateBatch recreates instances of a group and waits for the group to be
+// stable again, within a timeout.
+func (p *plugin) recreateBatch(ctx context.Context, name string, instances []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := p.managers().RecreateInstances(ctx, name, instances...); err != nil {
+		var opErr *gcloud.OperationError
+		if errors.As(err, &opErr) {
+			for instance, message := range opErr.Instances() {
+				log.Warnln("Failed to recreate instance", instance, ":", message)
+			}
+		}
+		return err
+	}
+
+	return p.waitUntilStable(ctx, name)
+}
+
+// waitUntilStable waits until no instance of a group has a pending action.
+func (p *plugin) waitUntilStable(ctx context.Context, name string) error {
+	for {
+		groupManager, err := p.managers().Get(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		if groupManager.CurrentActions == nil || groupManager.CurrentActions.None == groupManager.TargetSize {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Group %s is not stable: %v", name, ctx.Err())
+		case <-time.After(p.pollInterval):
+		}
+	}
+}
+
+// createTemplate creates an instance template and returns its version. The
+// version is recorded in the template's description, prefixed with the
+// templateMarker, so that the history of a group outlives the plugin.
+func (p *plugin) createTemplate(ctx context.Context, name, templateName string, spec instance.Spec, instanceSettings *gcloud.InstanceSettings) (TemplateVersion, error) {
+	templateSettings, err := newTemplateSettings(name, spec, instanceSettings)
+	if err != nil {
+		return TemplateVersion{}, err
+	}
+
+	version := newTemplateVersion(templateName, templateSettings)
+	encoded, err := json.Marshal(version)
+	if err != nil {
+		return TemplateVersion{}, err
+	}
+
+	templateSettings.TemplateDescription = templateMarker + string(encoded)
+
+	err = p.API.CreateInstanceTemplate(ctx, templateName, templateSettings)
+	if errors.Is(err, gcloud.ErrAlreadyExists) {
+		return TemplateVersion{}, fmt.Errorf("Instance template '%s' already exists. Delete it or use another group ID", templateName)
+	}
+
+	return version, err
+}
+
+// newTemplateSettings returns the settings of the instance template of a group,
+// with the instance tags of the spec as metadata, the network tags added by the
+// flavor and the group label.
+func newTemplateSettings(name string, spec instance.Spec, instanceSettings *gcloud.InstanceSettings) (*gcloud.InstanceSettings, error) {
+	// TODO - for now we overwrite, but support merging of MetaData field in the future, if the
+	// user provided some.
+	tags, err := instance_types.ParseTags(spec)
+	if err != nil {
+		return nil, err
+	}
+	if tags[GroupTag] != "" && tags[GroupTag] != name {
+		log.Warningln("Tag", GroupTag, "is reserved, replacing", tags[GroupTag], "with", name)
+	}
+	tags[GroupTag] = name
+
+	// The settings are those of the group, which must not change.
+	copied := *instanceSettings
+	copied.MetaData = gcloud.TagsToMetaData(tags)
+
+	if err = gcloud.ValidateMetaData(copied.MetaData); err != nil {
+		return nil, err
+	}
+
+	copied.Tags = instance_types.NetworkTags(spec, instanceSettings.Tags)
+	copied.Labels = map[string]string{}
+	for key, value := range instanceSettings.Labels {
+		copied.Labels[key] = value
+	}
+	if copied.Labels[GroupLabel] != "" && copied.Labels[GroupLabel] != name {
+		log.Warningln("Label", GroupLabel, "is reserved, replacing", copied.Labels[GroupLabel], "with", name)
+	}
+	copied.Labels[GroupLabel] = name
+	if err = gcloud.ValidateLabels(copied.Labels); err != nil {
+		return nil, err
+	}
+
+	return &copied, nil
+}
+
+func newTemplateVersion(templateName string, instanceSettings *gcloud.InstanceSettings) TemplateVersion {
+	version := TemplateVersion{
+		Name:        templateName,
+		MachineType: instanceSettings.MachineType,
+		Tags:        append([]string{}, instanceSettings.Tags...),
+		Created:     now().UTC(),
+	}
+	if len(instanceSettings.Disks) > 0 {
+		version.Image = instanceSettings.Disks[0].Image
+	}
+	if encoded, err := json.Marshal(instanceSettings); err == nil {
+		version.Digest = fmt.Sprintf("%x", sha256.Sum256(encoded))
+	}
+
+	return version
+}
+
+// parseTemplateVersion reads the version recorded in the description of a
+// template created by the plugin.
+func parseTemplateVersion(description string) (TemplateVersion, bool) {
+	if !strings.HasPrefix(description, templateMarker) {
+		return TemplateVersion{}, false
+	}
+
+	version := TemplateVersion{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(description, templateMarker)), &version); err != nil {
+		return TemplateVersion{}, false
+	}
+
+	return version, true
+}
+
+func (p *plugin) GetTemplateHistory(id group.ID) ([]TemplateVersion, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	settings, present := p.groups[id]
+	if !present {
+		return nil, fmt.Errorf("This group is not being watched: '%s", id)
+	}
+
+	history := []TemplateVersion{}
+	for _, version := range settings.templateHistory {
+		version.Tags = append([]string{}, version.Tags...)
+		history = append(history, version)
+	}
+
+	return history, nil
+}
+
+func (p *plugin) AddNetworkTag(id group.ID, tag string) error {
+	return p.updateNetworkTags(id, func(tags []string) []string {
+		for _, existing := range tags {
+			if existing == tag {
+				return tags
+			}
+		}
+
+		return append(append([]string{}, tags...), tag)
+	})
+}
+
+func (p *plugin) RemoveNetworkTag(id group.ID, tag string) error {
+	return p.updateNetworkTags(id, func(tags []string) []string {
+		kept := []string{}
+		for _, existing := range tags {
+			if existing != tag {
+				kept = append(kept, existing)
+			}
+		}
+
+		return kept
+	})
+}
+
+// updateNetworkTags applies a change to the network tags of every instance of
+// a group and creates a new template version so that future instances get the
+// same tags.
+func (p *plugin) updateNetworkTags(id group.ID, update func([]string) []string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	settings, present := p.groups[id]
+	if !present {
+		return fmt.Errorf("This group is not being watched: '%s", id)
+	}
+
+	// The tags of the instances are set in the zone of the plugin.
+	if p.region != "" {
+		return errors.New("Network tags can't be updated in place in a regional group")
+	}
+
+	name := string(id)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	instanceGroupInstances, err := p.managers().ListInstances(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	instanceNames := make([]string, len(instanceGroupInstances))
+	for i, grpInst := range instanceGroupInstances {
+		instanceNames[i] = last(grpInst.Instance)
+	}
+
+	found, failures, err := p.API.BatchGetInstances(ctx, instanceNames)
+	if err != nil {
+		return err
+	}
+
+	for _, instanceName := range instanceNames {
+		if err := failures[instanceName]; errors.Is(err, gcloud.ErrNotFound) {
+			log.Debugln("Instance", instanceName, "was deleted, its network tags are left as is")
+			continue
+		} else if err != nil {
+			return err
+		}
+		inst := found[instanceName]
+
+		current := []string{}
+		if inst.Tags != nil {
+			current = inst.Tags.Items
+		}
+
+		updated := update(current)
+		if sameTags(current, updated) {
+			continue
+		}
+
+		log.Debugln("Setting network tags", updated, "on", instanceName)
+
+		if err = p.API.SetInstanceTags(ctx, instanceName, updated); err != nil {
+			return err
+		}
+	}
+
+	instanceSettings := *settings.instanceProperties.InstanceSettings
+	instanceSettings.Tags = update(instanceSettings.Tags)
+	if sameTags(instanceSettings.Tags, settings.instanceProperties.Tags) {
+		return nil
+	}
+
+	settings.currentTemplate++
+	templateName := fmt.Sprintf("%s-%d", name, settings.currentTemplate)
+
+	version, err := p.createTemplate(ctx, name, templateName, settings.instanceSpec, &instanceSettings)
+	if err != nil {
+		return err
+	}
+
+	if err = p.managers().SetInstanceTemplate(ctx, name, templateName); err != nil {
+		// The template would otherwise be left behind, unused by the group.
+		if deleteErr := p.API.DeleteInstanceTemplate(ctx, templateName); deleteErr != nil {
+			log.Warningln("Failed to delete unused template", templateName, deleteErr)
+		}
+		return err
+	}
+	settings.createdTemplates = append(settings.createdTemplates, templateName)
+	settings.templateHistory = append(settings.templateHistory, version)
+
+	settings.instanceProperties.InstanceSettings = &instanceSettings
+	p.groups[id] = settings
+
+	return nil
+}
+
+func (p *plugin) ResizeProgress(id group.ID) (ResizeProgress, error) {
+	p.lock.Lock()
+	_, present := p.groups[id]
+	p.lock.Unlock()
+
+	if !present {
+		return ResizeProgress{}, fmt.Errorf("This group is not being watched: '%s", id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	groupManager, err := p.managers().Get(ctx, string(id))
+	if err != nil {
+		return ResizeProgress{}, err
+	}
+
+	progress := ResizeProgress{
+		Target: groupManager.TargetSize,
+	}
+	if groupManager.CurrentActions != nil {
+		progress.Current = groupManager.CurrentActions.None
+	}
+
+	return progress, nil
+}
+
+func (p *plugin) FreeGroup(id group.ID) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	_, present := p.groups[id]
+	if !present {
+		return fmt.Errorf("This group is not being watched: '%s", id)
+	}
+
+	delete(p.groups, id)
+
+	return nil
+}
+
+func (p *plugin) DescribeGroup(id group.ID) (group.Description, error) {
+	noDescription := group.Description{}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	currentSettings, present := p.groups[id]
+	if !present {
+		return noDescription, fmt.Errorf("This group is not being watched: '%s", id)
+	}
+
+	name := string(id)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	instanceGroupInstances, err := p.managers().ListInstances(ctx, name)
+	if err != nil {
+		return noDescription, err
+	}
 
-		inst, err := p.API.GetInstance(name)
+	// The autoscaler resizes the group manager of an autoscaled group.
+	targetSize := int64(currentSettings.spec.Allocation.Size)
+	if currentSettings.autoscaling.enabled() {
+		groupManager, err := p.managers().Get(ctx, name)
 		if err != nil {
 			return noDescription, err
 		}
+		targetSize = groupManager.TargetSize
+	}
 
-		instances = append(instances, instance.Description{
-			ID:   instance.ID(inst.Name),
-			Tags: gcloud.MetaDataToTags(inst.Metadata.Items),
-		})
+	managedInstances, err := p.managers().ListManagedInstances(ctx, name)
+	if err != nil {
+		return noDescription, err
+	}
+
+	instances, err := p.describeInstances(ctx, instanceGroupInstances, managedInstances)
+	if err != nil {
+		return noDescription, err
 	}
 
 	return group.Description{
-		Converged: len(instanceGroupInstances) == int(currentSettings.spec.Allocation.Size),
+		Converged: int64(len(instances)) == targetSize,
 		Instances: instances,
 	}, nil
 }
 
+// describeInstances fetches the instances of a group. The descriptions are in
+// the order of the members of the group, and are tagged with what the group
+// manager reports about each instance. The members deleted since the group
+// was listed are left out, and the first other member that couldn't be
+// fetched fails the description.
+func (p *plugin) describeInstances(ctx context.Context, members []*compute.InstanceWithNamedPorts, managedInstances []*compute.ManagedInstance) ([]instance.Description, error) {
+	managed := map[string]*compute.ManagedInstance{}
+	for _, managedInstance := range managedInstances {
+		managed[last(managedInstance.Instance)] = managedInstance
+	}
+
+	instanceURLs := make([]string, len(members))
+	for i, member := range members {
+		instanceURLs[i] = member.Instance
+	}
+
+	found, failures, err := p.managers().GetInstances(ctx, instanceURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := []instance.Description{}
+	for _, instanceURL := range instanceURLs {
+		if err := failures[instanceURL]; errors.Is(err, gcloud.ErrNotFound) {
+			log.Debugln("Instance", last(instanceURL), "was deleted while the group was described")
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		inst := found[instanceURL]
+
+		tags := gcloud.MetaDataToTagsWithout(inst.Metadata.Items, gcloud.ReservedMetaDataKeys)
+		if managedInstance, present := managed[inst.Name]; present {
+			tags[CurrentActionTag] = managedInstance.CurrentAction
+			tags[InstanceStatusTag] = managedInstance.InstanceStatus
+		}
+
+		instances = append(instances, instance.Description{
+			ID:   instance.ID(inst.Name),
+			Tags: tags,
+		})
+	}
+
+	return instances, nil
+}
+
 func (p *plugin) DestroyGroup(id group.ID) error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -281,14 +1543,66 @@ func (p *plugin) DestroyGroup(id group.ID) error {
 		return fmt.Errorf("This group is not being watched: '%s", id)
 	}
 
+	p.stopRollout(id)
+
 	name := string(id)
 
-	if err := p.API.DeleteInstanceGroupManager(name); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	// A group can't be deleted while it's the backend of a backend service.
+	for _, backendService := range currentSettings.loadBalancing.BackendServices {
+		err := p.API.RemoveInstanceGroupFromBackendService(ctx, backendService, p.managers().BackendGroup(name))
+		if err != nil && !errors.Is(err, gcloud.ErrNotFound) {
+			return err
+		}
+	}
+
+	// A group can't be deleted while it has an autoscaler.
+	if p.groups[id].autoscaling.enabled() {
+		err := p.API.DeleteAutoscaler(ctx, name)
+		if err != nil && !errors.Is(err, gcloud.ErrNotFound) {
+			return err
+		}
+	}
+
+	if err := p.managers().Delete(ctx, name); err != nil {
 		return err
 	}
 
-	for _, createdTemplate := range currentSettings.createdTemplates {
-		if err := p.API.DeleteInstanceTemplate(createdTemplate); err != nil {
+	// The health check can only be deleted once the group manager is gone.
+	if p.groups[id].autoHealing.enabled() {
+		err := p.API.DeleteHealthCheck(ctx, healthCheckName(name))
+		if err != nil && !errors.Is(err, gcloud.ErrNotFound) {
+			return err
+		}
+	}
+
+	// The templates created by the plugin are found by name and description
+	// rather than remembered, since they may predate a restart.
+	templates, err := p.API.ListInstanceTemplates(ctx, templateFilter(name))
+	if err != nil {
+		return err
+	}
+
+	for _, template := range templates {
+		if _, ok := parseTemplateVersion(template.Description); !ok {
+			continue
+		}
+
+		err := p.API.DeleteInstanceTemplate(ctx, template.Name)
+		if errors.Is(err, gcloud.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// The placement policy can only be deleted once the templates are gone.
+	if placement := currentSettings.placement; placement.Spread {
+		err := p.API.DeleteResourcePolicy(ctx, placementPolicyName(name, placement))
+		if err != nil && !errors.Is(err, gcloud.ErrNotFound) {
 			return err
 		}
 	}
@@ -298,6 +1612,12 @@ func (p *plugin) DestroyGroup(id group.ID) error {
 	return nil
 }
 
+// templateFilter matches the names of the instance templates created for a
+// group: <group>-<sequence>.
+func templateFilter(name string) string {
+	return fmt.Sprintf("name eq %s-[0-9]+", regexp.QuoteMeta(name))
+}
+
 func (p *plugin) InspectGroups() ([]group.Spec, error) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
@@ -310,6 +1630,29 @@ func (p *plugin) InspectGroups() ([]group.Spec, error) {
 	return specs, nil
 }
 
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 func last(url string) string {
 	parts := strings.Split(url, "/")
 	return parts[len(parts)-1]
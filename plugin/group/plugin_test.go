@@ -0,0 +1,1834 @@
+package group
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	mock_flavor "github.com/docker/infrakit.gcp/mock/flavor"
+	mock_gcloud "github.com/docker/infrakit.gcp/mock/gcloud"
+	"github.com/docker/infrakit.gcp/plugin/gcloud"
+	"github.com/docker/infrakit.gcp/plugin/gcloud/fake"
+	instance_types "github.com/docker/infrakit.gcp/plugin/instance/types"
+	"github.com/docker/infrakit.gcp/plugin/internal/testutil"
+	plugin_base "github.com/docker/infrakit/pkg/plugin"
+	group_plugin "github.com/docker/infrakit/pkg/plugin/group"
+	"github.com/docker/infrakit/pkg/spi/flavor"
+	"github.com/docker/infrakit/pkg/spi/group"
+	"github.com/docker/infrakit/pkg/spi/instance"
+	"github.com/docker/infrakit/pkg/types"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func NewMockGCloud(t *testing.T) (*mock_gcloud.MockAPI, *gomock.Controller) {
+	ctrl := gomock.NewController(t)
+	return mock_gcloud.NewMockAPI(ctrl), ctrl
+}
+
+func NewPlugin(api gcloud.API, groups map[group.ID]settings) *plugin {
+	return &plugin{API: api, groups: groups, rollouts: map[group.ID]*rollout{}}
+}
+
+func watchedGroup(tags ...string) map[group.ID]settings {
+	return map[group.ID]settings{
+		"workers": {
+			instanceProperties: instance_types.Properties{
+				InstanceSettings: &gcloud.InstanceSettings{
+					MachineType: "g1-small",
+					Tags:        tags,
+				},
+			},
+			currentTemplate:  1,
+			createdTemplates: []string{"workers-1"},
+		},
+	}
+}
+
+func TestAddNetworkTag(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return([]*compute.InstanceWithNamedPorts{
+		{Instance: "zones/z/instances/workers-a"},
+		{Instance: "zones/z/instances/workers-b"},
+	}, nil)
+	api.EXPECT().BatchGetInstances(gomock.Any(), []string{"workers-a", "workers-b"}).Return(map[string]*compute.Instance{
+		"workers-a": {Tags: &compute.Tags{Items: []string{"web"}}},
+		"workers-b": {Tags: &compute.Tags{Items: []string{"web", "maintenance"}}},
+	}, map[string]error{}, nil)
+	api.EXPECT().SetInstanceTags(gomock.Any(), "workers-a", []string{"web", "maintenance"}).Return(nil)
+	api.EXPECT().CreateInstanceTemplate(gomock.Any(), "workers-2", gomock.Any()).Return(nil)
+	api.EXPECT().SetInstanceTemplate(gomock.Any(), "workers", "workers-2").Return(nil)
+
+	plugin := NewPlugin(api, watchedGroup("web"))
+	err := plugin.AddNetworkTag("workers", "maintenance")
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"web", "maintenance"}, plugin.groups["workers"].instanceProperties.Tags)
+	require.Equal(t, []string{"workers-1", "workers-2"}, plugin.groups["workers"].createdTemplates)
+}
+
+func TestAddNetworkTagSkipsDeletedInstance(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return([]*compute.InstanceWithNamedPorts{
+		{Instance: "zones/z/instances/workers-a"},
+		{Instance: "zones/z/instances/workers-b"},
+	}, nil)
+	api.EXPECT().BatchGetInstances(gomock.Any(), []string{"workers-a", "workers-b"}).Return(map[string]*compute.Instance{
+		"workers-b": {Tags: &compute.Tags{Items: []string{"web"}}},
+	}, map[string]error{"workers-a": fmt.Errorf("Instance workers-a: %w", gcloud.ErrNotFound)}, nil)
+	api.EXPECT().SetInstanceTags(gomock.Any(), "workers-b", []string{"web", "maintenance"}).Return(nil)
+	api.EXPECT().CreateInstanceTemplate(gomock.Any(), "workers-2", gomock.Any()).Return(nil)
+	api.EXPECT().SetInstanceTemplate(gomock.Any(), "workers", "workers-2").Return(nil)
+
+	plugin := NewPlugin(api, watchedGroup("web"))
+	err := plugin.AddNetworkTag("workers", "maintenance")
+
+	require.NoError(t, err)
+}
+
+func TestRemoveNetworkTag(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return([]*compute.InstanceWithNamedPorts{
+		{Instance: "zones/z/instances/workers-a"},
+	}, nil)
+	api.EXPECT().BatchGetInstances(gomock.Any(), []string{"workers-a"}).Return(map[string]*compute.Instance{
+		"workers-a": {Tags: &compute.Tags{Items: []string{"web", "maintenance"}}},
+	}, map[string]error{}, nil)
+	api.EXPECT().SetInstanceTags(gomock.Any(), "workers-a", []string{"web"}).Return(nil)
+	api.EXPECT().CreateInstanceTemplate(gomock.Any(), "workers-2", gomock.Any()).Return(nil)
+	api.EXPECT().SetInstanceTemplate(gomock.Any(), "workers", "workers-2").Return(nil)
+
+	plugin := NewPlugin(api, watchedGroup("web", "maintenance"))
+	err := plugin.RemoveNetworkTag("workers", "maintenance")
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"web"}, plugin.groups["workers"].instanceProperties.Tags)
+}
+
+func TestAddNetworkTagDeletesUnusedTemplate(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return([]*compute.InstanceWithNamedPorts{}, nil)
+	api.EXPECT().BatchGetInstances(gomock.Any(), []string{}).Return(map[string]*compute.Instance{}, map[string]error{}, nil)
+	api.EXPECT().CreateInstanceTemplate(gomock.Any(), "workers-2", gomock.Any()).Return(nil)
+	api.EXPECT().SetInstanceTemplate(gomock.Any(), "workers", "workers-2").Return(errors.New("BUG"))
+	api.EXPECT().DeleteInstanceTemplate(gomock.Any(), "workers-2").Return(nil)
+
+	plugin := NewPlugin(api, watchedGroup("web"))
+	err := plugin.AddNetworkTag("workers", "maintenance")
+
+	require.EqualError(t, err, "BUG")
+	require.Equal(t, []string{"web"}, plugin.groups["workers"].instanceProperties.Tags)
+	require.Equal(t, []string{"workers-1"}, plugin.groups["workers"].createdTemplates)
+}
+
+func TestAddNetworkTagUnknownGroup(t *testing.T) {
+	plugin := NewPlugin(nil, map[group.ID]settings{})
+	err := plugin.AddNetworkTag("unknown", "maintenance")
+
+	require.EqualError(t, err, "This group is not being watched: 'unknown")
+}
+
+func TestCommitGroupAgainstEndpoint(t *testing.T) {
+	var template compute.InstanceTemplate
+	var manager compute.InstanceGroupManager
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroups/workers/listInstances", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.InstanceGroupsListInstances{})
+	})
+	mux.HandleFunc("/PROJECT/global/instanceTemplates", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&template))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroupManagers", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&manager))
+		testutil.ReplyJSON(t, w, &compute.Operation{Name: "op", Status: "DONE"})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(`{"NamePrefix":"workers", "MachineType":"n1-standard-1"}`),
+	}, nil)
+	flavorLookup := func(name plugin_base.Name) (flavor.Plugin, error) {
+		if name.String() != "flavor" {
+			return nil, errors.New("Unknown flavor")
+		}
+		return flavorPlugin, nil
+	}
+
+	groupPlugin := NewGCEGroupPlugin("PROJECT", "us-central1-f", flavorLookup,
+		gcloud.WithEndpoint(server.URL),
+		gcloud.WithHTTPClient(server.Client()))
+	_, err := groupPlugin.CommitGroup(group.Spec{
+		ID: "workers",
+		Properties: types.AnyString(`{
+			"Allocation": {"Size": 2},
+			"Instance": {"Properties": {}},
+			"Flavor": {"Plugin": "flavor", "Properties": {}}
+		}`),
+	}, false)
+
+	require.NoError(t, err)
+	require.Equal(t, "workers-1", template.Name)
+	require.Equal(t, "n1-standard-1", template.Properties.MachineType)
+	require.Equal(t, "workers", manager.Name)
+	require.Equal(t, "workers", manager.BaseInstanceName)
+	require.Equal(t, int64(2), manager.TargetSize)
+	require.Equal(t, "projects/PROJECT/global/instanceTemplates/workers-1", manager.InstanceTemplate)
+}
+
+func NewFlavorLookup(t *testing.T, ctrl *gomock.Controller, properties string) group_plugin.FlavorPluginLookup {
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(properties),
+	}, nil)
+
+	return func(name plugin_base.Name) (flavor.Plugin, error) {
+		return flavorPlugin, nil
+	}
+}
+
+var workersSpec = group.Spec{
+	ID: "workers",
+	Properties: types.AnyString(`{
+		"Allocation": {"Size": 2},
+		"Instance": {"Properties": {}},
+		"Flavor": {"Plugin": "flavor", "Properties": {}}
+	}`),
+}
+
+func TestCommitGroupFromSourceInstance(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+	require.NoError(t, api.CreateInstance(context.Background(), "golden-vm", &gcloud.InstanceSettings{
+		MachineType: "n1-highmem-2",
+		Disks:       []gcloud.DiskSettings{{Boot: true, SizeGb: 50, Image: "golden-image", Type: "pd-ssd"}},
+	}))
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers", "SourceInstance":"golden-vm"}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+
+	require.NoError(t, err)
+	require.Equal(t, "golden-vm", plugin.groups["workers"].instanceProperties.SourceInstance)
+
+	template, present := api.Template("workers-1")
+	require.True(t, present)
+	require.Equal(t, "golden-vm", template.SourceInstance)
+}
+
+func TestCommitGroupFromUnknownSourceInstance(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"SourceInstance":"golden-vm"}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+
+	require.EqualError(t, err, "Invalid source instance 'golden-vm': The resource 'projects/PROJECT/zones/us-central1-f/instances/golden-vm' was not found")
+	require.Empty(t, plugin.groups)
+	require.Empty(t, api.Templates())
+}
+
+func TestCommitGroupWithNetworkTagsFromFlavor(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Tags:       map[string]string{"role": "worker", "infrakit-network-tags": "swarm"},
+		Properties: types.AnyString(`{"NamePrefix":"workers", "Tags":["web"]}`),
+	}, nil)
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = func(name plugin_base.Name) (flavor.Plugin, error) {
+		return flavorPlugin, nil
+	}
+	_, err := plugin.CommitGroup(workersSpec, false)
+
+	require.NoError(t, err)
+
+	template, present := api.Template("workers-1")
+	require.True(t, present)
+	require.Equal(t, []string{"web", "swarm"}, template.Tags)
+	require.Equal(t, map[string]string{"role": "worker", "infrakit-gcp-version": "1", "infrakit.group": "workers"}, gcloud.MetaDataToTags(template.MetaData))
+}
+
+func TestCommitGroupLabelsInstances(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers", "Labels":{"team":"infra"}}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"team": "infra"}, plugin.groups["workers"].instanceProperties.Labels)
+
+	members, err := api.ListInstanceGroupInstances(context.Background(), "workers")
+	require.NoError(t, err)
+	require.NotEmpty(t, members)
+	for _, member := range members {
+		require.Equal(t, map[string]string{"infrakit-group": "workers", "team": "infra"}, api.Labels(path.Base(member.Instance)))
+	}
+}
+
+func TestCommitGroupReservesGroupTagAndLabel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Tags:       map[string]string{"infrakit.group": "managers"},
+		Properties: types.AnyString(`{"NamePrefix":"workers", "Labels":{"infrakit-group":"managers"}}`),
+	}, nil)
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = func(name plugin_base.Name) (flavor.Plugin, error) {
+		return flavorPlugin, nil
+	}
+	_, err := plugin.CommitGroup(workersSpec, false)
+
+	require.NoError(t, err)
+
+	template, present := api.Template("workers-1")
+	require.True(t, present)
+	require.Equal(t, "workers", gcloud.MetaDataToTags(template.MetaData)["infrakit.group"])
+	require.Equal(t, map[string]string{"infrakit-group": "workers"}, template.Labels)
+}
+
+func TestDetectOrphans(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, api.CreateInstance(ctx, "workers-leaked", &gcloud.InstanceSettings{Labels: map[string]string{"infrakit-group": "workers"}}))
+	require.NoError(t, api.CreateInstance(ctx, "managers-1", &gcloud.InstanceSettings{Labels: map[string]string{"infrakit-group": "managers"}}))
+	require.NoError(t, api.CreateInstance(ctx, "pet", &gcloud.InstanceSettings{}))
+
+	orphans, err := plugin.DetectOrphans("workers", false)
+
+	require.NoError(t, err)
+	require.Equal(t, []instance.ID{"workers-leaked"}, orphans)
+	_, err = api.GetInstance(ctx, "workers-leaked")
+	require.NoError(t, err)
+
+	orphans, err = plugin.DetectOrphans("workers", true)
+
+	require.NoError(t, err)
+	require.Equal(t, []instance.ID{"workers-leaked"}, orphans)
+	_, err = api.GetInstance(ctx, "workers-leaked")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+
+	members, err := api.ListInstanceGroupInstances(ctx, "workers")
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	_, err = api.GetInstance(ctx, "managers-1")
+	require.NoError(t, err)
+
+	orphans, err = plugin.DetectOrphans("workers", false)
+
+	require.NoError(t, err)
+	require.Empty(t, orphans)
+
+	_, err = plugin.DetectOrphans("managers", false)
+
+	require.EqualError(t, err, "This group is not being watched: 'managers")
+}
+
+func TestDetectOrphansWithoutManager(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, api.DeleteInstanceGroupManager(ctx, "workers"))
+	require.NoError(t, api.CreateInstance(ctx, "workers-leaked", &gcloud.InstanceSettings{Labels: map[string]string{"infrakit-group": "workers"}}))
+
+	orphans, err := plugin.DetectOrphans("workers", true)
+
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+	require.Nil(t, orphans)
+	_, err = api.GetInstance(ctx, "workers-leaked")
+	require.NoError(t, err)
+}
+
+func TestDetectOrphansCleanupCarriesOnPastFailures(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	require.NoError(t, api.CreateInstance(ctx, "workers-leaked-1", &gcloud.InstanceSettings{Labels: map[string]string{"infrakit-group": "workers"}, DeletionProtection: true}))
+	require.NoError(t, api.CreateInstance(ctx, "workers-leaked-2", &gcloud.InstanceSettings{Labels: map[string]string{"infrakit-group": "workers"}}))
+
+	_, err = plugin.DetectOrphans("workers", true)
+
+	require.EqualError(t, err, "Failed to delete 1 orphan instances of group workers: The resource 'projects/PROJECT/zones/us-central1-f/instances/workers-leaked-1' is protected against deletion")
+	_, err = api.GetInstance(ctx, "workers-leaked-1")
+	require.NoError(t, err)
+	_, err = api.GetInstance(ctx, "workers-leaked-2")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+}
+
+func TestCommitGroupTemplateAlreadyExists(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+	require.NoError(t, api.CreateInstanceTemplate(context.Background(), "workers-1", &gcloud.InstanceSettings{}))
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+
+	require.EqualError(t, err, "Instance template 'workers-1' already exists. Delete it or use another group ID")
+}
+
+func TestCommitNewGroup(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+
+	api.EXPECT().GetInstanceGroupManager(gomock.Any(), "workers").Return(nil, gcloud.ErrNotFound)
+	api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return(nil, gcloud.ErrNotFound)
+	api.EXPECT().CreateInstanceTemplate(gomock.Any(), "workers-1", gomock.Any()).Return(nil)
+	api.EXPECT().CreateInstanceGroupManager(gomock.Any(), "workers", gomock.Any()).Return(nil)
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+
+	require.NoError(t, err)
+	require.Equal(t, 1, plugin.groups["workers"].currentTemplate)
+}
+
+func TestResizeProgress(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+
+	api.EXPECT().GetInstanceGroupManager(gomock.Any(), "workers").Return(&compute.InstanceGroupManager{
+		TargetSize:     1000,
+		CurrentActions: &compute.InstanceGroupManagerActionsSummary{None: 10, Creating: 990},
+	}, nil)
+
+	plugin := NewPlugin(api, watchedGroup())
+	progress, err := plugin.ResizeProgress("workers")
+
+	require.NoError(t, err)
+	require.Equal(t, ResizeProgress{Current: 10, Target: 1000}, progress)
+	require.False(t, progress.Done())
+}
+
+func TestResizeProgressUnknownGroup(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	_, err := plugin.ResizeProgress("workers")
+
+	require.EqualError(t, err, "This group is not being watched: 'workers")
+}
+
+func TestTemplateHistory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"MachineType":"n1-standard-1", "Tags":["web"]}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+	require.NoError(t, err)
+	require.NoError(t, plugin.AddNetworkTag("workers", "maintenance"))
+
+	history, err := plugin.GetTemplateHistory("workers")
+
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, "workers-1", history[0].Name)
+	require.Equal(t, "n1-standard-1", history[0].MachineType)
+	require.Equal(t, "docker", history[0].Image)
+	require.Equal(t, []string{"web"}, history[0].Tags)
+	require.Equal(t, "workers-2", history[1].Name)
+	require.Equal(t, []string{"web", "maintenance"}, history[1].Tags)
+	require.Len(t, history[0].Digest, 64)
+	require.NotEqual(t, history[0].Digest, history[1].Digest)
+
+	history[0].Tags[0] = "changed"
+	history, err = plugin.GetTemplateHistory("workers")
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"web"}, history[0].Tags)
+	require.Equal(t, []string{"workers-1", "workers-2"}, api.Templates())
+}
+
+func TestTemplateVersionInDescription(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+
+	api.EXPECT().GetInstanceGroupManager(gomock.Any(), "workers").Return(nil, gcloud.ErrNotFound)
+	var description string
+	api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return(nil, nil)
+	api.EXPECT().CreateInstanceTemplate(gomock.Any(), "workers-1", gomock.Any()).Do(func(_ context.Context, _ string, settings *gcloud.InstanceSettings) {
+		description = settings.TemplateDescription
+	}).Return(nil)
+	api.EXPECT().CreateInstanceGroupManager(gomock.Any(), "workers", gomock.Any()).Return(nil)
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"MachineType":"n1-standard-1", "Tags":["web"]}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+	require.NoError(t, err)
+
+	version, present := parseTemplateVersion(description)
+
+	require.True(t, present)
+	require.Equal(t, "workers-1", version.Name)
+	require.Equal(t, "n1-standard-1", version.MachineType)
+	require.Equal(t, []string{"web"}, version.Tags)
+	require.Equal(t, plugin.groups["workers"].templateHistory[0].Digest, version.Digest)
+
+	_, present = parseTemplateVersion("A user description")
+	require.False(t, present)
+}
+
+func TestTemplateHistoryUnknownGroup(t *testing.T) {
+	plugin := NewPlugin(nil, map[group.ID]settings{})
+	_, err := plugin.GetTemplateHistory("unknown")
+
+	require.EqualError(t, err, "This group is not being watched: 'unknown")
+}
+
+func TestDescribeGroupCountsAllPages(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroups/workers/listInstances", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pageToken") == "" {
+			testutil.ReplyJSON(t, w, &compute.InstanceGroupsListInstances{
+				Items:         []*compute.InstanceWithNamedPorts{{Instance: "zones/us-central1-f/instances/workers-a"}},
+				NextPageToken: "page-2",
+			})
+			return
+		}
+		testutil.ReplyJSON(t, w, &compute.InstanceGroupsListInstances{
+			Items: []*compute.InstanceWithNamedPorts{{Instance: "zones/us-central1-f/instances/workers-b"}},
+		})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroupManagers/workers/listManagedInstances", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.InstanceGroupManagersListManagedInstancesResponse{})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.Instance{Name: last(r.URL.Path), Metadata: &compute.Metadata{}})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	groupPlugin := NewGCEGroupPlugin("PROJECT", "us-central1-f", nil,
+		gcloud.WithEndpoint(server.URL),
+		gcloud.WithHTTPClient(server.Client())).(*plugin)
+	groups := watchedGroup()
+	watched := groups["workers"]
+	watched.spec.Allocation.Size = 2
+	groupPlugin.groups["workers"] = watched
+
+	description, err := groupPlugin.DescribeGroup("workers")
+
+	require.NoError(t, err)
+	require.True(t, description.Converged)
+	require.Len(t, description.Instances, 2)
+}
+
+func groupSpecWithPlacement(placement string) group.Spec {
+	return group.Spec{
+		ID: "workers",
+		Properties: types.AnyString(fmt.Sprintf(`{
+			"Allocation": {"Size": 2},
+			"Instance": {"Properties": {}},
+			"Flavor": {"Plugin": "flavor", "Properties": {}},
+			"Placement": %s
+		}`, placement)),
+	}
+}
+
+func TestCommitGroupWithSpreadPlacement(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+
+	api.EXPECT().GetInstanceGroupManager(gomock.Any(), "workers").Return(nil, gcloud.ErrNotFound)
+	api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return(nil, nil)
+	api.EXPECT().GetSpreadPlacementPolicy(gomock.Any(), "workers-spread-3").Return(int64(0), gcloud.ErrNotFound)
+	api.EXPECT().CreateSpreadPlacementPolicy(gomock.Any(), "workers-spread-3", int64(3)).Return(nil)
+	api.EXPECT().CreateInstanceTemplate(gomock.Any(), "workers-1", gomock.Any()).Do(func(_ context.Context, _ string, settings *gcloud.InstanceSettings) {
+		require.Equal(t, []string{"workers-spread-3"}, settings.ResourcePolicies)
+	}).Return(nil)
+	api.EXPECT().CreateInstanceGroupManager(gomock.Any(), "workers", gomock.Any()).Return(nil)
+	api.EXPECT().DeleteInstanceGroupManager(gomock.Any(), "workers").Return(nil)
+	api.EXPECT().ListInstanceTemplates(gomock.Any(), "name eq workers-[0-9]+").Return([]*compute.InstanceTemplate{
+		{Name: "workers-1", Description: templateMarker + `{"Name":"workers-1"}`},
+	}, nil)
+	api.EXPECT().DeleteInstanceTemplate(gomock.Any(), "workers-1").Return(nil)
+	api.EXPECT().DeleteResourcePolicy(gomock.Any(), "workers-spread-3").Return(nil)
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers", "MachineType":"n2-standard-4"}`)
+
+	description, err := plugin.CommitGroup(groupSpecWithPlacement(`{"Spread": true}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Managing 2 instances\nSpreading instances across 3 availability domains", description)
+	require.NoError(t, plugin.DestroyGroup("workers"))
+}
+
+func TestCommitGroupWithExistingPlacementPolicy(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+
+	api.EXPECT().GetInstanceGroupManager(gomock.Any(), "workers").Return(nil, gcloud.ErrNotFound)
+	api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return(nil, nil)
+	api.EXPECT().GetSpreadPlacementPolicy(gomock.Any(), "workers-spread-3").Return(int64(2), nil)
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers", "MachineType":"c2-standard-8"}`)
+
+	_, err := plugin.CommitGroup(groupSpecWithPlacement(`{"Spread": true}`), false)
+
+	require.EqualError(t, err, "Resource policy 'workers-spread-3' spreads instances across 2 availability domains, not 3. Delete it or use another group ID")
+}
+
+func TestCommitGroupChangesPlacement(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+
+	api.EXPECT().GetInstanceGroupManager(gomock.Any(), "workers").Return(nil, gcloud.ErrNotFound)
+	api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return(nil, nil).AnyTimes()
+	api.EXPECT().GetSpreadPlacementPolicy(gomock.Any(), "workers-spread-3").Return(int64(0), gcloud.ErrNotFound)
+	api.EXPECT().CreateSpreadPlacementPolicy(gomock.Any(), "workers-spread-3", int64(3)).Return(nil)
+	api.EXPECT().CreateInstanceTemplate(gomock.Any(), "workers-1", gomock.Any()).Return(nil)
+	api.EXPECT().CreateInstanceGroupManager(gomock.Any(), "workers", gomock.Any()).Return(nil)
+	api.EXPECT().GetSpreadPlacementPolicy(gomock.Any(), "workers-spread-4").Return(int64(0), gcloud.ErrNotFound)
+	api.EXPECT().CreateSpreadPlacementPolicy(gomock.Any(), "workers-spread-4", int64(4)).Return(nil)
+	api.EXPECT().CreateInstanceTemplate(gomock.Any(), "workers-2", gomock.Any()).Do(func(_ context.Context, _ string, settings *gcloud.InstanceSettings) {
+		require.Equal(t, []string{"workers-spread-4"}, settings.ResourcePolicies)
+	}).Return(nil)
+	api.EXPECT().SetInstanceTemplate(gomock.Any(), "workers", "workers-2").Return(nil)
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers", "MachineType":"n2-standard-4"}`)
+
+	_, err := plugin.CommitGroup(groupSpecWithPlacement(`{"Spread": true}`), false)
+	require.NoError(t, err)
+
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers", "MachineType":"n2-standard-4"}`)
+
+	description, err := plugin.CommitGroup(groupSpecWithPlacement(`{"Spread": true, "AvailabilityDomains": 4}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Spreading instances across 4 availability domains\nUpdating instance template\nRecreating instances, 1 at a time", description)
+	require.Equal(t, int64(4), plugin.groups["workers"].placement.AvailabilityDomains)
+
+	<-plugin.rollouts["workers"].done
+}
+
+func TestCommitGroupInvalidPlacement(t *testing.T) {
+	invalid := map[string]string{
+		`{"Spread": true, "AvailabilityDomains": 9}`: "Placement.AvailabilityDomains must be between 2 and 8",
+		`{"Spread": true, "AvailabilityDomains": 1}`: "Placement.AvailabilityDomains must be between 2 and 8",
+		`{"Spread": true}`:                           "Placement.Spread is not supported by machine type e2-standard-2: its family should be one of n1, n2, n2d, c2, c2d, c3, t2d",
+	}
+	for placement, message := range invalid {
+		api, ctrl := NewMockGCloud(t)
+		api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return(nil, nil)
+
+		plugin := NewPlugin(api, map[group.ID]settings{})
+		plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"MachineType":"e2-standard-2"}`)
+
+		_, err := plugin.CommitGroup(groupSpecWithPlacement(placement), false)
+
+		require.EqualError(t, err, message)
+		ctrl.Finish()
+	}
+}
+
+func TestMachineFamily(t *testing.T) {
+	require.Equal(t, "n2", machineFamily("n2-standard-4"))
+	require.Equal(t, "n2d", machineFamily("zones/us-central1-f/machineTypes/n2d-custom-4-8192"))
+	require.Equal(t, "n1", machineFamily("custom-2-4096"))
+	require.Equal(t, "g1", machineFamily("g1-small"))
+}
+
+// NewDescribeServer serves a group of instances that are fetched more slowly
+// the earlier they are listed. Every third instance is being recreated.
+// Instances in failing aren't found.
+func NewDescribeServer(t *testing.T, size int, failing ...string) *httptest.Server {
+	return httptest.NewServer(NewDescribeMux(t, size, failing...))
+}
+
+// NewDescribeMux routes the requests of NewDescribeServer.
+func NewDescribeMux(t *testing.T, size int, failing ...string) *http.ServeMux {
+	members := []*compute.InstanceWithNamedPorts{}
+	managed := []*compute.ManagedInstance{}
+	delays := map[string]time.Duration{}
+	for i := 0; i < size; i++ {
+		name := fmt.Sprintf("workers-%02d", i)
+		members = append(members, &compute.InstanceWithNamedPorts{Instance: "zones/us-central1-f/instances/" + name})
+		delays[name] = time.Duration(size-i) * time.Millisecond
+
+		if i%3 == 0 {
+			managed = append(managed, &compute.ManagedInstance{Instance: "zones/us-central1-f/instances/" + name, CurrentAction: "RECREATING", InstanceStatus: "STOPPING"})
+		} else {
+			managed = append(managed, &compute.ManagedInstance{Instance: "zones/us-central1-f/instances/" + name, CurrentAction: "NONE", InstanceStatus: "RUNNING"})
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroups/workers/listInstances", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.InstanceGroupsListInstances{Items: members})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instanceGroupManagers/workers/listManagedInstances", func(w http.ResponseWriter, r *http.Request) {
+		testutil.ReplyJSON(t, w, &compute.InstanceGroupManagersListManagedInstancesResponse{ManagedInstances: managed})
+	})
+	mux.HandleFunc("/PROJECT/zones/us-central1-f/instances/", func(w http.ResponseWriter, r *http.Request) {
+		name := last(r.URL.Path)
+		for _, failed := range failing {
+			if name == failed {
+				http.Error(w, `{"error": {"code": 404, "message": "The resource was not found"}}`, http.StatusNotFound)
+				return
+			}
+		}
+		time.Sleep(delays[name])
+		testutil.ReplyJSON(t, w, &compute.Instance{Name: name, Metadata: &compute.Metadata{}})
+	})
+
+	return mux
+}
+
+// ServeBatch answers the batch requests with the other routes of a mux.
+func ServeBatch(t *testing.T, mux *http.ServeMux) {
+	mux.HandleFunc("/batch/compute/v1", func(w http.ResponseWriter, r *http.Request) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		require.NoError(t, err)
+
+		ids := []string{}
+		responses := []*http.Response{}
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+
+			request, err := http.ReadRequest(bufio.NewReader(part))
+			require.NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, request)
+
+			ids = append(ids, strings.Trim(part.Header.Get("Content-ID"), "<>"))
+			responses = append(responses, recorder.Result())
+		}
+
+		writer := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+		for i, response := range responses {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Type", "application/http")
+			header.Set("Content-ID", "<response-"+ids[i]+">")
+			part, err := writer.CreatePart(header)
+			require.NoError(t, err)
+			require.NoError(t, response.Write(part))
+		}
+		require.NoError(t, writer.Close())
+	})
+}
+
+func TestDescribeGroupKeepsInstanceOrder(t *testing.T) {
+	server := NewDescribeServer(t, 25)
+	defer server.Close()
+
+	groupPlugin := NewGCEGroupPlugin("PROJECT", "us-central1-f", nil,
+		gcloud.WithEndpoint(server.URL),
+		gcloud.WithHTTPClient(server.Client())).(*plugin)
+	groups := watchedGroup()
+	watched := groups["workers"]
+	watched.spec.Allocation.Size = 25
+	groupPlugin.groups["workers"] = watched
+
+	description, err := groupPlugin.DescribeGroup("workers")
+
+	require.NoError(t, err)
+	require.True(t, description.Converged)
+	require.Len(t, description.Instances, 25)
+	for i, inst := range description.Instances {
+		require.Equal(t, instance.ID(fmt.Sprintf("workers-%02d", i)), inst.ID)
+	}
+	require.Equal(t, "RECREATING", description.Instances[3].Tags[CurrentActionTag])
+	require.Equal(t, "STOPPING", description.Instances[3].Tags[InstanceStatusTag])
+	require.Equal(t, "NONE", description.Instances[4].Tags[CurrentActionTag])
+	require.Equal(t, "RUNNING", description.Instances[4].Tags[InstanceStatusTag])
+}
+
+func TestDescribeGroupBatchesInstanceLookups(t *testing.T) {
+	mux := NewDescribeMux(t, 50)
+	ServeBatch(t, mux)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		mux.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	groupPlugin := NewGCEGroupPlugin("PROJECT", "us-central1-f", nil,
+		gcloud.WithEndpoint(server.URL),
+		gcloud.WithHTTPClient(server.Client())).(*plugin)
+	groups := watchedGroup()
+	watched := groups["workers"]
+	watched.spec.Allocation.Size = 50
+	groupPlugin.groups["workers"] = watched
+
+	description, err := groupPlugin.DescribeGroup("workers")
+
+	require.NoError(t, err)
+	require.Len(t, description.Instances, 50)
+	require.Equal(t, instance.ID("workers-42"), description.Instances[42].ID)
+	require.Equal(t, "RECREATING", description.Instances[42].Tags[CurrentActionTag])
+
+	// The members, the managed instances and a single batch for the 50
+	// instances, instead of 52 requests.
+	require.Equal(t, 3, requests)
+}
+
+func TestDescribeGroupWithoutReservedMetadata(t *testing.T) {
+	api := fake.New("PROJECT", "us-central1-f")
+	require.NoError(t, api.CreateInstanceTemplate(context.Background(), "workers-1", &gcloud.InstanceSettings{
+		MetaData: gcloud.TagsToMetaData(map[string]string{
+			"startup-script": "echo 'Startup'",
+			"userdata":       "echo 'Startup'",
+			"role":           "worker",
+		}),
+	}))
+	require.NoError(t, api.CreateInstanceGroupManager(context.Background(), "workers", &gcloud.InstanceManagerSettings{
+		TemplateName:     "workers-1",
+		TargetSize:       1,
+		BaseInstanceName: "workers",
+	}))
+
+	plugin := NewPlugin(api, watchedGroup())
+	description, err := plugin.DescribeGroup("workers")
+
+	require.NoError(t, err)
+	require.Len(t, description.Instances, 1)
+	require.Equal(t, "worker", description.Instances[0].Tags["role"])
+	require.NotContains(t, description.Instances[0].Tags, "startup-script")
+	require.NotContains(t, description.Instances[0].Tags, "userdata")
+}
+
+func TestDescribeGroupSkipsMissingInstance(t *testing.T) {
+	server := NewDescribeServer(t, 25, "workers-17")
+	defer server.Close()
+
+	groupPlugin := NewGCEGroupPlugin("PROJECT", "us-central1-f", nil,
+		gcloud.WithEndpoint(server.URL),
+		gcloud.WithHTTPClient(server.Client())).(*plugin)
+	groupPlugin.groups = watchedGroup()
+
+	description, err := groupPlugin.DescribeGroup("workers")
+
+	require.NoError(t, err)
+	require.Len(t, description.Instances, 24)
+	for _, inst := range description.Instances {
+		require.NotEqual(t, instance.ID("workers-17"), inst.ID)
+	}
+}
+
+func groupSpecWithUpdates(updates string) group.Spec {
+	return group.Spec{
+		ID: "workers",
+		Properties: types.AnyString(`{
+			"Allocation": {"Size": 3},
+			"Instance": {"Properties": {}},
+			"Flavor": {"Plugin": "flavor", "Properties": {}},
+			"Updates": ` + updates + `
+		}`),
+	}
+}
+
+func TestCommitGroupRollingUpdate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(`{"NamePrefix":"workers", "MachineType":"n1-standard-1"}`),
+	}, nil)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(`{"NamePrefix":"workers", "MachineType":"n1-standard-2"}`),
+	}, nil)
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = func(name plugin_base.Name) (flavor.Plugin, error) { return flavorPlugin, nil }
+
+	_, err := plugin.CommitGroup(groupSpecWithUpdates(`{"MaxUnavailable": 2}`), false)
+	require.NoError(t, err)
+
+	description, err := plugin.CommitGroup(groupSpecWithUpdates(`{"MaxUnavailable": 2}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Updating instance template\nRecreating instances, 2 at a time", description)
+	require.Equal(t, "n1-standard-2", plugin.groups["workers"].instanceProperties.MachineType)
+	require.Equal(t, []string{"workers-1", "workers-2"}, plugin.groups["workers"].createdTemplates)
+
+	<-plugin.rollouts["workers"].done
+
+	manager, err := api.GetInstanceGroupManager(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Equal(t, "projects/PROJECT/global/instanceTemplates/workers-2", manager.InstanceTemplate)
+
+	instances, err := api.ListInstances(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, instances, 3)
+	for _, inst := range instances {
+		require.Equal(t, "n1-standard-2", last(inst.MachineType))
+	}
+}
+
+func TestCommitGroupRollingUpdateWithSurge(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+
+	api.EXPECT().GetInstanceGroupManager(gomock.Any(), "workers").Return(nil, gcloud.ErrNotFound)
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(`{"MachineType":"n1-standard-1"}`),
+	}, nil)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(`{"MachineType":"n1-standard-2"}`),
+	}, nil).Times(2)
+
+	stable := &compute.InstanceGroupManager{TargetSize: 3, CurrentActions: &compute.InstanceGroupManagerActionsSummary{None: 3}}
+
+	api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return(nil, nil).Times(3)
+	api.EXPECT().CreateInstanceTemplate(gomock.Any(), "workers-1", gomock.Any()).Return(nil)
+	api.EXPECT().CreateInstanceGroupManager(gomock.Any(), "workers", gomock.Any()).Return(nil)
+	gomock.InOrder(
+		api.EXPECT().CreateInstanceTemplate(gomock.Any(), "workers-2", gomock.Any()).Return(nil),
+		api.EXPECT().SetInstanceTemplate(gomock.Any(), "workers", "workers-2").Return(nil),
+		api.EXPECT().StartRollingUpdate(gomock.Any(), "workers", "workers-2", 2, 0).Return(nil),
+		api.EXPECT().GetInstanceGroupManager(gomock.Any(), "workers").Return(stable, nil),
+	)
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = func(name plugin_base.Name) (flavor.Plugin, error) { return flavorPlugin, nil }
+
+	_, err := plugin.CommitGroup(groupSpecWithUpdates(`{"MaxSurge": 2, "MaxUnavailable": 0}`), false)
+	require.NoError(t, err)
+
+	description, err := plugin.CommitGroup(groupSpecWithUpdates(`{"MaxSurge": 2, "MaxUnavailable": 0}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Updating instance template\nReplacing instances, with up to 2 extra and 0 unavailable", description)
+
+	<-plugin.rollouts["workers"].done
+
+	// The tags set on the template don't make the same spec look changed.
+	description, err = plugin.CommitGroup(groupSpecWithUpdates(`{"MaxSurge": 2, "MaxUnavailable": 0}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "", description)
+}
+
+func TestCommitGroupInvalidUpdates(t *testing.T) {
+	invalid := map[string]string{
+		`{"MaxUnavailable": -1}`: "Updates.MaxUnavailable must be >= 0",
+		`{"MaxSurge": -1}`:       "Updates.MaxSurge must be >= 0",
+	}
+	for updates, message := range invalid {
+		api, ctrl := NewMockGCloud(t)
+		api.EXPECT().ListInstanceGroupInstances(gomock.Any(), "workers").Return(nil, nil)
+
+		plugin := NewPlugin(api, map[group.ID]settings{})
+		plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{}`)
+
+		_, err := plugin.CommitGroup(groupSpecWithUpdates(updates), false)
+
+		require.EqualError(t, err, message)
+		ctrl.Finish()
+	}
+}
+
+func TestCommitGroupDeletionProtectionNotSupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"DeletionProtection": true}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+
+	require.EqualError(t, err, "DeletionProtection is not supported by instance templates")
+}
+
+func TestDestroyGroup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+	require.NoError(t, err)
+
+	instances, err := api.ListInstances(context.Background(), "")
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+
+	err = plugin.DestroyGroup("workers")
+
+	require.NoError(t, err)
+	require.Empty(t, plugin.groups)
+	require.Empty(t, api.Templates())
+
+	instances, err = api.ListInstances(context.Background(), "")
+	require.NoError(t, err)
+	require.Empty(t, instances)
+}
+
+func TestDestroyGroupFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+	require.NoError(t, err)
+
+	api.Fail("DeleteInstanceGroupManager", errors.New("BUG"))
+	err = plugin.DestroyGroup("workers")
+
+	require.EqualError(t, err, "BUG")
+	require.Contains(t, plugin.groups, group.ID("workers"))
+	require.Equal(t, []string{"workers-1"}, api.Templates())
+}
+
+func TestDestroyUnknownGroup(t *testing.T) {
+	plugin := NewPlugin(fake.New("PROJECT", "us-central1-f"), map[group.ID]settings{})
+	err := plugin.DestroyGroup("workers")
+
+	require.EqualError(t, err, "This group is not being watched: 'workers")
+}
+
+func TestCommitGroupAdoptsExistingManager(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	previous := NewPlugin(api, map[group.ID]settings{})
+	previous.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	_, err := previous.CommitGroup(workersSpec, false)
+	require.NoError(t, err)
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	description, err := plugin.CommitGroup(groupSpecWithUpdates(`{}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Scaling group to 3 instance.", description)
+	require.Equal(t, 1, plugin.groups["workers"].currentTemplate)
+	require.Equal(t, []string{"workers-1"}, plugin.groups["workers"].createdTemplates)
+	require.Equal(t, previous.groups["workers"].templateHistory, plugin.groups["workers"].templateHistory)
+	require.Equal(t, []string{"workers-1"}, api.Templates())
+
+	manager, err := api.GetInstanceGroupManager(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), manager.TargetSize)
+
+	require.NoError(t, plugin.DestroyGroup("workers"))
+	require.Empty(t, api.Templates())
+}
+
+func TestCommitGroupAdoptsManagerWithOutdatedTemplate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	previous := NewPlugin(api, map[group.ID]settings{})
+	previous.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers", "MachineType":"n1-standard-1"}`)
+	_, err := previous.CommitGroup(workersSpec, false)
+	require.NoError(t, err)
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers", "MachineType":"n1-standard-2"}`)
+	description, err := plugin.CommitGroup(workersSpec, false)
+	<-plugin.rollouts["workers"].done
+
+	require.NoError(t, err)
+	require.Equal(t, "Updating instance template\nRecreating instances, 1 at a time", description)
+	require.Equal(t, []string{"workers-1", "workers-2"}, plugin.groups["workers"].createdTemplates)
+	require.Len(t, plugin.groups["workers"].templateHistory, 2)
+	require.Equal(t, "n1-standard-2", plugin.groups["workers"].templateHistory[1].MachineType)
+
+	manager, err := api.GetInstanceGroupManager(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Equal(t, "workers-2", last(manager.InstanceTemplate))
+}
+
+func TestCommitGroupAdoptsManagerWithForeignTemplate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+	require.NoError(t, api.CreateInstanceTemplate(context.Background(), "golden", &gcloud.InstanceSettings{}))
+	require.NoError(t, api.CreateInstanceGroupManager(context.Background(), "workers", &gcloud.InstanceManagerSettings{
+		TemplateName:     "golden",
+		TargetSize:       2,
+		BaseInstanceName: "workers",
+	}))
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	description, err := plugin.CommitGroup(workersSpec, false)
+	<-plugin.rollouts["workers"].done
+
+	require.NoError(t, err)
+	require.Equal(t, "Updating instance template\nRecreating instances, 1 at a time", description)
+	require.Equal(t, []string{"workers-1"}, plugin.groups["workers"].createdTemplates)
+
+	require.NoError(t, plugin.DestroyGroup("workers"))
+	require.Equal(t, []string{"golden"}, api.Templates())
+}
+
+// createMarkedTemplate creates a template that looks like it was created by
+// the plugin.
+func createMarkedTemplate(t *testing.T, api gcloud.API, name string) {
+	require.NoError(t, api.CreateInstanceTemplate(context.Background(), name, &gcloud.InstanceSettings{
+		TemplateDescription: templateMarker + `{"Name":"` + name + `"}`,
+	}))
+}
+
+func TestDestroyGroupDeletesTemplatesByName(t *testing.T) {
+	api := fake.New("PROJECT", "us-central1-f")
+	for _, name := range []string{"workers-1", "workers-2", "workers-10", "workers-1-1", "workersx-1", "other-1"} {
+		createMarkedTemplate(t, api, name)
+	}
+	require.NoError(t, api.CreateInstanceTemplate(context.Background(), "workers-5", &gcloud.InstanceSettings{}))
+	require.NoError(t, api.CreateInstanceGroupManager(context.Background(), "workers", &gcloud.InstanceManagerSettings{
+		TemplateName: "workers-10",
+		TargetSize:   1,
+	}))
+
+	// The plugin was restarted and doesn't remember the templates.
+	plugin := NewPlugin(api, map[group.ID]settings{"workers": {}})
+	err := plugin.DestroyGroup("workers")
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"other-1", "workers-1-1", "workers-5", "workersx-1"}, api.Templates())
+}
+
+func TestDestroyGroupTemplateDeletedConcurrently(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+
+	api.EXPECT().DeleteInstanceGroupManager(gomock.Any(), "workers").Return(nil)
+	api.EXPECT().ListInstanceTemplates(gomock.Any(), "name eq workers-[0-9]+").Return([]*compute.InstanceTemplate{
+		{Name: "workers-1", Description: templateMarker + `{"Name":"workers-1"}`},
+		{Name: "workers-2", Description: templateMarker + `{"Name":"workers-2"}`},
+	}, nil)
+	api.EXPECT().DeleteInstanceTemplate(gomock.Any(), "workers-1").Return(gcloud.ErrNotFound)
+	api.EXPECT().DeleteInstanceTemplate(gomock.Any(), "workers-2").Return(nil)
+
+	plugin := NewPlugin(api, watchedGroup())
+	err := plugin.DestroyGroup("workers")
+
+	require.NoError(t, err)
+	require.Empty(t, plugin.groups)
+}
+
+func groupSpecWithLoadBalancing(loadBalancing string) group.Spec {
+	return group.Spec{
+		ID: "workers",
+		Properties: types.AnyString(`{
+			"Allocation": {"Size": 2},
+			"Instance": {"Properties": {}},
+			"Flavor": {"Plugin": "flavor", "Properties": {}},
+			"LoadBalancing": ` + loadBalancing + `
+		}`),
+	}
+}
+
+func TestCommitGroupWithBackendServices(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(`{"NamePrefix":"workers"}`),
+	}, nil).Times(2)
+
+	api := fake.New("PROJECT", "us-central1-f")
+	api.AddBackendService("web")
+	api.AddBackendService("api")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = func(name plugin_base.Name) (flavor.Plugin, error) { return flavorPlugin, nil }
+
+	description, err := plugin.CommitGroup(groupSpecWithLoadBalancing(`{"BackendServices": ["web"], "ConnectionDrainingTimeoutSec": 300}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Managing 2 instances\nAdding group to backend services", description)
+	web, _ := api.BackendService("web")
+	require.Len(t, web.Backends, 1)
+	require.Equal(t, "projects/PROJECT/zones/us-central1-f/instanceGroups/workers", web.Backends[0].Group)
+	require.Equal(t, int64(300), web.ConnectionDraining.DrainingTimeoutSec)
+
+	description, err = plugin.CommitGroup(groupSpecWithLoadBalancing(`{"BackendServices": ["api"]}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Updating backend services", description)
+	web, _ = api.BackendService("web")
+	require.Empty(t, web.Backends)
+	backend, _ := api.BackendService("api")
+	require.Len(t, backend.Backends, 1)
+	require.Nil(t, backend.ConnectionDraining)
+
+	require.NoError(t, plugin.DestroyGroup("workers"))
+	backend, _ = api.BackendService("api")
+	require.Empty(t, backend.Backends)
+}
+
+func TestCommitGroupWithUnknownBackendService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	_, err := plugin.CommitGroup(groupSpecWithLoadBalancing(`{"BackendServices": ["web"]}`), false)
+
+	require.EqualError(t, err, "The resource 'projects/PROJECT/global/backendServices/web' was not found")
+}
+
+func TestCommitGroupNegativeDrainingTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	plugin := NewPlugin(fake.New("PROJECT", "us-central1-f"), map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{}`)
+	_, err := plugin.CommitGroup(groupSpecWithLoadBalancing(`{"ConnectionDrainingTimeoutSec": -1}`), false)
+
+	require.EqualError(t, err, "LoadBalancing.ConnectionDrainingTimeoutSec must be >= 0")
+}
+
+func TestCommitGroupWithNamedPorts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(`{"NamePrefix":"workers"}`),
+	}, nil).Times(3)
+
+	api := fake.New("PROJECT", "us-central1-f")
+	api.AddBackendService("web")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = func(name plugin_base.Name) (flavor.Plugin, error) { return flavorPlugin, nil }
+
+	description, err := plugin.CommitGroup(groupSpecWithLoadBalancing(`{"BackendServices": ["web"], "NamedPorts": [{"Name": "http", "Port": 8080}]}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Managing 2 instances\nSetting named ports\nAdding group to backend services", description)
+	manager, err := api.GetInstanceGroupManager(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Equal(t, []*compute.NamedPort{{Name: "http", Port: 8080}}, manager.NamedPorts)
+
+	description, err = plugin.CommitGroup(groupSpecWithLoadBalancing(`{"BackendServices": ["web"], "NamedPorts": [{"Name": "http", "Port": 80}]}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Setting named ports", description)
+	manager, err = api.GetInstanceGroupManager(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Equal(t, []*compute.NamedPort{{Name: "http", Port: 80}}, manager.NamedPorts)
+	web, _ := api.BackendService("web")
+	require.Len(t, web.Backends, 1)
+
+	description, err = plugin.CommitGroup(groupSpecWithLoadBalancing(`{"BackendServices": ["web"], "NamedPorts": [{"Name": "http", "Port": 80}]}`), false)
+
+	require.NoError(t, err)
+	require.Empty(t, description)
+}
+
+func TestCommitGroupInvalidNamedPorts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	plugin := NewPlugin(fake.New("PROJECT", "us-central1-f"), map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{}`)
+
+	_, err := plugin.CommitGroup(groupSpecWithLoadBalancing(`{"NamedPorts": [{"Name": "HTTP", "Port": 80}]}`), false)
+	require.EqualError(t, err, `Invalid LoadBalancing.NamedPorts name "HTTP": should start with a lowercase letter and have at most 63 lowercase letters, digits or -`)
+
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{}`)
+	_, err = plugin.CommitGroup(groupSpecWithLoadBalancing(`{"NamedPorts": [{"Name": "http", "Port": 0}]}`), false)
+	require.EqualError(t, err, "LoadBalancing.NamedPorts port http must be between 1 and 65535")
+
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{}`)
+	_, err = plugin.CommitGroup(groupSpecWithLoadBalancing(`{"NamedPorts": [{"Name": "http", "Port": 80}, {"Name": "http", "Port": 8080}]}`), false)
+	require.EqualError(t, err, "LoadBalancing.NamedPorts has several ports named http")
+}
+
+func TestCommitGroupWithTargetPools(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	gomock.InOrder(
+		flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+			Properties: types.AnyString(`{"NamePrefix":"workers", "TargetPools":"regional"}`),
+		}, nil),
+		flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+			Properties: types.AnyString(`{"NamePrefix":"workers", "TargetPools":["regional", "global"]}`),
+		}, nil),
+	)
+
+	api := fake.New("PROJECT", "us-central1-f")
+	api.CreateTargetPool("regional")
+	api.CreateTargetPool("global")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = func(name plugin_base.Name) (flavor.Plugin, error) { return flavorPlugin, nil }
+
+	_, err := plugin.CommitGroup(workersSpec, false)
+
+	require.NoError(t, err)
+	manager, err := api.GetInstanceGroupManager(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Equal(t, []string{"regional"}, manager.TargetPools)
+	template := manager.InstanceTemplate
+
+	description, err := plugin.CommitGroup(workersSpec, false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Updating target pools", description)
+	manager, err = api.GetInstanceGroupManager(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Equal(t, []string{"regional", "global"}, manager.TargetPools)
+	require.Equal(t, template, manager.InstanceTemplate)
+}
+
+func TestCommitGroupWithUnknownTargetPool(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+	api.CreateTargetPool("regional")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers", "TargetPools":["regional", "global"]}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Invalid target pool 'global'")
+	_, err = api.GetInstanceGroupManager(context.Background(), "workers")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+}
+
+func TestCommitGroupAdoptsManagerWithSameTemplateAndSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	previous := NewPlugin(api, map[group.ID]settings{})
+	previous.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	_, err := previous.CommitGroup(workersSpec, false)
+	require.NoError(t, err)
+
+	instances, err := api.ListInstances(context.Background(), "")
+	require.NoError(t, err)
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	description, err := plugin.CommitGroup(workersSpec, false)
+
+	require.NoError(t, err)
+	require.Empty(t, description)
+	require.Equal(t, 1, plugin.groups["workers"].currentTemplate)
+	require.Equal(t, []string{"workers-1"}, api.Templates())
+
+	unchanged, err := api.ListInstances(context.Background(), "")
+	require.NoError(t, err)
+	require.Equal(t, instances, unchanged)
+}
+
+func TestCommitGroupAdoptsManagerWithLiveTargetPools(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+	api.CreateTargetPool("regional")
+
+	previous := NewPlugin(api, map[group.ID]settings{})
+	previous.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers", "TargetPools":["regional"]}`)
+	_, err := previous.CommitGroup(workersSpec, false)
+	require.NoError(t, err)
+
+	// The pools of the manager changed behind the back of the plugin.
+	ctx := context.Background()
+	require.NoError(t, api.SetTargetPools(ctx, "workers", nil))
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers", "TargetPools":["regional"]}`)
+	description, err := plugin.CommitGroup(workersSpec, false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Updating target pools", description)
+	require.Equal(t, []string{"workers-1"}, api.Templates())
+	manager, err := api.GetInstanceGroupManager(ctx, "workers")
+	require.NoError(t, err)
+	require.Equal(t, []string{"regional"}, manager.TargetPools)
+}
+
+func TestCommitGroupCreatesMissingManager(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	description, err := plugin.CommitGroup(workersSpec, false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Managing 2 instances", description)
+
+	managers, err := api.ListInstanceGroupManagers(context.Background())
+	require.NoError(t, err)
+	require.Len(t, managers, 1)
+	require.Equal(t, "projects/PROJECT/global/instanceTemplates/workers-1", managers[0].InstanceTemplate)
+	require.Equal(t, int64(2), managers[0].TargetSize)
+}
+
+func TestRegionalGroup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(`{"NamePrefix":"workers"}`),
+	}, nil)
+
+	api := fake.New("PROJECT", "")
+	api.SetRegion("us-central1", "us-central1-a", "us-central1-b", "us-central1-c")
+	api.AddBackendService("web")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.region = "us-central1"
+	plugin.flavorPlugins = func(name plugin_base.Name) (flavor.Plugin, error) { return flavorPlugin, nil }
+
+	_, err := plugin.CommitGroup(groupSpecWithLoadBalancing(`{"BackendServices": ["web"]}`), false)
+
+	require.NoError(t, err)
+	manager, err := api.GetRegionalInstanceGroupManager(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Equal(t, "us-central1", manager.Region)
+	web, _ := api.BackendService("web")
+	require.Equal(t, "projects/PROJECT/regions/us-central1/instanceGroups/workers", web.Backends[0].Group)
+
+	description, err := plugin.DescribeGroup("workers")
+
+	require.NoError(t, err)
+	require.True(t, description.Converged)
+	require.Len(t, description.Instances, 2)
+	zones := []string{}
+	for _, inst := range description.Instances {
+		found, err := api.AggregatedListInstances(context.Background(), "name eq "+string(inst.ID))
+		require.NoError(t, err)
+		zones = append(zones, last(found[0].Zone))
+	}
+	require.Equal(t, []string{"us-central1-a", "us-central1-b"}, zones)
+
+	require.NoError(t, plugin.DestroyGroup("workers"))
+	_, err = api.GetRegionalInstanceGroupManager(context.Background(), "workers")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+	require.Empty(t, api.Templates())
+}
+
+func TestDescribeRegionalGroupSkipsDeletedInstance(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().ListRegionalInstanceGroupInstances(gomock.Any(), "workers").Return([]*compute.InstanceWithNamedPorts{
+		{Instance: "zones/us-central1-a/instances/workers-a"},
+		{Instance: "zones/us-central1-b/instances/workers-b"},
+	}, nil)
+	api.EXPECT().ListRegionalManagedInstances(gomock.Any(), "workers").Return([]*compute.ManagedInstance{}, nil)
+	api.EXPECT().GetInstanceInZone(gomock.Any(), "us-central1-a", "workers-a").Return(nil, fmt.Errorf("Instance workers-a: %w", gcloud.ErrNotFound))
+	api.EXPECT().GetInstanceInZone(gomock.Any(), "us-central1-b", "workers-b").Return(&compute.Instance{
+		Name:     "workers-b",
+		Metadata: &compute.Metadata{},
+	}, nil)
+
+	groups := watchedGroup()
+	workers := groups["workers"]
+	workers.spec.Allocation.Size = 2
+	groups["workers"] = workers
+
+	plugin := NewPlugin(api, groups)
+	plugin.region = "us-central1"
+	description, err := plugin.DescribeGroup("workers")
+
+	require.NoError(t, err)
+	require.Len(t, description.Instances, 1)
+	require.Equal(t, instance.ID("workers-b"), description.Instances[0].ID)
+	require.False(t, description.Converged)
+}
+
+var testPricing = &StaticPricing{
+	MachineTypes: map[string]float64{"n1-standard-1": 0.0475},
+	DiskTypes:    map[string]float64{"pd-standard": 0.04},
+}
+
+func groupSpecWithSize(size int) group.Spec {
+	return group.Spec{
+		ID: "workers",
+		Properties: types.AnyString(fmt.Sprintf(`{
+			"Allocation": {"Size": %d},
+			"Instance": {"Properties": {}},
+			"Flavor": {"Plugin": "flavor", "Properties": {}}
+		}`, size)),
+	}
+}
+
+func TestCommitGroupPretendEstimatesCost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(`{"NamePrefix":"workers","MachineType":"n1-standard-1","Disks":[{"Boot":true,"SizeGb":73,"Type":"pd-standard"}]}`),
+	}, nil).Times(3)
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = func(name plugin_base.Name) (flavor.Plugin, error) { return flavorPlugin, nil }
+	plugin.SetPricing(testPricing)
+
+	description, err := plugin.CommitGroup(groupSpecWithSize(2), true)
+
+	require.NoError(t, err)
+	require.Equal(t, "Managing 2 instances\nEstimated cost: 0.1030/h, currently 0.0000/h (+0.1030/h)", description)
+	require.Empty(t, api.Templates())
+
+	_, err = plugin.CommitGroup(groupSpecWithSize(2), false)
+	require.NoError(t, err)
+
+	description, err = plugin.CommitGroup(groupSpecWithSize(3), true)
+
+	require.NoError(t, err)
+	require.Equal(t, "Scaling group to 3 instance.\nEstimated cost: 0.1545/h, currently 0.1030/h (+0.0515/h)", description)
+}
+
+func TestCommitGroupPretendWithoutPrice(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	plugin := NewPlugin(fake.New("PROJECT", "us-central1-f"), map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"MachineType":"n1-highmem-8"}`)
+	plugin.SetPricing(testPricing)
+
+	description, err := plugin.CommitGroup(workersSpec, true)
+
+	require.NoError(t, err)
+	require.Equal(t, "Managing 2 instances\nEstimated cost unavailable: No price for machine type n1-highmem-8", description)
+}
+
+func groupSpecWithAutoscaling(size int, autoscaling string) group.Spec {
+	return group.Spec{
+		ID: "workers",
+		Properties: types.AnyString(fmt.Sprintf(`{
+			"Allocation": {"Size": %d},
+			"Instance": {"Properties": {}},
+			"Flavor": {"Plugin": "flavor", "Properties": {}},
+			"Autoscaling": %s
+		}`, size, autoscaling)),
+	}
+}
+
+func TestCommitGroupWithAutoscaling(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(`{"NamePrefix":"workers"}`),
+	}, nil).Times(3)
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = func(name plugin_base.Name) (flavor.Plugin, error) { return flavorPlugin, nil }
+
+	description, err := plugin.CommitGroup(groupSpecWithAutoscaling(2, `{"MinReplicas": 2, "MaxReplicas": 10}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Managing 2 instances\nAutoscaling between 2 and 10 instances at 60% CPU utilization", description)
+	autoscaler, err := api.GetAutoscaler(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), autoscaler.AutoscalingPolicy.MaxNumReplicas)
+	require.Equal(t, 0.6, autoscaler.AutoscalingPolicy.CpuUtilization.UtilizationTarget)
+
+	// The autoscaler owns the size of the group.
+	description, err = plugin.CommitGroup(groupSpecWithAutoscaling(3, `{"MinReplicas": 2, "MaxReplicas": 5, "CPUUtilization": 0.8}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Autoscaling between 2 and 5 instances at 80% CPU utilization", description)
+	autoscaler, err = api.GetAutoscaler(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Equal(t, int64(5), autoscaler.AutoscalingPolicy.MaxNumReplicas)
+	members, err := api.ListInstanceGroupInstances(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+
+	description, err = plugin.CommitGroup(groupSpecWithAutoscaling(3, `{}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Disabling autoscaling\nScaling group to 3 instance.", description)
+	_, err = api.GetAutoscaler(context.Background(), "workers")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+	members, err = api.ListInstanceGroupInstances(context.Background(), "workers")
+	require.NoError(t, err)
+	require.Len(t, members, 3)
+}
+
+func TestDescribeAutoscaledGroup(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+
+	_, err := plugin.CommitGroup(groupSpecWithAutoscaling(2, `{"MinReplicas": 1, "MaxReplicas": 5}`), false)
+	require.NoError(t, err)
+
+	// The autoscaler grows the group.
+	require.NoError(t, api.ResizeInstanceGroupManager(context.Background(), "workers", 4))
+
+	description, err := plugin.DescribeGroup("workers")
+
+	require.NoError(t, err)
+	require.True(t, description.Converged)
+	require.Len(t, description.Instances, 4)
+
+	require.NoError(t, plugin.DestroyGroup("workers"))
+	_, err = api.GetAutoscaler(context.Background(), "workers")
+	require.True(t, errors.Is(err, gcloud.ErrNotFound))
+}
+
+func TestCommitGroupInvalidAutoscaling(t *testing.T) {
+	invalid := map[string]string{
+		`{"MinReplicas": -1}`:                       "Autoscaling.MinReplicas must be >= 0",
+		`{"MinReplicas": 3, "MaxReplicas": 2}`:      "Autoscaling.MaxReplicas must be >= Autoscaling.MinReplicas",
+		`{"MaxReplicas": 2, "CPUUtilization": 1.5}`: "Autoscaling.CPUUtilization must be between 0 and 1",
+	}
+	for autoscaling, message := range invalid {
+		ctrl := gomock.NewController(t)
+
+		plugin := NewPlugin(fake.New("PROJECT", "us-central1-f"), map[group.ID]settings{})
+		plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{}`)
+
+		_, err := plugin.CommitGroup(groupSpecWithAutoscaling(2, autoscaling), false)
+
+		require.EqualError(t, err, message)
+		ctrl.Finish()
+	}
+}
+
+func groupSpecWithAutoHealing(autoHealing string) group.Spec {
+	return group.Spec{
+		ID: "workers",
+		Properties: types.AnyString(fmt.Sprintf(`{
+			"Allocation": {"Size": 2},
+			"Instance": {"Properties": {}},
+			"Flavor": {"Plugin": "flavor", "Properties": {}},
+			"AutoHealing": %s
+		}`, autoHealing)),
+	}
+}
+
+func TestCommitGroupWithAutoHealing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	flavorPlugin := mock_flavor.NewMockPlugin(ctrl)
+	flavorPlugin.EXPECT().Validate(gomock.Any(), gomock.Any()).Return(nil).Times(3)
+	flavorPlugin.EXPECT().Prepare(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(instance.Spec{
+		Properties: types.AnyString(`{"NamePrefix":"workers"}`),
+	}, nil).Times(3)
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = func(name plugin_base.Name) (flavor.Plugin, error) { return flavorPlugin, nil }
+
+	description, err := plugin.CommitGroup(groupSpecWithAutoHealing(`{"Protocol": "HTTP", "RequestPath": "/health", "InitialDelaySec": 120}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Managing 2 instances\nAutohealing with a HTTP health check on port 80", description)
+	check, present := api.HealthCheck("workers-autohealing")
+	require.True(t, present)
+	require.Equal(t, &gcloud.HealthCheckSettings{Protocol: "HTTP", Port: 80, RequestPath: "/health"}, check)
+	healthCheck, initialDelaySec := api.AutoHealingPolicy("workers")
+	require.Equal(t, "workers-autohealing", healthCheck)
+	require.Equal(t, int64(120), initialDelaySec)
+
+	description, err = plugin.CommitGroup(groupSpecWithAutoHealing(`{"Protocol": "TCP", "Port": 22, "InitialDelaySec": 120}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Autohealing with a TCP health check on port 22", description)
+	check, _ = api.HealthCheck("workers-autohealing")
+	require.Equal(t, &gcloud.HealthCheckSettings{Protocol: "TCP", Port: 22}, check)
+
+	description, err = plugin.CommitGroup(groupSpecWithAutoHealing(`{}`), false)
+
+	require.NoError(t, err)
+	require.Equal(t, "Disabling autohealing", description)
+	healthCheck, _ = api.AutoHealingPolicy("workers")
+	require.Empty(t, healthCheck)
+	_, present = api.HealthCheck("workers-autohealing")
+	require.False(t, present)
+}
+
+func TestRecommitGroupWithSameAutoHealing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+	spec := groupSpecWithAutoHealing(`{"Protocol": "HTTP", "InitialDelaySec": 60}`)
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+
+	_, err := plugin.CommitGroup(spec, false)
+	require.NoError(t, err)
+
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+	description, err := plugin.CommitGroup(spec, false)
+	require.NoError(t, err)
+	require.Empty(t, description)
+
+	restarted := NewPlugin(api, map[group.ID]settings{})
+	restarted.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+
+	_, err = restarted.CommitGroup(spec, false)
+	require.NoError(t, err)
+	healthCheck, initialDelaySec := api.AutoHealingPolicy("workers")
+	require.Equal(t, "workers-autohealing", healthCheck)
+	require.Equal(t, int64(60), initialDelaySec)
+}
+
+func TestDestroyGroupWithAutoHealing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := fake.New("PROJECT", "us-central1-f")
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{"NamePrefix":"workers"}`)
+
+	_, err := plugin.CommitGroup(groupSpecWithAutoHealing(`{"Protocol": "HTTPS"}`), false)
+	require.NoError(t, err)
+
+	require.NoError(t, plugin.DestroyGroup("workers"))
+
+	_, present := api.HealthCheck("workers-autohealing")
+	require.False(t, present)
+}
+
+func TestCommitGroupInvalidAutoHealing(t *testing.T) {
+	invalid := map[string]string{
+		`{"Protocol": "UDP"}`:                         "AutoHealing.Protocol must be HTTP, HTTPS or TCP",
+		`{"Protocol": "TCP"}`:                         "AutoHealing.Port must be between 1 and 65535",
+		`{"Protocol": "HTTP", "InitialDelaySec": -1}`: "AutoHealing intervals, timeouts, thresholds and delays must be >= 0",
+	}
+	for autoHealing, message := range invalid {
+		ctrl := gomock.NewController(t)
+
+		plugin := NewPlugin(fake.New("PROJECT", "us-central1-f"), map[group.ID]settings{})
+		plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{}`)
+
+		_, err := plugin.CommitGroup(groupSpecWithAutoHealing(autoHealing), false)
+
+		require.EqualError(t, err, message)
+		ctrl.Finish()
+	}
+}
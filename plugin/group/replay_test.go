@@ -0,0 +1,58 @@
+package group
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/infrakit.gcp/plugin/gcloud"
+	"github.com/docker/infrakit/pkg/spi/group"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+// NewReplayAPI answers the calls with the exchanges of a fixture. With
+// GCLOUD_RECORD_PROJECT set, the calls go to that project instead and the
+// fixture is recorded again when the test ends.
+func NewReplayAPI(t *testing.T, fixture string) (gcloud.API, func()) {
+	if project := os.Getenv("GCLOUD_RECORD_PROJECT"); project != "" {
+		recorder := gcloud.NewRecorder(project)
+		api, err := gcloud.NewAPI(project, "us-central1-f", gcloud.WithRecorder(recorder))
+		require.NoError(t, err)
+
+		return api, func() { require.NoError(t, recorder.Save(fixture)) }
+	}
+
+	replayer, err := gcloud.LoadReplayer("PROJECT", fixture)
+	require.NoError(t, err)
+	api, err := gcloud.NewAPI("PROJECT", "us-central1-f", gcloud.WithReplayer(replayer), gcloud.WithPollInterval(time.Millisecond))
+	require.NoError(t, err)
+
+	return api, func() { require.Equal(t, 0, replayer.Remaining(), "Exchanges left in %s", fixture) }
+}
+
+func TestCommitAndDestroyGroupReplay(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api, done := NewReplayAPI(t, "testdata/commit_and_destroy_group.json")
+	defer done()
+
+	defer func(original func() time.Time) { now = original }(now)
+	now = func() time.Time { return time.Date(2017, 6, 13, 13, 12, 4, 0, time.UTC) }
+
+	plugin := NewPlugin(api, map[group.ID]settings{})
+	plugin.flavorPlugins = NewFlavorLookup(t, ctrl, `{
+		"NamePrefix":"workers",
+		"MachineType":"n1-standard-1",
+		"Disks":[{"SizeGb":10, "Image":"debian-cloud/global/images/family/debian-9", "Type":"pd-standard", "AutoDelete":true}]
+	}`)
+	_, err := plugin.CommitGroup(workersSpec, false)
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"workers-1"}, plugin.groups["workers"].createdTemplates)
+
+	err = plugin.DestroyGroup("workers")
+
+	require.NoError(t, err)
+}
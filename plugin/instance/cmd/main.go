@@ -7,6 +7,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/infrakit.gcp/plugin"
 	instance_plugin "github.com/docker/infrakit.gcp/plugin/instance"
+	maintenance_rpc "github.com/docker/infrakit.gcp/plugin/instance/rpc"
 	metadata_plugin "github.com/docker/infrakit.gcp/plugin/metadata"
 	"github.com/docker/infrakit/pkg/cli"
 	instance_rpc "github.com/docker/infrakit/pkg/rpc/instance"
@@ -24,6 +25,13 @@ func main() {
 	logLevel := cmd.Flags().Int("log", cli.DefaultLogLevel, "Logging level. 0 is least verbose. Max is 5")
 	project := cmd.Flags().String("project", "", "Google Cloud project")
 	zone := cmd.Flags().String("zone", "", "Google Cloud zone")
+	allZones := cmd.Flags().Bool("all-zones", false, "Describe the instances of all the zones of the project")
+	tagLabels := cmd.Flags().Bool("tag-labels", false, "Label the instances with their tags and filter the described instances by label")
+	fallbackZones := cmd.Flags().StringSlice("fallback-zones", []string{}, "Zones to create the instances in, in order, when the zone is out of capacity")
+	listCacheTTL := cmd.Flags().Duration("list-cache", 0, "Reuse the listing of instances for the describe calls made within this duration")
+	requestTimeout := cmd.Flags().Duration("request-timeout", 0, "Bound the Compute API calls made for each request. 10m if 0")
+	clearDeletionProtection := cmd.Flags().Bool("clear-deletion-protection", false, "Clear the deletion protection of the instances being destroyed, instead of failing")
+	gcloudOptions := plugin.GCloudOptions(cmd.Flags(), "instance")
 	namespaceTags := cmd.Flags().StringSlice("namespace-tags", []string{},
 		"A list of key=value resource tags to namespace all resources created")
 
@@ -42,9 +50,29 @@ func main() {
 
 		log.Debug("Using namespace", namespace)
 
+		options := gcloudOptions()
+
+		instanceOptions := []instance_plugin.Option{}
+		if *tagLabels {
+			instanceOptions = append(instanceOptions, instance_plugin.WithTagLabels())
+		}
+		if len(*fallbackZones) > 0 {
+			instanceOptions = append(instanceOptions, instance_plugin.WithFallbackZones(*fallbackZones...))
+		}
+		if *clearDeletionProtection {
+			instanceOptions = append(instanceOptions, instance_plugin.WithClearDeletionProtection())
+		}
+		if *listCacheTTL > 0 {
+			instanceOptions = append(instanceOptions, instance_plugin.WithListCacheTTL(*listCacheTTL))
+		}
+
+		instancePlugin := instance_plugin.NewGCEInstancePlugin(*project, *zone, *allZones, namespace, options, instanceOptions...)
+		instancePlugin.(plugin.RequestTimeouts).SetRequestTimeout(*requestTimeout)
+
 		cli.RunPlugin(*name,
-			instance_rpc.PluginServer(instance_plugin.NewGCEInstancePlugin(*project, *zone, namespace)),
-			metadata_rpc.PluginServer(metadata_plugin.NewGCEMetadataPlugin(*project, *zone)),
+			instance_rpc.PluginServer(instancePlugin),
+			maintenance_rpc.PluginServer(instancePlugin.(instance_plugin.MaintenanceReporter)),
+			metadata_rpc.PluginServer(metadata_plugin.NewGCEMetadataPlugin(*project, *zone, options...)),
 		)
 	}
 
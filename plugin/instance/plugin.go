@@ -1,10 +1,15 @@
 package instance
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/infrakit.gcp/plugin/gcloud"
@@ -16,23 +21,162 @@ import (
 	"google.golang.org/api/compute/v1"
 )
 
+// defaultRequestTimeout bounds the Compute API calls made for a single plugin
+// request, unless the plugin is given another timeout.
+const defaultRequestTimeout = 10 * time.Minute
+
+// defaultPollInterval is how often the status of an instance is checked while
+// waiting for it to be RUNNING.
+const defaultPollInterval = 5 * time.Second
+
+// nameSuffixLength is the length of the random suffix of the generated
+// instance names.
+const nameSuffixLength = 6
+
+// maxNameAttempts bounds how many names are generated before giving up on
+// finding one that no instance has.
+const maxNameAttempts = 5
+
 type plugin struct {
-	API       gcloud.API
-	namespace map[string]string
+	API           gcloud.API
+	namespace     map[string]string
+	allZones      bool
+	fallbackZones []string
+	zones         map[instance.ID]string
+	tagLabels     bool
+	lock          sync.Mutex
+	timeout       time.Duration
+	pollInterval  time.Duration
+
+	clearDeletionProtection bool
+
+	listCacheTTL        time.Duration
+	listCache           []*compute.Instance
+	listCacheTime       time.Time
+	listCacheGeneration int
+}
+
+// Option configures the instance plugin.
+type Option func(*plugin)
+
+// WithTagLabels also sets the tags of the instances as labels, when they make
+// valid labels, and matches these labels server-side when describing
+// instances. Only use it once all the instances of the namespace are labeled:
+// the others would no longer be described.
+func WithTagLabels() Option {
+	return func(p *plugin) {
+		p.tagLabels = true
+	}
+}
+
+// WithClearDeletionProtection lets Destroy clear the deletion protection of
+// the instances. Otherwise destroying a protected instance fails.
+func WithClearDeletionProtection() Option {
+	return func(p *plugin) {
+		p.clearDeletionProtection = true
+	}
+}
+
+// WithListCacheTTL reuses the listing of the instances for the describe calls
+// made within the ttl, unless instances were changed by the plugin meanwhile.
+func WithListCacheTTL(ttl time.Duration) Option {
+	return func(p *plugin) {
+		p.listCacheTTL = ttl
+	}
+}
+
+// WithFallbackZones creates the instances in the first of the zones, tried in
+// order, that isn't out of capacity when the plugin's zone is. The zones must
+// be in the region of the plugin's zone, and the instances are then described
+// across all the zones.
+func WithFallbackZones(zones ...string) Option {
+	return func(p *plugin) {
+		p.fallbackZones = zones
+		p.allZones = true
+	}
 }
 
 // NewGCEInstancePlugin creates a new GCE instance plugin for a given project
-// and zone.
-func NewGCEInstancePlugin(project, zone string, namespace map[string]string) instance.Plugin {
-	api, err := gcloud.NewAPI(project, zone)
+// and zone. With allZones, instances are described across all the zones of the
+// project, not only the plugin's zone.
+func NewGCEInstancePlugin(project, zone string, allZones bool, namespace map[string]string, gcloudOptions []gcloud.Option, options ...Option) instance.Plugin {
+	api, err := gcloud.NewAPI(project, zone, gcloudOptions...)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	return &plugin{
+	p := &plugin{
 		API:       api,
 		namespace: namespace,
+		allZones:  allZones,
+		zones:     map[instance.ID]string{},
+	}
+	for _, option := range options {
+		option(p)
+	}
+
+	if err = checkFallbackZones(zone, p.fallbackZones); err != nil {
+		log.Fatal(err)
+	}
+	if p.allZones {
+		p.loadZones()
+	}
+
+	return p
+}
+
+// checkFallbackZones makes sure that the fallback zones are in the region of
+// the plugin's zone, since the subnetworks, the static IPs and the target
+// pools the instances use are regional.
+func checkFallbackZones(zone string, fallbackZones []string) error {
+	for _, fallbackZone := range fallbackZones {
+		if regionOf(fallbackZone) != regionOf(zone) {
+			return fmt.Errorf("Fallback zone %s is not in the region of zone %s", fallbackZone, zone)
+		}
+	}
+
+	return nil
+}
+
+// regionOf is the region of a zone, eg. us-central1 for us-central1-f.
+func regionOf(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// loadZones lists the instances of the namespace once, so that the instances
+// created in another zone before the plugin was restarted are destroyed in
+// their own zone.
+func (p *plugin) loadZones() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	filter := ""
+	if p.tagLabels {
+		filter = joinFilters(labelFilters(p.namespace))
+	}
+
+	if _, err := p.listInstances(ctx, filter); err != nil {
+		log.Warningln("Failed to list the zones of the instances:", err)
+	}
+}
+
+// SetRequestTimeout bounds the Compute API calls made for a single plugin
+// request. Zero restores the default of 10 minutes.
+func (p *plugin) SetRequestTimeout(timeout time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.timeout = timeout
+}
+
+func (p *plugin) requestTimeout() time.Duration {
+	if p.timeout <= 0 {
+		return defaultRequestTimeout
 	}
+	return p.timeout
 }
 
 func (p *plugin) VendorInfo() *spi.VendorInfo {
@@ -48,27 +192,241 @@ func (p *plugin) VendorInfo() *spi.VendorInfo {
 func (p *plugin) Validate(req *types.Any) error {
 	log.Debugln("validate", req.String())
 
-	parsed := instance_types.Properties{}
-	return req.Decode(&parsed)
+	properties, err := instance_types.ParseProperties(req)
+	if err != nil {
+		return err
+	}
+	if err = properties.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	return p.API.ValidateNetwork(ctx, properties.Network, properties.Subnetwork)
 }
 
 func (p *plugin) Label(instance instance.ID, labels map[string]string) error {
 	metadata := gcloud.TagsToMetaData(labels)
 
-	return p.API.AddInstanceMetadata(string(instance), metadata)
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+	defer p.invalidateListCache()
+
+	if err := p.API.AddInstanceMetadata(ctx, string(instance), metadata); err != nil {
+		return err
+	}
+
+	// Keep the labels in line with the tags they are matched against.
+	if tagLabels := gcloud.TagsToLabels(labels); p.tagLabels && len(tagLabels) > 0 {
+		return p.API.AddInstanceLabels(ctx, string(instance), tagLabels)
+	}
+
+	return nil
+}
+
+// MaintenanceReporter is implemented by the instance plugins that report the
+// host maintenance of their instances.
+type MaintenanceReporter interface {
+	// GetMaintenanceStatus tells how an instance reacts to host maintenance,
+	// and whether it's under maintenance.
+	GetMaintenanceStatus(id instance.ID) (*MaintenanceStatus, error)
+}
+
+// MaintenanceStatus is the host maintenance status of an instance.
+type MaintenanceStatus struct {
+	// OnHostMaintenance is MIGRATE when the instance is live migrated off a
+	// host under maintenance, TERMINATE when it's stopped.
+	OnHostMaintenance string
+
+	// UnderMaintenance is true while GCE live migrates or terminates the
+	// instance for a host maintenance.
+	UnderMaintenance bool
+
+	// LastMaintenance is when the last recorded maintenance of the instance
+	// started, nil when none is recorded.
+	LastMaintenance *time.Time
+
+	// Scheduled is the maintenance GCE scheduled on the host of the
+	// instance, nil when none is.
+	Scheduled *gcloud.UpcomingMaintenance
+}
+
+// GetMaintenanceStatus reads the maintenance policy of an instance, the
+// maintenance GCE scheduled on its host and the maintenance operations GCE
+// ran on it.
+func (p *plugin) GetMaintenanceStatus(id instance.ID) (*MaintenanceStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	zone := p.zoneOf(id)
+	if zone == "" {
+		zone = p.API.GetZone()
+	}
+	maintenance, err := p.API.GetInstanceMaintenance(ctx, zone, string(id))
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MaintenanceStatus{
+		OnHostMaintenance: maintenance.OnHostMaintenance,
+		Scheduled:         maintenance.Upcoming,
+	}
+	if status.Scheduled != nil && status.Scheduled.Status == "ONGOING" {
+		status.UnderMaintenance = true
+	}
+
+	operations, err := p.API.ListMaintenanceOperations(ctx, zone, string(id))
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range operations {
+		if op.Status != "DONE" {
+			status.UnderMaintenance = true
+		}
+
+		// The offsets of the times can differ, so they're compared parsed.
+		started, err := time.Parse(time.RFC3339, op.InsertTime)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid start time %q of maintenance operation %s: %v", op.InsertTime, op.Name, err)
+		}
+		if status.LastMaintenance == nil || started.After(*status.LastMaintenance) {
+			status.LastMaintenance = &started
+		}
+	}
+
+	return status, nil
+}
+
+// BatchProvisioner is implemented by the instance plugins that provision the
+// instances of many specs at once.
+type BatchProvisioner interface {
+	// ProvisionBatch returns, for each spec, the ID of its instance or the
+	// error that kept it from being provisioned.
+	ProvisionBatch(specs []instance.Spec) ([]*instance.ID, []error)
+}
+
+// provisioning is an instance about to be created for a spec.
+type provisioning struct {
+	id         instance.ID
+	properties *instance_types.Properties
+	settings   *gcloud.InstanceSettings
 }
 
 func (p *plugin) Provision(spec instance.Spec) (*instance.ID, error) {
+	prov, err := p.prepare(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.provisionTimeout(prov))
+	defer cancel()
+	defer p.invalidateListCache()
+
+	name := string(prov.id)
+	zone, err := p.createInstance(ctx, name, prov.settings)
+	if err = p.complete(ctx, prov, zone, err); err != nil {
+		return nil, err
+	}
+
+	return &prov.id, nil
+}
+
+// ProvisionBatch provisions the instances of many specs together. Their
+// inserts are sent concurrently and their operations waited for together,
+// which is much faster than provisioning them one by one. It returns, for each
+// spec, the ID of its instance or the error that kept it from being
+// provisioned.
+func (p *plugin) ProvisionBatch(specs []instance.Spec) ([]*instance.ID, []error) {
+	ids := make([]*instance.ID, len(specs))
+	errs := make([]error, len(specs))
+
+	provs := map[string]*provisioning{}
+	indexes := map[string]int{}
+	instances := map[string]*gcloud.InstanceSettings{}
+	timeout := p.requestTimeout()
+	for i, spec := range specs {
+		prov, err := p.prepare(spec)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		name := string(prov.id)
+		if _, duplicate := provs[name]; duplicate {
+			errs[i] = fmt.Errorf("Instance %s is provisioned by several specs", name)
+			continue
+		}
+
+		provs[name] = prov
+		indexes[name] = i
+		instances[name] = prov.settings
+		if provisionTimeout := p.provisionTimeout(prov); provisionTimeout > timeout {
+			timeout = provisionTimeout
+		}
+	}
+
+	if len(instances) == 0 {
+		return ids, errs
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	defer p.invalidateListCache()
+
+	for name := range instances {
+		if err := p.checkUniqueName(ctx, name); err != nil {
+			errs[indexes[name]] = err
+			delete(instances, name)
+			delete(provs, name)
+		}
+	}
+	if len(instances) == 0 {
+		return ids, errs
+	}
+
+	failures := p.API.CreateInstances(ctx, instances)
+
+	var wg sync.WaitGroup
+	for name, prov := range provs {
+		wg.Add(1)
+
+		go func(name string, prov *provisioning) {
+			defer wg.Done()
+
+			zone, err := p.fallBack(ctx, name, prov.settings, failures[name])
+			if err = p.complete(ctx, prov, zone, err); err != nil {
+				errs[indexes[name]] = err
+				return
+			}
+
+			ids[indexes[name]] = &prov.id
+		}(name, prov)
+	}
+
+	wg.Wait()
+
+	return ids, errs
+}
+
+// prepare parses and validates a spec, and picks the name and the settings of
+// its instance.
+func (p *plugin) prepare(spec instance.Spec) (*provisioning, error) {
 	properties, err := instance_types.ParseProperties(spec.Properties)
 	if err != nil {
 		return nil, err
 	}
+	if err = properties.Validate(); err != nil {
+		return nil, err
+	}
 
 	settings := properties.InstanceSettings
 
 	var name string
 	if spec.LogicalID == nil {
-		name = fmt.Sprintf("%s-%s", properties.NamePrefix, util.RandomSuffix(6))
+		if name, err = p.unusedName(properties.NamePrefix); err != nil {
+			return nil, err
+		}
 	} else {
 		// IP addresses / Logical ID
 		// If the logical ID is set and is parsable as an IP address, then use that as the private IP
@@ -77,52 +435,438 @@ func (p *plugin) Provision(spec instance.Spec) (*instance.ID, error) {
 		if ip := net.ParseIP(string(*spec.LogicalID)); len(ip) > 0 {
 			settings.PrivateIP = ip.String()
 			name = fmt.Sprintf("%s-%s", properties.NamePrefix, strings.Replace(ip.String(), ".", "-", -1))
+			if err = util.ValidateName(name); err != nil {
+				return nil, fmt.Errorf("NamePrefix %q is too long for the IP address %s: %v", properties.NamePrefix, ip, err)
+			}
 		} else {
 			name = string(*spec.LogicalID)
 		}
 	}
 
-	id := instance.ID(name)
-
 	// Parse the metadata in the spec, also merge in namespace tags to create the final metadata
 	tags, err := instance_types.ParseTags(spec)
 	if err != nil {
 		return nil, err
 	}
 	_, tags = mergeTags(tags, p.namespace) // scope this resource with namespace tags
+	if len(properties.TargetPools) > 0 {
+		tags[instance_types.InfrakitTargetPools] = strings.Join(properties.TargetPools, ",")
+	}
 
 	// TODO - for now we overwrite, but support merging of MetaData field in the future, if the
 	// user provided some.
 	settings.MetaData = gcloud.TagsToMetaData(tags)
-
-	if err = p.API.CreateInstance(name, settings); err != nil {
+	if p.tagLabels {
+		labels := gcloud.TagsToLabels(tags)
+		for k, v := range settings.Labels {
+			if _, present := labels[k]; !present {
+				labels[k] = v
+			}
+		}
+		settings.Labels = labels
+	}
+	if err = gcloud.ValidateMetaData(settings.MetaData); err != nil {
 		return nil, err
 	}
+	settings.Tags = instance_types.NetworkTags(spec, settings.Tags)
+
+	return &provisioning{
+		id:         instance.ID(name),
+		properties: &properties,
+		settings:   settings,
+	}, nil
+}
+
+// provisionTimeout bounds the Compute API calls made to provision an
+// instance, including its creation and the wait for it to be RUNNING.
+func (p *plugin) provisionTimeout(prov *provisioning) time.Duration {
+	timeout := p.requestTimeout()
+	if creationTimeout := time.Duration(prov.settings.CreationTimeoutSeconds) * time.Second; creationTimeout > timeout {
+		timeout = creationTimeout + time.Minute
+	}
+	if prov.properties.WaitForRunning {
+		timeout += time.Duration(prov.properties.RunningTimeoutSeconds) * time.Second
+	}
+
+	return timeout
+}
+
+// complete finishes the provisioning of an instance once its creation
+// returned, in the given zone: it waits for the instance to be RUNNING and
+// adds it to its target pools. An instance that failed to be created in time
+// is deleted if the properties ask for it.
+func (p *plugin) complete(ctx context.Context, prov *provisioning, zone string, err error) error {
+	name := string(prov.id)
+	properties := prov.properties
 
+	if err != nil {
+		if properties.DeleteOnTimeout && isTimeout(err) {
+			p.deleteTimedOut(zone, prov.id)
+		}
+
+		return err
+	}
+
+	if zone != "" {
+		p.lock.Lock()
+		p.zones[prov.id] = zone
+		p.lock.Unlock()
+	}
+
+	if properties.WaitForRunning {
+		runningTimeout := time.Duration(properties.RunningTimeoutSeconds) * time.Second
+		if err = p.waitForRunning(ctx, zone, name, runningTimeout); err != nil {
+			err = p.withConsoleOutput(err, zone, name, properties.ConsoleOutputBytes)
+			if properties.DeleteOnTimeout && isTimeout(err) {
+				p.deleteTimedOut(zone, prov.id)
+			}
+			return err
+		}
+	}
+
+	reference := p.instanceReference(zone, name)
 	for _, targetPool := range properties.TargetPools {
-		if err = p.API.AddInstanceToTargetPool(targetPool, name); err != nil {
-			return nil, err
+		if err = p.API.AddInstanceToTargetPool(ctx, targetPool, reference); err != nil {
+			return err
 		}
 	}
 
-	return &id, nil
+	return nil
+}
+
+// isTimeout tells if an error is an operation that didn't complete in time,
+// or a provisioning request that timed out.
+func isTimeout(err error) bool {
+	var timeoutErr *gcloud.TimeoutError
+	var notRunningErr *notRunningError
+	return errors.As(err, &timeoutErr) || errors.As(err, &notRunningErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// deleteTimedOut deletes an instance that wasn't created, or wasn't RUNNING,
+// in time.
+func (p *plugin) deleteTimedOut(zone string, id instance.ID) {
+	log.Warningln("Deleting instance", id, "that failed to be created in time")
+
+	// The provisioning context may have expired already.
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	if err := p.deleteInstance(ctx, zone, string(id)); err != nil {
+		log.Warningln("Failed to delete instance", id, err)
+		return
+	}
+
+	p.forgetZone(id)
 }
 
 func (p *plugin) Destroy(id instance.ID) error {
-	err := p.API.DeleteInstance(string(id))
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+	defer p.invalidateListCache()
+
+	zone := p.zoneOf(id)
+
+	err := p.unprotect(ctx, zone, string(id))
+	if err == nil {
+		err = p.leaveTargetPools(ctx, zone, string(id))
+	}
+	if err == nil {
+		err = p.deleteInstance(ctx, zone, string(id))
+	}
 
 	log.Debugln("destroy", id, "err=", err)
 
+	if errors.Is(err, gcloud.ErrNotFound) {
+		log.Warningln("Instance", id, "is already deleted")
+		err = nil
+	}
+	if err == nil {
+		p.forgetZone(id)
+	}
+
 	return err
 }
 
+// unprotect clears the deletion protection of an instance about to be
+// destroyed, if the plugin is allowed to.
+func (p *plugin) unprotect(ctx context.Context, zone, name string) error {
+	if zone == "" {
+		zone = p.API.GetZone()
+	}
+
+	protected, err := p.API.GetDeletionProtection(ctx, zone, name)
+	if err != nil || !protected {
+		return err
+	}
+
+	if !p.clearDeletionProtection {
+		return fmt.Errorf("Instance %s is protected against deletion. Clear its deletion protection, or let the plugin clear it with --clear-deletion-protection", name)
+	}
+
+	log.Infoln("Clearing the deletion protection of", name)
+
+	return p.API.SetDeletionProtection(ctx, zone, name, false)
+}
+
+// unusedName picks a name made of a prefix and a short random suffix that no
+// instance of the plugin's zone has yet.
+func (p *plugin) unusedName(prefix string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	for attempt := 0; attempt < maxNameAttempts; attempt++ {
+		name := fmt.Sprintf("%s-%s", prefix, util.RandomSuffix(nameSuffixLength))
+
+		_, err := p.API.GetInstance(ctx, name)
+		if errors.Is(err, gcloud.ErrNotFound) {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		log.Warningln("Instance name", name, "is already used")
+	}
+
+	return "", fmt.Errorf("No unused instance name found with prefix %s after %d attempts", prefix, maxNameAttempts)
+}
+
+// createInstance creates an instance in the plugin's zone or, if it's out of
+// capacity, in the first fallback zone that isn't. It returns the zone of the
+// last attempt, empty for the plugin's zone.
+func (p *plugin) createInstance(ctx context.Context, name string, settings *gcloud.InstanceSettings) (string, error) {
+	if err := p.checkUniqueName(ctx, name); err != nil {
+		return "", err
+	}
+
+	return p.fallBack(ctx, name, settings, p.API.CreateInstance(ctx, name, settings))
+}
+
+// checkUniqueName fails if an instance with the given name exists in any zone
+// when there are fallback zones. Names are only unique within a zone, while
+// the instances are identified by their name alone.
+func (p *plugin) checkUniqueName(ctx context.Context, name string) error {
+	if len(p.fallbackZones) == 0 {
+		return nil
+	}
+
+	existing, err := p.API.AggregatedListInstances(ctx, fmt.Sprintf("name eq %s", regexp.QuoteMeta(name)))
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("Instance %s already exists in zone %s", name, last(existing[0].Zone))
+	}
+
+	return nil
+}
+
+// fallBack creates an instance in the fallback zones when its creation in the
+// plugin's zone failed with err because the zone is out of capacity. It
+// returns the zone of the last attempt, empty for the plugin's zone.
+func (p *plugin) fallBack(ctx context.Context, name string, settings *gcloud.InstanceSettings, err error) (string, error) {
+	zone := ""
+
+	for _, fallbackZone := range p.fallbackZones {
+		if !errors.Is(err, gcloud.ErrStockout) {
+			break
+		}
+
+		log.Warningln("Out of capacity, creating instance", name, "in zone", fallbackZone, "instead:", err)
+
+		zone = fallbackZone
+		err = p.API.CreateInstanceInZone(ctx, zone, name, settings)
+	}
+
+	return zone, err
+}
+
+// leaveTargetPools removes an instance from the target pools it was added to
+// when it was provisioned. Target pools that are already gone are ignored.
+func (p *plugin) leaveTargetPools(ctx context.Context, zone, name string) error {
+	inst, err := p.getInstance(ctx, zone, name)
+	if err != nil {
+		return err
+	}
+
+	targetPools := gcloud.MetaDataToTags(inst.Metadata.Items)[instance_types.InfrakitTargetPools]
+	if targetPools == "" {
+		return nil
+	}
+
+	reference := p.instanceReference(zone, name)
+	for _, targetPool := range strings.Split(targetPools, ",") {
+		err := p.API.RemoveInstanceFromTargetPool(ctx, targetPool, reference)
+		if err != nil && !errors.Is(err, gcloud.ErrNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// instanceReference is how target pools refer to an instance: by name in the
+// plugin's zone, by url in other zones.
+func (p *plugin) instanceReference(zone, name string) string {
+	if zone == "" {
+		return name
+	}
+
+	return fmt.Sprintf("projects/%s/zones/%s/instances/%s", p.API.GetProject(), zone, name)
+}
+
+// waitForRunning polls the status of an instance until it's RUNNING or until
+// the timeout expires.
+func (p *plugin) waitForRunning(ctx context.Context, zone, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		inst, err := p.getInstance(ctx, zone, name)
+		if err != nil {
+			return err
+		}
+
+		switch inst.Status {
+		case "RUNNING":
+			return nil
+		case "STOPPING", "STOPPED", "SUSPENDED", "TERMINATED":
+			return fmt.Errorf("Instance %s is %s instead of RUNNING", name, inst.Status)
+		}
+
+		if time.Now().After(deadline) {
+			return &notRunningError{name: name, status: inst.Status, timeout: timeout}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.statusPollInterval()):
+		}
+	}
+}
+
+// notRunningError is returned when an instance isn't RUNNING in time.
+type notRunningError struct {
+	name    string
+	status  string
+	timeout time.Duration
+}
+
+func (e *notRunningError) Error() string {
+	return fmt.Sprintf("Instance %s is still %s after %v", e.name, e.status, e.timeout)
+}
+
+// withConsoleOutput adds the end of the serial console output of an instance
+// to an error, since that's where a failing startup script leaves its traces.
+// Only the instances of the plugin's zone are covered.
+func (p *plugin) withConsoleOutput(err error, zone, name string, maxBytes int) error {
+	if maxBytes == 0 || (zone != "" && zone != p.API.GetZone()) {
+		return err
+	}
+
+	// The provisioning context may have expired already.
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
+
+	output, errOutput := p.API.GetSerialPortOutput(ctx, name, 1)
+	if errOutput != nil {
+		log.Warningln("Failed to get the console output of", name, errOutput)
+		return err
+	}
+	if output == "" {
+		return err
+	}
+
+	return fmt.Errorf("%w\nLast console output:\n%s", err, lastBytes(output, maxBytes))
+}
+
+// lastBytes keeps the end of an output, starting with a full line when it's
+// truncated.
+func lastBytes(output string, maxBytes int) string {
+	if len(output) <= maxBytes {
+		return output
+	}
+
+	output = output[len(output)-maxBytes:]
+	if i := strings.Index(output, "\n"); i >= 0 && i < len(output)-1 {
+		output = output[i+1:]
+	}
+
+	return "...\n" + output
+}
+
+func (p *plugin) statusPollInterval() time.Duration {
+	if p.pollInterval <= 0 {
+		return defaultPollInterval
+	}
+	return p.pollInterval
+}
+
+// externalIP is the external IP of an instance, if it has one.
+func externalIP(inst *compute.Instance) string {
+	for _, networkInterface := range inst.NetworkInterfaces {
+		for _, accessConfig := range networkInterface.AccessConfigs {
+			if accessConfig.NatIP != "" {
+				return accessConfig.NatIP
+			}
+		}
+	}
+
+	return ""
+}
+
+// getInstance gets an instance from a given zone, the plugin's zone if it's
+// empty.
+func (p *plugin) getInstance(ctx context.Context, zone, name string) (*compute.Instance, error) {
+	if zone != "" && zone != p.API.GetZone() {
+		return p.API.GetInstanceInZone(ctx, zone, name)
+	}
+
+	return p.API.GetInstance(ctx, name)
+}
+
+// deleteInstance deletes an instance from a given zone, the plugin's zone if
+// it's empty.
+func (p *plugin) deleteInstance(ctx context.Context, zone, name string) error {
+	if zone != "" && zone != p.API.GetZone() {
+		return p.API.DeleteInstanceInZone(ctx, zone, name)
+	}
+
+	return p.API.DeleteInstance(ctx, name)
+}
+
 func (p *plugin) DescribeInstances(tags map[string]string, properties bool) ([]instance.Description, error) {
 	log.Debugln("describe-instances", tags)
 
+	// With MatchAny, an instance must have one of the requested tags but still
+	// all the namespace tags.
+	requested := map[string]string{}
+	for k, v := range tags {
+		requested[k] = v
+	}
+	matchAny := requested[gcloud.MatchTag] == gcloud.MatchAny
+	delete(requested, gcloud.MatchTag)
+
 	// apply the scoping namespace to restrict what we search for
-	_, tags = mergeTags(tags, p.namespace)
+	_, tags = mergeTags(requested, p.namespace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.requestTimeout())
+	defer cancel()
 
-	instances, err := p.API.ListInstances()
+	// With MatchAny, an instance needn't have the requested logical ID, so the
+	// name can't narrow down the list, and only the namespace tags are required.
+	filters := []string{}
+	required := tags
+	if matchAny {
+		required = p.namespace
+	} else if filter := nameFilter(tags); filter != "" {
+		filters = append(filters, filter)
+	}
+	if p.tagLabels {
+		filters = append(filters, labelFilters(required)...)
+	}
+
+	instances, err := p.listInstances(ctx, joinFilters(filters))
 	if err != nil {
 		return nil, err
 	}
@@ -130,10 +874,21 @@ func (p *plugin) DescribeInstances(tags map[string]string, properties bool) ([]i
 	log.Debugln("total count:", len(instances))
 
 	result := []instance.Description{}
+	skipped := gcloud.UnrequestedReservedKeys(requested)
 
 	for _, inst := range instances {
-		instTags := gcloud.MetaDataToTags(inst.Metadata.Items)
-		if gcloud.HasDifferentTag(tags, instTags) {
+		instTags := gcloud.MetaDataToTagsWithout(inst.Metadata.Items, skipped)
+		if inst.Scheduling != nil && inst.Scheduling.Preemptible {
+			instTags[instance_types.InfrakitPreemptible] = "true"
+		}
+		if externalIP := externalIP(inst); externalIP != "" {
+			instTags[instance_types.InfrakitExternalIP] = externalIP
+		}
+		if matchAny {
+			if gcloud.HasDifferentTag(p.namespace, instTags) || gcloud.HasNoMatchingTag(requested, instTags) {
+				continue
+			}
+		} else if gcloud.HasDifferentTag(tags, instTags) {
 			continue
 		}
 
@@ -159,6 +914,121 @@ func (p *plugin) DescribeInstances(tags map[string]string, properties bool) ([]i
 	return result, nil
 }
 
+// listInstances lists the instances of the plugin's zone or, with allZones,
+// of every zone, remembering the zone of each instance for Destroy. With a
+// listCacheTTL, the unfiltered listing is kept for that long and reused by the
+// following calls, whatever their filter.
+func (p *plugin) listInstances(ctx context.Context, filter string) ([]*compute.Instance, error) {
+	p.lock.Lock()
+	cached, listed, generation := p.listCache, p.listCacheTime, p.listCacheGeneration
+	p.lock.Unlock()
+
+	if p.listCacheTTL > 0 {
+		if cached != nil && time.Since(listed) < p.listCacheTTL {
+			return cached, nil
+		}
+		filter = ""
+	}
+
+	var instances []*compute.Instance
+	var err error
+	if p.allZones {
+		instances, err = p.API.AggregatedListInstances(ctx, filter)
+	} else {
+		instances, err = p.API.ListInstances(ctx, filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.allZones {
+		for _, inst := range instances {
+			p.zones[instance.ID(inst.Name)] = last(inst.Zone)
+		}
+	}
+	// A listing that raced with a change of the instances is already stale.
+	if p.listCacheTTL > 0 && generation == p.listCacheGeneration {
+		p.listCache, p.listCacheTime = instances, time.Now()
+	}
+
+	return instances, nil
+}
+
+// invalidateListCache drops the cached listing once instances change, and
+// keeps the listings still running from being cached.
+func (p *plugin) invalidateListCache() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.listCache = nil
+	p.listCacheGeneration++
+}
+
+// nameFilter narrows down the instances listed by the API when the requested
+// tags include a logical ID, since it determines the instance name. Every
+// other tag is stored in the metadata and is matched client-side.
+func nameFilter(tags map[string]string) string {
+	logicalID, present := tags[instance_types.InfrakitLogicalID]
+	if !present || logicalID == "" {
+		return ""
+	}
+
+	if ip := net.ParseIP(logicalID); len(ip) > 0 {
+		return fmt.Sprintf("name eq .*-%s", regexp.QuoteMeta(strings.Replace(ip.String(), ".", "-", -1)))
+	}
+
+	return fmt.Sprintf("name eq %s", regexp.QuoteMeta(logicalID))
+}
+
+// labelFilters match the tags that make valid labels against the labels of the
+// instances.
+func labelFilters(tags map[string]string) []string {
+	filters := []string{}
+	for key, value := range gcloud.TagsToLabels(tags) {
+		if value == "" {
+			continue
+		}
+		filters = append(filters, fmt.Sprintf("labels.%s eq %s", key, value))
+	}
+	sort.Strings(filters)
+
+	return filters
+}
+
+// joinFilters combines filter expressions. The API requires each one to be in
+// parentheses when there are several.
+func joinFilters(filters []string) string {
+	if len(filters) == 1 {
+		return filters[0]
+	}
+
+	joined := []string{}
+	for _, filter := range filters {
+		joined = append(joined, "("+filter+")")
+	}
+
+	return strings.Join(joined, " ")
+}
+
+func (p *plugin) zoneOf(id instance.ID) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.zones[id]
+}
+
+// forgetZone drops the zone of a deleted instance, since an instance with the
+// same name may be created in another zone.
+func (p *plugin) forgetZone(id instance.ID) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.zones, id)
+}
+
 func logicalID(inst *compute.Instance, tags map[string]string) *instance.LogicalID {
 	_, present := tags[instance_types.InfrakitGCPVersion]
 	if !present {
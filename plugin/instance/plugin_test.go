@@ -1,9 +1,13 @@
 package instance
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
+	"time"
 
 	mock_gcloud "github.com/docker/infrakit.gcp/mock/gcloud"
 	"github.com/docker/infrakit.gcp/plugin/gcloud"
@@ -19,6 +23,13 @@ func NewMockGCloud(t *testing.T) (*mock_gcloud.MockAPI, *gomock.Controller) {
 	return mock_gcloud.NewMockAPI(ctrl), ctrl
 }
 
+// ExpectUnprotected expects the deletion protection of an instance of the
+// plugin's zone to be checked, and not set.
+func ExpectUnprotected(api *mock_gcloud.MockAPI, name string) *gomock.Call {
+	api.EXPECT().GetZone().Return("us-central1-f").AnyTimes()
+	return api.EXPECT().GetDeletionProtection(gomock.Any(), "us-central1-f", name).Return(false, nil)
+}
+
 func NewPlugin(api gcloud.API, namespace map[string]string) instance.Plugin {
 	return &plugin{API: api, namespace: namespace}
 }
@@ -34,9 +45,9 @@ func TestProvision(t *testing.T) {
 		"Disks":[{
 			"SizeGb":100,
 			"Image":"docker-image",
-			"Type":"ssd"
+			"Type":"pd-ssd"
 		}],
-		"Scopes":["SCOPE1", "SCOPE2"],
+		"Scopes":["storage-ro", "https://www.googleapis.com/auth/logging.write"],
 		"TargetPools":["POOL1", "POOL2"],
 		"Preemptible":true,
 		"Description":"vm"}`)
@@ -48,35 +59,37 @@ func TestProvision(t *testing.T) {
 	rand.Seed(0)
 	api, ctrl := NewMockGCloud(t)
 	defer ctrl.Finish()
-	api.EXPECT().CreateInstance("worker-ssnk9q", &gcloud.InstanceSettings{
+	api.EXPECT().GetInstance(gomock.Any(), "worker-ssnk9q").Return(nil, gcloud.ErrNotFound)
+	api.EXPECT().CreateInstance(gomock.Any(), "worker-ssnk9q", &gcloud.InstanceSettings{
 		Description: "vm",
 		MachineType: "n1-standard-1",
 		PrivateIP:   "10.20.2.100",
 		Network:     "NETWORK",
 		Subnetwork:  "SUB_EUROPE",
 		Tags:        []string{"TAG1", "TAG2"},
-		Scopes:      []string{"SCOPE1", "SCOPE2"},
+		Scopes:      []string{"storage-ro", "https://www.googleapis.com/auth/logging.write"},
 		Preemptible: true,
 		Disks: []gcloud.DiskSettings{
 			{
 				Boot:          true,
 				SizeGb:        100,
 				Image:         "docker-image",
-				Type:          "ssd",
+				Type:          "pd-ssd",
 				AutoDelete:    true,
 				ReuseExisting: false,
 			},
 		},
 		MetaData: gcloud.TagsToMetaData(map[string]string{
-			"key1":                 "value1",
-			"key2":                 "value2",
-			"startup-script":       "echo 'Startup'",
-			"userdata":             "echo 'Startup'",
-			"infrakit-gcp-version": "1",
+			"key1":                  "value1",
+			"key2":                  "value2",
+			"startup-script":        "echo 'Startup'",
+			"userdata":              "echo 'Startup'",
+			"infrakit-gcp-version":  "1",
+			"infrakit-target-pools": "POOL1,POOL2",
 		}),
 	}).Return(nil)
-	api.EXPECT().AddInstanceToTargetPool("POOL1", "worker-ssnk9q").Return(nil)
-	api.EXPECT().AddInstanceToTargetPool("POOL2", "worker-ssnk9q").Return(nil)
+	api.EXPECT().AddInstanceToTargetPool(gomock.Any(), "POOL1", "worker-ssnk9q").Return(nil)
+	api.EXPECT().AddInstanceToTargetPool(gomock.Any(), "POOL2", "worker-ssnk9q").Return(nil)
 
 	plugin := NewPlugin(api, nil)
 	id, err := plugin.Provision(instance.Spec{
@@ -89,6 +102,59 @@ func TestProvision(t *testing.T) {
 	require.Equal(t, *id, instance.ID("worker-ssnk9q"))
 }
 
+func TestProvisionBatch(t *testing.T) {
+	properties := types.AnyString(`{"MachineType":"g1-small", "TargetPools":["POOL"]}`)
+	settings := func(logicalID string) *gcloud.InstanceSettings {
+		return &gcloud.InstanceSettings{
+			MachineType: "g1-small",
+			Network:     "default",
+			Disks: []gcloud.DiskSettings{
+				{
+					Boot:       true,
+					SizeGb:     10,
+					Image:      "docker",
+					Type:       "pd-standard",
+					AutoDelete: true,
+				},
+			},
+			MetaData: gcloud.TagsToMetaData(map[string]string{
+				"infrakit-logical-id":   logicalID,
+				"infrakit-gcp-version":  "1",
+				"infrakit-target-pools": "POOL",
+			}),
+		}
+	}
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstances(gomock.Any(), map[string]*gcloud.InstanceSettings{
+		"vm-1": settings("vm-1"),
+		"vm-2": settings("vm-2"),
+	}).Return(map[string]error{"vm-2": errors.New("QUOTA_EXCEEDED")})
+	api.EXPECT().AddInstanceToTargetPool(gomock.Any(), "POOL", "vm-1").Return(nil)
+
+	vm1 := instance.LogicalID("vm-1")
+	vm2 := instance.LogicalID("vm-2")
+
+	plugin := NewPlugin(api, nil).(BatchProvisioner)
+	ids, errs := plugin.ProvisionBatch([]instance.Spec{
+		{LogicalID: &vm1, Properties: properties},
+		{LogicalID: &vm2, Properties: properties},
+		{LogicalID: &vm1, Properties: properties},
+		{LogicalID: &vm2, Properties: types.AnyString(`{"Preemptible":true, "OnHostMaintenance":"MIGRATE"}`)},
+	})
+
+	require.Len(t, ids, 4)
+	require.Equal(t, instance.ID("vm-1"), *ids[0])
+	require.Nil(t, ids[1])
+	require.Nil(t, ids[2])
+	require.Nil(t, ids[3])
+	require.NoError(t, errs[0])
+	require.EqualError(t, errs[1], "QUOTA_EXCEEDED")
+	require.EqualError(t, errs[2], "Instance vm-1 is provisioned by several specs")
+	require.EqualError(t, errs[3], "Preemptible instances can't have OnHostMaintenance MIGRATE")
+}
+
 func TestProvisionLogicalID(t *testing.T) {
 	properties := types.AnyString(`{
 		"Disks":[{
@@ -99,7 +165,7 @@ func TestProvisionLogicalID(t *testing.T) {
 
 	api, ctrl := NewMockGCloud(t)
 	defer ctrl.Finish()
-	api.EXPECT().CreateInstance("LOGICAL-ID", &gcloud.InstanceSettings{
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", &gcloud.InstanceSettings{
 		MachineType: "g1-small",
 		Network:     "default",
 		Preemptible: false,
@@ -132,6 +198,118 @@ func TestProvisionLogicalID(t *testing.T) {
 	require.Equal(t, *id, instance.ID("LOGICAL-ID"))
 }
 
+func TestProvisionWithTagLabels(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstance(gomock.Any(), "manager-1", gomock.Any()).Do(func(_ context.Context, _ string, settings *gcloud.InstanceSettings) {
+		require.Equal(t, map[string]string{
+			"infrakit-logical-id":  "manager-1",
+			"infrakit-gcp-version": "1",
+			"infrakit--group":      "managers",
+			"scope":                "test",
+			"team":                 "infra",
+		}, settings.Labels)
+	}).Return(nil)
+
+	logicalID := instance.LogicalID("manager-1")
+
+	plugin := &plugin{API: api, namespace: map[string]string{"scope": "test"}, tagLabels: true}
+	_, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{"infrakit.group": "managers", "Config": "SHA"},
+		Properties: types.AnyString(`{"Labels": {"team": "infra"}}`),
+	})
+
+	require.NoError(t, err)
+}
+
+func TestLabelWithTagLabels(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().AddInstanceMetadata(gomock.Any(), "vm", gcloud.TagsToMetaData(map[string]string{"role": "worker", "Config": "SHA"})).Return(nil)
+	api.EXPECT().AddInstanceLabels(gomock.Any(), "vm", map[string]string{"role": "worker"}).Return(nil)
+
+	plugin := &plugin{API: api, tagLabels: true}
+	err := plugin.Label("vm", map[string]string{"role": "worker", "Config": "SHA"})
+
+	require.NoError(t, err)
+}
+
+func TestProvisionBootDiskImageTypeAndPreemptible(t *testing.T) {
+	properties := types.AnyString(`{"DiskImage":"ubuntu-1804", "DiskType":"pd-ssd", "Preemptible":true}`)
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", &gcloud.InstanceSettings{
+		MachineType: "g1-small",
+		Network:     "default",
+		Preemptible: true,
+		Disks: []gcloud.DiskSettings{
+			{
+				Boot:       true,
+				SizeGb:     10,
+				Image:      "ubuntu-1804",
+				Type:       "pd-ssd",
+				AutoDelete: true,
+			},
+		},
+		MetaData: gcloud.TagsToMetaData(map[string]string{
+			"infrakit-logical-id":  "LOGICAL-ID",
+			"infrakit-gcp-version": "1",
+		}),
+	}).Return(nil)
+
+	plugin := NewPlugin(api, nil)
+	id, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: properties,
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, instance.ID("LOGICAL-ID"), *id)
+}
+
+func TestProvisionWithNetworkTagsFromFlavor(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", &gcloud.InstanceSettings{
+		MachineType: "g1-small",
+		Network:     "default",
+		Tags:        []string{"web", "swarm"},
+		Disks: []gcloud.DiskSettings{
+			{
+				Boot:       true,
+				SizeGb:     10,
+				Image:      "docker",
+				Type:       "pd-standard",
+				AutoDelete: true,
+			},
+		},
+		MetaData: gcloud.TagsToMetaData(map[string]string{
+			"role":                 "manager",
+			"infrakit-logical-id":  "LOGICAL-ID",
+			"infrakit-gcp-version": "1",
+		}),
+	}).Return(nil)
+
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	plugin := NewPlugin(api, nil)
+	id, err := plugin.Provision(instance.Spec{
+		LogicalID: &logicalID,
+		Tags: map[string]string{
+			"role":                  "manager",
+			"infrakit-network-tags": "swarm,web",
+		},
+		Properties: types.AnyString(`{"Tags":["web"]}`),
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, instance.ID("LOGICAL-ID"), *id)
+}
+
 func TestProvisionLogicalIDIsIPAddress(t *testing.T) {
 	properties := types.AnyString(`{
 		"PrivateIP" : "10.20.1.0",
@@ -144,7 +322,7 @@ func TestProvisionLogicalIDIsIPAddress(t *testing.T) {
 	api, ctrl := NewMockGCloud(t)
 	defer ctrl.Finish()
 
-	api.EXPECT().CreateInstance(gomock.Any(), &gcloud.InstanceSettings{
+	api.EXPECT().CreateInstance(gomock.Any(), gomock.Any(), &gcloud.InstanceSettings{
 		MachineType: "g1-small",
 		Network:     "default",
 		Disks: []gcloud.DiskSettings{
@@ -178,6 +356,23 @@ func TestProvisionLogicalIDIsIPAddress(t *testing.T) {
 	require.Equal(t, "instance-10-20-1-100", string(*id))
 }
 
+func TestProvisionLogicalIDIsIPAddressWithLongPrefix(t *testing.T) {
+	prefix := strings.Repeat("w", 52)
+	logicalID := instance.LogicalID("10.20.1.100")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+
+	plugin := NewPlugin(api, nil)
+	id, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Properties: types.AnyString(`{"NamePrefix":"` + prefix + `"}`),
+	})
+
+	require.EqualError(t, err, `NamePrefix "`+prefix+`" is too long for the IP address 10.20.1.100: Invalid instance name "`+prefix+`-10-20-1-100": should start with a lowercase letter, end with a lowercase letter or a digit and have at most 63 lowercase letters, digits or -`)
+	require.Nil(t, id)
+}
+
 func TestProvisionFails(t *testing.T) {
 	properties := types.AnyString(`{}`)
 	tags := map[string]string{
@@ -186,7 +381,8 @@ func TestProvisionFails(t *testing.T) {
 
 	rand.Seed(0)
 	api, _ := NewMockGCloud(t)
-	api.EXPECT().CreateInstance("instance-ssnk9q", &gcloud.InstanceSettings{
+	api.EXPECT().GetInstance(gomock.Any(), "instance-ssnk9q").Return(nil, gcloud.ErrNotFound)
+	api.EXPECT().CreateInstance(gomock.Any(), "instance-ssnk9q", &gcloud.InstanceSettings{
 		MachineType: "g1-small",
 		Network:     "default",
 		Disks: []gcloud.DiskSettings{
@@ -215,13 +411,103 @@ func TestProvisionFails(t *testing.T) {
 	require.Nil(t, id)
 }
 
+func TestProvisionRegeneratesUsedName(t *testing.T) {
+	rand.Seed(0)
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	gomock.InOrder(
+		api.EXPECT().GetInstance(gomock.Any(), "instance-ssnk9q").Return(&compute.Instance{Name: "instance-ssnk9q"}, nil),
+		api.EXPECT().GetInstance(gomock.Any(), gomock.Not("instance-ssnk9q")).Return(nil, gcloud.ErrNotFound),
+		api.EXPECT().CreateInstance(gomock.Any(), gomock.Not("instance-ssnk9q"), gomock.Any()).Return(errors.New("BUG")),
+	)
+
+	plugin := NewPlugin(api, nil)
+	id, err := plugin.Provision(instance.Spec{Properties: types.AnyString(`{}`)})
+
+	require.EqualError(t, err, "BUG")
+	require.Nil(t, id)
+}
+
+func TestProvisionFailsWithoutUnusedName(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().GetInstance(gomock.Any(), gomock.Any()).Return(&compute.Instance{}, nil).Times(5)
+
+	plugin := NewPlugin(api, nil)
+	id, err := plugin.Provision(instance.Spec{Properties: types.AnyString(`{}`)})
+
+	require.EqualError(t, err, "No unused instance name found with prefix instance after 5 attempts")
+	require.Nil(t, id)
+}
+
+func TestProvisionTimeoutDeletesInstance(t *testing.T) {
+	properties := types.AnyString(`{"CreationTimeoutSeconds":30, "DeleteOnTimeout":true}`)
+	tags := map[string]string{}
+
+	rand.Seed(0)
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().GetInstance(gomock.Any(), "instance-ssnk9q").Return(nil, gcloud.ErrNotFound)
+	api.EXPECT().CreateInstance(gomock.Any(), "instance-ssnk9q", &gcloud.InstanceSettings{
+		MachineType: "g1-small",
+		Network:     "default",
+		Disks: []gcloud.DiskSettings{
+			{
+				Boot:          true,
+				SizeGb:        10,
+				Image:         "docker",
+				Type:          "pd-standard",
+				AutoDelete:    true,
+				ReuseExisting: false,
+			},
+		},
+		MetaData: gcloud.TagsToMetaData(map[string]string{
+			"infrakit-gcp-version": "1",
+		}),
+		CreationTimeoutSeconds: 30,
+	}).Return(&gcloud.TimeoutError{Operation: "op", Timeout: 30 * time.Second})
+	api.EXPECT().DeleteInstance(gomock.Any(), "instance-ssnk9q").Return(nil)
+
+	plugin := NewPlugin(api, nil)
+	id, err := plugin.Provision(instance.Spec{
+		Tags:       tags,
+		Properties: properties,
+	})
+
+	require.EqualError(t, err, "Timeout waiting for operation op after 30s")
+	require.Nil(t, id)
+}
+
+func TestProvisionDeadlineDeletesInstance(t *testing.T) {
+	properties := types.AnyString(`{"DeleteOnTimeout":true}`)
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", gomock.Any()).Return(fmt.Errorf("CreateInstance: %w", context.DeadlineExceeded))
+	api.EXPECT().DeleteInstance(gomock.Any(), "LOGICAL-ID").Do(func(ctx context.Context, name string) {
+		require.NoError(t, ctx.Err())
+	}).Return(nil)
+
+	plugin := NewPlugin(api, nil)
+	id, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: properties,
+	})
+
+	require.EqualError(t, err, "CreateInstance: context deadline exceeded")
+	require.Nil(t, id)
+}
+
 func TestProvisionFailsToAddToTargetPool(t *testing.T) {
 	properties := types.AnyString(`{"TargetPools":["POOL"]}`)
 	tags := map[string]string{}
 
 	rand.Seed(0)
 	api, _ := NewMockGCloud(t)
-	api.EXPECT().CreateInstance("instance-ssnk9q", &gcloud.InstanceSettings{
+	api.EXPECT().GetInstance(gomock.Any(), "instance-ssnk9q").Return(nil, gcloud.ErrNotFound)
+	api.EXPECT().CreateInstance(gomock.Any(), "instance-ssnk9q", &gcloud.InstanceSettings{
 		MachineType: "g1-small",
 		Network:     "default",
 		Disks: []gcloud.DiskSettings{
@@ -235,10 +521,11 @@ func TestProvisionFailsToAddToTargetPool(t *testing.T) {
 			},
 		},
 		MetaData: gcloud.TagsToMetaData(map[string]string{
-			"infrakit-gcp-version": "1",
+			"infrakit-gcp-version":  "1",
+			"infrakit-target-pools": "POOL",
 		}),
 	}).Return(nil)
-	api.EXPECT().AddInstanceToTargetPool("POOL", "instance-ssnk9q").Return(errors.New("BUG"))
+	api.EXPECT().AddInstanceToTargetPool(gomock.Any(), "POOL", "instance-ssnk9q").Return(errors.New("BUG"))
 
 	plugin := NewPlugin(api, nil)
 	id, err := plugin.Provision(instance.Spec{
@@ -262,9 +549,71 @@ func TestProvisionWithInvalidProperties(t *testing.T) {
 	require.Nil(t, id)
 }
 
+func TestGetMaintenanceStatus(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().GetZone().Return("us-central1-f")
+	api.EXPECT().GetInstanceMaintenance(gomock.Any(), "us-central1-f", "instance-id").Return(&gcloud.InstanceMaintenance{
+		OnHostMaintenance: "MIGRATE",
+	}, nil)
+	api.EXPECT().ListMaintenanceOperations(gomock.Any(), "us-central1-f", "instance-id").Return([]*compute.Operation{
+		{InsertTime: "2017-06-12T09:30:00.000-07:00", Status: "RUNNING"},
+		{InsertTime: "2017-06-12T20:30:00.000+09:00", Status: "DONE"},
+	}, nil)
+
+	plugin := NewPlugin(api, nil).(MaintenanceReporter)
+	status, err := plugin.GetMaintenanceStatus("instance-id")
+
+	require.NoError(t, err)
+	require.Equal(t, "MIGRATE", status.OnHostMaintenance)
+	require.True(t, status.UnderMaintenance)
+	require.Equal(t, time.Date(2017, 6, 12, 16, 30, 0, 0, time.UTC), status.LastMaintenance.UTC())
+	require.Nil(t, status.Scheduled)
+}
+
+func TestGetMaintenanceStatusScheduled(t *testing.T) {
+	scheduled := &gcloud.UpcomingMaintenance{
+		Type:        "SCHEDULED",
+		Status:      "PENDING",
+		WindowStart: "2017-06-14T02:00:00.000-07:00",
+		WindowEnd:   "2017-06-14T06:00:00.000-07:00",
+	}
+
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().GetZone().Return("us-central1-f")
+	api.EXPECT().GetInstanceMaintenance(gomock.Any(), "us-central1-f", "instance-id").Return(&gcloud.InstanceMaintenance{
+		OnHostMaintenance: "TERMINATE",
+		Upcoming:          scheduled,
+	}, nil)
+	api.EXPECT().ListMaintenanceOperations(gomock.Any(), "us-central1-f", "instance-id").Return([]*compute.Operation{}, nil)
+
+	plugin := NewPlugin(api, nil).(MaintenanceReporter)
+	status, err := plugin.GetMaintenanceStatus("instance-id")
+
+	require.NoError(t, err)
+	require.Equal(t, &MaintenanceStatus{OnHostMaintenance: "TERMINATE", Scheduled: scheduled}, status)
+}
+
+func TestGetMaintenanceStatusOngoing(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().GetZone().Return("us-central1-f")
+	api.EXPECT().GetInstanceMaintenance(gomock.Any(), "us-central1-f", "instance-id").Return(&gcloud.InstanceMaintenance{
+		Upcoming: &gcloud.UpcomingMaintenance{Status: "ONGOING"},
+	}, nil)
+	api.EXPECT().ListMaintenanceOperations(gomock.Any(), "us-central1-f", "instance-id").Return([]*compute.Operation{}, nil)
+
+	plugin := NewPlugin(api, nil).(MaintenanceReporter)
+	status, err := plugin.GetMaintenanceStatus("instance-id")
+
+	require.NoError(t, err)
+	require.True(t, status.UnderMaintenance)
+	require.Nil(t, status.LastMaintenance)
+}
+
 func TestDestroy(t *testing.T) {
 	api, _ := NewMockGCloud(t)
-	api.EXPECT().DeleteInstance("instance-id").Return(nil)
+	ExpectUnprotected(api, "instance-id")
+	api.EXPECT().GetInstance(gomock.Any(), "instance-id").Return(&compute.Instance{Metadata: &compute.Metadata{}}, nil)
+	api.EXPECT().DeleteInstance(gomock.Any(), "instance-id").Return(nil)
 
 	plugin := NewPlugin(api, nil)
 	err := plugin.Destroy("instance-id")
@@ -274,7 +623,9 @@ func TestDestroy(t *testing.T) {
 
 func TestDestroyFails(t *testing.T) {
 	api, _ := NewMockGCloud(t)
-	api.EXPECT().DeleteInstance("instance-wrong-id").Return(errors.New("BUG"))
+	ExpectUnprotected(api, "instance-wrong-id")
+	api.EXPECT().GetInstance(gomock.Any(), "instance-wrong-id").Return(&compute.Instance{Metadata: &compute.Metadata{}}, nil)
+	api.EXPECT().DeleteInstance(gomock.Any(), "instance-wrong-id").Return(errors.New("BUG"))
 
 	plugin := NewPlugin(api, nil)
 	err := plugin.Destroy("instance-wrong-id")
@@ -282,9 +633,99 @@ func TestDestroyFails(t *testing.T) {
 	require.EqualError(t, err, "BUG")
 }
 
+func TestDestroyAlreadyDeleted(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().GetZone().Return("us-central1-f").AnyTimes()
+	api.EXPECT().GetDeletionProtection(gomock.Any(), "us-central1-f", "instance-id").Return(false, gcloud.ErrNotFound)
+
+	plugin := NewPlugin(api, nil)
+	err := plugin.Destroy("instance-id")
+
+	require.NoError(t, err)
+}
+
+func TestDestroyRemovesFromTargetPools(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	ExpectUnprotected(api, "instance-id")
+	api.EXPECT().GetInstance(gomock.Any(), "instance-id").Return(&compute.Instance{
+		Metadata: &compute.Metadata{Items: []*compute.MetadataItems{NewMetadataItems("infrakit-target-pools", "POOL1,POOL2")}},
+	}, nil)
+	gomock.InOrder(
+		api.EXPECT().RemoveInstanceFromTargetPool(gomock.Any(), "POOL1", "instance-id").Return(gcloud.ErrNotFound),
+		api.EXPECT().RemoveInstanceFromTargetPool(gomock.Any(), "POOL2", "instance-id").Return(nil),
+		api.EXPECT().DeleteInstance(gomock.Any(), "instance-id").Return(nil),
+	)
+
+	plugin := NewPlugin(api, nil)
+	err := plugin.Destroy("instance-id")
+
+	require.NoError(t, err)
+}
+
+func TestDestroyFailsToRemoveFromTargetPool(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	ExpectUnprotected(api, "instance-id")
+	api.EXPECT().GetInstance(gomock.Any(), "instance-id").Return(&compute.Instance{
+		Metadata: &compute.Metadata{Items: []*compute.MetadataItems{NewMetadataItems("infrakit-target-pools", "POOL")}},
+	}, nil)
+	api.EXPECT().RemoveInstanceFromTargetPool(gomock.Any(), "POOL", "instance-id").Return(errors.New("BUG"))
+
+	plugin := NewPlugin(api, nil)
+	err := plugin.Destroy("instance-id")
+
+	require.EqualError(t, err, "BUG")
+}
+
+func TestDestroyProtectedInstance(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().GetZone().Return("us-central1-f").AnyTimes()
+	api.EXPECT().GetDeletionProtection(gomock.Any(), "us-central1-f", "instance-id").Return(true, nil).Times(2)
+
+	instancePlugin := &plugin{API: api}
+	err := instancePlugin.Destroy("instance-id")
+
+	require.EqualError(t, err, "Instance instance-id is protected against deletion. Clear its deletion protection, or let the plugin clear it with --clear-deletion-protection")
+
+	gomock.InOrder(
+		api.EXPECT().SetDeletionProtection(gomock.Any(), "us-central1-f", "instance-id", false).Return(nil),
+		api.EXPECT().GetInstance(gomock.Any(), "instance-id").Return(&compute.Instance{Metadata: &compute.Metadata{}}, nil),
+		api.EXPECT().DeleteInstance(gomock.Any(), "instance-id").Return(nil),
+	)
+
+	instancePlugin.clearDeletionProtection = true
+	err = instancePlugin.Destroy("instance-id")
+
+	require.NoError(t, err)
+}
+
+func TestDestroyWithRequestTimeout(t *testing.T) {
+	var deadline time.Time
+
+	api, _ := NewMockGCloud(t)
+	ExpectUnprotected(api, "instance-id").Times(2)
+	api.EXPECT().GetInstance(gomock.Any(), "instance-id").Return(&compute.Instance{Metadata: &compute.Metadata{}}, nil).Times(2)
+	api.EXPECT().DeleteInstance(gomock.Any(), "instance-id").Do(func(ctx context.Context, name string) {
+		deadline, _ = ctx.Deadline()
+	}).Return(nil)
+
+	instancePlugin := &plugin{API: api}
+	instancePlugin.SetRequestTimeout(time.Minute)
+	err := instancePlugin.Destroy("instance-id")
+
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+
+	instancePlugin.SetRequestTimeout(0)
+	api.EXPECT().DeleteInstance(gomock.Any(), "instance-id").Do(func(ctx context.Context, name string) {
+		deadline, _ = ctx.Deadline()
+	}).Return(nil)
+	require.NoError(t, instancePlugin.Destroy("instance-id"))
+	require.WithinDuration(t, time.Now().Add(defaultRequestTimeout), deadline, 5*time.Second)
+}
+
 func TestDescribeEmptyInstances(t *testing.T) {
 	api, _ := NewMockGCloud(t)
-	api.EXPECT().ListInstances().Return([]*compute.Instance{}, nil)
+	api.EXPECT().ListInstances(gomock.Any(), "").Return([]*compute.Instance{}, nil)
 
 	plugin := NewPlugin(api, nil)
 	instances, err := plugin.DescribeInstances(nil, false)
@@ -309,7 +750,7 @@ func TestDescribeInstances(t *testing.T) {
 	namespace := map[string]string{"scope": "test"}
 
 	api, _ := NewMockGCloud(t)
-	api.EXPECT().ListInstances().Return([]*compute.Instance{
+	api.EXPECT().ListInstances(gomock.Any(), "").Return([]*compute.Instance{
 		{
 			Name: "instance-pet-valid",
 			Metadata: &compute.Metadata{
@@ -392,27 +833,681 @@ func TestDescribeInstances(t *testing.T) {
 	require.Nil(t, instances[2].LogicalID)
 }
 
-func TestDescribeInstancesFails(t *testing.T) {
+func TestDescribeInstancesWithoutReservedMetadata(t *testing.T) {
 	api, _ := NewMockGCloud(t)
-	api.EXPECT().ListInstances().Return(nil, errors.New("BUG"))
+	api.EXPECT().ListInstances(gomock.Any(), "").Return([]*compute.Instance{
+		{
+			Name: "instance",
+			Metadata: &compute.Metadata{
+				Items: []*compute.MetadataItems{
+					NewMetadataItems("key1", "value1"),
+					NewMetadataItems("startup-script", "echo 'Startup'"),
+					NewMetadataItems("userdata", "echo 'Startup'"),
+					NewMetadataItems("ssh-keys", "admin:ssh-rsa AAAA"),
+				},
+			},
+		},
+	}, nil).Times(2)
 
 	plugin := NewPlugin(api, nil)
-	instances, err := plugin.DescribeInstances(nil, false)
+	instances, err := plugin.DescribeInstances(map[string]string{"key1": "value1"}, false)
 
-	require.EqualError(t, err, "BUG")
-	require.Nil(t, instances)
-}
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	require.Equal(t, map[string]string{"key1": "value1"}, instances[0].Tags)
 
-func TestValidate(t *testing.T) {
-	plugin := &plugin{}
-	err := plugin.Validate(types.AnyString(`{"MachineType":"g1-small", "Network":"default"}`))
+	instances, err = plugin.DescribeInstances(map[string]string{"ssh-keys": "admin:ssh-rsa AAAA"}, false)
 
 	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	require.Equal(t, map[string]string{"key1": "value1", "ssh-keys": "admin:ssh-rsa AAAA"}, instances[0].Tags)
 }
 
-func TestValidateFails(t *testing.T) {
-	plugin := &plugin{}
-	err := plugin.Validate(types.AnyString("-"))
-
-	require.Error(t, err)
+func TestDescribeInstancesTagsPreemptibleInstances(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().ListInstances(gomock.Any(), "").Return([]*compute.Instance{
+		{
+			Name:       "spot",
+			Scheduling: &compute.Scheduling{Preemptible: true},
+			Metadata:   &compute.Metadata{Items: []*compute.MetadataItems{NewMetadataItems("key1", "value1")}},
+		},
+		{
+			Name:       "regular",
+			Scheduling: &compute.Scheduling{AutomaticRestart: true},
+			Metadata:   &compute.Metadata{Items: []*compute.MetadataItems{NewMetadataItems("key1", "value1")}},
+		},
+	}, nil).Times(2)
+
+	plugin := NewPlugin(api, nil)
+	instances, err := plugin.DescribeInstances(map[string]string{"key1": "value1"}, false)
+
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	require.Equal(t, map[string]string{"key1": "value1", "infrakit-gcp-preemptible": "true"}, instances[0].Tags)
+	require.Equal(t, map[string]string{"key1": "value1"}, instances[1].Tags)
+
+	instances, err = plugin.DescribeInstances(map[string]string{"infrakit-gcp-preemptible": "true"}, false)
+
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	require.Equal(t, instance.ID("spot"), instances[0].ID)
+}
+
+func TestDescribeInstancesTagsExternalIPs(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().ListInstances(gomock.Any(), "").Return([]*compute.Instance{
+		{
+			Name: "manager",
+			NetworkInterfaces: []*compute.NetworkInterface{{
+				NetworkIP:     "10.128.0.2",
+				AccessConfigs: []*compute.AccessConfig{{Type: "ONE_TO_ONE_NAT", NatIP: "35.1.2.3"}},
+			}},
+			Metadata: &compute.Metadata{Items: []*compute.MetadataItems{NewMetadataItems("role", "manager")}},
+		},
+		{
+			Name:              "worker",
+			NetworkInterfaces: []*compute.NetworkInterface{{NetworkIP: "10.128.0.3"}},
+			Metadata:          &compute.Metadata{Items: []*compute.MetadataItems{NewMetadataItems("role", "worker")}},
+		},
+	}, nil)
+
+	plugin := NewPlugin(api, nil)
+	instances, err := plugin.DescribeInstances(map[string]string{}, false)
+
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	require.Equal(t, map[string]string{"role": "manager", "infrakit-gcp-external-ip": "35.1.2.3"}, instances[0].Tags)
+	require.Equal(t, map[string]string{"role": "worker"}, instances[1].Tags)
+}
+
+func TestProvisionPreemptible(t *testing.T) {
+	automaticRestart := false
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", &gcloud.InstanceSettings{
+		MachineType:       "g1-small",
+		Network:           "default",
+		Preemptible:       true,
+		AutomaticRestart:  &automaticRestart,
+		OnHostMaintenance: "TERMINATE",
+		Disks: []gcloud.DiskSettings{
+			{
+				Boot:       true,
+				SizeGb:     10,
+				Image:      "docker",
+				Type:       "pd-standard",
+				AutoDelete: true,
+			},
+		},
+		MetaData: gcloud.TagsToMetaData(map[string]string{
+			"infrakit-logical-id":  "LOGICAL-ID",
+			"infrakit-gcp-version": "1",
+		}),
+	}).Return(nil)
+
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	plugin := NewPlugin(api, nil)
+	_, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Properties: types.AnyString(`{"Preemptible":true, "AutomaticRestart":false, "OnHostMaintenance":"TERMINATE"}`),
+	})
+
+	require.NoError(t, err)
+
+	_, err = plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Properties: types.AnyString(`{"Preemptible":true, "OnHostMaintenance":"MIGRATE"}`),
+	})
+
+	require.EqualError(t, err, "Preemptible instances can't have OnHostMaintenance MIGRATE")
+}
+
+func TestDescribeInstancesFails(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().ListInstances(gomock.Any(), "").Return(nil, errors.New("BUG"))
+
+	plugin := NewPlugin(api, nil)
+	instances, err := plugin.DescribeInstances(nil, false)
+
+	require.EqualError(t, err, "BUG")
+	require.Nil(t, instances)
+}
+
+func TestValidate(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().ValidateNetwork(gomock.Any(), "default", "").Return(nil)
+
+	plugin := NewPlugin(api, nil)
+	err := plugin.Validate(types.AnyString(`{"MachineType":"g1-small", "Network":"default"}`))
+
+	require.NoError(t, err)
+}
+
+func TestValidateNetworkFails(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().ValidateNetwork(gomock.Any(), "default", "SUB_EUROPE").Return(errors.New("Subnetwork SUB_EUROPE belongs to network other, not default"))
+
+	plugin := NewPlugin(api, nil)
+	err := plugin.Validate(types.AnyString(`{"Network":"default", "Subnetwork":"SUB_EUROPE"}`))
+
+	require.EqualError(t, err, "Subnetwork SUB_EUROPE belongs to network other, not default")
+}
+
+func TestValidateFails(t *testing.T) {
+	plugin := &plugin{}
+	err := plugin.Validate(types.AnyString("-"))
+
+	require.Error(t, err)
+}
+
+func TestValidateSubnetworkWithoutNetwork(t *testing.T) {
+	plugin := &plugin{}
+	err := plugin.Validate(types.AnyString(`{"Network":"", "Subnetwork":"SUB_EUROPE"}`))
+
+	require.EqualError(t, err, "A Network must be set when a Subnetwork is set")
+}
+
+func TestDescribeAndDestroyInstancesInAllZones(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().AggregatedListInstances(gomock.Any(), "").Return([]*compute.Instance{
+		{
+			Name:     "instance-1",
+			Zone:     "https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-f",
+			Metadata: &compute.Metadata{},
+		},
+		{
+			Name:     "instance-2",
+			Zone:     "https://www.googleapis.com/compute/v1/projects/p/zones/europe-west1-b",
+			Metadata: &compute.Metadata{},
+		},
+	}, nil)
+	api.EXPECT().GetZone().Return("us-central1-f").AnyTimes()
+	api.EXPECT().GetDeletionProtection(gomock.Any(), "us-central1-f", "instance-1").Return(false, nil)
+	api.EXPECT().GetDeletionProtection(gomock.Any(), "europe-west1-b", "instance-2").Return(false, nil)
+	api.EXPECT().GetInstance(gomock.Any(), "instance-1").Return(&compute.Instance{Metadata: &compute.Metadata{}}, nil)
+	api.EXPECT().GetInstanceInZone(gomock.Any(), "europe-west1-b", "instance-2").Return(&compute.Instance{Metadata: &compute.Metadata{}}, nil)
+	api.EXPECT().DeleteInstance(gomock.Any(), "instance-1").Return(nil)
+	api.EXPECT().DeleteInstanceInZone(gomock.Any(), "europe-west1-b", "instance-2").Return(nil)
+
+	plugin := &plugin{API: api, allZones: true, zones: map[instance.ID]string{}}
+	instances, err := plugin.DescribeInstances(nil, false)
+
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	require.NoError(t, plugin.Destroy("instance-1"))
+	require.NoError(t, plugin.Destroy("instance-2"))
+	require.Empty(t, plugin.zones)
+}
+
+func TestDescribeInstancesMatchingAnyTag(t *testing.T) {
+	tags := map[string]string{
+		"role":          "manager",
+		"env":           "dev",
+		gcloud.MatchTag: gcloud.MatchAny,
+	}
+
+	namespace := map[string]string{"scope": "test"}
+
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().ListInstances(gomock.Any(), "").Return([]*compute.Instance{
+		{
+			Name: "manager",
+			Metadata: &compute.Metadata{Items: []*compute.MetadataItems{
+				NewMetadataItems("role", "manager"),
+				NewMetadataItems("env", "prod"),
+				NewMetadataItems("scope", "test"),
+			}},
+		},
+		{
+			Name: "dev-worker",
+			Metadata: &compute.Metadata{Items: []*compute.MetadataItems{
+				NewMetadataItems("role", "worker"),
+				NewMetadataItems("env", "dev"),
+				NewMetadataItems("scope", "test"),
+			}},
+		},
+		{
+			Name: "prod-worker",
+			Metadata: &compute.Metadata{Items: []*compute.MetadataItems{
+				NewMetadataItems("role", "worker"),
+				NewMetadataItems("env", "prod"),
+				NewMetadataItems("scope", "test"),
+			}},
+		},
+		{
+			Name: "other-scope",
+			Metadata: &compute.Metadata{Items: []*compute.MetadataItems{
+				NewMetadataItems("role", "manager"),
+				NewMetadataItems("scope", "other"),
+			}},
+		},
+	}, nil)
+
+	plugin := NewPlugin(api, namespace)
+	instances, err := plugin.DescribeInstances(tags, false)
+
+	require.NoError(t, err)
+	require.Len(t, instances, 2)
+	require.Equal(t, instance.ID("manager"), instances[0].ID)
+	require.Equal(t, instance.ID("dev-worker"), instances[1].ID)
+	require.Equal(t, gcloud.MatchAny, tags[gcloud.MatchTag])
+}
+
+func TestDescribeInstancesFilteredByLogicalID(t *testing.T) {
+	tags := map[string]string{
+		"infrakit-logical-id": "10.20.2.100",
+		"role":                "manager",
+	}
+
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().ListInstances(gomock.Any(), "name eq .*-10-20-2-100").Return([]*compute.Instance{}, nil)
+
+	plugin := NewPlugin(api, nil)
+	instances, err := plugin.DescribeInstances(tags, false)
+
+	require.NoError(t, err)
+	require.Empty(t, instances)
+}
+
+func TestDescribeInstancesMatchingAnyTagIsNotFilteredByLogicalID(t *testing.T) {
+	tags := map[string]string{
+		"infrakit-logical-id": "manager-1",
+		"role":                "worker",
+		gcloud.MatchTag:       gcloud.MatchAny,
+	}
+
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().ListInstances(gomock.Any(), "").Return([]*compute.Instance{
+		{
+			Name: "worker-1",
+			Metadata: &compute.Metadata{Items: []*compute.MetadataItems{
+				NewMetadataItems("role", "worker"),
+			}},
+		},
+	}, nil)
+
+	plugin := NewPlugin(api, nil)
+	instances, err := plugin.DescribeInstances(tags, false)
+
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	require.Equal(t, instance.ID("worker-1"), instances[0].ID)
+}
+
+func TestDescribeInstancesFilteredByLabels(t *testing.T) {
+	tags := map[string]string{
+		"infrakit-logical-id": "manager-1",
+		"infrakit.group":      "managers",
+		"config":              "Checksum",
+	}
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().ListInstances(gomock.Any(), "(name eq manager-1) (labels.infrakit--group eq managers) (labels.infrakit-logical-id eq manager-1) (labels.scope eq test)").Return(nil, nil)
+	api.EXPECT().ListInstances(gomock.Any(), "labels.scope eq test").Return(nil, nil)
+
+	plugin := &plugin{API: api, namespace: map[string]string{"scope": "test"}, tagLabels: true}
+
+	_, err := plugin.DescribeInstances(tags, false)
+	require.NoError(t, err)
+
+	tags[gcloud.MatchTag] = gcloud.MatchAny
+	_, err = plugin.DescribeInstances(tags, false)
+	require.NoError(t, err)
+}
+
+func TestNameFilter(t *testing.T) {
+	require.Equal(t, "", nameFilter(map[string]string{"role": "manager"}))
+	require.Equal(t, "name eq manager-1", nameFilter(map[string]string{"infrakit-logical-id": "manager-1"}))
+	require.Equal(t, "name eq .*-10-20-2-100", nameFilter(map[string]string{"infrakit-logical-id": "10.20.2.100"}))
+	require.Equal(t, `name eq pet\.1`, nameFilter(map[string]string{"infrakit-logical-id": "pet.1"}))
+}
+
+func TestDescribeInstancesReusesCachedListing(t *testing.T) {
+	listing := []*compute.Instance{
+		{
+			Name:     "manager",
+			Metadata: &compute.Metadata{Items: []*compute.MetadataItems{NewMetadataItems("role", "manager")}},
+		},
+		{
+			Name:     "worker",
+			Metadata: &compute.Metadata{Items: []*compute.MetadataItems{NewMetadataItems("role", "worker")}},
+		},
+	}
+
+	api, _ := NewMockGCloud(t)
+	api.EXPECT().ListInstances(gomock.Any(), "").Return(listing, nil).Times(2)
+	ExpectUnprotected(api, "worker")
+	api.EXPECT().GetInstance(gomock.Any(), "worker").Return(listing[1], nil)
+	api.EXPECT().DeleteInstance(gomock.Any(), "worker").Return(nil)
+
+	plugin := &plugin{API: api, listCacheTTL: time.Minute}
+
+	managers, err := plugin.DescribeInstances(map[string]string{"role": "manager"}, false)
+	require.NoError(t, err)
+	require.Len(t, managers, 1)
+
+	workers, err := plugin.DescribeInstances(map[string]string{"role": "worker", "infrakit-logical-id": "worker"}, false)
+	require.NoError(t, err)
+	require.Len(t, workers, 0)
+
+	require.NoError(t, plugin.Destroy("worker"))
+
+	all, err := plugin.DescribeInstances(map[string]string{}, false)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+}
+
+func TestListingRacingWithAChangeIsNotCached(t *testing.T) {
+	api, _ := NewMockGCloud(t)
+	plugin := &plugin{API: api, listCacheTTL: time.Minute}
+
+	// An instance is destroyed while the first listing is in flight.
+	api.EXPECT().ListInstances(gomock.Any(), "").Do(func(context.Context, string) {
+		plugin.invalidateListCache()
+	}).Return([]*compute.Instance{{Name: "worker", Metadata: &compute.Metadata{}}}, nil)
+	api.EXPECT().ListInstances(gomock.Any(), "").Return([]*compute.Instance{}, nil)
+
+	stale, err := plugin.DescribeInstances(map[string]string{}, false)
+	require.NoError(t, err)
+	require.Len(t, stale, 1)
+
+	fresh, err := plugin.DescribeInstances(map[string]string{}, false)
+	require.NoError(t, err)
+	require.Empty(t, fresh)
+}
+
+func TestProvisionCallsHook(t *testing.T) {
+	properties := types.AnyString(`{"TargetPools":["POOL1", "POOL2"]}`)
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", gomock.Any()).Return(nil)
+	api.EXPECT().AddInstanceToTargetPool(gomock.Any(), "POOL1", "LOGICAL-ID").Return(nil)
+	api.EXPECT().AddInstanceToTargetPool(gomock.Any(), "POOL2", "LOGICAL-ID").Return(errors.New("BUG"))
+
+	calls := []string{}
+	hook := func(method string, start time.Time, err error) {
+		if err != nil {
+			method += " failed"
+		}
+		calls = append(calls, method)
+	}
+
+	plugin := NewPlugin(gcloud.Instrument(api, hook), nil)
+	_, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: properties,
+	})
+
+	require.EqualError(t, err, "BUG")
+	require.Equal(t, []string{"CreateInstance", "AddInstanceToTargetPool", "AddInstanceToTargetPool failed"}, calls)
+}
+
+func TestProvisionFallsBackToOtherZonesOnStockout(t *testing.T) {
+	properties := types.AnyString(`{"TargetPools":["POOL"]}`)
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	gomock.InOrder(
+		api.EXPECT().AggregatedListInstances(gomock.Any(), "name eq LOGICAL-ID").Return(nil, nil),
+		api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", gomock.Any()).Return(gcloud.ErrStockout),
+		api.EXPECT().CreateInstanceInZone(gomock.Any(), "us-central1-b", "LOGICAL-ID", gomock.Any()).Return(gcloud.ErrStockout),
+		api.EXPECT().CreateInstanceInZone(gomock.Any(), "us-central1-c", "LOGICAL-ID", gomock.Any()).Return(nil),
+		api.EXPECT().GetProject().Return("PROJECT"),
+		api.EXPECT().AddInstanceToTargetPool(gomock.Any(), "POOL", "projects/PROJECT/zones/us-central1-c/instances/LOGICAL-ID").Return(nil),
+	)
+	api.EXPECT().GetZone().Return("us-central1-f").AnyTimes()
+	api.EXPECT().GetDeletionProtection(gomock.Any(), "us-central1-c", "LOGICAL-ID").Return(false, nil)
+	api.EXPECT().GetInstanceInZone(gomock.Any(), "us-central1-c", "LOGICAL-ID").Return(&compute.Instance{
+		Metadata: &compute.Metadata{Items: []*compute.MetadataItems{NewMetadataItems("infrakit-target-pools", "POOL")}},
+	}, nil)
+	api.EXPECT().GetProject().Return("PROJECT")
+	api.EXPECT().RemoveInstanceFromTargetPool(gomock.Any(), "POOL", "projects/PROJECT/zones/us-central1-c/instances/LOGICAL-ID").Return(nil)
+	api.EXPECT().DeleteInstanceInZone(gomock.Any(), "us-central1-c", "LOGICAL-ID").Return(nil)
+
+	plugin := &plugin{API: api, fallbackZones: []string{"us-central1-b", "us-central1-c", "us-central1-a"}, zones: map[instance.ID]string{}}
+	id, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: properties,
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, instance.ID("LOGICAL-ID"), *id)
+	require.NoError(t, plugin.Destroy(*id))
+}
+
+func TestProvisionDoesntFallBackOnOtherErrors(t *testing.T) {
+	properties := types.AnyString(`{}`)
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().AggregatedListInstances(gomock.Any(), "name eq LOGICAL-ID").Return(nil, nil)
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", gomock.Any()).Return(gcloud.ErrQuotaExceeded)
+
+	plugin := &plugin{API: api, fallbackZones: []string{"us-central1-b"}, zones: map[instance.ID]string{}}
+	_, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: properties,
+	})
+
+	require.Equal(t, gcloud.ErrQuotaExceeded, err)
+}
+
+func TestProvisionWithFallbackZonesChecksNameInAllZones(t *testing.T) {
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().AggregatedListInstances(gomock.Any(), "name eq LOGICAL-ID").Return([]*compute.Instance{
+		{Name: "LOGICAL-ID", Zone: "https://www.googleapis.com/compute/v1/projects/PROJECT/zones/us-central1-b"},
+	}, nil)
+
+	plugin := &plugin{API: api, fallbackZones: []string{"us-central1-b"}, zones: map[instance.ID]string{}}
+	_, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: types.AnyString(`{}`),
+	})
+
+	require.EqualError(t, err, "Instance LOGICAL-ID already exists in zone us-central1-b")
+}
+
+func TestProvisionBatchWithFallbackZonesChecksNameInAllZones(t *testing.T) {
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().AggregatedListInstances(gomock.Any(), "name eq LOGICAL-ID").Return([]*compute.Instance{
+		{Name: "LOGICAL-ID", Zone: "https://www.googleapis.com/compute/v1/projects/PROJECT/zones/us-central1-b"},
+	}, nil)
+
+	plugin := &plugin{API: api, fallbackZones: []string{"us-central1-b"}, zones: map[instance.ID]string{}}
+	ids, errs := plugin.ProvisionBatch([]instance.Spec{{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: types.AnyString(`{}`),
+	}})
+
+	require.Nil(t, ids[0])
+	require.EqualError(t, errs[0], "Instance LOGICAL-ID already exists in zone us-central1-b")
+}
+
+func TestCheckFallbackZones(t *testing.T) {
+	require.NoError(t, checkFallbackZones("us-central1-f", []string{"us-central1-b", "us-central1-c"}))
+	require.EqualError(t, checkFallbackZones("us-central1-f", []string{"us-central1-b", "europe-west1-b"}), "Fallback zone europe-west1-b is not in the region of zone us-central1-f")
+}
+
+func TestLoadZones(t *testing.T) {
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().AggregatedListInstances(gomock.Any(), "").Return([]*compute.Instance{
+		{Name: "LOGICAL-ID", Zone: "https://www.googleapis.com/compute/v1/projects/PROJECT/zones/us-central1-b"},
+	}, nil)
+	api.EXPECT().GetZone().Return("us-central1-f").AnyTimes()
+	api.EXPECT().GetDeletionProtection(gomock.Any(), "us-central1-b", "LOGICAL-ID").Return(false, nil)
+	api.EXPECT().GetInstanceInZone(gomock.Any(), "us-central1-b", "LOGICAL-ID").Return(&compute.Instance{Metadata: &compute.Metadata{}}, nil)
+	api.EXPECT().DeleteInstanceInZone(gomock.Any(), "us-central1-b", "LOGICAL-ID").Return(nil)
+
+	plugin := &plugin{API: api, allZones: true, fallbackZones: []string{"us-central1-b"}, zones: map[instance.ID]string{}}
+	plugin.loadZones()
+
+	require.NoError(t, plugin.Destroy("LOGICAL-ID"))
+}
+
+func TestProvisionWaitsForRunning(t *testing.T) {
+	properties := types.AnyString(`{"WaitForRunning":true}`)
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	gomock.InOrder(
+		api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", gomock.Any()).Return(nil),
+		api.EXPECT().GetInstance(gomock.Any(), "LOGICAL-ID").Return(&compute.Instance{Status: "PROVISIONING"}, nil),
+		api.EXPECT().GetInstance(gomock.Any(), "LOGICAL-ID").Return(&compute.Instance{Status: "STAGING"}, nil),
+		api.EXPECT().GetInstance(gomock.Any(), "LOGICAL-ID").Return(&compute.Instance{Status: "RUNNING"}, nil),
+	)
+
+	plugin := &plugin{API: api, pollInterval: time.Millisecond}
+	id, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: properties,
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, instance.ID("LOGICAL-ID"), *id)
+}
+
+func TestProvisionTimesOutWaitingForRunning(t *testing.T) {
+	properties := types.AnyString(`{"WaitForRunning":true, "RunningTimeoutSeconds":0}`)
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", gomock.Any()).Return(nil)
+	api.EXPECT().GetInstance(gomock.Any(), "LOGICAL-ID").Return(&compute.Instance{Status: "STAGING"}, nil)
+	api.EXPECT().GetSerialPortOutput(gomock.Any(), "LOGICAL-ID", int64(1)).Return("", nil)
+
+	plugin := &plugin{API: api, pollInterval: time.Millisecond}
+	id, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: properties,
+	})
+
+	require.EqualError(t, err, "Instance LOGICAL-ID is still STAGING after 0s")
+	require.Nil(t, id)
+}
+
+func TestProvisionFailsIfInstanceIsTerminated(t *testing.T) {
+	properties := types.AnyString(`{"WaitForRunning":true}`)
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", gomock.Any()).Return(nil)
+	api.EXPECT().GetInstance(gomock.Any(), "LOGICAL-ID").Return(&compute.Instance{Status: "TERMINATED"}, nil)
+	api.EXPECT().GetSerialPortOutput(gomock.Any(), "LOGICAL-ID", int64(1)).Return("", errors.New("BUG"))
+
+	plugin := &plugin{API: api, pollInterval: time.Millisecond}
+	_, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: properties,
+	})
+
+	require.EqualError(t, err, "Instance LOGICAL-ID is TERMINATED instead of RUNNING")
+}
+
+func TestProvisionFailureShowsConsoleOutput(t *testing.T) {
+	properties := types.AnyString(`{"WaitForRunning":true, "ConsoleOutputBytes":20}`)
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", gomock.Any()).Return(nil)
+	api.EXPECT().GetInstance(gomock.Any(), "LOGICAL-ID").Return(&compute.Instance{Status: "TERMINATED"}, nil)
+	api.EXPECT().GetSerialPortOutput(gomock.Any(), "LOGICAL-ID", int64(1)).Return("Booting\nRunning startup-script\nscript failed\n", nil)
+
+	plugin := &plugin{API: api, pollInterval: time.Millisecond}
+	_, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: properties,
+	})
+
+	require.EqualError(t, err, "Instance LOGICAL-ID is TERMINATED instead of RUNNING\nLast console output:\n...\nscript failed\n")
+}
+
+func TestProvisionFailureWithoutConsoleOutput(t *testing.T) {
+	properties := types.AnyString(`{"WaitForRunning":true, "ConsoleOutputBytes":0}`)
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", gomock.Any()).Return(nil)
+	api.EXPECT().GetInstance(gomock.Any(), "LOGICAL-ID").Return(&compute.Instance{Status: "TERMINATED"}, nil)
+
+	plugin := &plugin{API: api, pollInterval: time.Millisecond}
+	_, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: properties,
+	})
+
+	require.EqualError(t, err, "Instance LOGICAL-ID is TERMINATED instead of RUNNING")
+}
+
+func TestLastBytes(t *testing.T) {
+	require.Equal(t, "short\n", lastBytes("short\n", 10))
+	require.Equal(t, "...\nthird\n", lastBytes("first\nsecond\nthird\n", 10))
+	require.Equal(t, "...\nlong-line", lastBytes("a-very-long-line", 9))
+	require.Equal(t, "...\nline\n", lastBytes("line\nline\n", 5))
+}
+
+func TestProvisionDeletesInstanceNotRunningInTime(t *testing.T) {
+	properties := types.AnyString(`{"WaitForRunning":true, "RunningTimeoutSeconds":0, "DeleteOnTimeout":true}`)
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+	api.EXPECT().CreateInstance(gomock.Any(), "LOGICAL-ID", gomock.Any()).Return(nil)
+	api.EXPECT().GetInstance(gomock.Any(), "LOGICAL-ID").Return(&compute.Instance{Status: "STAGING"}, nil)
+	api.EXPECT().GetSerialPortOutput(gomock.Any(), "LOGICAL-ID", int64(1)).Return("", nil)
+	api.EXPECT().DeleteInstance(gomock.Any(), "LOGICAL-ID").Return(nil)
+
+	plugin := &plugin{API: api, pollInterval: time.Millisecond}
+	_, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: properties,
+	})
+
+	require.EqualError(t, err, "Instance LOGICAL-ID is still STAGING after 0s")
+}
+
+func TestProvisionFailsOnLargeMetadata(t *testing.T) {
+	logicalID := instance.LogicalID("LOGICAL-ID")
+
+	api, ctrl := NewMockGCloud(t)
+	defer ctrl.Finish()
+
+	plugin := NewPlugin(api, nil)
+	_, err := plugin.Provision(instance.Spec{
+		LogicalID:  &logicalID,
+		Tags:       map[string]string{},
+		Properties: types.AnyString(`{}`),
+		Init:       strings.Repeat("x", gcloud.MaxMetaDataValueSize+1),
+	})
+
+	require.EqualError(t, err, "Metadata values larger than 262144 bytes: startup-script (262145 bytes), userdata (262145 bytes)")
 }
@@ -0,0 +1,58 @@
+package instance
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/infrakit.gcp/plugin/gcloud"
+	"github.com/docker/infrakit/pkg/spi/instance"
+	"github.com/docker/infrakit/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+// NewReplayAPI answers the calls with the exchanges of a fixture. With
+// GCLOUD_RECORD_PROJECT set, the calls go to that project instead and the
+// fixture is recorded again when the test ends.
+func NewReplayAPI(t *testing.T, fixture string) (gcloud.API, func()) {
+	if project := os.Getenv("GCLOUD_RECORD_PROJECT"); project != "" {
+		recorder := gcloud.NewRecorder(project)
+		api, err := gcloud.NewAPI(project, "us-central1-f", gcloud.WithRecorder(recorder))
+		require.NoError(t, err)
+
+		return api, func() { require.NoError(t, recorder.Save(fixture)) }
+	}
+
+	replayer, err := gcloud.LoadReplayer("PROJECT", fixture)
+	require.NoError(t, err)
+	api, err := gcloud.NewAPI("PROJECT", "us-central1-f", gcloud.WithReplayer(replayer), gcloud.WithPollInterval(time.Millisecond))
+	require.NoError(t, err)
+
+	return api, func() { require.Equal(t, 0, replayer.Remaining(), "Exchanges left in %s", fixture) }
+}
+
+func TestProvisionAndDestroyReplay(t *testing.T) {
+	api, done := NewReplayAPI(t, "testdata/provision_and_destroy.json")
+	defer done()
+
+	rand.Seed(0)
+
+	plugin := NewPlugin(api, nil)
+	id, err := plugin.Provision(instance.Spec{
+		Tags: map[string]string{"role": "worker"},
+		Properties: types.AnyString(`{
+			"NamePrefix":"worker",
+			"MachineType":"n1-standard-1",
+			"Disks":[{"SizeGb":10, "Image":"debian-cloud/global/images/family/debian-9", "Type":"pd-standard", "AutoDelete":true}]
+		}`),
+		Init: "echo 'Startup'",
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, instance.ID("worker-ssnk9q"), *id)
+
+	err = plugin.Destroy(*id)
+
+	require.NoError(t, err)
+}
@@ -0,0 +1,33 @@
+package rpc
+
+import (
+	instance_plugin "github.com/docker/infrakit.gcp/plugin/instance"
+	rpc_client "github.com/docker/infrakit/pkg/rpc/client"
+	"github.com/docker/infrakit/pkg/spi/instance"
+)
+
+// NewClient returns a MaintenanceReporter connected to the plugin listening
+// on a socket.
+func NewClient(socketPath string) (instance_plugin.MaintenanceReporter, error) {
+	rpcClient, err := rpc_client.New(socketPath, InterfaceSpec)
+	if err != nil {
+		return nil, err
+	}
+	return &client{client: rpcClient}, nil
+}
+
+type client struct {
+	client rpc_client.Client
+}
+
+// GetMaintenanceStatus returns the host maintenance status of an instance.
+func (c client) GetMaintenanceStatus(id instance.ID) (*instance_plugin.MaintenanceStatus, error) {
+	req := GetMaintenanceStatusRequest{Instance: id}
+	resp := GetMaintenanceStatusResponse{}
+
+	if err := c.client.Call("Maintenance.GetMaintenanceStatus", req, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Status, nil
+}
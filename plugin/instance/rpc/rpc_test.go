@@ -0,0 +1,73 @@
+package rpc
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/infrakit.gcp/plugin/gcloud"
+	instance_plugin "github.com/docker/infrakit.gcp/plugin/instance"
+	"github.com/docker/infrakit/pkg/rpc/server"
+	"github.com/docker/infrakit/pkg/spi/instance"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeReporter map[instance.ID]*instance_plugin.MaintenanceStatus
+
+func (f fakeReporter) GetMaintenanceStatus(id instance.ID) (*instance_plugin.MaintenanceStatus, error) {
+	status, present := f[id]
+	if !present {
+		return nil, errors.New("Unknown instance " + string(id))
+	}
+	return status, nil
+}
+
+func startServer(t *testing.T, reporter instance_plugin.MaintenanceReporter) (string, func()) {
+	dir, err := ioutil.TempDir("", "maintenance")
+	require.NoError(t, err)
+
+	socketPath := filepath.Join(dir, "instance-gcp")
+	stoppable, err := server.StartPluginAtPath(socketPath, PluginServer(reporter))
+	require.NoError(t, err)
+
+	return socketPath, func() {
+		stoppable.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGetMaintenanceStatus(t *testing.T) {
+	lastMaintenance := time.Date(2017, 6, 12, 16, 30, 0, 0, time.UTC)
+	status := &instance_plugin.MaintenanceStatus{
+		OnHostMaintenance: "MIGRATE",
+		UnderMaintenance:  true,
+		LastMaintenance:   &lastMaintenance,
+		Scheduled:         &gcloud.UpcomingMaintenance{Type: "SCHEDULED", Status: "ONGOING"},
+	}
+
+	socketPath, stop := startServer(t, fakeReporter{"vm-1": status})
+	defer stop()
+
+	reporter, err := NewClient(socketPath)
+	require.NoError(t, err)
+
+	actual, err := reporter.GetMaintenanceStatus("vm-1")
+
+	require.NoError(t, err)
+	require.Equal(t, status, actual)
+}
+
+func TestGetMaintenanceStatusFails(t *testing.T) {
+	socketPath, stop := startServer(t, fakeReporter{})
+	defer stop()
+
+	reporter, err := NewClient(socketPath)
+	require.NoError(t, err)
+
+	_, err = reporter.GetMaintenanceStatus("vm-1")
+
+	require.EqualError(t, err, "Unknown instance vm-1")
+}
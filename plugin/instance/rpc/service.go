@@ -0,0 +1,36 @@
+package rpc
+
+import (
+	"net/http"
+
+	instance_plugin "github.com/docker/infrakit.gcp/plugin/instance"
+	"github.com/docker/infrakit/pkg/spi"
+)
+
+// PluginServer returns a RPC service that serves the maintenance status of
+// the instances of a plugin, next to its instance SPI.
+func PluginServer(reporter instance_plugin.MaintenanceReporter) *Maintenance {
+	return &Maintenance{reporter: reporter}
+}
+
+// Maintenance is the JSON RPC service of a MaintenanceReporter. It must be
+// exported in order to be registered by the rpc server package.
+type Maintenance struct {
+	reporter instance_plugin.MaintenanceReporter
+}
+
+// ImplementedInterface returns the interface implemented by this RPC service.
+func (m *Maintenance) ImplementedInterface() spi.InterfaceSpec {
+	return InterfaceSpec
+}
+
+// GetMaintenanceStatus returns the host maintenance status of an instance.
+func (m *Maintenance) GetMaintenanceStatus(_ *http.Request, req *GetMaintenanceStatusRequest, resp *GetMaintenanceStatusResponse) error {
+	status, err := m.reporter.GetMaintenanceStatus(req.Instance)
+	if err != nil {
+		return err
+	}
+
+	resp.Status = status
+	return nil
+}
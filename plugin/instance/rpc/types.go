@@ -0,0 +1,24 @@
+package rpc
+
+import (
+	instance_plugin "github.com/docker/infrakit.gcp/plugin/instance"
+	"github.com/docker/infrakit/pkg/spi"
+	"github.com/docker/infrakit/pkg/spi/instance"
+)
+
+// InterfaceSpec is the interface of the plugins that report the host
+// maintenance of their instances.
+var InterfaceSpec = spi.InterfaceSpec{
+	Name:    "MaintenanceReporter",
+	Version: "0.1.0",
+}
+
+// GetMaintenanceStatusRequest is the rpc wrapper for GetMaintenanceStatus request
+type GetMaintenanceStatusRequest struct {
+	Instance instance.ID
+}
+
+// GetMaintenanceStatusResponse is the rpc wrapper for GetMaintenanceStatus response
+type GetMaintenanceStatusResponse struct {
+	Status *instance_plugin.MaintenanceStatus
+}
@@ -1,8 +1,14 @@
 package types
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/docker/infrakit.gcp/plugin/gcloud"
 	"github.com/docker/infrakit/pkg/spi/instance"
 	"github.com/docker/infrakit/pkg/types"
@@ -20,10 +26,44 @@ const (
 	defaultDiskType          = "pd-standard"
 	defaultDiskAutoDelete    = true
 	defaultDiskReuseExisting = false
+	defaultDiskSizeRounding  = DiskSizeRoundUp
+
+	// DiskSizeRoundUp rounds a DiskSizeMb up to the next whole GB.
+	DiskSizeRoundUp = "up"
+
+	// DiskSizeReject rejects a DiskSizeMb that isn't a whole number of GB.
+	DiskSizeReject = "reject"
+
+	defaultRunningTimeoutSeconds = int64(300)
+	defaultConsoleOutputBytes    = 4096
+
+	// maxNamePrefixLength leaves room for a dash and a 6 characters suffix in
+	// the 63 characters of an instance name.
+	maxNamePrefixLength = 56
+
+	maxCustomCPUs         = int64(96)
+	minCustomMemoryPerCPU = int64(922)  // 0.9GB
+	maxCustomMemoryPerCPU = int64(6656) // 6.5GB
 
 	// InfrakitLogicalID is a metadata key that is used to tag instances created with a LogicalId.
 	InfrakitLogicalID = "infrakit-logical-id"
 
+	// InfrakitTargetPools is a metadata key that is used to remember the target pools an instance was added to, so
+	// that it can be removed from them when it's destroyed.
+	InfrakitTargetPools = "infrakit-target-pools"
+
+	// InfrakitNetworkTags is a tag that flavors set to add network tags, separated by commas, to the instances,
+	// for example so that firewall rules target them. It's not stored in the metadata.
+	InfrakitNetworkTags = "infrakit-network-tags"
+
+	// InfrakitPreemptible is added, set to true, to the tags of the preemptible instances described by the plugin,
+	// so that spot capacity can be told apart. It's not stored in the metadata.
+	InfrakitPreemptible = "infrakit-gcp-preemptible"
+
+	// InfrakitExternalIP is added to the tags of the instances with an external IP described by the plugin, so
+	// that the static IP of an instance can be confirmed. It's not stored in the metadata.
+	InfrakitExternalIP = "infrakit-gcp-external-ip"
+
 	// InfrakitGCPVersion is a metadata key that is used to know which version of the plugin was used to create
 	// the instance.
 	InfrakitGCPVersion = "infrakit-gcp-version"
@@ -37,15 +77,64 @@ const (
 type Properties struct {
 	*gcloud.InstanceSettings
 
-	NamePrefix  string
-	TargetPools []string
-	Connect     bool
+	NamePrefix string
+
+	// TargetPools are the target pools of the region the instances are
+	// added to. A single pool can also be given as a string.
+	TargetPools Names
+
+	Connect bool
+
+	// EnableOSConfig enrolls the instances in VM Manager, whose agent then
+	// reports their inventory and applies the patch deployments that target
+	// them.
+	EnableOSConfig bool
+
+	// StartupScriptURL is the Cloud Storage URL of the startup script, for
+	// scripts too large to be stored in the metadata. The init script is then
+	// left out of the metadata.
+	StartupScriptURL string
+
+	// SSHKeys are the public SSH keys allowed to log into the instances, each
+	// given as user:key, for example "admin:ssh-rsa AAAA... admin@example.com".
+	SSHKeys []string
+
+	// DeleteOnTimeout deletes the partially created instance when its
+	// creation times out.
+	DeleteOnTimeout bool
+
+	// DiskSizeMb sizes the boot disk in MB rather than with its SizeGb. GCE
+	// only provisions whole GB so DiskSizeRounding tells whether a size that
+	// isn't a multiple of 1024 is rounded up, or rejected.
+	DiskSizeMb       int64
+	DiskSizeRounding string
+
+	// DiskImage and DiskType set the image and the type of the boot disk,
+	// without having to list the disks. They default to the image and the
+	// type of the default boot disk. DiskSnapshot restores the boot disk from
+	// a snapshot instead of an image.
+	DiskImage    string
+	DiskSnapshot string
+	DiskType     string
+
+	// WaitForRunning makes Provision wait until the instance is RUNNING,
+	// for at most RunningTimeoutSeconds, instead of returning as soon as
+	// the instance is created.
+	WaitForRunning        bool
+	RunningTimeoutSeconds int64
+
+	// ConsoleOutputBytes is how much of the end of the serial console output
+	// is added to the error of an instance that fails to run. 0 leaves it out.
+	ConsoleOutputBytes int
 }
 
 // ParseProperties parses instance Properties from a json description.
 func ParseProperties(req *types.Any) (Properties, error) {
 	parsed := Properties{
-		NamePrefix: defaultNamePrefix,
+		NamePrefix:            defaultNamePrefix,
+		DiskSizeRounding:      defaultDiskSizeRounding,
+		RunningTimeoutSeconds: defaultRunningTimeoutSeconds,
+		ConsoleOutputBytes:    defaultConsoleOutputBytes,
 		InstanceSettings: &gcloud.InstanceSettings{
 			Description: defaultDescription,
 			MachineType: defaultMachineType,
@@ -68,9 +157,286 @@ func ParseProperties(req *types.Any) (Properties, error) {
 		return parsed, fmt.Errorf("Invalid properties: %s", err)
 	}
 
+	// The default size of the boot disk is for the default image. A disk
+	// created from a snapshot has the size of the snapshot unless it's given,
+	// and doesn't use the default image.
+	given := struct {
+		Disks []struct {
+			SizeGb *int64
+			Image  *string
+		}
+	}{}
+	if err := req.Decode(&given); err != nil {
+		return parsed, fmt.Errorf("Invalid properties: %s", err)
+	}
+	for i := range parsed.Disks {
+		if parsed.Disks[i].Boot && parsed.DiskSnapshot != "" {
+			parsed.Disks[i].Snapshot = parsed.DiskSnapshot
+		}
+		if parsed.Disks[i].Snapshot == "" {
+			continue
+		}
+		if i >= len(given.Disks) || given.Disks[i].SizeGb == nil {
+			parsed.Disks[i].SizeGb = 0
+		}
+		if i >= len(given.Disks) || given.Disks[i].Image == nil {
+			parsed.Disks[i].Image = ""
+		}
+	}
+
+	for i := range parsed.Disks {
+		if !parsed.Disks[i].Boot {
+			continue
+		}
+		if parsed.DiskSizeMb > 0 {
+			parsed.Disks[i].SizeGb = (parsed.DiskSizeMb + 1023) / 1024
+		}
+		if parsed.DiskImage != "" {
+			parsed.Disks[i].Image = parsed.DiskImage
+		}
+		if parsed.DiskType != "" {
+			parsed.Disks[i].Type = parsed.DiskType
+		}
+	}
+
 	return parsed, nil
 }
 
+// Names is a list of names that also decodes from a single JSON string.
+type Names []string
+
+// UnmarshalJSON decodes either a list of names or a single name.
+func (n *Names) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*n = nil
+		if name != "" {
+			*n = Names{name}
+		}
+		return nil
+	}
+
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	*n = names
+
+	return nil
+}
+
+// diskTypes are the types of persistent disks an instance can use.
+var diskTypes = []string{"pd-standard", "pd-balanced", "pd-ssd"}
+
+// namePrefix matches the prefixes that make valid instance names.
+var namePrefix = regexp.MustCompile(`^[a-z][-a-z0-9]*$`)
+
+// serviceAccountEmail is the rough shape of the email of a service account.
+var serviceAccountEmail = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// Validate checks that the properties are consistent.
+func (p Properties) Validate() error {
+	if !namePrefix.MatchString(p.NamePrefix) || len(p.NamePrefix) > maxNamePrefixLength {
+		return fmt.Errorf("Invalid NamePrefix %q: should start with a lowercase letter and have at most %d lowercase letters, digits or -", p.NamePrefix, maxNamePrefixLength)
+	}
+
+	if p.Subnetwork != "" && p.Network == "" {
+		return errors.New("A Network must be set when a Subnetwork is set")
+	}
+
+	if p.StartupScriptURL != "" && !strings.HasPrefix(p.StartupScriptURL, "gs://") && !strings.HasPrefix(p.StartupScriptURL, "https://storage.googleapis.com/") {
+		return fmt.Errorf("Invalid StartupScriptURL %s: should be a gs:// or https://storage.googleapis.com/ URL", p.StartupScriptURL)
+	}
+
+	if p.DiskImage != "" && p.DiskSnapshot != "" {
+		return errors.New("DiskImage and DiskSnapshot can't both be set")
+	}
+	if err := validateDisks(p.Disks); err != nil {
+		return err
+	}
+
+	for _, key := range p.SSHKeys {
+		if err := validateSSHKey(key); err != nil {
+			return err
+		}
+	}
+
+	if p.RunningTimeoutSeconds < 0 {
+		return errors.New("RunningTimeoutSeconds must be >= 0")
+	}
+
+	// A protected instance couldn't be deleted when its creation times out.
+	if p.DeleteOnTimeout && p.DeletionProtection {
+		return errors.New("DeleteOnTimeout can't be set with DeletionProtection")
+	}
+
+	if err := validateScheduling(p.InstanceSettings); err != nil {
+		return err
+	}
+	for _, accelerator := range p.Accelerators {
+		if accelerator.Type == "" || accelerator.Count < 1 {
+			return fmt.Errorf("Invalid accelerator %+v: should have a Type and a positive Count", accelerator)
+		}
+	}
+	if len(p.Accelerators) > 0 && p.OnHostMaintenance == "MIGRATE" {
+		return errors.New("Instances with accelerators can't have OnHostMaintenance MIGRATE")
+	}
+
+	if p.ConsoleOutputBytes < 0 {
+		return errors.New("ConsoleOutputBytes must be >= 0")
+	}
+
+	if err := validateNetworkTier(p.InstanceSettings); err != nil {
+		return err
+	}
+
+	if p.ServiceAccount != "" && !serviceAccountEmail.MatchString(p.ServiceAccount) {
+		return fmt.Errorf("Invalid ServiceAccount %q: should be the email of a service account", p.ServiceAccount)
+	}
+
+	if err := gcloud.ValidateScopes(p.Scopes); err != nil {
+		return err
+	}
+
+	if err := gcloud.ValidateLabels(p.Labels); err != nil {
+		return err
+	}
+
+	if p.DiskSizeMb < 0 {
+		return fmt.Errorf("Invalid DiskSizeMb %d", p.DiskSizeMb)
+	}
+
+	switch p.DiskSizeRounding {
+	case DiskSizeRoundUp:
+		if p.DiskSizeMb%1024 != 0 {
+			log.Warningf("Rounding the boot disk size up from %dMB to %dGB", p.DiskSizeMb, (p.DiskSizeMb+1023)/1024)
+		}
+	case DiskSizeReject:
+		if p.DiskSizeMb%1024 != 0 {
+			return fmt.Errorf("DiskSizeMb must be a whole number of GB, not %dMB", p.DiskSizeMb)
+		}
+	default:
+		return fmt.Errorf("Invalid DiskSizeRounding %q, must be %q or %q", p.DiskSizeRounding, DiskSizeRoundUp, DiskSizeReject)
+	}
+
+	return validateMachineType(p.MachineType)
+}
+
+// validateDisks checks that each disk has a single source, that the boot disk
+// has one, that the disk types exist, and that each disk has a name and a
+// device name of its own.
+func validateDisks(disks []gcloud.DiskSettings) error {
+	suffixes := map[string]bool{}
+	deviceNames := map[string]bool{}
+	for _, disk := range disks {
+		if disk.Image != "" && disk.Snapshot != "" {
+			return errors.New("A disk can't be created from both an Image and a Snapshot")
+		}
+		if disk.Boot && disk.Image == "" && disk.Snapshot == "" {
+			return errors.New("The boot disk needs an Image or a Snapshot")
+		}
+		if disk.Type != "" && !contains(diskTypes, last(disk.Type)) {
+			return fmt.Errorf("Invalid disk Type %q: should be one of %s", disk.Type, strings.Join(diskTypes, ", "))
+		}
+		if suffixes[disk.NameSuffix] {
+			return fmt.Errorf("Disks must have different NameSuffix: %q is used twice", disk.NameSuffix)
+		}
+		suffixes[disk.NameSuffix] = true
+		if disk.DeviceName != "" && deviceNames[disk.DeviceName] {
+			return fmt.Errorf("Disks must have different DeviceName: %q is used twice", disk.DeviceName)
+		}
+		deviceNames[disk.DeviceName] = true
+	}
+
+	return nil
+}
+
+// validateScheduling checks the maintenance policy, and that preemptible
+// instances are neither restarted nor live migrated, which GCE forbids.
+func validateScheduling(settings *gcloud.InstanceSettings) error {
+	switch settings.OnHostMaintenance {
+	case "", "MIGRATE", "TERMINATE":
+	default:
+		return fmt.Errorf("Invalid OnHostMaintenance %q: should be MIGRATE or TERMINATE", settings.OnHostMaintenance)
+	}
+
+	if !settings.Preemptible {
+		return nil
+	}
+	if settings.AutomaticRestart != nil && *settings.AutomaticRestart {
+		return errors.New("Preemptible instances can't have AutomaticRestart")
+	}
+	if settings.OnHostMaintenance == "MIGRATE" {
+		return errors.New("Preemptible instances can't have OnHostMaintenance MIGRATE")
+	}
+
+	return nil
+}
+
+// validateNetworkTier checks the tier of the external IP, which instances
+// without one can't have.
+func validateNetworkTier(settings *gcloud.InstanceSettings) error {
+	switch settings.NetworkTier {
+	case "":
+		return nil
+	case "PREMIUM", "STANDARD":
+	default:
+		return fmt.Errorf("Invalid NetworkTier %q: should be PREMIUM or STANDARD", settings.NetworkTier)
+	}
+
+	if settings.NoExternalIP {
+		return errors.New("A NetworkTier can't be set on instances without external IP")
+	}
+
+	return nil
+}
+
+// validateSSHKey checks that an SSH key is given as user:key.
+func validateSSHKey(key string) error {
+	parts := strings.SplitN(key, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.ContainsAny(parts[0], " \t") || strings.TrimSpace(parts[1]) == "" {
+		return fmt.Errorf("Invalid SSH key %q: should be user:key", key)
+	}
+
+	return nil
+}
+
+// validateMachineType checks that a custom machine type, custom-CPUS-MEMORY or
+// custom-CPUS-MEMORY-ext, matches GCE's constraints: 1 or an even number of
+// vCPUs, and a memory in MB that is a multiple of 256 and, unless extended,
+// between 0.9GB and 6.5GB per vCPU.
+func validateMachineType(machineType string) error {
+	name := machineType[strings.LastIndex(machineType, "/")+1:]
+	if !strings.HasPrefix(name, "custom-") {
+		return nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(name, "custom-"), "-")
+	extended := len(parts) == 3 && parts[2] == "ext"
+	if len(parts) != 2 && !extended {
+		return fmt.Errorf("Invalid custom machine type %s: should be custom-CPUS-MEMORY", name)
+	}
+
+	cpus, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || cpus < 1 || cpus > maxCustomCPUs || (cpus > 1 && cpus%2 != 0) {
+		return fmt.Errorf("Invalid custom machine type %s: the number of vCPUs should be 1 or an even number up to %d", name, maxCustomCPUs)
+	}
+
+	memory, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || memory <= 0 || memory%256 != 0 {
+		return fmt.Errorf("Invalid custom machine type %s: the memory should be a multiple of 256MB", name)
+	}
+
+	if minMemory := cpus * minCustomMemoryPerCPU; memory < minMemory {
+		return fmt.Errorf("Invalid custom machine type %s: %d vCPUs need at least %dMB of memory", name, cpus, minMemory)
+	}
+	if maxMemory := cpus * maxCustomMemoryPerCPU; memory > maxMemory && !extended {
+		return fmt.Errorf("Invalid custom machine type %s: %d vCPUs support at most %dMB of memory", name, cpus, maxMemory)
+	}
+
+	return nil
+}
+
 // ParseTags returns a key/value map from the instance specification.
 func ParseTags(spec instance.Spec) (map[string]string, error) {
 	tags := make(map[string]string)
@@ -78,22 +444,33 @@ func ParseTags(spec instance.Spec) (map[string]string, error) {
 	for k, v := range spec.Tags {
 		tags[k] = v
 	}
+	delete(tags, InfrakitNetworkTags)
+
+	properties, err := ParseProperties(spec.Properties)
+	if err != nil {
+		return nil, err
+	}
 
-	if spec.Init != "" {
+	if properties.StartupScriptURL != "" {
+		// The init script is the downloaded startup script, which
+		// may be too large to be stored in the metadata.
+		tags["startup-script-url"] = properties.StartupScriptURL
+	} else if spec.Init != "" {
 		// spec.Init is special. Some plugins customise it via
 		// the templating mechanism and it can either be a
 		// startup script or just userdata. Store it twice.
 		tags["startup-script"] = spec.Init
 		tags["userdata"] = spec.Init
 	}
-
-	properties, err := ParseProperties(spec.Properties)
-	if err != nil {
-		return nil, err
-	}
 	if properties.Connect {
 		tags["serial-port-enable"] = "true"
 	}
+	if properties.EnableOSConfig {
+		tags["enable-osconfig"] = "true"
+	}
+	if len(properties.SSHKeys) > 0 {
+		tags["ssh-keys"] = strings.Join(properties.SSHKeys, "\n")
+	}
 
 	if spec.LogicalID != nil {
 		tags[InfrakitLogicalID] = string(*spec.LogicalID)
@@ -103,3 +480,36 @@ func ParseTags(spec instance.Spec) (map[string]string, error) {
 
 	return tags, nil
 }
+
+// NetworkTags are the network tags of the properties followed by the ones that
+// the spec's tags add.
+func NetworkTags(spec instance.Spec, tags []string) []string {
+	var merged []string
+	merged = append(merged, tags...)
+
+	for _, tag := range strings.Split(spec.Tags[InfrakitNetworkTags], ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || contains(merged, tag) {
+			continue
+		}
+		merged = append(merged, tag)
+	}
+
+	return merged
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// last returns the last part of a url or of a path.
+func last(url string) string {
+	parts := strings.Split(url, "/")
+	return parts[len(parts)-1]
+}
@@ -1,8 +1,12 @@
 package types
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/docker/infrakit.gcp/plugin/gcloud"
+	"github.com/docker/infrakit/pkg/spi/instance"
 	"github.com/docker/infrakit/pkg/types"
 	"github.com/stretchr/testify/require"
 )
@@ -23,6 +27,10 @@ func TestParseProperties(t *testing.T) {
 		"Scopes":["SCOPE1", "SCOPE2"],
 		"TargetPools":["POOL1", "POOL2"],
 		"Preemptible":true,
+		"NoExternalIP":true,
+		"CanIPForward":true,
+		"MinCPUPlatform":"Intel Skylake",
+		"Shielded":{"SecureBoot":true, "VTPM":true},
 		"Description":"vm"}`)
 
 	p, err := ParseProperties(properties)
@@ -34,9 +42,13 @@ func TestParseProperties(t *testing.T) {
 	require.Equal(t, "n1-standard-1", p.MachineType)
 	require.Equal(t, "NETWORK", p.Network)
 	require.Equal(t, true, p.Preemptible)
+	require.Equal(t, true, p.NoExternalIP)
+	require.Equal(t, true, p.CanIPForward)
+	require.Equal(t, "Intel Skylake", p.MinCPUPlatform)
+	require.Equal(t, &gcloud.ShieldedSettings{SecureBoot: true, VTPM: true}, p.Shielded)
 	require.Equal(t, []string{"TAG1", "TAG2"}, p.Tags)
 	require.Equal(t, []string{"SCOPE1", "SCOPE2"}, p.Scopes)
-	require.Equal(t, []string{"POOL1", "POOL2"}, p.TargetPools)
+	require.Equal(t, Names{"POOL1", "POOL2"}, p.TargetPools)
 
 	// Disk settings
 	bootDisk := p.Disks[0]
@@ -59,6 +71,7 @@ func TestParseEmptyProperties(t *testing.T) {
 	require.Equal(t, defaultMachineType, p.MachineType)
 	require.Equal(t, defaultNetwork, p.Network)
 	require.Equal(t, defaultPreemptible, p.Preemptible)
+	require.Equal(t, false, p.NoExternalIP)
 	require.Nil(t, p.Tags)
 	require.Nil(t, p.Scopes)
 	require.Nil(t, p.TargetPools)
@@ -72,6 +85,22 @@ func TestParseEmptyProperties(t *testing.T) {
 	require.Equal(t, false, bootDisk.ReuseExisting)
 }
 
+func TestParseSingleTargetPool(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"TargetPools":"POOL"}`))
+
+	require.NoError(t, err)
+	require.Equal(t, Names{"POOL"}, p.TargetPools)
+
+	p, err = ParseProperties(types.AnyString(`{"TargetPools":""}`))
+
+	require.NoError(t, err)
+	require.Nil(t, p.TargetPools)
+
+	_, err = ParseProperties(types.AnyString(`{"TargetPools":1}`))
+
+	require.Error(t, err)
+}
+
 func TestParseDefaultDiskProperties(t *testing.T) {
 	properties := types.AnyString(`{
 		"Disks":[{
@@ -89,3 +118,436 @@ func TestParseDefaultDiskProperties(t *testing.T) {
 	require.Equal(t, true, bootDisk.AutoDelete)
 	require.Equal(t, false, bootDisk.ReuseExisting)
 }
+
+func TestParseBootDiskFromSnapshot(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"Disks":[{"Snapshot":"backup"}]}`))
+
+	require.NoError(t, err)
+	require.NoError(t, p.Validate())
+
+	bootDisk := p.Disks[0]
+	require.True(t, bootDisk.Boot)
+	require.Equal(t, "backup", bootDisk.Snapshot)
+	require.Empty(t, bootDisk.Image)
+}
+
+func TestParseDiskSnapshot(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"DiskSnapshot":"backup", "DiskType":"pd-ssd"}`))
+
+	require.NoError(t, err)
+	require.NoError(t, p.Validate())
+	require.Equal(t, "backup", p.Disks[0].Snapshot)
+	require.Empty(t, p.Disks[0].Image)
+	require.Equal(t, int64(0), p.Disks[0].SizeGb)
+	require.Equal(t, "pd-ssd", p.Disks[0].Type)
+}
+
+func TestValidateImageAndSnapshot(t *testing.T) {
+	invalid := map[string]string{
+		`{"DiskImage":"ubuntu-1804", "DiskSnapshot":"backup"}`: "DiskImage and DiskSnapshot can't both be set",
+		`{"Disks":[{"Image":"docker", "Snapshot":"backup"}]}`:  "A disk can't be created from both an Image and a Snapshot",
+	}
+	for properties, expected := range invalid {
+		p, err := ParseProperties(types.AnyString(properties))
+
+		require.NoError(t, err)
+		require.EqualError(t, p.Validate(), expected, properties)
+	}
+}
+
+func TestValidateDisks(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{
+		"Disks":[
+			{"Boot":true, "Image":"docker-image", "AutoDelete":true},
+			{"SizeGb":100, "Snapshot":"backup", "NameSuffix":"-data", "DeviceName":"data"}
+		]}`))
+
+	require.NoError(t, err)
+	require.NoError(t, p.Validate())
+	require.Equal(t, "backup", p.Disks[1].Snapshot)
+	require.False(t, p.Disks[1].AutoDelete)
+
+	p, err = ParseProperties(types.AnyString(`{"Disks":[{"Image":"docker-image", "Snapshot":"backup"}]}`))
+
+	require.NoError(t, err)
+	require.EqualError(t, p.Validate(), "A disk can't be created from both an Image and a Snapshot")
+
+	p, err = ParseProperties(types.AnyString(`{"Disks":[{"Image":"docker-image"}, {"SizeGb":100}]}`))
+
+	require.NoError(t, err)
+	require.EqualError(t, p.Validate(), `Disks must have different NameSuffix: "" is used twice`)
+
+	p, err = ParseProperties(types.AnyString(`{"Disks":[{"DeviceName":"data"}, {"NameSuffix":"-data", "DeviceName":"data"}]}`))
+
+	require.NoError(t, err)
+	require.EqualError(t, p.Validate(), `Disks must have different DeviceName: "data" is used twice`)
+}
+
+func TestParseSnapshotDiskSize(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"Disks":[{"Boot":true, "Image":"", "Snapshot":"backup"}]}`))
+
+	require.NoError(t, err)
+	require.Equal(t, int64(0), p.Disks[0].SizeGb)
+
+	p, err = ParseProperties(types.AnyString(`{"Disks":[{"Boot":true, "Image":"", "Snapshot":"backup", "SizeGb":20}]}`))
+
+	require.NoError(t, err)
+	require.Equal(t, int64(20), p.Disks[0].SizeGb)
+}
+
+func TestValidateNamePrefix(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"NamePrefix":"worker-2"}`))
+
+	require.NoError(t, err)
+	require.NoError(t, p.Validate())
+
+	for _, prefix := range []string{"Worker", "2-worker", "worker_2", strings.Repeat("w", 57)} {
+		p, err := ParseProperties(types.AnyString(`{"NamePrefix":"` + prefix + `"}`))
+
+		require.NoError(t, err)
+		require.EqualError(t, p.Validate(), fmt.Sprintf("Invalid NamePrefix %q: should start with a lowercase letter and have at most 56 lowercase letters, digits or -", prefix))
+	}
+}
+
+func TestValidateBootDiskImage(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"Disks":[{"Boot":true, "Image":"projects/debian-cloud/global/images/family/debian-9"}]}`))
+
+	require.NoError(t, err)
+	require.NoError(t, p.Validate())
+
+	p, err = ParseProperties(types.AnyString(`{"Disks":[{"Boot":true, "Image":""}]}`))
+
+	require.NoError(t, err)
+	require.EqualError(t, p.Validate(), "The boot disk needs an Image or a Snapshot")
+}
+
+func TestValidateDiskType(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"Disks":[{"Boot":true, "Type":"pd-balanced"}]}`))
+
+	require.NoError(t, err)
+	require.Equal(t, "pd-balanced", p.Disks[0].Type)
+	require.Equal(t, "docker", p.Disks[0].Image)
+	require.NoError(t, p.Validate())
+
+	p, err = ParseProperties(types.AnyString(`{"Disks":[{"Boot":true}, {"NameSuffix":"-data", "Type":"zones/us-central1-f/diskTypes/pd-ssd"}]}`))
+
+	require.NoError(t, err)
+	require.Equal(t, "pd-standard", p.Disks[0].Type)
+	require.NoError(t, p.Validate())
+
+	p, err = ParseProperties(types.AnyString(`{"Disks":[{"Boot":true, "Type":"pd-sdd"}]}`))
+
+	require.NoError(t, err)
+	require.EqualError(t, p.Validate(), `Invalid disk Type "pd-sdd": should be one of pd-standard, pd-balanced, pd-ssd`)
+}
+
+func TestValidateScheduling(t *testing.T) {
+	valid := []string{
+		`{"OnHostMaintenance":"TERMINATE", "AutomaticRestart":false}`,
+		`{"Preemptible":true}`,
+		`{"Preemptible":true, "OnHostMaintenance":"TERMINATE", "AutomaticRestart":false}`,
+	}
+	for _, properties := range valid {
+		p, err := ParseProperties(types.AnyString(properties))
+
+		require.NoError(t, err)
+		require.NoError(t, p.Validate(), properties)
+	}
+
+	invalid := map[string]string{
+		`{"OnHostMaintenance":"migrate"}`:                     `Invalid OnHostMaintenance "migrate": should be MIGRATE or TERMINATE`,
+		`{"Preemptible":true, "AutomaticRestart":true}`:       "Preemptible instances can't have AutomaticRestart",
+		`{"Preemptible":true, "OnHostMaintenance":"MIGRATE"}`: "Preemptible instances can't have OnHostMaintenance MIGRATE",
+	}
+	for properties, expected := range invalid {
+		p, err := ParseProperties(types.AnyString(properties))
+
+		require.NoError(t, err)
+		require.EqualError(t, p.Validate(), expected)
+	}
+}
+
+func TestValidateNetworkTier(t *testing.T) {
+	valid := []string{
+		`{"NetworkTier":"PREMIUM"}`,
+		`{"NetworkTier":"STANDARD"}`,
+		`{"NoExternalIP":true}`,
+	}
+	for _, properties := range valid {
+		p, err := ParseProperties(types.AnyString(properties))
+
+		require.NoError(t, err)
+		require.NoError(t, p.Validate(), properties)
+	}
+
+	invalid := map[string]string{
+		`{"NetworkTier":"standard"}`:                      `Invalid NetworkTier "standard": should be PREMIUM or STANDARD`,
+		`{"NetworkTier":"STANDARD", "NoExternalIP":true}`: "A NetworkTier can't be set on instances without external IP",
+	}
+	for properties, expected := range invalid {
+		p, err := ParseProperties(types.AnyString(properties))
+
+		require.NoError(t, err)
+		require.EqualError(t, p.Validate(), expected)
+	}
+}
+
+func TestValidateSubnetwork(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"Network":"NETWORK", "Subnetwork":"SUBNETWORK"}`))
+
+	require.NoError(t, err)
+	require.NoError(t, p.Validate())
+}
+
+func TestValidateSubnetworkWithoutNetwork(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"Network":"", "Subnetwork":"SUBNETWORK"}`))
+
+	require.NoError(t, err)
+	require.EqualError(t, p.Validate(), "A Network must be set when a Subnetwork is set")
+}
+
+func TestValidateLabels(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"Labels": {"team": "infra"}}`))
+
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"team": "infra"}, p.Labels)
+	require.NoError(t, p.Validate())
+
+	p, err = ParseProperties(types.AnyString(`{"Labels": {"Team": "infra"}}`))
+
+	require.NoError(t, err)
+	require.EqualError(t, p.Validate(), `Invalid label key "Team": should start with a lowercase letter and have at most 63 lowercase letters, digits, _ or -`)
+}
+
+func TestDiskSizeMbRoundedUp(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"DiskSizeMb":20000}`))
+
+	require.NoError(t, err)
+	require.NoError(t, p.Validate())
+	require.Equal(t, int64(20), p.Disks[0].SizeGb)
+}
+
+func TestDiskSizeMbWholeGb(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"DiskSizeMb":20480, "DiskSizeRounding":"reject"}`))
+
+	require.NoError(t, err)
+	require.NoError(t, p.Validate())
+	require.Equal(t, int64(20), p.Disks[0].SizeGb)
+}
+
+func TestDiskSizeMbRejected(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"DiskSizeMb":500, "DiskSizeRounding":"reject"}`))
+
+	require.NoError(t, err)
+	require.EqualError(t, p.Validate(), "DiskSizeMb must be a whole number of GB, not 500MB")
+}
+
+func TestInvalidDiskSizeRounding(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"DiskSizeMb":500, "DiskSizeRounding":"down"}`))
+
+	require.NoError(t, err)
+	require.EqualError(t, p.Validate(), `Invalid DiskSizeRounding "down", must be "up" or "reject"`)
+}
+
+func TestParseBootDiskShorthands(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"DiskImage":"ubuntu-1804", "DiskType":"pd-ssd", "Preemptible":true}`))
+
+	require.NoError(t, err)
+	require.Equal(t, "ubuntu-1804", p.Disks[0].Image)
+	require.Equal(t, "pd-ssd", p.Disks[0].Type)
+	require.Equal(t, defaultDiskSizeGb, p.Disks[0].SizeGb)
+	require.True(t, p.Preemptible)
+}
+
+func TestValidateAccelerators(t *testing.T) {
+	valid := []string{
+		`{"Accelerators":[{"Type":"nvidia-tesla-t4", "Count":1}]}`,
+		`{"Accelerators":[{"Type":"nvidia-tesla-t4", "Count":1}], "OnHostMaintenance":"TERMINATE"}`,
+		`{"OnHostMaintenance":"MIGRATE"}`,
+	}
+	for _, properties := range valid {
+		p, err := ParseProperties(types.AnyString(properties))
+
+		require.NoError(t, err)
+		require.NoError(t, p.Validate(), properties)
+	}
+
+	invalid := map[string]string{
+		`{"OnHostMaintenance":"migrate"}`: `Invalid OnHostMaintenance "migrate": should be MIGRATE or TERMINATE`,
+		`{"Accelerators":[{"Type":"nvidia-tesla-t4", "Count":1}], "OnHostMaintenance":"MIGRATE"}`: "Instances with accelerators can't have OnHostMaintenance MIGRATE",
+		`{"Accelerators":[{"Type":"nvidia-tesla-t4"}]}`:                                           "Invalid accelerator {Type:nvidia-tesla-t4 Count:0}: should have a Type and a positive Count",
+	}
+	for properties, expected := range invalid {
+		p, err := ParseProperties(types.AnyString(properties))
+
+		require.NoError(t, err)
+		require.EqualError(t, p.Validate(), expected)
+	}
+}
+
+func TestValidateCustomMachineType(t *testing.T) {
+	valid := []string{
+		"n1-standard-1",
+		"custom-1-1024",
+		"custom-4-16384",
+		"custom-2-13312",
+		"custom-2-32768-ext",
+		"zones/us-central1-f/machineTypes/custom-4-16384",
+	}
+	for _, machineType := range valid {
+		p, err := ParseProperties(types.AnyString(`{"MachineType":"` + machineType + `"}`))
+
+		require.NoError(t, err)
+		require.NoError(t, p.Validate(), machineType)
+	}
+
+	invalid := map[string]string{
+		"custom-4":           "should be custom-CPUS-MEMORY",
+		"custom-3-4096":      "the number of vCPUs should be 1 or an even number up to 96",
+		"custom-98-98304":    "the number of vCPUs should be 1 or an even number up to 96",
+		"custom-x-4096":      "the number of vCPUs should be 1 or an even number up to 96",
+		"custom-4-16000":     "the memory should be a multiple of 256MB",
+		"custom-4-2048":      "4 vCPUs need at least 3688MB of memory",
+		"custom-2-32768":     "2 vCPUs support at most 13312MB of memory",
+		"custom-2-4096-huge": "should be custom-CPUS-MEMORY",
+	}
+	for machineType, message := range invalid {
+		p, err := ParseProperties(types.AnyString(`{"MachineType":"` + machineType + `"}`))
+
+		require.NoError(t, err)
+		require.EqualError(t, p.Validate(), "Invalid custom machine type "+machineType+": "+message)
+	}
+}
+
+func TestParseTagsEnableOSConfig(t *testing.T) {
+	tags, err := ParseTags(instance.Spec{Properties: types.AnyString(`{"EnableOSConfig":true}`)})
+
+	require.NoError(t, err)
+	require.Equal(t, "true", tags["enable-osconfig"])
+
+	tags, err = ParseTags(instance.Spec{Properties: types.AnyString(`{}`)})
+
+	require.NoError(t, err)
+	require.NotContains(t, tags, "enable-osconfig")
+}
+
+func TestNetworkTags(t *testing.T) {
+	spec := instance.Spec{
+		Tags: map[string]string{
+			"role":                  "manager",
+			"infrakit-network-tags": "swarm, web,,http-server",
+		},
+		Properties: types.AnyString(`{}`),
+	}
+
+	require.Equal(t, []string{"web", "swarm", "http-server"}, NetworkTags(spec, []string{"web"}))
+	require.Nil(t, NetworkTags(instance.Spec{}, nil))
+
+	tags, err := ParseTags(spec)
+
+	require.NoError(t, err)
+	require.Equal(t, "manager", tags["role"])
+	require.NotContains(t, tags, "infrakit-network-tags")
+}
+
+func TestParseTagsSSHKeys(t *testing.T) {
+	tags, err := ParseTags(instance.Spec{
+		Init:       "echo 'Startup'",
+		Properties: types.AnyString(`{"SSHKeys":["admin:ssh-rsa AAAA admin@example.com", "ops:ssh-ed25519 BBBB"]}`),
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "admin:ssh-rsa AAAA admin@example.com\nops:ssh-ed25519 BBBB", tags["ssh-keys"])
+	require.Equal(t, "echo 'Startup'", tags["startup-script"])
+
+	tags, err = ParseTags(instance.Spec{Properties: types.AnyString(`{}`)})
+
+	require.NoError(t, err)
+	require.NotContains(t, tags, "ssh-keys")
+}
+
+func TestValidateSSHKeys(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"SSHKeys":["admin:ssh-rsa AAAA admin@example.com"]}`))
+
+	require.NoError(t, err)
+	require.NoError(t, p.Validate())
+
+	invalid := []string{
+		"ssh-rsa AAAA admin@example.com",
+		":ssh-rsa AAAA",
+		"admin:",
+		"admin user:ssh-rsa AAAA",
+	}
+	for _, key := range invalid {
+		p, err := ParseProperties(types.AnyString(`{"SSHKeys":["` + key + `"]}`))
+
+		require.NoError(t, err)
+		require.EqualError(t, p.Validate(), `Invalid SSH key "`+key+`": should be user:key`)
+	}
+}
+
+func TestParseTagsStartupScriptURL(t *testing.T) {
+	tags, err := ParseTags(instance.Spec{
+		Init:       "#cloud-config",
+		Properties: types.AnyString(`{"StartupScriptURL":"gs://bucket/startup.sh"}`),
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, "gs://bucket/startup.sh", tags["startup-script-url"])
+	require.NotContains(t, tags, "startup-script")
+	require.NotContains(t, tags, "userdata")
+
+	tags, err = ParseTags(instance.Spec{Init: "echo 'Startup'", Properties: types.AnyString(`{}`)})
+
+	require.NoError(t, err)
+	require.Equal(t, "echo 'Startup'", tags["startup-script"])
+	require.NotContains(t, tags, "startup-script-url")
+}
+
+func TestValidateStartupScriptURL(t *testing.T) {
+	for _, url := range []string{"gs://bucket/startup.sh", "https://storage.googleapis.com/bucket/startup.sh"} {
+		p, err := ParseProperties(types.AnyString(`{"StartupScriptURL":"` + url + `"}`))
+
+		require.NoError(t, err)
+		require.NoError(t, p.Validate())
+	}
+
+	p, err := ParseProperties(types.AnyString(`{"StartupScriptURL":"http://example.com/startup.sh"}`))
+
+	require.NoError(t, err)
+	require.EqualError(t, p.Validate(), "Invalid StartupScriptURL http://example.com/startup.sh: should be a gs:// or https://storage.googleapis.com/ URL")
+}
+
+func TestValidateDeleteOnTimeoutWithDeletionProtection(t *testing.T) {
+	p, err := ParseProperties(types.AnyString(`{"DeleteOnTimeout":true, "DeletionProtection":true}`))
+
+	require.NoError(t, err)
+	require.EqualError(t, p.Validate(), "DeleteOnTimeout can't be set with DeletionProtection")
+}
+
+func TestValidateServiceAccount(t *testing.T) {
+	valid := []string{
+		`{}`,
+		`{"ServiceAccount":"workers@PROJECT.iam.gserviceaccount.com"}`,
+		`{"ServiceAccount":"workers@PROJECT.iam.gserviceaccount.com", "Scopes":[]}`,
+		`{"Scopes":["storage-ro", "https://www.googleapis.com/auth/logging.write"]}`,
+	}
+	for _, properties := range valid {
+		p, err := ParseProperties(types.AnyString(properties))
+
+		require.NoError(t, err)
+		require.NoError(t, p.Validate(), properties)
+	}
+
+	invalid := map[string]string{
+		`{"ServiceAccount":"workers"}`:           `Invalid ServiceAccount "workers": should be the email of a service account`,
+		`{"ServiceAccount":"workers@localhost"}`: `Invalid ServiceAccount "workers@localhost": should be the email of a service account`,
+		`{"Scopes":["storage-ro", "logging"]}`:   `Unknown scope "logging": should be a https://www.googleapis.com/auth/ URL or one of logging-write`,
+	}
+	for properties, expected := range invalid {
+		p, err := ParseProperties(types.AnyString(properties))
+
+		require.NoError(t, err)
+		require.EqualError(t, p.Validate(), expected)
+	}
+}
@@ -1,10 +1,18 @@
 package util
 
 import (
+	"fmt"
 	"math/rand"
+	"regexp"
 	"time"
 )
 
+// maxNameLength is the maximum length of an instance name.
+const maxNameLength = 63
+
+// instanceName matches the names that GCE accepts for instances.
+var instanceName = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
 func init() {
 	rand.Seed(time.Now().UTC().UnixNano())
 }
@@ -21,3 +29,12 @@ func RandomSuffix(n int) string {
 
 	return string(suffix)
 }
+
+// ValidateName checks that GCE accepts a name for an instance.
+func ValidateName(name string) error {
+	if !instanceName.MatchString(name) || len(name) > maxNameLength {
+		return fmt.Errorf("Invalid instance name %q: should start with a lowercase letter, end with a lowercase letter or a digit and have at most %d lowercase letters, digits or -", name, maxNameLength)
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -16,3 +17,15 @@ func TestRandomNameSuffixLen(t *testing.T) {
 func TestRandomNameSuffix(t *testing.T) {
 	require.NotEqual(t, RandomSuffix(8), RandomSuffix(8))
 }
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"w", "worker-1", "worker--ssnk9q", strings.Repeat("w", 63)} {
+		require.NoError(t, ValidateName(name))
+	}
+
+	for _, name := range []string{"", "Worker", "1-worker", "worker-", "worker_1", "worker.1", strings.Repeat("w", 64)} {
+		require.Error(t, ValidateName(name), name)
+	}
+
+	require.EqualError(t, ValidateName("worker-"), `Invalid instance name "worker-": should start with a lowercase letter, end with a lowercase letter or a digit and have at most 63 lowercase letters, digits or -`)
+}
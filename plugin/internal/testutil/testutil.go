@@ -0,0 +1,16 @@
+// Package testutil holds helpers shared by the tests of the plugins.
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ReplyJSON writes a value as the JSON body of a fake Compute API response.
+func ReplyJSON(t *testing.T, w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	require.NoError(t, json.NewEncoder(w).Encode(value))
+}
@@ -21,8 +21,8 @@ type plugin struct {
 
 // NewGCEMetadataPlugin creates a new GCE metadata plugin for a given project
 // and zone.
-func NewGCEMetadataPlugin(project, zone string) metadata.Plugin {
-	api, err := gcloud.NewAPI(project, zone)
+func NewGCEMetadataPlugin(project, zone string, options ...gcloud.Option) metadata.Plugin {
+	api, err := gcloud.NewAPI(project, zone, options...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,53 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/docker/infrakit.gcp/plugin/gcloud"
+	"github.com/spf13/pflag"
+)
+
+// RequestTimeouts is implemented by the plugins that bound the Compute API
+// calls made for each of their requests.
+type RequestTimeouts interface {
+	// SetRequestTimeout sets the bound. Zero restores the default.
+	SetRequestTimeout(timeout time.Duration)
+}
+
+// GCloudOptions registers the command line flags that configure how the
+// plugins access the Compute API. The returned function builds the matching
+// options once the flags are parsed. The component, for example "instance",
+// is sent in the User-Agent of the requests.
+func GCloudOptions(flags *pflag.FlagSet, component string) func() []gcloud.Option {
+	credentials := flags.String("credentials", "", "Service account JSON key file. Uses the Application Default Credentials if empty")
+	endpoint := flags.String("endpoint", "", "Compute API endpoint, for example an emulator. Uses the Google Cloud endpoint if empty")
+	skipVerify := flags.Bool("insecure-skip-verify", false, "Skip the TLS verification of the Compute API endpoint")
+	readRate := flags.Float64("read-rate", 0, "Maximum Compute API reads per second. No limit if 0")
+	mutationRate := flags.Float64("mutation-rate", 0, "Maximum Compute API mutations per second. No limit if 0")
+	maxListResults := flags.Int("max-list-results", 0, "Fail the listings that return more items. No limit if 0")
+	operationTimeout := flags.Duration("operation-timeout", gcloud.DefaultOperationTimeout, "How long mutating calls wait for their operation to complete")
+	readTimeout := flags.Duration("read-timeout", gcloud.DefaultReadTimeout, "How long a Compute API read waits for a response. No limit if 0")
+	mutationTimeout := flags.Duration("mutation-timeout", gcloud.DefaultMutationTimeout, "How long a Compute API mutation waits for a response. No limit if 0")
+	dryRun := flags.Bool("dry-run", false, "Log the Compute API mutations instead of sending them")
+
+	return func() []gcloud.Option {
+		options := []gcloud.Option{
+			gcloud.WithCredentialsFile(*credentials),
+			gcloud.WithEndpoint(*endpoint),
+			gcloud.WithMaxListResults(*maxListResults),
+			gcloud.WithRateLimits(*readRate, *mutationRate),
+			gcloud.WithOperationTimeout(*operationTimeout),
+			gcloud.WithCallTimeouts(*readTimeout, *mutationTimeout),
+			gcloud.WithUserAgent(UserAgent(component)),
+		}
+
+		if *skipVerify {
+			options = append(options, gcloud.WithInsecureSkipVerify())
+		}
+		if *dryRun {
+			options = append(options, gcloud.WithDryRun(gcloud.NewDryRun()))
+		}
+
+		return options
+	}
+}
@@ -14,6 +14,12 @@ var (
 	Revision = "Unspecified"
 )
 
+// UserAgent identifies a component of the plugins, for example "instance", in
+// the requests to the Compute API.
+func UserAgent(component string) string {
+	return fmt.Sprintf("infrakit.gcp/%s (%s)", Version, component)
+}
+
 // VersionCommand creates a cobra Command that prints build version information.
 func VersionCommand() *cobra.Command {
 	return &cobra.Command{